@@ -1,19 +1,22 @@
 package main
 
 import (
+	"context"
 	"log/slog"
+	"os"
+	"time"
 
 	"github.com/vijayvenkatj/LiveTran/internal/config"
 	api "github.com/vijayvenkatj/LiveTran/internal/http"
 	"github.com/vijayvenkatj/LiveTran/internal/ingest"
+	"github.com/vijayvenkatj/LiveTran/internal/store"
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
+	"github.com/vijayvenkatj/LiveTran/internal/webhook"
 )
 
-var tm *ingest.TaskManager
-
 
 
 func init() {
-	tm = ingest.NewTaskManager()
 	config.InitEnv()
 
 	config.InitSlogOTLP()
@@ -21,10 +24,80 @@ func init() {
 }
 
 func main() {
+	storePath := os.Getenv("TASK_STORE_PATH")
+	if storePath == "" {
+		storePath = "data/livetran.db"
+	}
+
+	taskStore, err := store.NewBoltStore(storePath)
+	if err != nil {
+		slog.Error("TASK STORE", "error", err)
+		return
+	}
+	// Closed last, once the streams are drained and the dispatcher has dead-lettered what it could not deliver
+	defer taskStore.Close()
+
+	stopPruning := taskStore.StartPruning(store.PruneConfigFromEnv())
+	defer stopPruning()
+
+	webhooks := webhook.NewDispatcher(webhook.ConfigFromEnv(), taskStore)
+	defer webhooks.Close()
+
+	// Hands the last events to the dispatcher and writes the last records before both are closed
+	tm := ingest.NewTaskManager(taskStore, webhooks)
+	defer tm.Close()
+
+	profiles, err := transcode.NewRegistry(transcode.ConfigFromEnv(), taskStore)
+	if err != nil {
+		slog.Error("TRANSCODE PROFILES", "error", err)
+		return
+	}
+	tm.SetProfiles(profiles)
+
+	if err := tm.SetReconnect(ingest.ReconnectConfigFromEnv()); err != nil {
+		slog.Error("RECONNECT CONFIG", "error", err)
+	}
+
+	// Before restoring, so restored streams get their route on the shared ports back
+	stopSRT, err := tm.StartSRT(ingest.SRTConfigFromEnv())
+	if err != nil {
+		slog.Error("SRT SERVER", "error", err)
+	} else {
+		defer stopSRT()
+	}
+
+	stopRTMP, err := tm.StartRTMP(ingest.RTMPConfigFromEnv())
+	if err != nil {
+		slog.Error("RTMP SERVER", "error", err)
+	} else {
+		defer stopRTMP()
+	}
+
+	stopWHIP, err := tm.StartWHIP(ingest.WHIPConfigFromEnv())
+	if err != nil {
+		slog.Error("WHIP SERVER", "error", err)
+	} else {
+		defer stopWHIP()
+	}
+
+	if err := tm.Restore(); err != nil {
+		slog.Error("TASK RESTORE", "error", err)
+	}
+
+	stopRetention := tm.StartRetention(ingest.RetentionConfigFromEnv())
+	defer stopRetention()
+
+	// Deferred last so it runs first: on SIGTERM the streams are stopped and drained before anything else goes away
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), config.EnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second))
+		defer cancel()
+		tm.Shutdown(ctx)
+	}()
+
 	apiServer := api.NewAPIServer(":8080")
-	err := apiServer.StartAPIServer(tm);
+	err = apiServer.StartAPIServer(tm, webhooks);
 	if err != nil {
 		slog.Error("SERVER STARTUP", "error", err)
 		return
 	}
-}
\ No newline at end of file
+}
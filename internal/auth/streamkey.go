@@ -10,25 +10,29 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func GenerateStreamKey(streamId string) (token string, err error) {
+// How long a generated stream key can be used to publish
+const StreamKeyTTL = 2 * time.Hour
 
+func GenerateStreamKey(streamId string) (token string, expiresAt time.Time, err error) {
+
+	expiresAt = time.Now().Add(StreamKeyTTL)
 	claims := jwt.MapClaims{
 		"stream_id": streamId,
-		"exp":       time.Now().Add(time.Hour * 2).Unix(),
+		"exp":       expiresAt.Unix(),
 	}
 	unsigned_token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	jwt_secret := os.Getenv("JWT_SECRET")
 	if jwt_secret == "" {
-		return "", fmt.Errorf("unable to verify the stream")
+		return "", time.Time{}, fmt.Errorf("unable to verify the stream")
 	}
 
 	token, err = unsigned_token.SignedString([]byte(jwt_secret))
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 
-	return fmt.Sprintf("mode=publish,rid=%s,token=%s", streamId, token), nil
+	return fmt.Sprintf("mode=publish,rid=%s,token=%s", streamId, token), expiresAt, nil
 }
 
 func DecodeStreamKey(streamId string, streamkey string) (ok bool, data string) {
@@ -68,6 +72,11 @@ func DecodeStreamKey(streamId string, streamkey string) (ok bool, data string) {
 		return false, "Invalid Claim Structure"
 	}
 
+	// Scoped tokens, like live tokens, only grant what their scope names and never publishing
+	if _, scoped := claims["scope"]; scoped {
+		return false, "Invalid Token"
+	}
+
 	sid, ok := claims["stream_id"].(string)
 	if !ok {
 		return false, "stream_id claim is not a string"
@@ -79,6 +88,28 @@ func DecodeStreamKey(streamId string, streamkey string) (ok bool, data string) {
 
 }
 
+// StreamKeyId returns the stream id a stream key claims to publish to, without verifying its token.
+// Used to route publishers on shared ports before the key is validated with DecodeStreamKey.
+func StreamKeyId(streamkey string) (string, error) {
+	values, err := parseStreamKey(streamkey)
+	if err != nil {
+		return "", err
+	}
+	if values["rid"] == "" {
+		return "", errors.New("stream key has no rid")
+	}
+	return values["rid"], nil
+}
+
+// StreamKeyRole returns the role the publisher asks for with `role=` in its stream key, empty when it names none
+func StreamKeyRole(streamkey string) string {
+	values, err := parseStreamKey(streamkey)
+	if err != nil {
+		return ""
+	}
+	return values["role"]
+}
+
 func parseStreamKey(streamkey string) (map[string]string, error) {
 	keys := strings.Split(streamkey, ",")
 	data := make(map[string]string)
@@ -93,3 +124,57 @@ func parseStreamKey(streamkey string) (map[string]string, error) {
 
 	return data, nil
 }
+
+// How long a live token can be used to open the live status stream of a stream
+const LiveTokenTTL = 5 * time.Minute
+
+// Scope of live tokens, they cannot be used as stream keys and stream keys cannot be used as them
+const liveTokenScope = "live"
+
+// GenerateLiveToken issues a token that opens the live status stream (SSE or WebSocket) of one stream,
+// for clients that cannot sign requests. Connections opened with it are not cut when it expires.
+func GenerateLiveToken(streamId string) (token string, expiresAt time.Time, err error) {
+	jwt_secret := os.Getenv("JWT_SECRET")
+	if jwt_secret == "" {
+		return "", time.Time{}, fmt.Errorf("unable to sign the token")
+	}
+
+	expiresAt = time.Now().Add(LiveTokenTTL)
+	claims := jwt.MapClaims{
+		"stream_id": streamId,
+		"scope":     liveTokenScope,
+		"exp":       expiresAt.Unix(),
+	}
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(jwt_secret))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// VerifyLiveToken checks a token from GenerateLiveToken against the stream it is used for
+func VerifyLiveToken(streamId string, token string) (ok bool, reason string) {
+	jwt_secret := os.Getenv("JWT_SECRET")
+	if jwt_secret == "" {
+		return false, "unable to verify the token"
+	}
+
+	parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		return []byte(jwt_secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || !parsed.Valid {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return false, "Token expired"
+		}
+		return false, "Invalid Token"
+	}
+
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || claims["scope"] != liveTokenScope {
+		return false, "Invalid Token"
+	}
+	if sid, _ := claims["stream_id"].(string); sid != streamId {
+		return false, "stream_id mismatch"
+	}
+	return true, ""
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeStreamKey(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	streamKey, _, err := GenerateStreamKey("cam1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	liveToken, _, err := GenerateLiveToken("cam1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publishToken := streamKey[strings.Index(streamKey, "token=")+len("token="):]
+
+	tests := []struct {
+		name      string
+		streamId  string
+		streamKey string
+		want      bool
+	}{
+		{name: "stream key", streamId: "cam1", streamKey: streamKey, want: true},
+		{name: "stream key of another stream", streamId: "cam2", streamKey: streamKey, want: false},
+		{name: "live token as stream key", streamId: "cam1", streamKey: "mode=publish,rid=cam1,token=" + liveToken, want: false},
+		{name: "rid rewritten", streamId: "cam2", streamKey: "mode=publish,rid=cam2,token=" + publishToken, want: false},
+		{name: "malformed", streamId: "cam1", streamKey: "garbage", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ok, reason := DecodeStreamKey(tt.streamId, tt.streamKey); ok != tt.want {
+				t.Errorf("DecodeStreamKey() = %v (%s), want %v", ok, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyLiveToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	liveToken, _, err := GenerateLiveToken("cam1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamKey, _, err := GenerateStreamKey("cam1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publishToken := streamKey[strings.Index(streamKey, "token=")+len("token="):]
+
+	if ok, reason := VerifyLiveToken("cam1", liveToken); !ok {
+		t.Errorf("live token rejected: %s", reason)
+	}
+	if ok, _ := VerifyLiveToken("cam2", liveToken); ok {
+		t.Error("live token accepted for another stream")
+	}
+	if ok, _ := VerifyLiveToken("cam1", publishToken); ok {
+		t.Error("stream key token accepted as live token")
+	}
+}
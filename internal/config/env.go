@@ -0,0 +1,52 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// EnvDuration reads a positive duration such as "30s" from the environment, falling back to def
+func EnvDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		slog.Error("Invalid duration in env, using default", "key", key, "value", v, "default", def)
+	}
+	return def
+}
+
+// EnvInt reads a positive integer from the environment, falling back to def
+func EnvInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		slog.Error("Invalid integer in env, using default", "key", key, "value", v, "default", def)
+	}
+	return def
+}
+
+// EnvNonNegativeInt reads an integer that may be 0, such as a port where 0 has a meaning of its own, falling back to def
+func EnvNonNegativeInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+		slog.Error("Invalid integer in env, using default", "key", key, "value", v, "default", def)
+	}
+	return def
+}
+
+// EnvBool reads a boolean such as "true" or "0" from the environment, falling back to def
+func EnvBool(key string, def bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+		slog.Error("Invalid boolean in env, using default", "key", key, "value", v, "default", def)
+	}
+	return def
+}
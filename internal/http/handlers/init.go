@@ -5,18 +5,21 @@ import (
 
 	"github.com/vijayvenkatj/LiveTran/internal/http/middlewares"
 	"github.com/vijayvenkatj/LiveTran/internal/ingest"
+	"github.com/vijayvenkatj/LiveTran/internal/webhook"
 )
 
 
 type Handler struct {
 	tm		*ingest.TaskManager
+	webhooks	*webhook.Dispatcher
 }
 
 
 // Constructor for Handler
-func NewHandler(tm *ingest.TaskManager) *Handler {
+func NewHandler(tm *ingest.TaskManager, webhooks *webhook.Dispatcher) *Handler {
 	return &Handler{
 		tm: tm,
+		webhooks: webhooks,
 	}
 }
 
@@ -28,7 +31,34 @@ func (h *Handler) StreamRoutes() http.Handler {
 	mux.HandleFunc("POST /stop-stream",h.StopStream)
 	mux.HandleFunc("GET /status", h.Status)
 
-	handler := middlewares.CORSMiddleware(middlewares.VerifyRequest(mux))
+	mux.HandleFunc("GET /streams", h.ListStreams)
+	mux.HandleFunc("GET /streams/{id}", h.GetStream)
+	mux.HandleFunc("DELETE /streams/{id}", h.DeleteStream)
+	mux.HandleFunc("GET /streams/{id}/stats", h.GetStreamStats)
+	mux.HandleFunc("POST /streams/{id}/live-token", h.IssueLiveToken)
+
+	mux.HandleFunc("GET /profiles", h.ListProfiles)
+	mux.HandleFunc("GET /profiles/{name}", h.GetProfile)
+	mux.HandleFunc("POST /profiles", h.SaveProfile)
+	mux.HandleFunc("DELETE /profiles/{name}", h.DeleteProfile)
+
+	mux.HandleFunc("GET /webhooks/deliveries", h.ListWebhookDeliveries)
+	mux.HandleFunc("GET /webhooks/dead-letters", h.ListDeadLetters)
+	mux.HandleFunc("POST /webhooks/dead-letters/{id}/replay", h.ReplayDeadLetter)
+
+	// WHIP clients authenticate with the stream key, browsers cannot sign requests
+	whip := http.NewServeMux()
+	whip.HandleFunc("POST /whip/{id}", h.WHIPPublish)
+	whip.HandleFunc("DELETE /whip/{id}/{session}", h.WHIPEnd)
+
+	router := http.NewServeMux()
+	router.Handle("/whip/", whip)
+	// Live status streams also take a live token, EventSource and browser WebSockets cannot sign requests
+	router.Handle("GET /streams/{id}/events", middlewares.VerifyLiveRequest(http.HandlerFunc(h.StreamEvents)))
+	router.Handle("GET /streams/{id}/ws", middlewares.VerifyLiveRequest(http.HandlerFunc(h.StreamEventsWS)))
+	router.Handle("/", middlewares.VerifyRequest(mux))
+
+	handler := middlewares.CORSMiddleware(router)
 
 	return handler
 }
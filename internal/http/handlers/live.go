@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vijayvenkatj/LiveTran/internal/auth"
+	"github.com/vijayvenkatj/LiveTran/internal/ingest"
+)
+
+const (
+	liveStatsInterval = 5 * time.Second
+	liveBufferSize    = 64
+	wsWriteTimeout    = 10 * time.Second
+	wsPongTimeout     = 60 * time.Second
+)
+
+// LiveTokenResponse is the token a live status stream can be opened with, in the `token` query parameter
+type LiveTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+var upgrader = websocket.Upgrader{
+	// Requests are authenticated by the HMAC signature or a live token, not the origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// IssueLiveToken : POST /streams/{id}/live-token gives clients that cannot sign requests a short-lived
+// token to open the stream's live status stream with
+func (handler *Handler) IssueLiveToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	task, exists := handler.tm.GetTask(r.PathValue("id"))
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Task not found",
+		})
+		return
+	}
+
+	token, expiresAt, err := auth.GenerateLiveToken(task.Id)
+	if err != nil {
+		slog.Error("failed to generate live token", "stream_id", task.Id, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Failed to generate live token",
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Data: LiveTokenResponse{
+			Token:     token,
+			ExpiresAt: expiresAt,
+		},
+	})
+}
+
+// StreamEvents : GET /streams/{id}/events pushes status events and periodic stats over SSE
+func (handler *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	task, exists := handler.tm.GetTask(r.PathValue("id"))
+	if !exists {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Task not found",
+		})
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event ingest.Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.EventId, event.Type, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	slog.Info("live status stream opened", "stream_id", task.Id, "transport", "sse", "remote_addr", r.RemoteAddr)
+	defer slog.Info("live status stream closed", "stream_id", task.Id, "transport", "sse", "remote_addr", r.RemoteAddr)
+
+	handler.streamLive(r, task, send)
+}
+
+// StreamEventsWS : GET /streams/{id}/ws is the WebSocket equivalent of StreamEvents
+func (handler *Handler) StreamEventsWS(w http.ResponseWriter, r *http.Request) {
+	task, exists := handler.tm.GetTask(r.PathValue("id"))
+	if !exists {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Task not found",
+		})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("failed to upgrade websocket", "stream_id", task.Id, "error", err)
+		return
+	}
+	defer conn.Close()
+
+	// Drain client frames so control messages (pong, close) are processed
+	closed := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(event ingest.Event) error {
+		select {
+		case <-closed:
+			return websocket.ErrCloseSent
+		default:
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := conn.WriteJSON(event); err != nil {
+			return err
+		}
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+	}
+
+	slog.Info("live status stream opened", "stream_id", task.Id, "transport", "websocket", "remote_addr", r.RemoteAddr)
+	defer slog.Info("live status stream closed", "stream_id", task.Id, "transport", "websocket", "remote_addr", r.RemoteAddr)
+
+	handler.streamLive(r, task, send)
+
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(wsWriteTimeout),
+	)
+}
+
+// streamLive sends the current stats, then every status event and periodic stats until the
+// client goes away or the stream ends
+func (handler *Handler) streamLive(r *http.Request, task *ingest.Task, send func(ingest.Event) error) {
+	sub := handler.tm.Events().Subscribe("live:"+task.Id, liveBufferSize, ingest.DropOldest, func(event ingest.Event) bool {
+		return event.StreamId == task.Id
+	})
+	defer sub.Close()
+
+	if err := send(task.StatsEvent()); err != nil {
+		return
+	}
+	if task.GetStatus().IsTerminal() {
+		return
+	}
+
+	ticker := time.NewTicker(liveStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+			if event.Type == ingest.EventStreamStopped || event.Type == ingest.EventStreamFailed {
+				return
+			}
+
+		case <-ticker.C:
+			// A restart replaces the task under the same id, the stats are the current run's
+			current, exists := handler.tm.GetTask(task.Id)
+			if !exists {
+				return
+			}
+			if err := send(current.StatsEvent()); err != nil {
+				return
+			}
+		}
+	}
+}
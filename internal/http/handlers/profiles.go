@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
+)
+
+// ListProfiles : GET /profiles
+func (handler *Handler) ListProfiles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Data:    handler.tm.Profiles().List(),
+	})
+}
+
+// GetProfile : GET /profiles/{name}
+func (handler *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	info, err := handler.tm.Profiles().Info(r.PathValue("name"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Profile not found",
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Data:    info,
+	})
+}
+
+// SaveProfile : POST /profiles creates a profile, or replaces one created through the API
+func (handler *Handler) SaveProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var profile transcode.Profile
+	if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Cannot read Request body!",
+		})
+		return
+	}
+
+	slog.Info("received save profile request",
+		"profile", profile.Name,
+		"renditions", len(profile.Renditions),
+		"remote_addr", r.RemoteAddr,
+		"user_agent", r.Header.Get("User-Agent"),
+	)
+
+	info, err := handler.tm.Profiles().Save(profile)
+	if err != nil {
+		writeProfileError(w, profile.Name, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Data:    info,
+	})
+}
+
+// DeleteProfile : DELETE /profiles/{name}, streams running with it keep their copy
+func (handler *Handler) DeleteProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := r.PathValue("name")
+
+	slog.Info("received delete profile request",
+		"profile", name,
+		"remote_addr", r.RemoteAddr,
+		"user_agent", r.Header.Get("User-Agent"),
+	)
+
+	if err := handler.tm.Profiles().Delete(name); err != nil {
+		writeProfileError(w, name, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Data:    "Profile deleted!",
+	})
+}
+
+func writeProfileError(w http.ResponseWriter, name string, err error) {
+	status := http.StatusInternalServerError
+	message := "Failed to update profile"
+
+	switch {
+	case errors.Is(err, transcode.ErrInvalidProfile):
+		status, message = http.StatusBadRequest, err.Error()
+	case errors.Is(err, transcode.ErrUnknownProfile):
+		status, message = http.StatusNotFound, "Profile not found"
+	case errors.Is(err, transcode.ErrProfileReadOnly):
+		status, message = http.StatusConflict, err.Error()
+	default:
+		slog.Error("failed to update profile", "profile", name, "error", err)
+	}
+
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Response{
+		Success: false,
+		Error:   message,
+	})
+}
@@ -4,24 +4,41 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/vijayvenkatj/LiveTran/internal/ingest"
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
 )
 
 type Response struct {
 	Success bool   `json:"success"`
 	Error   string `json:"error,omitempty"`
-	Data    string `json:"data,omitempty"`
+	Data    any    `json:"data,omitempty"`
 }
 
 type StreamRequest struct {
 	StreamId	string	    `json:"stream_id"`
 	WebhookUrls []string 	`json:"webhook_urls,omitempty"`
-	Abr			bool		`json:"abr,omitempty"`
+	Abr			bool		`json:"abr,omitempty"` // Shorthand for the built-in abr profile
+	Profile		string		`json:"profile,omitempty"` // Transcoding profile, the server default when left out
+	Mode		string		`json:"mode,omitempty"` // transcode (default) or passthrough
+	Protocol	string		`json:"protocol,omitempty"` // srt (default), rtmp, whip, or rtsp/hls for pulled streams
+	Pull		*ingest.PullSource	`json:"pull,omitempty"` // Remote source to pull instead of waiting for a publisher
+	Encryption	*ingest.SrtEncryption	`json:"encryption,omitempty"` // SRT only, the passphrase is generated when left out
+	Srt			*ingest.SrtOptions	`json:"srt,omitempty"` // SRT only, latency and connection settings over the server defaults
+	Reconnect	*ingest.ReconnectOptions	`json:"reconnect,omitempty"` // Grace window and slate while the publisher is away
 }
 
+// StartConflict details why a start request was refused
+type StartConflict struct {
+	Status            ingest.StreamState `json:"status"`
+	ConflictingFields []string           `json:"conflicting_fields,omitempty"`
+}
 
 func (handler *Handler) StartStream(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -42,19 +59,111 @@ func (handler *Handler) StartStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+
 	slog.Info("received start stream request",
 		"stream_id", streamBody.StreamId,
 		"webhook_urls", streamBody.WebhookUrls,
 		"abr", streamBody.Abr,
+		"profile", streamBody.Profile,
+		"mode", streamBody.Mode,
+		"protocol", streamBody.Protocol,
+		"pull", streamBody.Pull != nil,
+		"encrypted", streamBody.Encryption != nil,
+		"srt_options", streamBody.Srt != nil,
+		"reconnect_options", streamBody.Reconnect != nil,
+		"idempotency_key", idempotencyKey,
 		"remote_addr", r.RemoteAddr,
 		"user_agent", r.Header.Get("User-Agent"),
 	)
 
-	handler.tm.StartTask(streamBody.StreamId, streamBody.WebhookUrls, streamBody.Abr)
+	// A pulled stream's protocol is the scheme of its URL
+	protocolName := streamBody.Protocol
+	if protocolName == "" && streamBody.Pull != nil {
+		protocolName = string(streamBody.Pull.Protocol())
+	}
+
+	protocol, err := ingest.ParseProtocol(protocolName)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Unsupported protocol, use srt, rtmp, whip, rtsp or hls",
+		})
+		return
+	}
+
+	mode, err := transcode.ParseMode(streamBody.Mode)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Unsupported mode, use transcode or passthrough",
+		})
+		return
+	}
+
+	info, err := handler.tm.StartTask(streamBody.StreamId, ingest.StartOptions{
+		Protocol:       protocol,
+		Pull:           streamBody.Pull,
+		Encryption:     streamBody.Encryption,
+		Srt:            streamBody.Srt,
+		Reconnect:      streamBody.Reconnect,
+		Webhooks:       streamBody.WebhookUrls,
+		Abr:            streamBody.Abr,
+		Profile:        streamBody.Profile,
+		Mode:           mode,
+		IdempotencyKey: idempotencyKey,
+	})
+	var conflict *ingest.ConflictError
+	if errors.As(err, &conflict) {
+		slog.Warn("conflicting start stream request",
+			"stream_id", streamBody.StreamId,
+			"status", conflict.Status,
+			"fields", conflict.Fields,
+			"reason", conflict.Reason,
+		)
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   conflict.Reason,
+			Data:    StartConflict{Status: conflict.Status, ConflictingFields: conflict.Fields},
+		})
+		return
+	}
+	if errors.Is(err, ingest.ErrProtocolDisabled) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   fmt.Sprintf("%s ingest is not enabled on this server", protocol),
+		})
+		return
+	}
+	if errors.Is(err, ingest.ErrInvalidStreamId) || errors.Is(err, ingest.ErrInvalidPullSource) || errors.Is(err, ingest.ErrInvalidEncryption) || errors.Is(err, ingest.ErrInvalidSrtOptions) || errors.Is(err, ingest.ErrInvalidReconnectOptions) ||
+		errors.Is(err, transcode.ErrInvalidProfile) || errors.Is(err, transcode.ErrUnknownProfile) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		slog.Error("failed to start stream",
+			"stream_id", streamBody.StreamId,
+			"error", err,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Failed to start stream",
+		})
+		return
+	}
 
 	json.NewEncoder(w).Encode(Response{
 		Success: true,
-		Data:    "Stream launching!",
+		Data:    info,
 	})
 }
 
@@ -116,12 +225,12 @@ func (handler *Handler) Status(w http.ResponseWriter, r *http.Request) {
 		"user_agent", r.Header.Get("User-Agent"),
 	)
 
-	task, exists := handler.tm.TaskMap[streamBody.StreamId]
+	task, exists := handler.tm.GetTask(streamBody.StreamId)
 	if exists {
 		w.WriteHeader(http.StatusAccepted)
 		json.NewEncoder(w).Encode(Response{
 			Success: true,
-			Data:    fmt.Sprintf("Status: %s", task.Status),
+			Data:    fmt.Sprintf("Status: %s", task.GetStatus()),
 		})
 		return
 	}
@@ -143,7 +252,51 @@ func (handler *Handler) GetVideoChunks(w http.ResponseWriter, r *http.Request) {
 		"user_agent", r.Header.Get("User-Agent"),
 	)
 
+	streamId, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	// Low-Latency HLS playlist requests can wait for the next segment or part, and ask for a delta update
+	if filepath.Ext(filePath) == ".m3u8" {
+		playlistRequest, directives, err := ingest.ParsePlaylistRequest(r.URL.Query())
+		if directives {
+			var playlist []byte
+			if err == nil {
+				playlist, err = handler.tm.LowLatencyPlaylist(r.Context(), streamId, name, playlistRequest)
+			}
+
+			switch {
+			case err == nil:
+				w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+				w.Write(playlist)
+				return
+			case errors.Is(err, ingest.ErrInvalidPlaylistRequest):
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(Response{
+					Success: false,
+					Error:   err.Error(),
+				})
+				return
+			case errors.Is(err, ingest.ErrPlaylistTimeout):
+				// What was asked for did not show up within three target durations
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).Encode(Response{
+					Success: false,
+					Error:   err.Error(),
+				})
+				return
+			}
+			// Other playlists are served as they are, directives ignored
+		}
+	}
+
 	file, err := os.Open(filePath)
+	if errors.Is(err, fs.ErrNotExist) && filepath.Ext(filePath) == ".m4s" {
+		// Parts named by a preload hint are held until they are published
+		if handler.tm.AwaitPart(r.Context(), streamId, name) == nil {
+			file, err = os.Open(filePath)
+		}
+	}
 	if err != nil {
 		slog.Error("failed to open video chunk",
 			"path", filePath,
@@ -163,8 +316,14 @@ func (handler *Handler) GetVideoChunks(w http.ResponseWriter, r *http.Request) {
 
 	if filepath.Ext(filePath) == ".m3u8" {
 		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	} else if filepath.Ext(filePath) == ".mpd" {
+		w.Header().Set("Content-Type", "application/dash+xml")
 	} else if filepath.Ext(filePath) == ".ts" {
 		w.Header().Set("Content-Type", "video/MP2T")
+	} else if filepath.Ext(filePath) == ".m4s" {
+		w.Header().Set("Content-Type", "video/iso.segment")
+	} else if filepath.Ext(filePath) == ".mp4" {
+		w.Header().Set("Content-Type", "video/mp4")
 	}
 
 	w.Header().Set("Accept-Ranges", "bytes")
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vijayvenkatj/LiveTran/internal/ingest"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+type StreamList struct {
+	Streams []ingest.TaskInfo `json:"streams"`
+	Total   int               `json:"total"`
+	Limit   int               `json:"limit"`
+	Offset  int               `json:"offset"`
+}
+
+// ListStreams : GET /streams?status=READY&limit=50&offset=0
+func (handler *Handler) ListStreams(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	status := ingest.StreamState(strings.ToUpper(query.Get("status")))
+	if status != "" && !status.IsValid() {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Invalid status",
+		})
+		return
+	}
+
+	limit, err := parsePageParam(query.Get("limit"), defaultPageLimit)
+	if err != nil || limit == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Invalid limit",
+		})
+		return
+	}
+	limit = min(limit, maxPageLimit)
+
+	offset, err := parsePageParam(query.Get("offset"), 0)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Invalid offset",
+		})
+		return
+	}
+
+	streams := []ingest.TaskInfo{}
+	for _, task := range handler.tm.ListTasks() {
+		info := task.Info()
+		if status != "" && info.Status != status {
+			continue
+		}
+		streams = append(streams, info)
+	}
+
+	total := len(streams)
+	start := min(offset, total)
+	end := min(start+limit, total)
+
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Data: StreamList{
+			Streams: streams[start:end],
+			Total:   total,
+			Limit:   limit,
+			Offset:  offset,
+		},
+	})
+}
+
+// GetStream : GET /streams/{id}
+func (handler *Handler) GetStream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+
+	task, exists := handler.tm.GetTask(id)
+	if exists {
+		json.NewEncoder(w).Encode(Response{
+			Success: true,
+			Data:    task.Info(),
+		})
+		return
+	}
+
+	// Evicted by retention, the archived record is still served
+	info, err := handler.tm.GetArchived(id)
+	if err != nil {
+		if !errors.Is(err, ingest.ErrTaskNotFound) {
+			slog.Error("failed to read archived stream", "stream_id", id, "error", err)
+		}
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Task not found",
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Data:    info,
+	})
+}
+
+// GetStreamStats : GET /streams/{id}/stats returns the live figures of a stream, SRT connection statistics included
+func (handler *Handler) GetStreamStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	task, exists := handler.tm.GetTask(r.PathValue("id"))
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Task not found",
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Data:    task.Stats(),
+	})
+}
+
+// DeleteStream : DELETE /streams/{id} stops the stream and purges its record
+func (handler *Handler) DeleteStream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+
+	slog.Info("received delete stream request",
+		"stream_id", id,
+		"remote_addr", r.RemoteAddr,
+		"user_agent", r.Header.Get("User-Agent"),
+	)
+
+	err := handler.tm.DeleteTask(id)
+	if errors.Is(err, ingest.ErrTaskNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Task not found",
+		})
+		return
+	}
+	if err != nil {
+		slog.Error("failed to delete stream", "stream_id", id, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Failed to delete stream",
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Data:    "Stream deleted!",
+	})
+}
+
+func parsePageParam(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid page parameter")
+	}
+	return n, nil
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vijayvenkatj/LiveTran/internal/ingest"
+)
+
+// listStreams calls ListStreams with query and decodes the page it answers with
+func listStreams(t *testing.T, h *Handler, query string) (int, StreamList) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ListStreams(w, httptest.NewRequest(http.MethodGet, "/streams"+query, nil))
+
+	var resp struct {
+		Success bool       `json:"success"`
+		Data    StreamList `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, resp.Data
+}
+
+func TestListStreams(t *testing.T) {
+	tm := ingest.NewTaskManager(nil, nil)
+	defer tm.Close()
+
+	// s0 to s4, started in that order, every other one live
+	start := time.Now().Add(-time.Hour)
+	for i := range 5 {
+		status := ingest.StreamReady
+		if i%2 == 0 {
+			status = ingest.StreamLive
+		}
+		id := fmt.Sprintf("s%d", i)
+		tm.TaskMap[id] = &ingest.Task{Id: id, Status: status, StartTime: start.Add(time.Duration(i) * time.Minute)}
+	}
+	h := NewHandler(tm, nil)
+
+	tests := []struct {
+		name   string
+		query  string
+		ids    []string
+		total  int
+		limit  int
+		offset int
+	}{
+		{"all", "", []string{"s0", "s1", "s2", "s3", "s4"}, 5, defaultPageLimit, 0},
+		{"first page", "?limit=2", []string{"s0", "s1"}, 5, 2, 0},
+		{"second page", "?limit=2&offset=2", []string{"s2", "s3"}, 5, 2, 2},
+		{"last page", "?limit=2&offset=4", []string{"s4"}, 5, 2, 4},
+		{"past the end", "?offset=10", []string{}, 5, defaultPageLimit, 10},
+		{"limit over the maximum", "?limit=1000", []string{"s0", "s1", "s2", "s3", "s4"}, 5, maxPageLimit, 0},
+		{"live", "?status=LIVE", []string{"s0", "s2", "s4"}, 3, defaultPageLimit, 0},
+		{"lower case status", "?status=ready", []string{"s1", "s3"}, 2, defaultPageLimit, 0},
+		{"filtered page", "?status=LIVE&limit=1&offset=1", []string{"s2"}, 3, 1, 1},
+		{"known status without streams", "?status=FAILED", []string{}, 0, defaultPageLimit, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, page := listStreams(t, h, tt.query)
+			if code != http.StatusOK {
+				t.Fatalf("status code = %d", code)
+			}
+			ids := []string{}
+			for _, stream := range page.Streams {
+				ids = append(ids, stream.Id)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.ids) || page.Total != tt.total || page.Limit != tt.limit || page.Offset != tt.offset {
+				t.Errorf("page = %v, total %d, limit %d, offset %d, want %v, total %d, limit %d, offset %d",
+					ids, page.Total, page.Limit, page.Offset, tt.ids, tt.total, tt.limit, tt.offset)
+			}
+		})
+	}
+
+	for _, query := range []string{"?status=live_ish", "?status=idle", "?limit=0", "?limit=-1", "?limit=ten", "?offset=-1"} {
+		t.Run(query, func(t *testing.T) {
+			if code, _ := listStreams(t, h, query); code != http.StatusBadRequest {
+				t.Errorf("status code = %d, want %d", code, http.StatusBadRequest)
+			}
+		})
+	}
+}
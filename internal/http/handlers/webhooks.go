@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/vijayvenkatj/LiveTran/internal/webhook"
+)
+
+// ListWebhookDeliveries : GET /webhooks/deliveries?stream_id=req1
+func (handler *Handler) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Data:    handler.webhooks.Deliveries(r.URL.Query().Get("stream_id")),
+	})
+}
+
+// ListDeadLetters : GET /webhooks/dead-letters
+func (handler *Handler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	letters, err := handler.webhooks.DeadLetters()
+	if err != nil {
+		slog.Error("failed to list dead letters", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Failed to list dead letters",
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Data:    letters,
+	})
+}
+
+// ReplayDeadLetter : POST /webhooks/dead-letters/{id}/replay
+func (handler *Handler) ReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+
+	slog.Info("received dead letter replay request",
+		"dead_letter_id", id,
+		"remote_addr", r.RemoteAddr,
+		"user_agent", r.Header.Get("User-Agent"),
+	)
+
+	err := handler.webhooks.Replay(id)
+	if errors.Is(err, webhook.ErrDeadLetterNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Dead letter not found",
+		})
+		return
+	}
+	if err != nil {
+		slog.Error("failed to replay dead letter", "dead_letter_id", id, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Success: false,
+			Error:   "Failed to replay dead letter",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(Response{
+		Success: true,
+		Data:    "Webhook queued for delivery",
+	})
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/vijayvenkatj/LiveTran/internal/ingest"
+)
+
+// Largest SDP offer accepted from a WHIP client
+const maxOfferSize = 64 << 10
+
+// WHIPPublish negotiates a WebRTC session for a browser publisher (RFC 9725).
+// Authenticated with the stream key as bearer token instead of a request signature.
+func (handler *Handler) WHIPPublish(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	slog.Info("received WHIP offer",
+		"stream_id", id,
+		"remote_addr", r.RemoteAddr,
+		"user_agent", r.Header.Get("User-Agent"),
+	)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/sdp" {
+		writeWHIPError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/sdp")
+		return
+	}
+
+	bearer, ok := bearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeWHIPError(w, http.StatusUnauthorized, "Missing bearer stream key")
+		return
+	}
+
+	offer, err := io.ReadAll(io.LimitReader(r.Body, maxOfferSize))
+	if err != nil {
+		writeWHIPError(w, http.StatusBadRequest, "Cannot read Request body!")
+		return
+	}
+
+	answer, session, err := handler.tm.WHIPPublish(r.Context(), id, bearer, string(offer), r.RemoteAddr)
+	if err != nil {
+		status, message := whipErrorStatus(err)
+		slog.Warn("WHIP offer refused",
+			"stream_id", id,
+			"status", status,
+			"error", err,
+			"remote_addr", r.RemoteAddr,
+		)
+		writeWHIPError(w, status, message)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", fmt.Sprintf("/api/whip/%s/%s", id, session))
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, answer)
+}
+
+// WHIPEnd tears down a WHIP session, the stream keeps waiting for a publisher
+func (handler *Handler) WHIPEnd(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	session := r.PathValue("session")
+
+	slog.Info("received WHIP session end",
+		"stream_id", id,
+		"session", session,
+		"remote_addr", r.RemoteAddr,
+		"user_agent", r.Header.Get("User-Agent"),
+	)
+
+	bearer, ok := bearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeWHIPError(w, http.StatusUnauthorized, "Missing bearer stream key")
+		return
+	}
+
+	if err := handler.tm.WHIPEnd(id, bearer, session); err != nil {
+		status, message := whipErrorStatus(err)
+		writeWHIPError(w, status, message)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func whipErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, ingest.ErrProtocolDisabled):
+		return http.StatusServiceUnavailable, "WHIP ingest is not enabled on this server"
+	case errors.Is(err, ingest.ErrInvalidStreamKey):
+		return http.StatusUnauthorized, "Invalid stream key"
+	case errors.Is(err, ingest.ErrUnknownStream):
+		return http.StatusNotFound, "Stream is not waiting for a WHIP publisher"
+	case errors.Is(err, ingest.ErrUnknownSession):
+		return http.StatusNotFound, "Session not found"
+	case errors.Is(err, ingest.ErrPublisherBusy):
+		return http.StatusConflict, "Stream already has a publisher"
+	case errors.Is(err, ingest.ErrInvalidOffer):
+		return http.StatusBadRequest, "Invalid SDP offer"
+	}
+	return http.StatusInternalServerError, "Failed to negotiate the session"
+}
+
+func writeWHIPError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Response{
+		Success: false,
+		Error:   message,
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	token = strings.TrimSpace(token)
+	return token, ok && token != ""
+}
@@ -10,7 +10,8 @@ func CORSMiddleware(next http.Handler) http.Handler {
 
 		w.Header().Set("Access-Control-Allow-Origin", "*") // or restrict to specific origin
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, LT-SIGNATURE")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, LT-SIGNATURE, Idempotency-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "Location") // WHIP session URL
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		// Preflight request
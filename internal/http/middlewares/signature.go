@@ -9,6 +9,8 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+
+	"github.com/vijayvenkatj/LiveTran/internal/auth"
 )
 
 func VerifyRequest(next http.Handler) http.Handler {
@@ -48,6 +50,27 @@ func VerifyRequest(next http.Handler) http.Handler {
 	})
 }
 
+// VerifyLiveRequest lets clients that cannot set headers, such as EventSource and browser WebSockets,
+// open the live status stream of a stream with a short-lived token from POST /streams/{id}/live-token
+// in the `token` query parameter. Other requests must be signed as usual.
+func VerifyLiveRequest(next http.Handler) http.Handler {
+	signed := VerifyRequest(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			signed.ServeHTTP(w, r)
+			return
+		}
+
+		if ok, reason := auth.VerifyLiveToken(r.PathValue("id"), token); !ok {
+			http.Error(w, reason, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func verifyHMAC(request []byte, secret []byte, signature string) bool {
 	// Convert the signature to bytes
 	byteSig, err := hex.DecodeString(signature)
@@ -0,0 +1,135 @@
+package middlewares
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vijayvenkatj/LiveTran/internal/auth"
+)
+
+const testSecret = "test-secret"
+
+// sign sets LT-SIGNATURE of r to the HMAC of body
+func sign(r *http.Request, body string) {
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write([]byte(body))
+	r.Header.Set("LT-SIGNATURE", hex.EncodeToString(mac.Sum(nil)))
+}
+
+func serve(handler http.Handler, r *http.Request) int {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestVerifyRequest(t *testing.T) {
+	t.Setenv("HMAC_SECRET", testSecret)
+	handler := VerifyRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		want    int
+	}{
+		{
+			name: "signed body",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodPost, "/api/stop-stream", strings.NewReader(`{"stream_id":"a"}`))
+				sign(r, `{"stream_id":"a"}`)
+				return r
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "signed bodiless request",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/api/streams/a/events", nil)
+				sign(r, "")
+				return r
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "tampered body",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodPost, "/api/stop-stream", strings.NewReader(`{"stream_id":"b"}`))
+				sign(r, `{"stream_id":"a"}`)
+				return r
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "malformed signature",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/api/streams", nil)
+				r.Header.Set("LT-SIGNATURE", "not-hex")
+				return r
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "signature in the query",
+			request: func() *http.Request {
+				signed := httptest.NewRequest(http.MethodGet, "/api/streams", nil)
+				sign(signed, "")
+				return httptest.NewRequest(http.MethodGet, "/api/streams?signature="+signed.Header.Get("LT-SIGNATURE"), nil)
+			},
+			want: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serve(handler, tt.request()); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyLiveRequest(t *testing.T) {
+	t.Setenv("HMAC_SECRET", testSecret)
+	t.Setenv("JWT_SECRET", "jwt-secret")
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/streams/{id}/events", VerifyLiveRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	token, _, err := auth.GenerateLiveToken("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamKey, _, err := auth.GenerateStreamKey("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, streamKey, _ = strings.Cut(streamKey, "token=")
+
+	signed := httptest.NewRequest(http.MethodGet, "/api/streams/a/events", nil)
+	sign(signed, "")
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		want    int
+	}{
+		{"token of the stream", httptest.NewRequest(http.MethodGet, "/api/streams/a/events?token="+token, nil), http.StatusOK},
+		{"token of another stream", httptest.NewRequest(http.MethodGet, "/api/streams/b/events?token="+token, nil), http.StatusForbidden},
+		{"stream key as token", httptest.NewRequest(http.MethodGet, "/api/streams/a/events?token="+streamKey, nil), http.StatusForbidden},
+		{"invalid token", httptest.NewRequest(http.MethodGet, "/api/streams/a/events?token=nope", nil), http.StatusForbidden},
+		{"signed request", signed, http.StatusOK},
+		{"neither", httptest.NewRequest(http.MethodGet, "/api/streams/a/events", nil), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serve(mux, tt.request); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -13,8 +13,10 @@ import (
 
 	"github.com/vijayvenkatj/LiveTran/internal/http/handlers"
 	"github.com/vijayvenkatj/LiveTran/internal/ingest"
+	"github.com/vijayvenkatj/LiveTran/internal/webhook"
 	"github.com/vijayvenkatj/LiveTran/metrics"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 )
 
@@ -33,7 +35,7 @@ func NewAPIServer(address string) *APIServer {
 }
 
 
-func (a *APIServer) StartAPIServer(tm *ingest.TaskManager) error {
+func (a *APIServer) StartAPIServer(tm *ingest.TaskManager, webhooks *webhook.Dispatcher) error {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
@@ -63,9 +65,33 @@ func (a *APIServer) StartAPIServer(tm *ingest.TaskManager) error {
 		metrics.RegisterStatusGauge(ctx, meter, "streams_info", "stream information based on status", func() (active,idle,stopped int64) {
 			return tm.GetAllStreams()
 		})
+
+		metrics.RegisterObservableCounter(meter, "retention_tasks_evicted_total", "finished tasks evicted from memory", func() int64 {
+			return tm.RetentionStats().TasksEvicted
+		})
+		metrics.RegisterObservableCounter(meter, "retention_reclaimed_bytes_total", "bytes of local stream output removed", func() int64 {
+			return tm.RetentionStats().BytesReclaimed
+		})
+		metrics.RegisterObservableCounter(meter, "retention_outputs_retained_total", "evicted streams whose local output was kept because uploads failed", func() int64 {
+			return tm.RetentionStats().OutputsRetained
+		})
+
+		registerSrtMetrics(meter, tm)
+
+		eventCounter, err := metrics.RegisterCounter(meter, "stream_events_total", "stream events emitted, by type")
+		if err == nil {
+			sub := tm.Events().Subscribe("metrics", 256, ingest.DropOldest, nil)
+			defer sub.Close()
+
+			go func() {
+				for event := range sub.Events() {
+					eventCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("type", string(event.Type))))
+				}
+			}()
+		}
 	}
 
-	routeHandler := handlers.NewHandler(tm)
+	routeHandler := handlers.NewHandler(tm, webhooks)
 	streamRoutes := routeHandler.StreamRoutes()
 	videoRoutes := routeHandler.VideoRoutes()
 
@@ -105,3 +131,38 @@ func (a *APIServer) StartAPIServer(tm *ingest.TaskManager) error {
 
 	return nil
 }
+
+// registerSrtMetrics exports the SRT connection statistics of every stream, labelled with its stream_id
+func registerSrtMetrics(meter metric.Meter, tm *ingest.TaskManager) {
+	gauge := func(name, description string, value func(ingest.SrtStats) float64) {
+		metrics.RegisterStreamGauge(meter, name, description, func() map[string]float64 {
+			values := make(map[string]float64)
+			for id, stats := range tm.SrtStats() {
+				if stats.Connected {
+					values[id] = value(stats)
+				}
+			}
+			return values
+		})
+	}
+	counter := func(name, description string, value func(ingest.SrtStats) uint64) {
+		metrics.RegisterStreamCounter(meter, name, description, func() map[string]int64 {
+			values := make(map[string]int64)
+			for id, stats := range tm.SrtStats() {
+				values[id] = int64(value(stats))
+			}
+			return values
+		})
+	}
+
+	gauge("srt_rtt_ms", "smoothed round trip time to the SRT publisher", func(s ingest.SrtStats) float64 { return s.RTTMs })
+	gauge("srt_receive_rate_mbps", "rate media is received at over SRT", func(s ingest.SrtStats) float64 { return s.ReceiveRateMbps })
+	gauge("srt_link_capacity_mbps", "estimated capacity of the link to the SRT publisher", func(s ingest.SrtStats) float64 { return s.LinkCapacityMbps })
+	gauge("srt_packet_loss_percent", "share of SRT packets lost over the last sample interval", func(s ingest.SrtStats) float64 { return s.PacketLossPercent })
+	gauge("srt_receive_buffer_ms", "media held in the SRT receive buffer", func(s ingest.SrtStats) float64 { return float64(s.ReceiveBufferMs) })
+
+	counter("srt_packets_received_total", "SRT data packets received, retransmissions included", func(s ingest.SrtStats) uint64 { return s.PacketsReceived })
+	counter("srt_packets_lost_total", "SRT data packets detected as lost", func(s ingest.SrtStats) uint64 { return s.PacketsLost })
+	counter("srt_packets_retransmitted_total", "retransmitted SRT data packets received", func(s ingest.SrtStats) uint64 { return s.PacketsRetrans })
+	counter("srt_packets_dropped_total", "SRT data packets that arrived too late to be delivered", func(s ingest.SrtStats) uint64 { return s.PacketsDropped })
+}
@@ -0,0 +1,326 @@
+package ingest
+
+import (
+	"cmp"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"math"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Live profile with CMAF media, segments are addressed by number
+const dashProfiles = "urn:mpeg:dash:profile:isoff-live:2011,urn:mpeg:dash:profile:cmaf:2019"
+
+// Segment timelines are in milliseconds
+const dashTimescale = 1000
+
+// Audio codec of the renditions when the master playlist does not say, AAC-LC
+const defaultAudioCodecs = "mp4a.40.2"
+
+// dashManifest is the DASH MPD of a CMAF output. It lists the segments of the HLS media playlists,
+// the media of every FFmpeg run is a period of its own.
+type dashManifest struct {
+	segmentTarget  float64
+	audioBandwidth int // Bits per second, the master playlist only has it for the video variants
+
+	availabilityStart time.Time // When the first segment started, zero before
+	periods           []dashPeriod
+}
+
+type dashPeriod struct {
+	run   int
+	start float64 // Seconds after the availability start
+}
+
+// dashVariant is a media playlist named by the master playlist, a representation in the manifest
+type dashVariant struct {
+	playlist  string
+	audio     bool
+	bandwidth int
+	width     int
+	height    int
+	codecs    string
+}
+
+// MPD elements of the manifest, as far as it uses them
+type mpd struct {
+	XMLName                    xml.Name    `xml:"urn:mpeg:dash:schema:mpd:2011 MPD"`
+	Profiles                   string      `xml:"profiles,attr"`
+	Type                       string      `xml:"type,attr"`
+	AvailabilityStartTime      string      `xml:"availabilityStartTime,attr"`
+	PublishTime                string      `xml:"publishTime,attr"`
+	MinimumUpdatePeriod        string      `xml:"minimumUpdatePeriod,attr,omitempty"`
+	MediaPresentationDuration  string      `xml:"mediaPresentationDuration,attr,omitempty"`
+	MinBufferTime              string      `xml:"minBufferTime,attr"`
+	TimeShiftBufferDepth       string      `xml:"timeShiftBufferDepth,attr"`
+	SuggestedPresentationDelay string      `xml:"suggestedPresentationDelay,attr"`
+	Periods                    []mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	Id             string             `xml:"id,attr"`
+	Start          string             `xml:"start,attr"`
+	AdaptationSets []mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	Id               int                 `xml:"id,attr"`
+	ContentType      string              `xml:"contentType,attr"`
+	MimeType         string              `xml:"mimeType,attr"`
+	SegmentAlignment bool                `xml:"segmentAlignment,attr"`
+	StartWithSAP     int                 `xml:"startWithSAP,attr"`
+	Representations  []mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	Id              string             `xml:"id,attr"`
+	Bandwidth       int                `xml:"bandwidth,attr"`
+	Codecs          string             `xml:"codecs,attr,omitempty"`
+	Width           int                `xml:"width,attr,omitempty"`
+	Height          int                `xml:"height,attr,omitempty"`
+	SegmentTemplate mpdSegmentTemplate `xml:"SegmentTemplate"`
+}
+
+type mpdSegmentTemplate struct {
+	Timescale      int          `xml:"timescale,attr"`
+	Initialization string       `xml:"initialization,attr"`
+	Media          string       `xml:"media,attr"`
+	StartNumber    int          `xml:"startNumber,attr"`
+	Timeline       []mpdSegment `xml:"SegmentTimeline>S"`
+}
+
+type mpdSegment struct {
+	T int64 `xml:"t,attr"`
+	D int64 `xml:"d,attr"`
+}
+
+// startPeriod starts the period of the current run at its first segment. Periods follow the wall clock,
+// players work out the live edge from it. Must be called with o.mu held.
+func (o *hlsOutput) startPeriod(duration float64) {
+	m := o.dash
+	n := len(m.periods)
+	if n > 0 && m.periods[n-1].run == o.run {
+		return
+	}
+
+	// The segment was just written, it started its duration ago
+	now := time.Now()
+	if m.availabilityStart.IsZero() {
+		m.availabilityStart = now.Add(-time.Duration(duration * float64(time.Second)))
+	}
+	start := now.Sub(m.availabilityStart).Seconds() - duration
+	if n > 0 {
+		start = max(start, m.periods[n-1].start+o.periodDuration(m.periods[n-1].run))
+	}
+	m.periods = append(m.periods, dashPeriod{run: o.run, start: start})
+}
+
+// periodDuration is how much media of the run the playlists have
+func (o *hlsOutput) periodDuration(run int) float64 {
+	duration := 0.0
+	for _, pl := range o.playlists {
+		for _, seg := range pl.segments {
+			if seg.run == run {
+				duration = max(duration, seg.start+seg.duration)
+			}
+		}
+	}
+	return duration
+}
+
+// writeManifest publishes the DASH manifest, once the master playlist names what is in it. Must be called with o.mu held.
+func (o *hlsOutput) writeManifest() {
+	master := ""
+	for name := range o.masters {
+		master = name
+	}
+	if master == "" || o.dash.availabilityStart.IsZero() {
+		return
+	}
+
+	// Next to the master playlist, <stream_id>.mpd
+	name := strings.TrimSuffix(master, "_master.m3u8") + ".mpd"
+	data, err := o.renderManifest(masterVariants(o.masters[master], o.dash.audioBandwidth))
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(o.dir, name), data)
+	}
+	if err != nil {
+		slog.Error("Failed to write DASH manifest", "path", filepath.Join(o.dir, name), "error", err)
+	}
+}
+
+// renderManifest lists the segments of the variants' playlists, by period
+func (o *hlsOutput) renderManifest(variants []dashVariant) ([]byte, error) {
+	m := o.dash
+	target := m.segmentTarget
+	for _, pl := range o.playlists {
+		target = max(target, float64(pl.target))
+	}
+
+	manifest := mpd{
+		Profiles:                   dashProfiles,
+		Type:                       "dynamic",
+		AvailabilityStartTime:      m.availabilityStart.UTC().Format(time.RFC3339Nano),
+		PublishTime:                time.Now().UTC().Format(time.RFC3339Nano),
+		MinBufferTime:              xsDuration(target),
+		TimeShiftBufferDepth:       xsDuration(hlsListSize * target),
+		SuggestedPresentationDelay: xsDuration(3 * target),
+	}
+
+	// Periods whose segments have all been dropped are left out, for good
+	current := m.periods[len(m.periods)-1].run
+	m.periods = slices.DeleteFunc(m.periods, func(period dashPeriod) bool {
+		return period.run != current && o.periodDuration(period.run) == 0
+	})
+
+	end := 0.0
+	for _, period := range m.periods {
+		sets := []mpdAdaptationSet{
+			{Id: 0, ContentType: "video", MimeType: "video/mp4", SegmentAlignment: true, StartWithSAP: 1},
+			{Id: 1, ContentType: "audio", MimeType: "audio/mp4", SegmentAlignment: true, StartWithSAP: 1},
+		}
+		for _, variant := range variants {
+			pl := o.playlists[variant.playlist]
+			if pl == nil {
+				continue
+			}
+			template, ok := segmentTemplate(pl, period.run)
+			if !ok {
+				continue
+			}
+
+			set := &sets[0]
+			if variant.audio {
+				set = &sets[1]
+			}
+			set.Representations = append(set.Representations, mpdRepresentation{
+				Id:              pl.base(),
+				Bandwidth:       variant.bandwidth,
+				Codecs:          variant.codecs,
+				Width:           variant.width,
+				Height:          variant.height,
+				SegmentTemplate: template,
+			})
+		}
+
+		sets = slices.DeleteFunc(sets, func(set mpdAdaptationSet) bool { return len(set.Representations) == 0 })
+		if len(sets) == 0 {
+			continue
+		}
+		manifest.Periods = append(manifest.Periods, mpdPeriod{
+			Id:             strconv.Itoa(period.run),
+			Start:          xsDuration(period.start),
+			AdaptationSets: sets,
+		})
+		end = period.start + o.periodDuration(period.run)
+	}
+
+	// A manifest that is not updated anymore ends the presentation
+	if o.ended {
+		manifest.MediaPresentationDuration = xsDuration(end)
+	} else {
+		manifest.MinimumUpdatePeriod = xsDuration(target)
+	}
+
+	data, err := xml.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// segmentTemplate addresses the segments of the run in the playlist by number, reports whether it has any
+func segmentTemplate(pl *stitchedPlaylist, run int) (mpdSegmentTemplate, bool) {
+	var template mpdSegmentTemplate
+	for _, seg := range pl.segments {
+		if seg.run != run {
+			continue
+		}
+
+		if len(template.Timeline) == 0 {
+			name := strings.TrimSuffix(path.Base(seg.uri), ".m4s")
+			i := strings.LastIndex(name, "_")
+			number, err := strconv.Atoi(name[i+1:])
+			if i < 0 || err != nil {
+				return template, false
+			}
+			template = mpdSegmentTemplate{
+				Timescale:      dashTimescale,
+				Initialization: seg.init,
+				Media:          name[:i+1] + "$Number%03d$.m4s",
+				StartNumber:    number,
+			}
+		}
+
+		// Both ends are rounded, so the segments add up to the run without gaps
+		start := int64(math.Round(seg.start * dashTimescale))
+		end := int64(math.Round((seg.start + seg.duration) * dashTimescale))
+		template.Timeline = append(template.Timeline, mpdSegment{T: start, D: end - start})
+	}
+	return template, len(template.Timeline) > 0
+}
+
+// masterVariants reads the media playlists a master playlist names. The video variants' codecs
+// include the audio's, that is where the audio rendition gets them from.
+func masterVariants(data []byte, audioBandwidth int) []dashVariant {
+	var variants []dashVariant
+	var audio []string
+	var audioCodecs string
+	var streamInf map[string]string
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		tag, value, _ := strings.Cut(line, ":")
+		switch {
+		case tag == "#EXT-X-MEDIA":
+			if attributes := parseAttributes(value); attributes["TYPE"] == "AUDIO" && attributes["URI"] != "" {
+				audio = append(audio, path.Base(attributes["URI"]))
+			}
+		case tag == "#EXT-X-STREAM-INF":
+			streamInf = parseAttributes(value)
+		case line != "" && !strings.HasPrefix(line, "#") && streamInf != nil:
+			variant := dashVariant{playlist: path.Base(line)}
+			variant.bandwidth, _ = strconv.Atoi(streamInf["BANDWIDTH"])
+			fmt.Sscanf(streamInf["RESOLUTION"], "%dx%d", &variant.width, &variant.height)
+
+			var video []string
+			for _, codec := range strings.Split(streamInf["CODECS"], ",") {
+				if strings.HasPrefix(codec, "mp4a") {
+					audioCodecs = codec
+				} else if codec != "" {
+					video = append(video, codec)
+				}
+			}
+			variant.codecs = strings.Join(video, ",")
+
+			// An audio-only source is a single variant of its own
+			if len(video) == 0 && variant.width == 0 {
+				variant.audio = true
+				variant.codecs = cmp.Or(audioCodecs, defaultAudioCodecs)
+			}
+			variants = append(variants, variant)
+			streamInf = nil
+		}
+	}
+
+	for _, playlist := range audio {
+		variants = append(variants, dashVariant{
+			playlist:  playlist,
+			audio:     true,
+			bandwidth: audioBandwidth,
+			codecs:    cmp.Or(audioCodecs, defaultAudioCodecs),
+		})
+	}
+	return variants
+}
+
+// xsDuration is a duration of the manifest, in seconds
+func xsDuration(seconds float64) string {
+	return "PT" + formatDuration(seconds) + "S"
+}
@@ -0,0 +1,127 @@
+package ingest
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// Master playlist FFmpeg writes for two video variants sharing an audio group
+const cmafMaster = `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="group_audio",NAME="audio_0",DEFAULT=YES,URI="s_2.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=3440800,RESOLUTION=1280x720,CODECS="avc1.64001f,mp4a.40.2",AUDIO="group_audio"
+s_0.m3u8
+
+#EXT-X-STREAM-INF:BANDWIDTH=1020800,RESOLUTION=640x360,CODECS="avc1.64001e,mp4a.40.2",AUDIO="group_audio"
+s_1.m3u8
+
+`
+
+// cmafRun writes what an FFmpeg run of the variants s_0, s_1 and s_2 wrote so far, numbered from first
+func cmafRun(t *testing.T, o *hlsOutput, first int, durations ...float64) {
+	t.Helper()
+
+	write := func(path string, data string) {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(o.runDir, "s_master.m3u8"), cmafMaster)
+
+	for _, variant := range []string{"s_0", "s_1", "s_2"} {
+		write(filepath.Join(o.runDir, variant+"_init.mp4"), "init")
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:%d\n#EXT-X-INDEPENDENT-SEGMENTS\n", first)
+		fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"%s_init.mp4\"\n", variant)
+		for i, duration := range durations {
+			name := fmt.Sprintf("%s_%03d.m4s", variant, first+i)
+			write(filepath.Join(o.dir, name), "m4s")
+			fmt.Fprintf(&b, "#EXTINF:%f,\n%s\n", duration, name)
+		}
+		write(filepath.Join(o.runDir, variant+".m3u8"), b.String())
+	}
+
+	o.mu.Lock()
+	o.sync()
+	o.mu.Unlock()
+}
+
+// Times of the manifest that follow the wall clock
+var manifestClock = regexp.MustCompile(`(availabilityStartTime|publishTime)="[^"]*"`)
+
+// checkGolden compares the manifest o published with testdata/name, -update rewrites it
+func checkGolden(t *testing.T, o *hlsOutput, name string) {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(o.dir, "s.mpd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := manifestClock.ReplaceAll(data, []byte(`$1="CLOCK"`))
+
+	golden := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s\n got:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+// The manifest lists the same segments as the HLS playlists, every run of FFmpeg a period of its own
+func TestDashManifest(t *testing.T) {
+	profile := &transcode.Profile{Audio: transcode.Audio{BitrateKbps: 128}, Packaging: transcode.Packaging{Format: transcode.FormatCMAF, SegmentSeconds: 4}}
+	o := newHlsOutput(t.TempDir(), profile)
+
+	_, start, err := o.startRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmafRun(t, o, start, 4, 4, 3.2)
+	checkGolden(t, o, "dash_live.mpd")
+
+	// The publisher reconnected
+	o.endRun()
+	_, start, err = o.startRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmafRun(t, o, start, 4, 2.5)
+	o.endRun()
+	o.finish()
+	checkGolden(t, o, "dash_ended.mpd")
+}
+
+func TestMasterVariants(t *testing.T) {
+	variants := masterVariants([]byte(cmafMaster), 128000)
+	want := []dashVariant{
+		{playlist: "s_0.m3u8", bandwidth: 3440800, width: 1280, height: 720, codecs: "avc1.64001f"},
+		{playlist: "s_1.m3u8", bandwidth: 1020800, width: 640, height: 360, codecs: "avc1.64001e"},
+		{playlist: "s_2.m3u8", audio: true, bandwidth: 128000, codecs: "mp4a.40.2"},
+	}
+	if fmt.Sprint(variants) != fmt.Sprint(want) {
+		t.Errorf("masterVariants = %+v, want %+v", variants, want)
+	}
+
+	// An audio-only source is a single variant without a resolution
+	audioOnly := "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=140800,CODECS=\"mp4a.40.2\"\ns_0.m3u8\n"
+	if variants := masterVariants([]byte(audioOnly), 128000); len(variants) != 1 || !variants[0].audio || variants[0].codecs != "mp4a.40.2" {
+		t.Errorf("masterVariants of an audio-only source = %+v", variants)
+	}
+}
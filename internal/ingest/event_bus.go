@@ -0,0 +1,134 @@
+package ingest
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy decides what happens when a subscriber's buffer is full
+type OverflowPolicy int
+
+const (
+	// DropNewest discards the event being published
+	DropNewest OverflowPolicy = iota
+	// DropOldest evicts the oldest buffered event to make room
+	DropOldest
+)
+
+// EventBus fans out task events to subscribers without ever blocking the publisher
+type EventBus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+type Subscription struct {
+	name    string
+	policy  OverflowPolicy
+	filter  func(Event) bool
+	spill   func(Event) // Takes what overflows the buffer instead of it being dropped
+	mu      sync.Mutex
+	ch      chan Event
+	dropped atomic.Uint64
+	bus     *EventBus
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a bounded subscriber. A nil filter receives every event.
+func (b *EventBus) Subscribe(name string, buffer int, policy OverflowPolicy, filter func(Event) bool) *Subscription {
+	sub := &Subscription{
+		name:   name,
+		policy: policy,
+		filter: filter,
+		ch:     make(chan Event, buffer),
+		bus:    b,
+	}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub
+}
+
+// SubscribeSpill registers a bounded subscriber that hands the events its buffer cannot take to spill
+// instead of dropping them. spill runs on the publisher's goroutine and must never block.
+func (b *EventBus) SubscribeSpill(name string, buffer int, filter func(Event) bool, spill func(Event)) *Subscription {
+	sub := b.Subscribe(name, buffer, DropNewest, filter)
+	sub.spill = spill
+	return sub
+}
+
+// Publish delivers event to every matching subscriber, applying their overflow policy when full
+func (b *EventBus) Publish(event Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs {
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
+		sub.offer(event)
+	}
+}
+
+func (sub *Subscription) offer(event Event) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	select {
+	case sub.ch <- event:
+		return
+	default:
+	}
+
+	if sub.spill != nil {
+		sub.spill(event)
+		return
+	}
+
+	if sub.policy == DropOldest {
+		select {
+		case <-sub.ch:
+		default:
+		}
+		select {
+		case sub.ch <- event:
+		default:
+		}
+	}
+
+	dropped := sub.dropped.Add(1)
+	slog.Warn("Event subscriber overflow, event dropped",
+		"subscriber", sub.name,
+		"stream_id", event.StreamId,
+		"type", event.Type,
+		"dropped_total", dropped,
+	)
+}
+
+// Events is closed once the subscription is closed, events buffered before that are still delivered
+func (sub *Subscription) Events() <-chan Event {
+	return sub.ch
+}
+
+// Dropped returns how many events overflowed this subscriber's buffer
+func (sub *Subscription) Dropped() uint64 {
+	return sub.dropped.Load()
+}
+
+func (sub *Subscription) Close() {
+	sub.bus.mu.Lock()
+	defer sub.bus.mu.Unlock()
+
+	if _, exists := sub.bus.subs[sub]; !exists {
+		return
+	}
+	delete(sub.bus.subs, sub)
+	close(sub.ch)
+}
@@ -0,0 +1,140 @@
+package ingest
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func numbered(n int) Event {
+	return Event{EventId: fmt.Sprintf("evt_%d", n), Type: EventStreamStats, StreamId: "bus"}
+}
+
+// drain returns the ids of the buffered events
+func drain(sub *Subscription) []string {
+	var ids []string
+	for {
+		select {
+		case event := <-sub.Events():
+			ids = append(ids, event.EventId)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestEventBusOverflow(t *testing.T) {
+	tests := []struct {
+		policy OverflowPolicy
+		want   []string
+	}{
+		{DropNewest, []string{"evt_0", "evt_1", "evt_2"}},
+		{DropOldest, []string{"evt_7", "evt_8", "evt_9"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.policy), func(t *testing.T) {
+			bus := NewEventBus()
+			sub := bus.Subscribe("slow", 3, tt.policy, nil)
+			defer sub.Close()
+
+			for i := range 10 {
+				bus.Publish(numbered(i))
+			}
+			if got := drain(sub); !slices.Equal(got, tt.want) {
+				t.Errorf("buffered = %v, want %v", got, tt.want)
+			}
+			if sub.Dropped() != 7 {
+				t.Errorf("Dropped = %d, want 7", sub.Dropped())
+			}
+		})
+	}
+}
+
+// What does not fit the buffer of a spilling subscriber is handed over, not dropped
+func TestEventBusSpill(t *testing.T) {
+	bus := NewEventBus()
+	var spilled []string
+	sub := bus.SubscribeSpill("webhooks", 3, nil, func(event Event) { spilled = append(spilled, event.EventId) })
+	defer sub.Close()
+
+	for i := range 10 {
+		bus.Publish(numbered(i))
+	}
+	if got := drain(sub); !slices.Equal(got, []string{"evt_0", "evt_1", "evt_2"}) {
+		t.Errorf("buffered = %v, want the first 3", got)
+	}
+	if len(spilled) != 7 || spilled[0] != "evt_3" || sub.Dropped() != 0 {
+		t.Errorf("spilled %v and dropped %d, want the other 7 spilled", spilled, sub.Dropped())
+	}
+}
+
+// A subscriber nobody reads from never holds up the publisher or the other subscribers
+func TestEventBusNeverBlocks(t *testing.T) {
+	bus := NewEventBus()
+	stalled := bus.Subscribe("stalled", 1, DropNewest, nil)
+	defer stalled.Close()
+	reader := bus.Subscribe("reader", 1000, DropNewest, nil)
+	defer reader.Close()
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		var wg sync.WaitGroup
+		for p := range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range 250 {
+					bus.Publish(numbered(p*250 + i))
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked on a stalled subscriber")
+	}
+	if got := len(drain(reader)); got != 1000 {
+		t.Errorf("reader got %d events, want 1000", got)
+	}
+	if stalled.Dropped() != 999 {
+		t.Errorf("stalled subscriber dropped %d events, want 999", stalled.Dropped())
+	}
+}
+
+func TestEventBusFilter(t *testing.T) {
+	bus := NewEventBus()
+	sub := bus.Subscribe("live", 10, DropNewest, func(event Event) bool { return event.Type == EventStreamLive })
+	defer sub.Close()
+
+	bus.Publish(Event{EventId: "evt_stats", Type: EventStreamStats})
+	bus.Publish(Event{EventId: "evt_live", Type: EventStreamLive})
+	if got := drain(sub); !slices.Equal(got, []string{"evt_live"}) {
+		t.Errorf("filtered = %v, want only evt_live", got)
+	}
+}
+
+// Events buffered before Close are still delivered, later ones are not and Close can be repeated
+func TestEventBusClose(t *testing.T) {
+	bus := NewEventBus()
+	sub := bus.Subscribe("closing", 10, DropOldest, nil)
+
+	bus.Publish(numbered(1))
+	sub.Close()
+	sub.Close()
+	bus.Publish(numbered(2))
+
+	var got []string
+	for event := range sub.Events() {
+		got = append(got, event.EventId)
+	}
+	if !slices.Equal(got, []string{"evt_1"}) {
+		t.Errorf("after Close = %v, want evt_1", got)
+	}
+}
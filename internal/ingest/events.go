@@ -0,0 +1,107 @@
+package ingest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Bumped whenever the envelope or a payload changes in a breaking way
+const EventSchemaVersion = "1"
+
+type EventType string
+
+const (
+	EventStreamReady           EventType = "stream.ready"
+	EventPublisherConnected    EventType = "stream.publisher_connected"
+	EventStreamLive            EventType = "stream.live"
+	EventPublisherDisconnected EventType = "stream.publisher_disconnected"
+	EventPublisherRejected     EventType = "stream.publisher_rejected"
+	EventInputSwitched         EventType = "stream.input_switched"
+	EventGraceExpired          EventType = "stream.grace_expired"
+	EventStreamEnding          EventType = "stream.ending"
+	EventStreamStopped         EventType = "stream.stopped"
+	EventStreamFailed          EventType = "stream.failed"
+
+	// Only sent on live status streams (SSE / WebSocket), never published on the bus
+	EventStreamStats EventType = "stream.stats"
+)
+
+// Event is the envelope delivered to webhooks for every status update
+type Event struct {
+	Version   string      `json:"version"`
+	EventId   string      `json:"event_id"`
+	Type      EventType   `json:"type"`
+	StreamId  string      `json:"stream_id"`
+	Status    StreamState `json:"status"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      any         `json:"data"`
+
+	// Webhooks configured on the task when the event was emitted
+	webhooks []string
+}
+
+// Payload of stream.ready
+type ReadyData struct {
+	IngestURL   string    `json:"ingest_url"`
+	KeyExpiry   time.Time `json:"key_expiry"`
+	PlaybackURL string    `json:"playback_url,omitempty"`
+}
+
+// Payload of stream.publisher_connected, stream.publisher_disconnected and stream.grace_expired
+type PublisherData struct {
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	Role       string `json:"role,omitempty"` // primary or backup, SRT only
+	Reason     string `json:"reason,omitempty"`
+	// Set on disconnects within the reconnect grace window, the stream stays LIVE this long
+	GraceSeconds int `json:"grace_seconds,omitempty"`
+}
+
+// Payload of stream.input_switched, sent when the backup publisher takes over or hands back to the primary
+type InputSwitchedData struct {
+	Active     string `json:"active"`
+	Previous   string `json:"previous"`
+	RemoteAddr string `json:"remote_addr"`
+	Reason     string `json:"reason"`
+}
+
+// Payload of stream.publisher_rejected, sent when an SRT handshake is turned down
+type RejectedData struct {
+	RemoteAddr string `json:"remote_addr"`
+	Code       string `json:"code"`
+	Reason     string `json:"reason"`
+}
+
+// Payload of stream.live
+type LiveData struct {
+	PlaybackURL string `json:"playback_url"`
+}
+
+// Payload of stream.ending and stream.stopped
+type StoppedData struct {
+	Reason string `json:"reason"`
+}
+
+// Payload of stream.failed
+type FailedData struct {
+	Error string `json:"error"`
+}
+
+func newEvent(task *Task, eventType EventType, status StreamState, data any) Event {
+	return Event{
+		Version:   EventSchemaVersion,
+		EventId:   newEventId(),
+		Type:      eventType,
+		StreamId:  task.Id,
+		Status:    status,
+		Timestamp: time.Now().UTC(),
+		Data:      data,
+		webhooks:  task.Webhooks,
+	}
+}
+
+func newEventId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return "evt_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,477 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+	"github.com/vijayvenkatj/LiveTran/internal/auth"
+)
+
+// Roles of the publishers of an SRT stream, picked with role= in the streamid
+const (
+	RolePrimary = "primary"
+	RoleBackup  = "backup"
+)
+
+const (
+	// How long the active input may go without data before the other one takes over
+	failoverStallTimeout = 1500 * time.Millisecond
+	// How long a recovered primary has to deliver before it takes over from the backup again
+	failbackAfter = 5 * time.Second
+	// How often the inputs are checked while the active one delivers nothing
+	failoverCheckInterval = 250 * time.Millisecond
+)
+
+// Chunks an input may read ahead of FFmpeg
+const failoverBuffer = 32
+
+var errNoInputs = errors.New("primary and backup publishers are gone")
+
+// publisherRole validates the role a stream key asks for, publishers naming none are the primary
+func publisherRole(streamkey string) (string, error) {
+	switch role := auth.StreamKeyRole(streamkey); role {
+	case "", RolePrimary:
+		return RolePrimary, nil
+	case RoleBackup:
+		return RoleBackup, nil
+	default:
+		return "", fmt.Errorf("unknown role %q", role)
+	}
+}
+
+// supportsFailover reports whether the task takes a backup publisher next to its primary
+func supportsFailover(task *Task) bool {
+	return task.Protocol == ProtocolSRT && task.Pull == nil
+}
+
+// failoverPublisher feeds FFmpeg from the primary publisher of a stream and switches to the
+// backup while the primary stalls or is gone. The input taking over is only handed to FFmpeg from
+// its next keyframe on, led by its PAT and PMT, so the decoder restarts cleanly on the other encoder.
+// Until then FFmpeg gets nothing, a switch leaves a gap of up to a GOP and a timestamp jump.
+type failoverPublisher struct {
+	task *Task
+
+	mu       sync.Mutex
+	inputs   map[string]*failoverInput
+	active   *failoverInput
+	switched chan struct{} // Closed and replaced whenever the active input changes
+	lastErr  error
+	finished bool
+
+	attached chan struct{}
+	closed   chan struct{} // Closed once the stream has no inputs left or is closed
+	stopOnce sync.Once
+	once     sync.Once
+
+	pending []byte
+}
+
+// failoverInput is one publisher of a failover stream and the reader draining it
+type failoverInput struct {
+	role string
+	pub  publisher
+
+	chunks chan []byte
+	done   chan struct{} // Closed once the publisher is gone, err says why
+	err    error
+
+	ts      *tsSwitchPoint // Only used by the reader of the input
+	cutover bool           // Took over from another input and waits for a switch point, guarded by fp.mu
+
+	lastData      atomic.Int64 // Unix nanoseconds
+	deliversSince atomic.Int64 // Start of the current run without stalls
+
+	// Packet counts of the connection when it last became active, the stream's stats count from there.
+	// Guarded by fp.mu.
+	baseline srt.StatisticsAccumulated
+}
+
+func newFailoverPublisher(task *Task, first publisher) *failoverPublisher {
+	fp := &failoverPublisher{
+		task:     task,
+		inputs:   make(map[string]*failoverInput),
+		switched: make(chan struct{}),
+		attached: make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+	}
+	fp.attach(first)
+	return fp
+}
+
+// acceptInputs attaches the publishers that connect while the stream runs, until ctx is done.
+// Once the last input is gone publishers are left to the next accept, which starts a new FFmpeg run.
+func (fp *failoverPublisher) acceptInputs(ctx context.Context, source ingestSource) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-fp.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		pub, err := source.accept(ctx)
+		if err != nil {
+			return
+		}
+		if !fp.attach(pub) {
+			pub.Close()
+		}
+	}
+}
+
+// attach adds pub as the input of its role. It is refused when the role is taken or the stream is over.
+func (fp *failoverPublisher) attach(pub publisher) bool {
+	role := roleOf(pub)
+	if role == "" {
+		role = RolePrimary
+	}
+
+	fp.mu.Lock()
+	if fp.finished {
+		fp.mu.Unlock()
+		slog.Warn("Rejected SRT publisher", "stream_id", fp.task.Id, "remote_addr", pub.RemoteAddr(), "role", role, "reason", "stream input closed")
+		return false
+	}
+	if fp.inputs[role] != nil {
+		fp.mu.Unlock()
+		slog.Warn("Rejected SRT publisher", "stream_id", fp.task.Id, "remote_addr", pub.RemoteAddr(), "role", role, "reason", "role already taken")
+		fp.task.Emit(EventPublisherRejected, RejectedData{
+			RemoteAddr: pub.RemoteAddr(),
+			Code:       RejectRoleTaken,
+			Reason:     fmt.Sprintf("A %s publisher is already connected", role),
+		})
+		return false
+	}
+
+	in := &failoverInput{
+		role:   role,
+		pub:    pub,
+		chunks: make(chan []byte, failoverBuffer),
+		done:   make(chan struct{}),
+		ts:     newTsSwitchPoint(),
+	}
+	fp.inputs[role] = in
+	first := len(fp.inputs) == 1 && fp.active == nil
+	fp.mu.Unlock()
+
+	go fp.read(in)
+
+	// The first input is reported by handleStream along with the state change
+	if !first {
+		fp.task.Emit(EventPublisherConnected, PublisherData{RemoteAddr: pub.RemoteAddr(), Role: role})
+	}
+
+	select {
+	case fp.attached <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// read drains an input, its chunks only reach FFmpeg while it is the active one
+func (fp *failoverPublisher) read(in *failoverInput) {
+	defer close(in.done)
+
+	buf := make([]byte, 8*1316)
+	for {
+		n, err := in.pub.Read(buf)
+		if err != nil {
+			in.err = err
+			return
+		}
+
+		now := time.Now().UnixNano()
+		if now-in.lastData.Load() > int64(failoverStallTimeout) {
+			in.deliversSince.Store(now)
+		}
+		in.lastData.Store(now)
+
+		chunk := append([]byte(nil), buf[:n]...)
+		in.ts.observe(chunk)
+		for {
+			fp.mu.Lock()
+			active, switched := fp.active == in, fp.switched
+			if active && in.cutover {
+				if cut, ok := in.ts.cut(chunk); ok {
+					chunk, in.cutover = cut, false
+				} else {
+					active = false // Dropped up to the next keyframe
+				}
+			}
+			fp.mu.Unlock()
+			if !active {
+				break // Only keeps the connection alive
+			}
+
+			select {
+			case in.chunks <- chunk:
+			case <-switched:
+				continue
+			case <-fp.closed:
+				return
+			}
+			break
+		}
+	}
+}
+
+func (fp *failoverPublisher) Read(p []byte) (int, error) {
+	if len(fp.pending) > 0 {
+		n := copy(p, fp.pending)
+		fp.pending = fp.pending[n:]
+		return n, nil
+	}
+
+	ticker := time.NewTicker(failoverCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		active, err := fp.pickActive()
+		if err != nil {
+			return 0, err
+		}
+
+		select {
+		case chunk := <-active.chunks:
+			n := copy(p, chunk)
+			fp.pending = chunk[n:]
+			return n, nil
+		case <-active.done:
+		case <-fp.attached:
+		case <-ticker.C:
+		case <-fp.closed:
+			return 0, io.ErrClosedPipe
+		}
+	}
+}
+
+// pickActive drops the inputs that are gone and decides which one feeds FFmpeg
+func (fp *failoverPublisher) pickActive() (*failoverInput, error) {
+	fp.mu.Lock()
+
+	var gone []*failoverInput
+	for role, in := range fp.inputs {
+		select {
+		case <-in.done:
+			delete(fp.inputs, role)
+			gone = append(gone, in)
+			fp.lastErr = in.err
+		default:
+		}
+	}
+
+	now := time.Now()
+	healthy := func(in *failoverInput) bool {
+		return in != nil && now.Sub(time.Unix(0, in.lastData.Load())) < failoverStallTimeout
+	}
+	primary, backup := fp.inputs[RolePrimary], fp.inputs[RoleBackup]
+	previous := fp.active
+	if previous != nil && fp.inputs[previous.role] != previous {
+		previous = nil
+	}
+
+	next, reason := previous, ""
+	switch {
+	case previous == nil:
+		// Nothing to fall back from, take whichever input is there, the primary first
+		next = primary
+		if next == nil || (!healthy(primary) && healthy(backup)) {
+			next = backup
+		}
+	case previous == backup && healthy(primary) && now.Sub(time.Unix(0, primary.deliversSince.Load())) >= failbackAfter:
+		next, reason = primary, "Primary publisher recovered"
+	case !healthy(previous):
+		if other := fp.inputs[otherRole(previous.role)]; healthy(other) {
+			next, reason = other, fmt.Sprintf("The %s publisher stalled", previous.role)
+		}
+	}
+	if fp.active != nil && previous == nil && next != nil {
+		reason = fmt.Sprintf("The %s publisher disconnected", fp.active.role)
+	}
+
+	// Without inputs the last active one is kept, it is what the stream reports as gone
+	changed := next != nil && next != fp.active
+	from := fp.active
+	if changed {
+		fp.activate(next)
+	}
+	if next == nil {
+		fp.finished = true
+	}
+	lastErr := fp.lastErr
+	fp.mu.Unlock()
+
+	// The last input going away is reported by handleStream with the state change
+	for _, in := range gone {
+		if next != nil {
+			fp.task.Emit(EventPublisherDisconnected, PublisherData{RemoteAddr: in.pub.RemoteAddr(), Role: in.role, Reason: fmt.Sprintf("%s read error: %v", ProtocolSRT, in.err)})
+		}
+		in.pub.Close()
+	}
+
+	if next == nil {
+		fp.stop()
+		if lastErr == nil {
+			lastErr = errNoInputs
+		}
+		return nil, lastErr
+	}
+
+	if changed {
+		fp.task.setActiveInput(next.role)
+		if from != nil {
+			slog.Info("Switched stream input", "stream_id", fp.task.Id, "from", from.role, "to", next.role, "reason", reason)
+			fp.task.Emit(EventInputSwitched, InputSwitchedData{
+				Active:     next.role,
+				Previous:   from.role,
+				RemoteAddr: next.pub.RemoteAddr(),
+				Reason:     reason,
+			})
+		}
+	}
+	return next, nil
+}
+
+// activate makes in the input FFmpeg reads from. Must be called with fp.mu held.
+func (fp *failoverPublisher) activate(in *failoverInput) {
+	if fp.active != nil {
+		// The stream's SRT stats carry on from the new connection
+		fp.task.stats.mu.Lock()
+		fp.task.stats.endSrt()
+		fp.task.stats.mu.Unlock()
+	}
+
+	in.cutover = fp.active != nil
+	fp.active = in
+	close(fp.switched)
+	fp.switched = make(chan struct{})
+
+	// Whatever was buffered before it lost its turn is stale by now
+	for drained := false; !drained; {
+		select {
+		case <-in.chunks:
+		default:
+			drained = true
+		}
+	}
+	if s, ok := in.pub.(srtStatsSource); ok {
+		in.baseline = s.srtStatistics().Accumulated
+	}
+}
+
+// stop tells the readers and acceptInputs that the stream takes no more input
+func (fp *failoverPublisher) stop() {
+	fp.stopOnce.Do(func() {
+		close(fp.closed)
+	})
+}
+
+func (fp *failoverPublisher) Close() error {
+	fp.once.Do(func() {
+		fp.stop()
+
+		fp.mu.Lock()
+		fp.finished = true
+		inputs := make([]*failoverInput, 0, len(fp.inputs))
+		for _, in := range fp.inputs {
+			inputs = append(inputs, in)
+		}
+		fp.mu.Unlock()
+
+		for _, in := range inputs {
+			in.pub.Close()
+		}
+	})
+	return nil
+}
+
+// RemoteAddr is the address of the input feeding FFmpeg
+func (fp *failoverPublisher) RemoteAddr() string {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if fp.active != nil {
+		return fp.active.pub.RemoteAddr()
+	}
+	for _, in := range fp.inputs {
+		return in.pub.RemoteAddr()
+	}
+	return ""
+}
+
+func (fp *failoverPublisher) InputArgs() []string {
+	return []string{
+		"-dts_delta_threshold", "1", // Rebase the timestamps of the other encoder when switching inputs
+		"-f", "mpegts", "-i", "pipe:0",
+	}
+}
+
+// srtStatistics are those of the active input, counted from when it became active
+func (fp *failoverPublisher) srtStatistics() srt.Statistics {
+	fp.mu.Lock()
+	in := fp.active
+	var baseline srt.StatisticsAccumulated
+	if in != nil {
+		baseline = in.baseline
+	}
+	fp.mu.Unlock()
+
+	if in == nil {
+		return srt.Statistics{}
+	}
+	source, ok := in.pub.(srtStatsSource)
+	if !ok {
+		return srt.Statistics{}
+	}
+
+	s := source.srtStatistics()
+	s.Accumulated.PktRecv -= baseline.PktRecv
+	s.Accumulated.PktRecvLoss -= baseline.PktRecvLoss
+	s.Accumulated.PktRecvRetrans -= baseline.PktRecvRetrans
+	s.Accumulated.PktRecvDrop -= baseline.PktRecvDrop
+	return s
+}
+
+// role is the role of the input feeding FFmpeg
+func (fp *failoverPublisher) role() string {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if fp.active != nil {
+		return fp.active.role
+	}
+	return RolePrimary
+}
+
+// roleOf is the role pub publishes in, empty for protocols without backup publishers
+func roleOf(pub publisher) string {
+	if r, ok := pub.(interface{ role() string }); ok {
+		return r.role()
+	}
+	return ""
+}
+
+func otherRole(role string) string {
+	if role == RolePrimary {
+		return RoleBackup
+	}
+	return RolePrimary
+}
+
+// setActiveInput records which publisher feeds the stream, empty once none does
+func (task *Task) setActiveInput(role string) {
+	task.mu.Lock()
+	defer task.mu.Unlock()
+
+	task.activeInput = role
+}
@@ -0,0 +1,212 @@
+package ingest
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"testing"
+	"time"
+)
+
+const (
+	testPmtPid   = 0x1000
+	testVideoPid = 0x100
+	testAudioPid = 0x101
+)
+
+// tsPacket pads a packet of pid to 188 bytes, with an adaptation field when rai is set
+func tsPacket(pid int, start bool, rai bool, payload []byte) []byte {
+	pkt := []byte{0x47, byte(pid >> 8 & 0x1f), byte(pid), 0x10}
+	if start {
+		pkt[1] |= 0x40
+	}
+	if rai {
+		pkt[3] |= 0x20
+		pkt = append(pkt, 1, 0x40)
+	}
+	pkt = append(pkt, payload...)
+	for len(pkt) < tsPacketSize {
+		pkt = append(pkt, 0xff)
+	}
+	return pkt
+}
+
+func testPat() []byte {
+	return tsPacket(0, true, false, []byte{
+		0, 0x00, 0xb0, 17, 0, 1, 0xc1, 0, 0,
+		0, 0, 0xe0, 0x10, // Network information
+		0, 1, 0xe0 | testPmtPid>>8, testPmtPid & 0xff,
+		0, 0, 0, 0,
+	})
+}
+
+func testPmt(video bool) []byte {
+	streams := []byte{0x0f, 0xe0 | testAudioPid>>8, testAudioPid & 0xff, 0xf0, 0}
+	if video {
+		streams = append([]byte{0x1b, 0xe0 | testVideoPid>>8, testVideoPid & 0xff, 0xf0, 0}, streams...)
+	}
+	section := []byte{0, 0x02, 0xb0, byte(13 + len(streams)), 0, 1, 0xc1, 0, 0, 0xe0 | testVideoPid>>8, testVideoPid & 0xff, 0xf0, 0}
+	section = append(section, streams...)
+	return tsPacket(testPmtPid, true, false, append(section, 0, 0, 0, 0))
+}
+
+// testFrame is the first packet of an H.264 frame, its NAL units after an access unit delimiter
+func testFrame(rai bool, nal byte) []byte {
+	pes := []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0x80, 5, 0x21, 0, 1, 0, 1, 0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1, nal}
+	return tsPacket(testVideoPid, true, rai, pes)
+}
+
+func TestTsSwitchPoint(t *testing.T) {
+	delta := testFrame(false, 0x41)
+	continuation := tsPacket(testVideoPid, false, false, nil)
+	audio := tsPacket(testAudioPid, true, false, []byte{0, 0, 1, 0xc0})
+
+	tests := []struct {
+		name   string
+		pmt    []byte
+		chunk  []byte
+		cutAt  []byte // Packet the cut starts at, nil when there is no switch point
+		tables bool
+	}{
+		{name: "tables not seen yet", chunk: slices.Concat(delta, testFrame(true, 0x41))},
+		{name: "no keyframe", tables: true, pmt: testPmt(true), chunk: slices.Concat(delta, continuation, audio)},
+		{
+			name: "random access indicator", tables: true, pmt: testPmt(true),
+			chunk: slices.Concat(delta, audio, testFrame(true, 0x41), continuation), cutAt: testFrame(true, 0x41),
+		},
+		{
+			name: "H.264 IDR without the indicator", tables: true, pmt: testPmt(true),
+			chunk: slices.Concat(delta, testFrame(false, 0x65), continuation), cutAt: testFrame(false, 0x65),
+		},
+		{
+			name: "H.264 SPS ahead of a keyframe", tables: true, pmt: testPmt(true),
+			chunk: slices.Concat(continuation, testFrame(false, 0x67)), cutAt: testFrame(false, 0x67),
+		},
+		{
+			name: "audio only", tables: true, pmt: testPmt(false),
+			chunk: slices.Concat(continuation, audio), cutAt: audio,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTsSwitchPoint()
+			if tt.tables {
+				ts.observe(slices.Concat(testPat(), tt.pmt))
+			}
+			ts.observe(tt.chunk)
+
+			got, ok := ts.cut(tt.chunk)
+			if tt.cutAt == nil {
+				if ok {
+					t.Fatalf("cut found a switch point at %d bytes", len(tt.chunk)-len(got))
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("cut found no switch point")
+			}
+
+			at := bytes.Index(tt.chunk, tt.cutAt)
+			if want := slices.Concat(testPat(), tt.pmt, tt.chunk[at:]); !bytes.Equal(got, want) {
+				t.Errorf("cut = %d bytes, want the tables and the chunk from %d (%d bytes)", len(got), at, len(want))
+			}
+		})
+	}
+}
+
+// fakeTsPublisher hands out the chunks it is sent, Read fails once chunks is closed
+type fakeTsPublisher struct {
+	chunks   chan []byte
+	publRole string
+}
+
+func newFakeTsPublisher(role string) *fakeTsPublisher {
+	return &fakeTsPublisher{chunks: make(chan []byte), publRole: role}
+}
+
+func (pub *fakeTsPublisher) Read(p []byte) (int, error) {
+	chunk, ok := <-pub.chunks
+	if !ok {
+		return 0, io.EOF
+	}
+	return copy(p, chunk), nil
+}
+
+func (pub *fakeTsPublisher) Close() error        { return nil }
+func (pub *fakeTsPublisher) RemoteAddr() string  { return pub.publRole }
+func (pub *fakeTsPublisher) InputArgs() []string { return []string{"-f", "mpegts", "-i", "pipe:0"} }
+func (pub *fakeTsPublisher) role() string        { return pub.publRole }
+
+// The backup taking over is only handed to FFmpeg from its next keyframe, led by its tables
+func TestFailoverSwitchesAtKeyframe(t *testing.T) {
+	primary, backup := newFakeTsPublisher(RolePrimary), newFakeTsPublisher(RoleBackup)
+	fp := newFailoverPublisher(&Task{Id: "failover"}, primary)
+	defer fp.Close()
+	if !fp.attach(backup) {
+		t.Fatal("backup was not attached")
+	}
+
+	// FFmpeg reading the stream
+	read := func() <-chan []byte {
+		got := make(chan []byte, 1)
+		go func() {
+			p := make([]byte, 64*tsPacketSize)
+			n, err := fp.Read(p)
+			if err != nil {
+				t.Errorf("Read: %v", err)
+			}
+			got <- p[:n]
+		}()
+		return got
+	}
+	wait := func(got <-chan []byte) []byte {
+		t.Helper()
+		select {
+		case chunk := <-got:
+			return chunk
+		case <-time.After(5 * time.Second):
+			t.Fatal("Read is stuck")
+			return nil
+		}
+	}
+
+	// Chunks of an input are dropped until FFmpeg reads from it
+	waitActive := func(role string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			fp.mu.Lock()
+			active := fp.active != nil && fp.active.role == role
+			fp.mu.Unlock()
+			if active {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("the %s publisher did not become active", role)
+			}
+		}
+	}
+
+	tables := slices.Concat(testPat(), testPmt(true))
+	first := slices.Concat(tables, testFrame(true, 0x65))
+	got := read()
+	waitActive(RolePrimary)
+	primary.chunks <- first
+	if got := wait(got); !bytes.Equal(got, first) {
+		t.Fatalf("Read = %d bytes, want the primary's %d", len(got), len(first))
+	}
+
+	// The primary goes away mid GOP, the backup is in the middle of one too
+	close(primary.chunks)
+	delta := slices.Concat(tables, testFrame(false, 0x41))
+	keyframe := slices.Concat(tsPacket(testVideoPid, false, false, nil), testFrame(true, 0x65), testFrame(false, 0x41))
+
+	got = read()
+	waitActive(RoleBackup)
+	backup.chunks <- delta
+	backup.chunks <- keyframe
+
+	if want := slices.Concat(tables, keyframe[tsPacketSize:]); !bytes.Equal(wait(got), want) {
+		t.Errorf("FFmpeg did not get the backup from its keyframe on")
+	}
+}
@@ -0,0 +1,122 @@
+package ingest
+
+import "encoding/binary"
+
+// Sample flag of ISO BMFF fragments set on samples that are not keyframes
+const sampleIsNonSync = 0x00010000
+
+// Flags of the tfhd and trun boxes telling which optional fields they have
+const (
+	tfhdBaseDataOffset     = 0x01
+	tfhdSampleDescription  = 0x02
+	tfhdDefaultDuration    = 0x08
+	tfhdDefaultSize        = 0x10
+	tfhdDefaultSampleFlags = 0x20
+
+	trunDataOffset       = 0x001
+	trunFirstSampleFlags = 0x004
+	trunSampleDuration   = 0x100
+	trunSampleSize       = 0x200
+	trunSampleFlags      = 0x400
+)
+
+// fragmentIndependent reports whether every track of the fMP4 fragment starts with a keyframe,
+// so a player can start decoding there. Fragments that do not say are taken not to.
+func fragmentIndependent(data []byte) bool {
+	moof, ok := findBox(data, "moof")
+	if !ok {
+		return false
+	}
+
+	tracks := 0
+	for traf := range boxes(moof, "traf") {
+		flags, ok := firstSampleFlags(traf)
+		if !ok || flags&sampleIsNonSync != 0 {
+			return false
+		}
+		tracks++
+	}
+	return tracks > 0
+}
+
+// firstSampleFlags are the flags of the first sample of a track fragment, from its trun or else its tfhd defaults
+func firstSampleFlags(traf []byte) (uint32, bool) {
+	if trun, ok := findBox(traf, "trun"); ok && len(trun) >= 8 {
+		flags := binary.BigEndian.Uint32(trun) & 0xffffff
+		offset := 8
+		if flags&trunDataOffset != 0 {
+			offset += 4
+		}
+		if flags&trunFirstSampleFlags != 0 {
+			return readUint32(trun, offset)
+		}
+		if flags&trunSampleFlags != 0 {
+			if flags&trunSampleDuration != 0 {
+				offset += 4
+			}
+			if flags&trunSampleSize != 0 {
+				offset += 4
+			}
+			return readUint32(trun, offset)
+		}
+	}
+
+	tfhd, ok := findBox(traf, "tfhd")
+	if !ok || len(tfhd) < 8 {
+		return 0, false
+	}
+	flags := binary.BigEndian.Uint32(tfhd) & 0xffffff
+	if flags&tfhdDefaultSampleFlags == 0 {
+		return 0, false
+	}
+	offset := 8
+	if flags&tfhdBaseDataOffset != 0 {
+		offset += 8
+	}
+	for _, field := range []uint32{tfhdSampleDescription, tfhdDefaultDuration, tfhdDefaultSize} {
+		if flags&field != 0 {
+			offset += 4
+		}
+	}
+	return readUint32(tfhd, offset)
+}
+
+// boxes yields the payload of every box of the given type directly in data
+func boxes(data []byte, boxType string) func(yield func([]byte) bool) {
+	return func(yield func([]byte) bool) {
+		for len(data) >= 8 {
+			size, header := uint64(binary.BigEndian.Uint32(data)), uint64(8)
+			switch size {
+			case 0:
+				size = uint64(len(data))
+			case 1:
+				if len(data) < 16 {
+					return
+				}
+				size, header = binary.BigEndian.Uint64(data[8:]), 16
+			}
+			if size < header || size > uint64(len(data)) {
+				return
+			}
+
+			if string(data[4:8]) == boxType && !yield(data[header:size]) {
+				return
+			}
+			data = data[size:]
+		}
+	}
+}
+
+func findBox(data []byte, boxType string) ([]byte, bool) {
+	for box := range boxes(data, boxType) {
+		return box, true
+	}
+	return nil, false
+}
+
+func readUint32(data []byte, offset int) (uint32, bool) {
+	if len(data) < offset+4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(data[offset:]), true
+}
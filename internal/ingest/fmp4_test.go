@@ -0,0 +1,96 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+// box is an ISO BMFF box of the given type around the payload
+func box(boxType string, payload ...[]byte) []byte {
+	body := slices.Concat(payload...)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, boxType...), body...)
+}
+
+// fullBox is a box with version 0, the flags and 32 bit fields
+func fullBox(boxType string, flags uint32, fields ...uint32) []byte {
+	body := binary.BigEndian.AppendUint32(nil, flags)
+	for _, field := range fields {
+		body = binary.BigEndian.AppendUint32(body, field)
+	}
+	return box(boxType, body)
+}
+
+// fragment is an fMP4 fragment with a track per flag, each starting with a keyframe or not.
+// The flags of the first sample are in the trun, as FFmpeg writes them.
+func fragment(keyframes ...bool) []byte {
+	var trafs [][]byte
+	for track, keyframe := range keyframes {
+		flags := uint32(0x02000000)
+		if !keyframe {
+			flags = 0x01010000
+		}
+		trafs = append(trafs, box("traf",
+			fullBox("tfhd", 0, uint32(track+1)),
+			fullBox("trun", trunDataOffset|trunFirstSampleFlags, 1, 0, flags),
+		))
+	}
+	return slices.Concat(box("moof", slices.Concat(trafs...)), box("mdat", []byte("media")))
+}
+
+func TestFragmentIndependent(t *testing.T) {
+	largeMoof := func(payload []byte) []byte {
+		b := binary.BigEndian.AppendUint32(nil, 1)
+		b = append(b, "moof"...)
+		b = binary.BigEndian.AppendUint64(b, uint64(16+len(payload)))
+		return append(b, payload...)
+	}
+	traf := func(boxes ...[]byte) []byte { return box("moof", box("traf", boxes...)) }
+
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"keyframe", fragment(true), true},
+		{"not a keyframe", fragment(false), false},
+		{"video and audio keyframes", fragment(true, true), true},
+		{"audio keyframe only", fragment(false, true), false},
+		{
+			"flags of every sample",
+			traf(fullBox("tfhd", 0, 1), fullBox("trun", trunSampleDuration|trunSampleSize|trunSampleFlags, 2, 1000, 300, 0x02000000, 1000, 200, 0x01010000)),
+			true,
+		},
+		{
+			"flags of every sample, not a keyframe",
+			traf(fullBox("tfhd", 0, 1), fullBox("trun", trunDataOffset|trunSampleSize|trunSampleFlags, 1, 0, 300, 0x01010000)),
+			false,
+		},
+		{
+			"default flags of the track fragment",
+			traf(fullBox("tfhd", tfhdBaseDataOffset|tfhdDefaultDuration|tfhdDefaultSampleFlags, 1, 0, 0, 1000, 0x02000000), fullBox("trun", trunSampleSize, 1, 300)),
+			true,
+		},
+		{
+			"default flags of the track fragment, not a keyframe",
+			traf(fullBox("tfhd", tfhdSampleDescription|tfhdDefaultSize|tfhdDefaultSampleFlags, 1, 1, 300, 0x01010000), fullBox("trun", 0, 1)),
+			false,
+		},
+		{"no flags at all", traf(fullBox("tfhd", 0, 1), fullBox("trun", trunSampleSize, 1, 300)), false},
+		{"64 bit box size", largeMoof(box("traf", fullBox("tfhd", 0, 1), fullBox("trun", trunFirstSampleFlags, 1, 0x02000000))), true},
+		{"initialization section", box("ftyp", []byte("iso6")), false},
+		{"no track fragments", box("moof", fullBox("mfhd", 0, 1)), false},
+		{"truncated trun", traf(fullBox("tfhd", 0, 1), fullBox("trun", trunFirstSampleFlags, 1)), false},
+		{"truncated box", fragment(true)[:20], false},
+		{"empty", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fragmentIndependent(tt.data); got != tt.want {
+				t.Errorf("fragmentIndependent = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
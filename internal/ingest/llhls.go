@@ -0,0 +1,457 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often the playlists of a Low-Latency HLS run are picked up, every part counts
+const llhlsSyncInterval = 50 * time.Millisecond
+
+// Playlist version with delta updates (EXT-X-SKIP)
+const llhlsVersion = 9
+
+// Published parts are named <playlist>_p<number>.m4s
+var hlsPartName = regexp.MustCompile(`_p(\d+)\.m4s$`)
+
+var (
+	ErrNotLowLatency          = errors.New("not a Low-Latency HLS playlist")
+	ErrInvalidPlaylistRequest = errors.New("invalid playlist request")
+	ErrPlaylistTimeout        = errors.New("playlist did not update in time")
+)
+
+// lowLatency are the targets of a Low-Latency HLS output, from the stream's profile
+type lowLatency struct {
+	partTarget    float64
+	segmentTarget float64
+}
+
+// blockTimeout is how long a playlist request may wait for what it asked for, three target durations
+func (ll *lowLatency) blockTimeout() time.Duration {
+	return time.Duration(3 * ll.segmentTarget * float64(time.Second))
+}
+
+// hlsPart is a partial segment, one fMP4 fragment FFmpeg wrote
+type hlsPart struct {
+	duration    float64
+	independent bool // Starts with a keyframe in every track
+	uri         string
+}
+
+// openSegment is the segment the latest parts go to. Its file is put together under a hidden
+// name and only appears, for players and the uploader, once the segment is complete.
+type openSegment struct {
+	number        int // Of the segment file
+	nextPart      int // Number of the next part file, named ahead in the preload hint
+	parts         []hlsPart
+	duration      float64
+	init          string // Initialization section of the run the parts come from
+	discontinuity bool
+}
+
+// PlaylistRequest are the delivery directives of a Low-Latency HLS playlist request
+type PlaylistRequest struct {
+	MSN  int  // _HLS_msn, the media sequence number to wait for, -1 for none
+	Part int  // _HLS_part, the part of that segment to wait for, -1 for the whole segment
+	Skip bool // _HLS_skip, a delta update leaving out the older segments
+}
+
+// ParsePlaylistRequest reads the delivery directives of a playlist request, reports whether it has any
+func ParsePlaylistRequest(query url.Values) (PlaylistRequest, bool, error) {
+	req := PlaylistRequest{MSN: -1, Part: -1}
+
+	msn, part, skip := query.Get("_HLS_msn"), query.Get("_HLS_part"), query.Get("_HLS_skip")
+	if msn == "" && part == "" && skip == "" {
+		return req, false, nil
+	}
+
+	var err error
+	if msn != "" {
+		if req.MSN, err = strconv.Atoi(msn); err != nil || req.MSN < 0 {
+			return req, true, fmt.Errorf("%w: _HLS_msn must be a media sequence number", ErrInvalidPlaylistRequest)
+		}
+	}
+	if part != "" {
+		if req.Part, err = strconv.Atoi(part); err != nil || req.Part < 0 || msn == "" {
+			return req, true, fmt.Errorf("%w: _HLS_part must be a part number and come with _HLS_msn", ErrInvalidPlaylistRequest)
+		}
+	}
+	switch skip {
+	case "":
+	case "YES", "v2":
+		req.Skip = true
+	default:
+		return req, true, fmt.Errorf("%w: _HLS_skip must be YES or v2", ErrInvalidPlaylistRequest)
+	}
+	return req, true, nil
+}
+
+// LowLatencyPlaylist answers a playlist request with delivery directives. It waits for the segment or part
+// asked for, up to three target durations. Playlists of streams without Low-Latency HLS are ErrNotLowLatency.
+func (tm *TaskManager) LowLatencyPlaylist(ctx context.Context, id string, name string, req PlaylistRequest) ([]byte, error) {
+	o := tm.lowLatencyOutput(id)
+	if o == nil {
+		return nil, ErrNotLowLatency
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.ll.blockTimeout())
+	defer cancel()
+	return o.awaitPlaylist(ctx, name, req)
+}
+
+// AwaitPart holds a request for the part a playlist hinted at until it is published
+func (tm *TaskManager) AwaitPart(ctx context.Context, id string, name string) error {
+	o := tm.lowLatencyOutput(id)
+	if o == nil {
+		return ErrNotLowLatency
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.ll.blockTimeout())
+	defer cancel()
+	return o.awaitPart(ctx, name)
+}
+
+func (tm *TaskManager) lowLatencyOutput(id string) *hlsOutput {
+	tm.mu.Lock()
+	task, exists := tm.TaskMap[id]
+	tm.mu.Unlock()
+	if !exists {
+		return nil
+	}
+
+	task.mu.Lock()
+	o := task.hls
+	task.mu.Unlock()
+	if o == nil || o.ll == nil {
+		return nil
+	}
+	return o
+}
+
+// mergeParts publishes the parts of the run playlist that are new and puts them together into segments.
+// Reports whether the public playlist changed.
+func (o *hlsOutput) mergeParts(pl *stitchedPlaylist, data []byte) bool {
+	run := parseRunPlaylist(data)
+	if run.init == "" || len(run.segments) == 0 {
+		return false
+	}
+
+	// Every run has an initialization section of its own, the codecs may have changed
+	if pl.run != o.run {
+		init, err := o.publishInit(pl, run.init, pl.open.nextPart)
+		if err != nil {
+			slog.Error("Failed to publish HLS initialization section", "playlist", pl.name, "error", err)
+			return false
+		}
+		pl.open.init = init
+		pl.open.discontinuity = pl.run != 0
+		pl.run = o.run
+		pl.runNext = 0
+	}
+
+	changed := false
+	for i, seg := range run.segments {
+		sequence := run.mediaSequence + i
+		if sequence < pl.runNext {
+			continue
+		}
+		pl.runNext = sequence + 1
+
+		// FFmpeg is told not to delete its parts, they are done with once published
+		file := filepath.Join(o.runDir, seg.uri)
+		media, err := os.ReadFile(file)
+		if err != nil {
+			slog.Error("Failed to read HLS part", "path", file, "error", err)
+			continue
+		}
+		os.Remove(file)
+
+		if o.addPart(pl, seg.duration, media) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// addPart publishes a part and appends it to the open segment. A new segment is started
+// at the first part players can start decoding at once the open one is long enough.
+func (o *hlsOutput) addPart(pl *stitchedPlaylist, duration float64, media []byte) bool {
+	independent := fragmentIndependent(media)
+
+	// Half a part early, parts rarely add up to the target exactly
+	full := pl.open.duration >= o.ll.segmentTarget-o.ll.partTarget/2
+	if full && independent || pl.open.duration >= 2*o.ll.segmentTarget {
+		o.closeSegment(pl)
+	}
+
+	open := &pl.open
+	name := pl.partName(open.nextPart)
+	if err := writeFileAtomic(filepath.Join(o.dir, name), media); err != nil {
+		slog.Error("Failed to publish HLS part", "path", filepath.Join(o.dir, name), "error", err)
+		return false
+	}
+	if err := appendFile(o.openSegmentPath(pl), media); err != nil {
+		slog.Error("Failed to write HLS segment", "path", o.openSegmentPath(pl), "error", err)
+	}
+
+	open.nextPart++
+	open.parts = append(open.parts, hlsPart{duration: duration, independent: independent, uri: name})
+	open.duration += duration
+	return true
+}
+
+// closeSegment completes the open segment and lists it, reports whether there was one
+func (o *hlsOutput) closeSegment(pl *stitchedPlaylist) bool {
+	open := &pl.open
+	if len(open.parts) == 0 {
+		return false
+	}
+
+	name := pl.segmentName(open.number)
+	if err := os.Rename(o.openSegmentPath(pl), filepath.Join(o.dir, name)); err != nil {
+		slog.Error("Failed to publish HLS segment", "path", filepath.Join(o.dir, name), "error", err)
+	}
+
+	pl.segments = append(pl.segments, hlsSegment{
+		duration:      open.duration,
+		discontinuity: open.discontinuity,
+		tags:          []string{fmt.Sprintf("#EXTINF:%.6f,", open.duration)},
+		uri:           name,
+		init:          open.init,
+		parts:         open.parts,
+	})
+	pl.target = max(pl.target, int(math.Ceil(open.duration)))
+	o.nextNumber = max(o.nextNumber, open.number+1)
+
+	*open = openSegment{number: open.number + 1, nextPart: open.nextPart, init: open.init}
+	o.trim(pl)
+	return true
+}
+
+func (o *hlsOutput) openSegmentPath(pl *stitchedPlaylist) string {
+	return filepath.Join(o.dir, "."+pl.segmentName(pl.open.number)+".tmp")
+}
+
+// renderParts is the Low-Latency HLS playlist, skip leaves out the segments a delta update may
+func (o *hlsOutput) renderParts(pl *stitchedPlaylist, skip bool) []byte {
+	target := max(pl.target, int(math.Ceil(o.ll.segmentTarget)))
+	skipUntil := float64(6 * target)
+
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", llhlsVersion)
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", target)
+	fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,CAN-SKIP-UNTIL=%s,PART-HOLD-BACK=%s\n",
+		formatDuration(skipUntil), formatDuration(3*o.ll.partTarget))
+	fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%s\n", formatDuration(o.ll.partTarget))
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", pl.mediaSequence)
+	if pl.discontinuitySequence > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", pl.discontinuitySequence)
+	}
+	for _, line := range pl.header {
+		b.WriteString(line + "\n")
+	}
+
+	// How much of the playlist follows each segment. Parts are listed for the last three
+	// target durations, delta updates leave out what is further back than CAN-SKIP-UNTIL.
+	after := make([]float64, len(pl.segments))
+	remaining := pl.open.duration
+	for i := len(pl.segments) - 1; i >= 0; i-- {
+		after[i] = remaining
+		remaining += pl.segments[i].duration
+	}
+
+	skipped := 0
+	for skip && skipped < len(pl.segments) && after[skipped] > skipUntil {
+		skipped++
+	}
+	if skipped > 0 {
+		fmt.Fprintf(&b, "#EXT-X-SKIP:SKIPPED-SEGMENTS=%d\n", skipped)
+	}
+
+	init := ""
+	writeParts := func(discontinuity bool, segmentInit string, parts []hlsPart, listParts bool) {
+		if discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if segmentInit != init {
+			fmt.Fprintf(&b, "#EXT-X-MAP:URI=%q\n", segmentInit)
+			init = segmentInit
+		}
+		if !listParts {
+			return
+		}
+		for _, part := range parts {
+			fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%s,URI=%q", formatDuration(part.duration), part.uri)
+			if part.independent {
+				b.WriteString(",INDEPENDENT=YES")
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	for i := skipped; i < len(pl.segments); i++ {
+		seg := pl.segments[i]
+		writeParts(seg.discontinuity, seg.init, seg.parts, after[i] < float64(3*target))
+		for _, tag := range seg.tags {
+			b.WriteString(tag + "\n")
+		}
+		b.WriteString(seg.uri + "\n")
+	}
+	if len(pl.open.parts) > 0 {
+		writeParts(pl.open.discontinuity, pl.open.init, pl.open.parts, true)
+	}
+
+	if o.ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	} else if o.running {
+		fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=%q\n", pl.partName(pl.open.nextPart))
+	}
+	return b.Bytes()
+}
+
+// awaitPlaylist renders the playlist once it has what req waits for
+func (o *hlsOutput) awaitPlaylist(ctx context.Context, name string, req PlaylistRequest) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for {
+		if _, master := o.masters[name]; master {
+			return nil, ErrNotLowLatency
+		}
+
+		pl := o.playlists[name]
+		if pl != nil && req.MSN > pl.lastSequence()+2 {
+			return nil, fmt.Errorf("%w: _HLS_msn is too far ahead of the playlist", ErrInvalidPlaylistRequest)
+		}
+		if pl != nil && (o.ended || pl.has(req)) {
+			return o.renderParts(pl, req.Skip), nil
+		}
+		if pl == nil && o.ended {
+			return nil, ErrNotLowLatency
+		}
+
+		if err := o.waitChange(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// awaitPart waits until the part a playlist hinted at is published. Other missing parts are os.ErrNotExist.
+func (o *hlsOutput) awaitPart(ctx context.Context, name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for {
+		if _, err := os.Stat(filepath.Join(o.dir, name)); err == nil {
+			return nil
+		}
+
+		hinted := false
+		for _, pl := range o.playlists {
+			if pl.partName(pl.open.nextPart) == name {
+				hinted = true
+			}
+		}
+		if !o.running || !hinted {
+			return os.ErrNotExist
+		}
+
+		if err := o.waitChange(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// waitChange waits for the next playlist to be published. Must be called with o.mu held, which it releases meanwhile.
+func (o *hlsOutput) waitChange(ctx context.Context) error {
+	changed := o.changed
+	o.mu.Unlock()
+	defer o.mu.Lock()
+
+	select {
+	case <-changed:
+		return nil
+	case <-ctx.Done():
+		return ErrPlaylistTimeout
+	}
+}
+
+// lastSequence is the media sequence number of the newest segment, the open one included
+func (pl *stitchedPlaylist) lastSequence() int {
+	last := pl.mediaSequence + len(pl.segments) - 1
+	if len(pl.open.parts) > 0 {
+		last++
+	}
+	return last
+}
+
+// has reports whether the playlist has the segment, or the part of it, req waits for
+func (pl *stitchedPlaylist) has(req PlaylistRequest) bool {
+	open := pl.mediaSequence + len(pl.segments)
+	if req.MSN < open {
+		return true
+	}
+	return req.MSN == open && req.Part >= 0 && req.Part < len(pl.open.parts)
+}
+
+// base is the name of the playlist without extension, every file of it starts with it
+func (pl *stitchedPlaylist) base() string {
+	return strings.TrimSuffix(pl.name, filepath.Ext(pl.name))
+}
+
+func (pl *stitchedPlaylist) segmentName(number int) string {
+	return fmt.Sprintf("%s_%03d.m4s", pl.base(), number)
+}
+
+func (pl *stitchedPlaylist) partName(number int) string {
+	return fmt.Sprintf("%s_p%d.m4s", pl.base(), number)
+}
+
+// lastPartNumber is the highest number among the parts of the playlist in dir, -1 without any
+func lastPartNumber(dir string, base string) int {
+	last := -1
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return last
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		match := hlsPartName.FindStringSubmatch(name)
+		if match == nil || name != base+match[0] {
+			continue
+		}
+		if n, err := strconv.Atoi(match[1]); err == nil {
+			last = max(last, n)
+		}
+	}
+	return last
+}
+
+func appendFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func formatDuration(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
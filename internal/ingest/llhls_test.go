@@ -0,0 +1,348 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
+)
+
+func TestParsePlaylistRequest(t *testing.T) {
+	tests := []struct {
+		query      string
+		want       PlaylistRequest
+		directives bool
+		invalid    bool
+	}{
+		{query: "", want: PlaylistRequest{MSN: -1, Part: -1}},
+		{query: "token=x", want: PlaylistRequest{MSN: -1, Part: -1}},
+		{query: "_HLS_msn=12", want: PlaylistRequest{MSN: 12, Part: -1}, directives: true},
+		{query: "_HLS_msn=12&_HLS_part=3", want: PlaylistRequest{MSN: 12, Part: 3}, directives: true},
+		{query: "_HLS_msn=0&_HLS_part=0", want: PlaylistRequest{MSN: 0, Part: 0}, directives: true},
+		{query: "_HLS_skip=YES", want: PlaylistRequest{MSN: -1, Part: -1, Skip: true}, directives: true},
+		{query: "_HLS_skip=v2", want: PlaylistRequest{MSN: -1, Part: -1, Skip: true}, directives: true},
+		{query: "_HLS_msn=4&_HLS_part=1&_HLS_skip=YES", want: PlaylistRequest{MSN: 4, Part: 1, Skip: true}, directives: true},
+		{query: "_HLS_part=3", directives: true, invalid: true},
+		{query: "_HLS_msn=-1", directives: true, invalid: true},
+		{query: "_HLS_msn=next", directives: true, invalid: true},
+		{query: "_HLS_msn=1&_HLS_part=-2", directives: true, invalid: true},
+		{query: "_HLS_msn=1&_HLS_part=x", directives: true, invalid: true},
+		{query: "_HLS_skip=NO", directives: true, invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, directives, err := ParsePlaylistRequest(query)
+			if directives != tt.directives {
+				t.Errorf("directives = %v, want %v", directives, tt.directives)
+			}
+			if tt.invalid {
+				if !errors.Is(err, ErrInvalidPlaylistRequest) {
+					t.Errorf("err = %v, want ErrInvalidPlaylistRequest", err)
+				}
+				return
+			}
+			if err != nil || req != tt.want {
+				t.Errorf("ParsePlaylistRequest = %+v, %v, want %+v", req, err, tt.want)
+			}
+		})
+	}
+}
+
+// llPlaylist is a Low-Latency playlist of complete segments of four 1s parts, the first independent,
+// and an open segment of openParts parts
+func llPlaylist(mediaSequence int, segments int, openParts int) *stitchedPlaylist {
+	pl := &stitchedPlaylist{name: "s.m3u8", target: 4, mediaSequence: mediaSequence}
+	part := 0
+	parts := func(n int) []hlsPart {
+		var parts []hlsPart
+		for i := range n {
+			parts = append(parts, hlsPart{duration: 1, independent: i == 0, uri: pl.partName(part)})
+			part++
+		}
+		return parts
+	}
+
+	for i := range segments {
+		pl.segments = append(pl.segments, hlsSegment{
+			duration: 4,
+			tags:     []string{"#EXTINF:4.000000,"},
+			uri:      pl.segmentName(mediaSequence + i),
+			init:     "s_init0.mp4",
+			parts:    parts(4),
+		})
+	}
+	pl.open = openSegment{number: mediaSequence + segments, init: "s_init0.mp4", parts: parts(openParts), duration: float64(openParts)}
+	pl.open.nextPart = part
+	return pl
+}
+
+func TestRenderParts(t *testing.T) {
+	o := &hlsOutput{ll: &lowLatency{partTarget: 1, segmentTarget: 4}, running: true}
+	pl := llPlaylist(5, 1, 2)
+
+	want := `#EXTM3U
+#EXT-X-VERSION:9
+#EXT-X-TARGETDURATION:4
+#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,CAN-SKIP-UNTIL=24.000,PART-HOLD-BACK=3.000
+#EXT-X-PART-INF:PART-TARGET=1.000
+#EXT-X-MEDIA-SEQUENCE:5
+#EXT-X-MAP:URI="s_init0.mp4"
+#EXT-X-PART:DURATION=1.000,URI="s_p0.m4s",INDEPENDENT=YES
+#EXT-X-PART:DURATION=1.000,URI="s_p1.m4s"
+#EXT-X-PART:DURATION=1.000,URI="s_p2.m4s"
+#EXT-X-PART:DURATION=1.000,URI="s_p3.m4s"
+#EXTINF:4.000000,
+s_005.m4s
+#EXT-X-PART:DURATION=1.000,URI="s_p4.m4s",INDEPENDENT=YES
+#EXT-X-PART:DURATION=1.000,URI="s_p5.m4s"
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="s_p6.m4s"
+`
+	if got := string(o.renderParts(pl, false)); got != want {
+		t.Errorf("renderParts\n got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// A finished stream has no part to hint at
+	o.running, o.ended = false, true
+	if got := string(o.renderParts(pl, false)); !strings.HasSuffix(got, "URI=\"s_p5.m4s\"\n#EXT-X-ENDLIST\n") {
+		t.Errorf("finished playlist does not end with the last part and EXT-X-ENDLIST:\n%s", got)
+	}
+}
+
+func TestRenderPartsDelta(t *testing.T) {
+	o := &hlsOutput{ll: &lowLatency{partTarget: 1, segmentTarget: 4}, running: true}
+	pl := llPlaylist(20, 10, 2)
+	pl.segments[6].discontinuity = true
+	pl.segments[6].init = "s_init44.mp4"
+	for i := 7; i < len(pl.segments); i++ {
+		pl.segments[i].init = "s_init44.mp4"
+	}
+	pl.open.init = "s_init44.mp4"
+
+	full := string(o.renderParts(pl, false))
+	delta := string(o.renderParts(pl, true))
+
+	// 42s of media, what is more than CAN-SKIP-UNTIL (24s) from the end can be skipped: 4 segments
+	if strings.Contains(full, "#EXT-X-SKIP") {
+		t.Error("full playlist has EXT-X-SKIP")
+	}
+	if !strings.Contains(delta, "#EXT-X-SKIP:SKIPPED-SEGMENTS=4\n") {
+		t.Errorf("delta update does not skip 4 segments:\n%s", delta)
+	}
+	for i, playlist := range []string{full, delta} {
+		if !strings.Contains(playlist, "#EXT-X-MEDIA-SEQUENCE:20\n") {
+			t.Errorf("playlist %d does not keep the media sequence of its first segment", i)
+		}
+	}
+	if strings.Contains(delta, "s_020.m4s") || strings.Contains(delta, "s_023.m4s") || !strings.Contains(delta, "\ns_024.m4s\n") {
+		t.Errorf("delta update lists the wrong segments:\n%s", delta)
+	}
+
+	// The map of the first listed segment is repeated after the skip, the next run's after its discontinuity
+	if !strings.Contains(delta, "#EXT-X-SKIP:SKIPPED-SEGMENTS=4\n#EXT-X-MAP:URI=\"s_init0.mp4\"\n#EXTINF") {
+		t.Errorf("delta update does not start with the initialization section:\n%s", delta)
+	}
+	if !strings.Contains(full, "s_025.m4s\n#EXT-X-DISCONTINUITY\n#EXT-X-MAP:URI=\"s_init44.mp4\"\n#EXTINF") {
+		t.Errorf("discontinuity is not followed by the new initialization section:\n%s", full)
+	}
+
+	// Parts are listed for the last three target durations: segments 27 to 29 and the open one
+	if n := strings.Count(full, "#EXT-X-PART:"); n != 3*4+2 {
+		t.Errorf("full playlist lists %d parts, want 14", n)
+	}
+	if strings.Contains(full, "URI=\"s_p27.m4s\"") || !strings.Contains(full, "URI=\"s_p28.m4s\"") {
+		t.Errorf("parts are listed from the wrong segment:\n%s", full)
+	}
+	if strings.Count(delta, "#EXT-X-PART:") != strings.Count(full, "#EXT-X-PART:") {
+		t.Error("delta update lists other parts than the full playlist")
+	}
+}
+
+func TestPlaylistHas(t *testing.T) {
+	pl := llPlaylist(5, 2, 2) // Segments 5 and 6, parts 0 and 1 of segment 7
+
+	tests := []struct {
+		req  PlaylistRequest
+		want bool
+	}{
+		{PlaylistRequest{MSN: 4, Part: -1}, true},
+		{PlaylistRequest{MSN: 6, Part: -1}, true},
+		{PlaylistRequest{MSN: 6, Part: 9}, true},
+		{PlaylistRequest{MSN: 7, Part: -1}, false},
+		{PlaylistRequest{MSN: 7, Part: 0}, true},
+		{PlaylistRequest{MSN: 7, Part: 1}, true},
+		{PlaylistRequest{MSN: 7, Part: 2}, false},
+		{PlaylistRequest{MSN: 8, Part: 0}, false},
+	}
+	for _, tt := range tests {
+		if got := pl.has(tt.req); got != tt.want {
+			t.Errorf("has(%+v) = %v, want %v", tt.req, got, tt.want)
+		}
+	}
+	if last := pl.lastSequence(); last != 7 {
+		t.Errorf("lastSequence = %d, want 7", last)
+	}
+}
+
+// llRun writes the playlist and the parts of an FFmpeg run of s.m3u8 to the run directory, parts of 0.5s
+func llRun(t *testing.T, dir string, parts []bool) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, "s_init.mp4"), box("ftyp", []byte("iso6")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-MAP:URI=\"s_init.mp4\"\n")
+	for i, keyframe := range parts {
+		name := fmt.Sprintf("s_%05d.m4s", i)
+		if err := os.WriteFile(filepath.Join(dir, name), fragment(keyframe), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&b, "#EXTINF:0.500000,\n%s\n", name)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "s.m3u8"), []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeParts(t *testing.T) {
+	dir := t.TempDir()
+	profile := &transcode.Profile{Packaging: transcode.Packaging{Format: transcode.FormatLLHLS, SegmentSeconds: 2, PartSeconds: 0.5}}
+	o := newHlsOutput(dir, profile)
+
+	// Segments of 2s start at a keyframe, the 6th part is not one so the second segment runs on
+	runDir, _, err := o.startRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	llRun(t, runDir, []bool{true, false, false, false, true, false, false, false, false, true})
+	o.mu.Lock()
+	o.sync()
+	o.mu.Unlock()
+
+	pl := o.playlists["s.m3u8"]
+	if pl == nil || len(pl.segments) != 2 || len(pl.open.parts) != 1 {
+		t.Fatalf("after the first run: %+v", pl)
+	}
+	if pl.segments[0].uri != "s_000.m4s" || len(pl.segments[0].parts) != 4 || pl.segments[1].duration != 2.5 {
+		t.Errorf("segments are not cut at the first keyframe after 2s: %+v", pl.segments)
+	}
+
+	// Published parts, and segments made of them
+	segment, err := os.ReadFile(filepath.Join(dir, "s_000.m4s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts []byte
+	for i := range 4 {
+		part, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("s_p%d.m4s", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, part...)
+	}
+	if !bytes.Equal(segment, parts) {
+		t.Error("segment is not its parts put together")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "s_002.m4s")); !os.IsNotExist(err) {
+		t.Errorf("open segment is visible: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "s_init0.mp4")); err != nil {
+		t.Errorf("initialization section was not published: %v", err)
+	}
+	playlist, _ := os.ReadFile(filepath.Join(dir, "s.m3u8"))
+	if !strings.Contains(string(playlist), "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"s_p10.m4s\"\n") {
+		t.Errorf("published playlist does not hint at the next part:\n%s", playlist)
+	}
+
+	// The next run starts a segment of its own, after a discontinuity
+	o.endRun()
+	runDir, _, err = o.startRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	llRun(t, runDir, []bool{true, false})
+	o.mu.Lock()
+	o.sync()
+	o.mu.Unlock()
+
+	if len(pl.segments) != 3 || pl.segments[2].uri != "s_002.m4s" {
+		t.Fatalf("the end of the run did not close its segment: %+v", pl.segments)
+	}
+	if !pl.open.discontinuity || pl.open.number != 3 || pl.open.init != "s_init10.mp4" || pl.open.parts[0].uri != "s_p10.m4s" {
+		t.Errorf("second run did not carry on after a discontinuity: %+v", pl.open)
+	}
+}
+
+func TestAwaitPlaylist(t *testing.T) {
+	dir := t.TempDir()
+	profile := &transcode.Profile{Packaging: transcode.Packaging{Format: transcode.FormatLLHLS, SegmentSeconds: 2, PartSeconds: 0.5}}
+	o := newHlsOutput(dir, profile)
+	runDir, _, err := o.startRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	llRun(t, runDir, []bool{true, false})
+	o.mu.Lock()
+	o.sync()
+	o.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Part 1 of segment 0 is there already
+	if _, err := o.awaitPlaylist(ctx, "s.m3u8", PlaylistRequest{MSN: 0, Part: 1}); err != nil {
+		t.Errorf("awaitPlaylist for a published part: %v", err)
+	}
+	if _, err := o.awaitPlaylist(ctx, "s.m3u8", PlaylistRequest{MSN: 5, Part: -1}); !errors.Is(err, ErrInvalidPlaylistRequest) {
+		t.Errorf("awaitPlaylist far ahead = %v, want ErrInvalidPlaylistRequest", err)
+	}
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	if _, err := o.awaitPlaylist(short, "s.m3u8", PlaylistRequest{MSN: 0, Part: 2}); !errors.Is(err, ErrPlaylistTimeout) {
+		t.Errorf("awaitPlaylist for a part that does not come = %v, want ErrPlaylistTimeout", err)
+	}
+
+	// A request for the next part blocks until it is published
+	result := make(chan []byte, 1)
+	go func() {
+		data, err := o.awaitPlaylist(ctx, "s.m3u8", PlaylistRequest{MSN: 0, Part: 2})
+		if err != nil {
+			t.Error(err)
+		}
+		result <- data
+	}()
+
+	select {
+	case <-result:
+		t.Fatal("awaitPlaylist returned before the part was published")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	llRun(t, runDir, []bool{true, false, false})
+	o.mu.Lock()
+	o.sync()
+	o.mu.Unlock()
+
+	select {
+	case data := <-result:
+		if !strings.Contains(string(data), "URI=\"s_p2.m4s\"") {
+			t.Errorf("playlist does not list the part waited for:\n%s", data)
+		}
+	case <-ctx.Done():
+		t.Fatal("awaitPlaylist did not return once the part was published")
+	}
+}
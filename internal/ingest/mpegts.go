@@ -0,0 +1,175 @@
+package ingest
+
+import "slices"
+
+// MPEG-TS stream types of video, a switch waits for a keyframe on those
+var tsVideoStreamTypes = map[byte]bool{
+	0x01: true, // MPEG-1
+	0x02: true, // MPEG-2
+	0x10: true, // MPEG-4 part 2
+	0x1b: true, // H.264
+	0x24: true, // HEVC
+}
+
+// tsSwitchPoint follows the tables of one MPEG-TS input, so that FFmpeg can be switched over to it
+// where a decoder can start: the PAT and PMT, then a video packet starting a keyframe.
+// Payloads are expected to hold whole packets, as SRT carries them.
+type tsSwitchPoint struct {
+	pat    []byte
+	pmt    []byte
+	pmtPid int
+	video  map[int]byte // Stream type of the video PIDs
+	audio  []int
+}
+
+func newTsSwitchPoint() *tsSwitchPoint {
+	return &tsSwitchPoint{pmtPid: -1, video: make(map[int]byte)}
+}
+
+// observe keeps the latest PAT and PMT of the input
+func (ts *tsSwitchPoint) observe(chunk []byte) {
+	for off := 0; off+tsPacketSize <= len(chunk); off += tsPacketSize {
+		pkt := chunk[off : off+tsPacketSize]
+		if pkt[0] != 0x47 || pkt[1]&0x40 == 0 {
+			continue
+		}
+		switch pid := tsPid(pkt); {
+		case pid == 0:
+			// The first program is the stream, program 0 points at network information
+			section := tsSection(pkt, 0x00)
+			for i := 0; i+4 <= len(section); i += 4 {
+				if section[i] == 0 && section[i+1] == 0 {
+					continue
+				}
+				ts.pat = append(ts.pat[:0], pkt...)
+				ts.pmtPid = int(section[i+2]&0x1f)<<8 | int(section[i+3])
+				break
+			}
+		case pid == ts.pmtPid:
+			ts.parsePmt(pkt)
+		}
+	}
+}
+
+func (ts *tsSwitchPoint) parsePmt(pkt []byte) {
+	section := tsSection(pkt, 0x02)
+	if len(section) < 4 {
+		return
+	}
+	ts.pmt = append(ts.pmt[:0], pkt...)
+	clear(ts.video)
+	ts.audio = ts.audio[:0]
+
+	// Program info first, then one entry per elementary stream
+	infoLen := int(section[2]&0x0f)<<8 | int(section[3])
+	for i := 4 + infoLen; i+5 <= len(section); {
+		streamType := section[i]
+		pid := int(section[i+1]&0x1f)<<8 | int(section[i+2])
+		if tsVideoStreamTypes[streamType] {
+			ts.video[pid] = streamType
+		} else {
+			ts.audio = append(ts.audio, pid)
+		}
+		i += 5 + (int(section[i+3]&0x0f)<<8 | int(section[i+4]))
+	}
+}
+
+// cut returns chunk from its first switch point on, led by the PAT and PMT. ok is false while
+// there is none: the tables are not known yet or no keyframe starts in chunk. Without video
+// the start of any audio frame will do.
+func (ts *tsSwitchPoint) cut(chunk []byte) ([]byte, bool) {
+	if ts.pat == nil || ts.pmt == nil {
+		return nil, false
+	}
+
+	for off := 0; off+tsPacketSize <= len(chunk); off += tsPacketSize {
+		pkt := chunk[off : off+tsPacketSize]
+		if pkt[0] != 0x47 || pkt[1]&0x40 == 0 {
+			continue
+		}
+
+		pid := tsPid(pkt)
+		streamType, video := ts.video[pid]
+		switch {
+		case video && tsKeyframe(pkt, streamType):
+		case len(ts.video) == 0 && slices.Contains(ts.audio, pid):
+		default:
+			continue
+		}
+		return slices.Concat(ts.pat, ts.pmt, chunk[off:]), true
+	}
+	return nil, false
+}
+
+func tsPid(pkt []byte) int {
+	return int(pkt[1]&0x1f)<<8 | int(pkt[2])
+}
+
+// tsPayload is what follows the header and the adaptation field of a packet
+func tsPayload(pkt []byte) []byte {
+	control := pkt[3] >> 4 & 0x3
+	if control&0x1 == 0 {
+		return nil
+	}
+	off := 4
+	if control&0x2 != 0 {
+		off += 1 + int(pkt[4])
+	}
+	if off >= len(pkt) {
+		return nil
+	}
+	return pkt[off:]
+}
+
+// tsSection is the body of a table section starting in pkt, after the table id and section length.
+// Tables are expected to fit in one packet, the CRC is left off.
+func tsSection(pkt []byte, tableId byte) []byte {
+	payload := tsPayload(pkt)
+	if len(payload) < 1 {
+		return nil
+	}
+	pointer := int(payload[0])
+	if 1+pointer+3 > len(payload) {
+		return nil
+	}
+	section := payload[1+pointer:]
+	if section[0] != tableId {
+		return nil
+	}
+	length := int(section[1]&0x0f)<<8 | int(section[2])
+	if length < 9 || 3+length > len(section) {
+		return nil
+	}
+	// Table id extension, version, section numbers and the CRC are skipped
+	return section[8 : 3+length-4]
+}
+
+// tsKeyframe reports whether a PES starting in pkt holds a keyframe: the adaptation field says it is a random
+// access point, or its first NAL units are an IDR picture or the parameter sets encoders send ahead of one
+func tsKeyframe(pkt []byte, streamType byte) bool {
+	if pkt[3]&0x20 != 0 && pkt[4] > 0 && pkt[5]&0x40 != 0 {
+		return true
+	}
+
+	pes := tsPayload(pkt)
+	if len(pes) < 9 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 {
+		return false
+	}
+	es := pes[min(len(pes), 9+int(pes[8])):]
+	for i := 0; i+3 < len(es); i++ {
+		if es[i] != 0 || es[i+1] != 0 || es[i+2] != 1 {
+			continue
+		}
+		switch header := es[i+3]; streamType {
+		case 0x1b:
+			if nal := header & 0x1f; nal == 5 || nal == 7 {
+				return true
+			}
+		case 0x24:
+			if nal := header >> 1 & 0x3f; nal >= 16 && nal <= 21 || nal == 32 || nal == 33 {
+				return true
+			}
+		}
+	}
+	return false
+}
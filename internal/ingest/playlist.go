@@ -0,0 +1,501 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
+)
+
+// Segments kept in the public playlists, every FFmpeg run keeps as many in its own
+const hlsListSize = 10
+
+// How often the playlists of the running FFmpeg are picked up
+const hlsSyncInterval = 250 * time.Millisecond
+
+// Directory of a stream's output the running FFmpeg writes its playlists to, away from the uploader
+const hlsRunDir = ".ffmpeg"
+
+// Playlist tags of a run that are carried over as they are, the others are computed over every run
+var carriedPlaylistTags = map[string]bool{
+	"#EXT-X-INDEPENDENT-SEGMENTS": true,
+	"#EXT-X-ALLOW-CACHE":          true,
+	"#EXT-X-START":                true,
+}
+
+var computedPlaylistTags = map[string]bool{
+	"#EXTM3U":                       true,
+	"#EXT-X-VERSION":                true,
+	"#EXT-X-TARGETDURATION":         true,
+	"#EXT-X-MEDIA-SEQUENCE":         true,
+	"#EXT-X-DISCONTINUITY-SEQUENCE": true,
+	"#EXT-X-PLAYLIST-TYPE":          true,
+	"#EXT-X-ENDLIST":                true,
+}
+
+// hlsOutput publishes the HLS playlists of a stream. Every FFmpeg run, of a publisher or the slate,
+// writes playlists of its own. They are stitched into the public ones, so the media sequence carries
+// on across runs and the first segment of every run after the first is a discontinuity.
+type hlsOutput struct {
+	dir    string // Public playlists and the segments, watched by the uploader
+	runDir string
+	ll     *lowLatency   // Set for Low-Latency HLS, whose segments are put together from FFmpeg's parts
+	dash   *dashManifest // Set for CMAF, whose segments are listed in a DASH manifest as well
+
+	mu         sync.Mutex
+	run        int // Counts the FFmpeg runs, 0 before the first
+	running    bool
+	nextNumber int // Segment number the next run starts at, no segment file is ever written twice
+	playlists  map[string]*stitchedPlaylist
+	masters    map[string][]byte
+	ended      bool
+	changed    chan struct{} // Closed and replaced whenever a playlist is published
+}
+
+// stitchedPlaylist is one public media playlist, made of the segments of every run
+type stitchedPlaylist struct {
+	name     string
+	header   []string // Carried tags of the latest run
+	version  int
+	target   int
+	segments []hlsSegment
+
+	mediaSequence         int // Of the first segment
+	discontinuitySequence int
+
+	run        int     // Run the last segment came from
+	runNext    int     // Sequence number of the run's next segment
+	runElapsed float64 // Media time of the run's segments so far
+	init       string  // Initialization section of the run's fMP4 segments
+	written    []byte
+
+	open openSegment // Low-Latency HLS only, the segment its latest parts belong to
+}
+
+type hlsSegment struct {
+	duration      float64
+	discontinuity bool
+	tags          []string // Segment tags before the URI, EXTINF included
+	uri           string
+
+	init  string    // fMP4 only, the initialization section
+	run   int       // Run the segment came from
+	start float64   // Media time the segment starts at in its run
+	parts []hlsPart // Low-Latency HLS only
+}
+
+func newHlsOutput(dir string, profile *transcode.Profile) *hlsOutput {
+	o := &hlsOutput{
+		dir:        dir,
+		runDir:     filepath.Join(dir, hlsRunDir),
+		nextNumber: lastSegmentNumber(dir) + 1, // Segments of an earlier task run may be in the bucket already
+		playlists:  make(map[string]*stitchedPlaylist),
+		masters:    make(map[string][]byte),
+		changed:    make(chan struct{}),
+	}
+	packaging := profile.Packaging
+	switch {
+	case profile.LowLatency():
+		o.ll = &lowLatency{partTarget: packaging.PartSeconds, segmentTarget: float64(packaging.SegmentSeconds)}
+	case profile.DASH():
+		o.dash = &dashManifest{segmentTarget: float64(packaging.SegmentSeconds), audioBandwidth: 1000 * profile.Audio.BitrateKbps}
+	}
+	return o
+}
+
+// startRun prepares the playlist directory of the next FFmpeg run, which numbers its segments from startNumber
+func (o *hlsOutput) startRun() (dir string, startNumber int, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := os.RemoveAll(o.runDir); err != nil {
+		return "", 0, err
+	}
+	if err := os.MkdirAll(o.runDir, os.ModePerm); err != nil {
+		return "", 0, err
+	}
+
+	o.run++
+	o.running = true
+	return o.runDir, o.nextNumber, nil
+}
+
+// endRun picks up what the FFmpeg run wrote last, once it has exited
+func (o *hlsOutput) endRun() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.sync()
+	o.running = false
+	if o.ll != nil {
+		// The next run starts with a discontinuity, so with a segment of its own
+		for _, pl := range o.playlists {
+			o.closeSegment(pl)
+			o.write(pl)
+		}
+	}
+	o.nextNumber = max(o.nextNumber, lastSegmentNumber(o.dir)+1)
+	os.RemoveAll(o.runDir)
+}
+
+// watch stitches the playlists of the running FFmpeg as it updates them, until ctx is done
+func (o *hlsOutput) watch(ctx context.Context) {
+	interval := hlsSyncInterval
+	if o.ll != nil {
+		interval = llhlsSyncInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			o.mu.Lock()
+			o.sync()
+			o.mu.Unlock()
+		}
+	}
+}
+
+// finish ends the public playlists, the stream is over
+func (o *hlsOutput) finish() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.ended = true
+	for _, pl := range o.playlists {
+		o.write(pl)
+	}
+	if o.dash != nil {
+		o.writeManifest()
+	}
+
+	// Requests waiting for more find there is none
+	close(o.changed)
+	o.changed = make(chan struct{})
+}
+
+// sync merges the playlists of the running FFmpeg. Must be called with o.mu held.
+func (o *hlsOutput) sync() {
+	if !o.running {
+		return
+	}
+
+	entries, err := os.ReadDir(o.runDir)
+	if err != nil {
+		return
+	}
+
+	changed := false
+	for _, entry := range entries {
+		name := entry.Name()
+		if filepath.Ext(name) != ".m3u8" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(o.runDir, name))
+		if err != nil || !bytes.HasPrefix(data, []byte("#EXTM3U")) {
+			continue
+		}
+
+		// The master playlist names the variants, it is the same for every run
+		if bytes.Contains(data, []byte("#EXT-X-STREAM-INF")) {
+			if !bytes.Equal(o.masters[name], data) {
+				if err := writeFileAtomic(filepath.Join(o.dir, name), data); err != nil {
+					slog.Error("Failed to write playlist", "path", filepath.Join(o.dir, name), "error", err)
+					continue
+				}
+				o.masters[name] = data
+				changed = true
+			}
+			continue
+		}
+
+		pl := o.playlists[name]
+		if pl == nil {
+			pl = o.newPlaylist(name)
+			o.playlists[name] = pl
+		}
+
+		merge := o.merge
+		if o.ll != nil {
+			merge = o.mergeParts
+		}
+		if merge(pl, data) {
+			o.write(pl)
+			changed = true
+		}
+	}
+
+	if changed && o.dash != nil {
+		o.writeManifest()
+	}
+}
+
+func (o *hlsOutput) newPlaylist(name string) *stitchedPlaylist {
+	pl := &stitchedPlaylist{name: name}
+	if o.ll != nil {
+		pl.open = openSegment{number: o.nextNumber, nextPart: lastPartNumber(o.dir, pl.base()) + 1}
+	}
+	return pl
+}
+
+// merge appends the segments of the run playlist that are new, reports whether the public playlist changed
+func (o *hlsOutput) merge(pl *stitchedPlaylist, data []byte) bool {
+	run := parseRunPlaylist(data)
+
+	pl.header = run.header
+	pl.version = max(pl.version, run.version)
+	pl.target = max(pl.target, run.target)
+
+	changed := false
+	for i, seg := range run.segments {
+		sequence := run.mediaSequence + i
+		if pl.run == o.run && sequence < pl.runNext {
+			continue
+		}
+
+		if pl.run != o.run {
+			// fMP4 runs have an initialization section of their own, the codecs may have changed
+			init := ""
+			if run.init != "" {
+				var err error
+				if init, err = o.publishInit(pl, run.init, sequence); err != nil {
+					slog.Error("Failed to publish HLS initialization section", "playlist", pl.name, "error", err)
+					break
+				}
+			}
+			if o.dash != nil {
+				o.startPeriod(seg.duration)
+			}
+			if pl.run != 0 {
+				seg.discontinuity = true
+			}
+			pl.init = init
+			pl.runElapsed = 0
+		}
+		seg.init = pl.init
+		seg.run = o.run
+		seg.start = pl.runElapsed
+		pl.runElapsed += seg.duration
+
+		pl.segments = append(pl.segments, seg)
+		pl.target = max(pl.target, int(math.Ceil(seg.duration)))
+		pl.run = o.run
+		pl.runNext = sequence + 1
+		o.nextNumber = max(o.nextNumber, sequence+1)
+		changed = true
+	}
+
+	o.trim(pl)
+	return changed
+}
+
+// trim drops the segments beyond the playlist size, deleting their files
+func (o *hlsOutput) trim(pl *stitchedPlaylist) {
+	for len(pl.segments) > hlsListSize {
+		dropped := pl.segments[0]
+		pl.segments = pl.segments[1:]
+		pl.mediaSequence++
+		if dropped.discontinuity {
+			pl.discontinuitySequence++
+		}
+
+		// FFmpeg only deletes the segments of its own run
+		if !strings.Contains(dropped.uri, "://") {
+			os.Remove(filepath.Join(o.dir, path.Base(dropped.uri)))
+		}
+		for _, part := range dropped.parts {
+			os.Remove(filepath.Join(o.dir, part.uri))
+		}
+
+		// An initialization section goes with the last segment of its run
+		if dropped.init != "" && dropped.init != pl.segments[0].init && dropped.init != pl.open.init {
+			os.Remove(filepath.Join(o.dir, dropped.init))
+		}
+	}
+}
+
+// publishInit copies the initialization section of the run under a name of its own, numbered after what comes first in it
+func (o *hlsOutput) publishInit(pl *stitchedPlaylist, uri string, number int) (string, error) {
+	data, err := os.ReadFile(filepath.Join(o.runDir, uri))
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s_init%d.mp4", pl.base(), number)
+	return name, writeFileAtomic(filepath.Join(o.dir, name), data)
+}
+
+// write publishes the playlist if it changed. Must be called with o.mu held.
+func (o *hlsOutput) write(pl *stitchedPlaylist) {
+	var data []byte
+	if o.ll != nil {
+		data = o.renderParts(pl, false)
+	} else {
+		data = o.render(pl)
+	}
+
+	if bytes.Equal(data, pl.written) {
+		return
+	}
+	if err := writeFileAtomic(filepath.Join(o.dir, pl.name), data); err != nil {
+		slog.Error("Failed to write playlist", "path", filepath.Join(o.dir, pl.name), "error", err)
+		return
+	}
+	pl.written = data
+
+	close(o.changed)
+	o.changed = make(chan struct{})
+}
+
+func (o *hlsOutput) render(pl *stitchedPlaylist) []byte {
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	if pl.version > 0 {
+		fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", pl.version)
+	}
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", pl.target)
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", pl.mediaSequence)
+	if pl.discontinuitySequence > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", pl.discontinuitySequence)
+	}
+	for _, line := range pl.header {
+		b.WriteString(line + "\n")
+	}
+
+	init := ""
+	for _, seg := range pl.segments {
+		if seg.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if seg.init != init {
+			fmt.Fprintf(&b, "#EXT-X-MAP:URI=%q\n", seg.init)
+			init = seg.init
+		}
+		for _, tag := range seg.tags {
+			b.WriteString(tag + "\n")
+		}
+		b.WriteString(path.Base(seg.uri) + "\n")
+	}
+	if o.ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return b.Bytes()
+}
+
+// runPlaylist is a media playlist as an FFmpeg run wrote it
+type runPlaylist struct {
+	header        []string
+	version       int
+	target        int
+	mediaSequence int
+	init          string // URI of the initialization section of fMP4 segments
+	segments      []hlsSegment
+}
+
+func parseRunPlaylist(data []byte) runPlaylist {
+	var run runPlaylist
+	var seg hlsSegment
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "#") {
+			seg.uri = line
+			run.segments = append(run.segments, seg)
+			seg = hlsSegment{}
+			continue
+		}
+
+		tag, value, _ := strings.Cut(line, ":")
+		switch {
+		case tag == "#EXT-X-VERSION":
+			run.version, _ = strconv.Atoi(value)
+		case tag == "#EXT-X-TARGETDURATION":
+			run.target, _ = strconv.Atoi(value)
+		case tag == "#EXT-X-MEDIA-SEQUENCE":
+			run.mediaSequence, _ = strconv.Atoi(value)
+		case tag == "#EXT-X-MAP":
+			run.init = attributeValue(value, "URI")
+		case computedPlaylistTags[tag]:
+		case carriedPlaylistTags[tag]:
+			run.header = append(run.header, line)
+		case tag == "#EXT-X-DISCONTINUITY":
+			seg.discontinuity = true
+		default:
+			if tag == "#EXTINF" {
+				duration, _, _ := strings.Cut(value, ",")
+				seg.duration, _ = strconv.ParseFloat(duration, 64)
+			}
+			seg.tags = append(seg.tags, line)
+		}
+	}
+	return run
+}
+
+// attributeValue is the value of an attribute of a tag, quotes removed
+func attributeValue(attributes, name string) string {
+	return parseAttributes(attributes)[name]
+}
+
+// parseAttributes reads the attribute list of a tag, quoted values may hold commas
+func parseAttributes(attributes string) map[string]string {
+	values := make(map[string]string)
+	for attributes != "" {
+		var key, value string
+		key, attributes, _ = strings.Cut(attributes, "=")
+		if quoted, ok := strings.CutPrefix(attributes, `"`); ok {
+			value, attributes, _ = strings.Cut(quoted, `"`)
+			_, attributes, _ = strings.Cut(attributes, ",")
+		} else {
+			value, attributes, _ = strings.Cut(attributes, ",")
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	return values
+}
+
+// lastSegmentNumber is the highest number among the segment files in dir, -1 without any
+func lastSegmentNumber(dir string) int {
+	last := -1
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return last
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if ext := filepath.Ext(name); ext != ".ts" && ext != ".m4s" {
+			continue
+		}
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+		if n, err := strconv.Atoi(name[strings.LastIndex(name, "_")+1:]); err == nil {
+			last = max(last, n)
+		}
+	}
+	return last
+}
+
+// writeFileAtomic replaces the file at path, readers and the uploader never see it half written
+func writeFileAtomic(path string, data []byte) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
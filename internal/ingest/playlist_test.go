@@ -0,0 +1,171 @@
+package ingest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
+)
+
+func TestParseRunPlaylist(t *testing.T) {
+	data := `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:12
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-MAP:URI="s_720p_init.mp4"
+#EXTINF:4.000000,
+#EXT-X-PROGRAM-DATE-TIME:2025-01-01T12:00:00.000Z
+s_720p_012.m4s
+#EXT-X-DISCONTINUITY
+#EXTINF:3.200000,
+s_720p_013.m4s
+
+#EXT-X-ENDLIST
+`
+	run := parseRunPlaylist([]byte(data))
+
+	if run.version != 7 || run.target != 4 || run.mediaSequence != 12 || run.init != "s_720p_init.mp4" {
+		t.Errorf("parseRunPlaylist = version %d, target %d, media sequence %d, init %q", run.version, run.target, run.mediaSequence, run.init)
+	}
+	if !slices.Equal(run.header, []string{"#EXT-X-INDEPENDENT-SEGMENTS"}) {
+		t.Errorf("header = %v, want only the carried tags", run.header)
+	}
+	if len(run.segments) != 2 {
+		t.Fatalf("parseRunPlaylist found %d segments, want 2", len(run.segments))
+	}
+
+	first, second := run.segments[0], run.segments[1]
+	if first.uri != "s_720p_012.m4s" || first.duration != 4 || first.discontinuity ||
+		!slices.Equal(first.tags, []string{"#EXTINF:4.000000,", "#EXT-X-PROGRAM-DATE-TIME:2025-01-01T12:00:00.000Z"}) {
+		t.Errorf("first segment = %+v", first)
+	}
+	if second.uri != "s_720p_013.m4s" || second.duration != 3.2 || !second.discontinuity {
+		t.Errorf("second segment = %+v", second)
+	}
+}
+
+func TestParseAttributes(t *testing.T) {
+	got := parseAttributes(`BANDWIDTH=5500000,CODECS="avc1.640028,mp4a.40.2",RESOLUTION=1920x1080,AUDIO="audio"`)
+	want := map[string]string{
+		"BANDWIDTH":  "5500000",
+		"CODECS":     "avc1.640028,mp4a.40.2",
+		"RESOLUTION": "1920x1080",
+		"AUDIO":      "audio",
+	}
+	if len(got) != len(want) {
+		t.Errorf("parseAttributes = %v, want %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %q, want %q", key, got[key], value)
+		}
+	}
+}
+
+// hlsRun writes what an FFmpeg run of s.m3u8 wrote so far: its playlist in the run directory and its segments in dir
+func hlsRun(t *testing.T, o *hlsOutput, mediaSequence int, durations ...float64) {
+	t.Helper()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:%d\n#EXT-X-INDEPENDENT-SEGMENTS\n", mediaSequence)
+	for i, duration := range durations {
+		name := fmt.Sprintf("s_%03d.ts", mediaSequence+i)
+		if err := os.WriteFile(filepath.Join(o.dir, name), []byte("ts"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&b, "#EXTINF:%f,\n%s\n", duration, name)
+	}
+	if err := os.WriteFile(filepath.Join(o.runDir, "s.m3u8"), []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	o.mu.Lock()
+	o.sync()
+	o.mu.Unlock()
+}
+
+func published(t *testing.T, o *hlsOutput) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(o.dir, "s.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestStitchedPlaylist(t *testing.T) {
+	o := newHlsOutput(t.TempDir(), &transcode.Profile{})
+
+	_, start, err := o.startRun()
+	if err != nil || start != 0 {
+		t.Fatalf("first run starts at %d: %v", start, err)
+	}
+	hlsRun(t, o, 0, 4, 4, 3.5)
+
+	// FFmpeg slides its own playlist, only what is new is appended
+	hlsRun(t, o, 1, 4, 3.5, 4)
+
+	// A run after the publisher reconnected carries on the numbering, after a discontinuity
+	o.endRun()
+	_, start, err = o.startRun()
+	if err != nil || start != 4 {
+		t.Fatalf("second run starts at %d: %v", start, err)
+	}
+	hlsRun(t, o, 4, 4.5, 4)
+
+	want := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:5
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXTINF:4.000000,
+s_000.ts
+#EXTINF:4.000000,
+s_001.ts
+#EXTINF:3.500000,
+s_002.ts
+#EXTINF:4.000000,
+s_003.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:4.500000,
+s_004.ts
+#EXTINF:4.000000,
+s_005.ts
+`
+	if got := published(t, o); got != want {
+		t.Errorf("stitched playlist\n got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Segments beyond the playlist size are dropped, files and discontinuities included
+	o.endRun()
+	_, start, _ = o.startRun()
+	hlsRun(t, o, start, 4, 4, 4, 4, 4, 4, 4, 4, 4)
+
+	got := published(t, o)
+	if !strings.Contains(got, "#EXT-X-MEDIA-SEQUENCE:5\n#EXT-X-DISCONTINUITY-SEQUENCE:1\n") {
+		t.Errorf("trimmed playlist does not count what it dropped:\n%s", got)
+	}
+	if !strings.Contains(got, "#EXT-X-INDEPENDENT-SEGMENTS\n#EXTINF:4.000000,\ns_005.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:4.000000,\ns_006.ts\n") {
+		t.Errorf("trimmed playlist does not start at s_005.ts:\n%s", got)
+	}
+	if n := strings.Count(got, "#EXTINF"); n != hlsListSize {
+		t.Errorf("trimmed playlist has %d segments, want %d", n, hlsListSize)
+	}
+	for number := range 6 {
+		_, err := os.Stat(filepath.Join(o.dir, fmt.Sprintf("s_%03d.ts", number)))
+		if dropped := number < 5; dropped != os.IsNotExist(err) {
+			t.Errorf("s_%03d.ts: dropped %v, stat %v", number, dropped, err)
+		}
+	}
+
+	o.endRun()
+	o.finish()
+	if got := published(t, o); !strings.HasSuffix(got, "s_014.ts\n#EXT-X-ENDLIST\n") {
+		t.Errorf("finished playlist does not end with EXT-X-ENDLIST:\n%s", got)
+	}
+}
@@ -0,0 +1,197 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
+)
+
+// How much media ffprobe looks at, and how long it may take, before FFmpeg is started
+const (
+	probeSize     = 2 << 20
+	probeDuration = 3 * time.Second
+	probeTimeout  = 8 * time.Second
+)
+
+// Most media read from a piped publisher while probing, ffprobe stops reading well before
+const maxProbeBuffer = 2 * probeSize
+
+// Input options of FFmpeg that ffprobe does not take, with the number of values they have
+var ffmpegOnlyInputOptions = map[string]int{
+	"-re":                  0,
+	"-dts_delta_threshold": 1,
+}
+
+// sourceDescriber is a publisher that knows its media without probing
+type sourceDescriber interface {
+	describeSource() *transcode.Source
+}
+
+// probeSource finds out what the publisher sends, the profile's ladder is fitted to it.
+// Media a piped publisher sent while it was probed is returned, FFmpeg has to be given it first.
+// A source that cannot be probed is nil, FFmpeg then gets the profile as it is.
+func probeSource(ctx context.Context, pub publisher) (*transcode.Source, []byte, error) {
+	if describer, ok := pub.(sourceDescriber); ok {
+		return describer.describeSource(), nil, nil
+	}
+
+	input := probeInput(pub.InputArgs())
+	args := append([]string{
+		"-v", "error",
+		"-probesize", strconv.Itoa(probeSize),
+		"-analyzeduration", strconv.FormatInt(probeDuration.Microseconds(), 10),
+		"-show_entries", "stream=codec_type,codec_name,width,height,avg_frame_rate,r_frame_rate",
+		"-of", "json",
+	}, input...)
+
+	probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(probeCtx, "ffprobe", args...)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+
+	var buffered []byte
+	if input[len(input)-1] == "pipe:0" {
+		var err error
+		if buffered, err = probePipe(ctx, cmd, pub); err != nil {
+			return nil, buffered, err
+		}
+	} else if err := cmd.Run(); err != nil {
+		slog.Warn("Failed to probe source", "remote_addr", pub.RemoteAddr(), "error", err)
+		return nil, nil, nil
+	}
+
+	src, err := parseProbe(output.Bytes())
+	if err != nil {
+		slog.Warn("Failed to probe source", "remote_addr", pub.RemoteAddr(), "error", err)
+		return nil, buffered, nil
+	}
+	return src, buffered, nil
+}
+
+// probePipe feeds ffprobe what the publisher sends until it has seen enough or was killed for taking too long,
+// and returns all of it. Only the publisher failing is an error, ffprobe failing leaves the source unknown.
+func probePipe(ctx context.Context, cmd *exec.Cmd, pub publisher) ([]byte, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil
+	}
+	if err := cmd.Start(); err != nil {
+		slog.Warn("Failed to probe source", "remote_addr", pub.RemoteAddr(), "error", err)
+		return nil, nil
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	// A publisher that goes quiet is dropped by its own idle timeout, the stream stopping closes it
+	stop := context.AfterFunc(ctx, func() { pub.Close() })
+	defer stop()
+
+	var buffered []byte
+	buf := make([]byte, 8*1316)
+	for len(buffered) < maxProbeBuffer {
+		n, err := pub.Read(buf)
+		if err != nil {
+			stdin.Close()
+			<-exited
+			return buffered, err
+		}
+		buffered = append(buffered, buf[:n]...)
+
+		// Writes fail once ffprobe has seen enough and exited
+		if _, err := stdin.Write(buf[:n]); err != nil {
+			break
+		}
+	}
+
+	stdin.Close()
+	<-exited
+	return buffered, nil
+}
+
+// probeInput is the input of FFmpeg as ffprobe takes it
+func probeInput(args []string) []string {
+	var input []string
+	for i := 0; i < len(args); i++ {
+		if values, ok := ffmpegOnlyInputOptions[args[i]]; ok {
+			i += values
+			continue
+		}
+		input = append(input, args[i])
+	}
+	return input
+}
+
+type probeOutput struct {
+	Streams []struct {
+		CodecType    string `json:"codec_type"`
+		CodecName    string `json:"codec_name"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+		AvgFrameRate string `json:"avg_frame_rate"`
+		RFrameRate   string `json:"r_frame_rate"`
+	} `json:"streams"`
+}
+
+func parseProbe(data []byte) (*transcode.Source, error) {
+	var probe probeOutput
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("decode ffprobe output: %w", err)
+	}
+
+	src := &transcode.Source{}
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "video":
+			if src.Video {
+				continue
+			}
+			src.Video, src.VideoCodec = true, stream.CodecName
+			src.Width, src.Height = stream.Width, stream.Height
+			src.FrameRate = parseFrameRate(stream.AvgFrameRate)
+			if src.FrameRate == 0 {
+				src.FrameRate = parseFrameRate(stream.RFrameRate)
+			}
+		case "audio":
+			if !src.Audio {
+				src.Audio, src.AudioCodec = true, stream.CodecName
+			}
+		}
+	}
+
+	if !src.Video && !src.Audio {
+		return nil, errors.New("no audio or video stream found")
+	}
+	return src, nil
+}
+
+// parseFrameRate reads ffprobe's num/den rates, 0 when unknown or implausible
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		return 0
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	if fps := n / d; fps >= 1 && fps <= 240 {
+		return fps
+	}
+	return 0
+}
@@ -0,0 +1,56 @@
+package ingest
+
+import (
+	"fmt"
+
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
+)
+
+// SetProfiles sets the transcoding profiles streams are started with, before streams are started or restored
+func (tm *TaskManager) SetProfiles(profiles *transcode.Registry) {
+	tm.profiles = profiles
+}
+
+// Profiles are the transcoding profiles streams can be started with, the built-in ones until others are set
+func (tm *TaskManager) Profiles() *transcode.Registry {
+	return tm.profiles
+}
+
+// selectProfile picks the profile a start request asks for. abr without a profile is the built-in ladder.
+func (tm *TaskManager) selectProfile(name string, abr bool) (*transcode.Profile, error) {
+	if name == "" && abr {
+		name = transcode.AbrProfileName
+	}
+
+	profile, err := tm.Profiles().Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if abr && !profile.MasterPlaylist() {
+		return nil, fmt.Errorf("%w: abr needs a profile with more than one rendition, %q has one", transcode.ErrInvalidProfile, profile.Name)
+	}
+	return profile, nil
+}
+
+// resolveProfile makes sure the task has its profile. Records from before profiles existed get the one abr picked.
+func (tm *TaskManager) resolveProfile(task *Task) error {
+	if task.Profile == nil {
+		profile, err := tm.selectProfile("", task.Abr)
+		if err != nil {
+			return err
+		}
+		task.Profile = profile
+	}
+
+	// Whether there is a master playlist is up to the profile
+	task.Abr = task.Profile.MasterPlaylist()
+	return nil
+}
+
+// profileName is the name of the task's profile, empty before it is resolved
+func (task *Task) profileName() string {
+	if task.Profile == nil {
+		return ""
+	}
+	return task.Profile.Name
+}
@@ -0,0 +1,304 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delay between attempts to reach a pull source that is down
+const (
+	pullInitialBackoff = time.Second
+	pullMaxBackoff     = 30 * time.Second
+)
+
+// How long a pull source gets to answer a reachability probe
+const pullProbeTimeout = 5 * time.Second
+
+// An FFmpeg run on a pulled URL that ends sooner counts as a failed attempt
+const pullStableAfter = 10 * time.Second
+
+var ErrInvalidPullSource = errors.New("invalid pull source")
+
+// PullSource is a remote feed LiveTran connects to instead of waiting for a publisher
+type PullSource struct {
+	URL        string `json:"url"`
+	Passphrase string `json:"passphrase,omitempty"` // SRT only
+	StreamId   string `json:"streamid,omitempty"`   // SRT only
+}
+
+// Protocol is the protocol named by the scheme of the pull URL
+func (p *PullSource) Protocol() Protocol {
+	scheme, _, _ := strings.Cut(p.URL, "://")
+
+	switch scheme = strings.ToLower(scheme); scheme {
+	case "rtsp", "rtsps":
+		return ProtocolRTSP
+	case "http", "https":
+		return ProtocolHLS
+	}
+	return Protocol(scheme)
+}
+
+// validate checks the source can be pulled with protocol
+func (p *PullSource) validate(protocol Protocol) error {
+	if p.Protocol() != protocol {
+		return fmt.Errorf("%w: %q is not a %s URL", ErrInvalidPullSource, p.redactedURL(), protocol)
+	}
+
+	switch protocol {
+	case ProtocolSRT:
+		if _, _, err := srtCallerConfig(p); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidPullSource, err)
+		}
+		return nil
+	case ProtocolRTSP, ProtocolHLS:
+		if p.Passphrase != "" || p.StreamId != "" {
+			return fmt.Errorf("%w: passphrase and streamid only apply to SRT sources", ErrInvalidPullSource)
+		}
+		if _, _, err := pullHostPort(p.URL); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidPullSource, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %s sources cannot be pulled", ErrInvalidPullSource, protocol)
+}
+
+// redactedURL drops the credentials and the query, which can carry some too
+func (p *PullSource) redactedURL() string {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return ""
+	}
+	u.RawQuery = ""
+	u.User = nil
+	return u.String()
+}
+
+func samePullSource(a, b *PullSource) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// preparePull resolves the task's remote source, nothing is dialed before the task runs
+func preparePull(task *Task) (ingestSource, IngestInfo, error) {
+	if err := task.Pull.validate(task.Protocol); err != nil {
+		return nil, IngestInfo{}, err
+	}
+
+	switch task.Protocol {
+	case ProtocolSRT:
+		return prepareSrtPull(task)
+	case ProtocolRTSP, ProtocolHLS:
+		return prepareURLPull(task)
+	}
+	return nil, IngestInfo{}, fmt.Errorf("%w: %s sources cannot be pulled", ErrInvalidPullSource, task.Protocol)
+}
+
+// prepareURLPull pulls a URL FFmpeg reads by itself, RTSP cameras and HLS feeds
+func prepareURLPull(task *Task) (ingestSource, IngestInfo, error) {
+	host, port, err := pullHostPort(task.Pull.URL)
+	if err != nil {
+		return nil, IngestInfo{}, fmt.Errorf("%w: %s", ErrInvalidPullSource, err)
+	}
+
+	info := IngestInfo{
+		StreamId:    task.Id,
+		Protocol:    task.Protocol,
+		URL:         task.Pull.redactedURL(),
+		Pull:        true,
+		Host:        host,
+		Port:        port,
+		PlaybackURL: PlaybackURL(task.Id, task.Abr),
+		DashURL:     DashPlaybackURL(task.Id, task.Profile),
+	}
+
+	return &urlSource{task: task}, info, nil
+}
+
+// pullHostPort is where a pull URL connects to, with the default port of its scheme
+func pullHostPort(rawURL string) (string, int, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", 0, err
+	}
+	if u.Hostname() == "" {
+		return "", 0, fmt.Errorf("pull URL has no host")
+	}
+
+	if u.Port() != "" {
+		port, err := strconv.Atoi(u.Port())
+		return u.Hostname(), port, err
+	}
+
+	defaults := map[string]int{"rtsp": 554, "rtsps": 322, "http": 80, "https": 443}
+	port, ok := defaults[strings.ToLower(u.Scheme)]
+	if !ok {
+		return "", 0, fmt.Errorf("pull URL has no port")
+	}
+	return u.Hostname(), port, nil
+}
+
+// urlSource probes a remote URL until it answers, then lets FFmpeg pull it.
+// Every FFmpeg run stands for one publisher connection.
+type urlSource struct {
+	task    *Task
+	attempt int
+	started time.Time
+}
+
+func (src *urlSource) accept(ctx context.Context) (publisher, error) {
+	// The probe cannot see everything FFmpeg can fail on (a wrong path, unsupported codecs).
+	// A run that lasted is a fresh start, the next outage backs off from the beginning.
+	if !src.started.IsZero() {
+		if time.Since(src.started) < pullStableAfter {
+			src.attempt++
+		} else {
+			src.attempt = 0
+		}
+	}
+
+	for {
+		if src.attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, acceptCanceled(ctx)
+			case <-time.After(pullBackoff(src.attempt)):
+			}
+		}
+
+		err := probePullSource(ctx, src.task.Protocol, src.task.Pull.URL)
+		if ctx.Err() != nil {
+			return nil, acceptCanceled(ctx)
+		}
+		if err != nil {
+			src.attempt++
+			slog.Warn("Pull source unreachable",
+				"stream_id", src.task.Id,
+				"url", src.task.Pull.redactedURL(),
+				"attempt", src.attempt,
+				"error", err,
+			)
+			continue
+		}
+
+		src.started = time.Now()
+		return newURLPublisher(src.task), nil
+	}
+}
+
+func (src *urlSource) Close() {}
+
+// probePullSource checks the source answers: the RTSP server accepts connections, the HLS playlist is served
+func probePullSource(ctx context.Context, protocol Protocol, rawURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, pullProbeTimeout)
+	defer cancel()
+
+	switch protocol {
+	case ProtocolRTSP:
+		host, port, err := pullHostPort(rawURL)
+		if err != nil {
+			return err
+		}
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+
+	case ProtocolHLS:
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("playlist returned %s", resp.Status)
+		}
+		head := make([]byte, 7)
+		if _, err := io.ReadFull(resp.Body, head); err != nil || !bytes.Equal(head, []byte("#EXTM3U")) {
+			return fmt.Errorf("response is not an HLS playlist")
+		}
+		return nil
+	}
+	return fmt.Errorf("%s sources cannot be probed", protocol)
+}
+
+// urlPublisher stands for an FFmpeg run that reads the remote URL itself, no media goes through LiveTran
+type urlPublisher struct {
+	input      []string
+	remoteAddr string
+
+	done chan struct{}
+	once sync.Once
+}
+
+func newURLPublisher(task *Task) *urlPublisher {
+	var input []string
+	switch task.Protocol {
+	case ProtocolRTSP:
+		input = []string{
+			"-rtsp_transport", "tcp", // Cameras behind NAT rarely get UDP through
+			"-timeout", "10000000", // Give up after 10s without data (microseconds)
+		}
+	case ProtocolHLS:
+		input = []string{
+			"-m3u8_hold_counters", "10", // Give up once the playlist stopped advancing
+		}
+	}
+
+	return &urlPublisher{
+		input:      append(input, "-i", task.Pull.URL),
+		remoteAddr: task.Pull.redactedURL(),
+		done:       make(chan struct{}),
+	}
+}
+
+// Read carries no media. It blocks until FFmpeg is done with the URL.
+func (pub *urlPublisher) Read(p []byte) (int, error) {
+	<-pub.done
+	return 0, fmt.Errorf("FFmpeg stopped pulling %s", pub.remoteAddr)
+}
+
+func (pub *urlPublisher) Close() error {
+	pub.once.Do(func() {
+		close(pub.done)
+	})
+	return nil
+}
+
+func (pub *urlPublisher) RemoteAddr() string {
+	return pub.remoteAddr
+}
+
+func (pub *urlPublisher) InputArgs() []string {
+	return pub.input
+}
+
+// Exponential backoff with up to 20% jitter, capped at pullMaxBackoff
+func pullBackoff(attempt int) time.Duration {
+	delay := float64(pullInitialBackoff) * math.Pow(2, float64(attempt-1))
+	delay = math.Min(delay, float64(pullMaxBackoff))
+	jitter := delay * 0.2 * mrand.Float64()
+	return time.Duration(delay + jitter)
+}
@@ -0,0 +1,199 @@
+package ingest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hlsStandIn serves what a remote HLS origin would at /live.m3u8, /missing.m3u8 and /page.html
+func hlsStandIn(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/live.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n"))
+	})
+	mux.HandleFunc("/page.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<!doctype html>"))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// rtspStandIn listens where an RTSP server would, the probe only needs the TCP handshake
+func rtspStandIn(t *testing.T, addr string) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	serveRTSPStandIn(ln)
+	return ln
+}
+
+func serveRTSPStandIn(ln net.Listener) {
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+}
+
+func TestProbePullSource(t *testing.T) {
+	hls := hlsStandIn(t)
+	rtsp := rtspStandIn(t, "127.0.0.1:0")
+
+	closed := rtspStandIn(t, "127.0.0.1:0")
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		protocol Protocol
+		url      string
+		ok       bool
+	}{
+		{"hls playlist", ProtocolHLS, hls.URL + "/live.m3u8", true},
+		{"hls not found", ProtocolHLS, hls.URL + "/missing.m3u8", false},
+		{"hls not a playlist", ProtocolHLS, hls.URL + "/page.html", false},
+		{"hls server down", ProtocolHLS, "http://" + closedAddr + "/live.m3u8", false},
+		{"rtsp listening", ProtocolRTSP, "rtsp://" + rtsp.Addr().String() + "/live", true},
+		{"rtsp refused", ProtocolRTSP, "rtsp://" + closedAddr + "/live", false},
+		{"srt", ProtocolSRT, "srt://" + rtsp.Addr().String(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := probePullSource(context.Background(), tt.protocol, tt.url)
+			if tt.ok && err != nil {
+				t.Errorf("probePullSource: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("probePullSource succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestPullBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		base    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{5, 16 * time.Second},
+		{6, pullMaxBackoff},
+		{40, pullMaxBackoff},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			got := pullBackoff(tt.attempt)
+			if got < tt.base || got > tt.base+tt.base/5 {
+				t.Errorf("pullBackoff(%d) = %v, want %v plus at most 20%%", tt.attempt, got, tt.base)
+				break
+			}
+		}
+	}
+}
+
+func TestURLSourceReconnect(t *testing.T) {
+	// The origin fails its first request, as if it were still coming up
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("#EXTM3U\n"))
+	}))
+	defer srv.Close()
+
+	task := &Task{Id: "pulled", Protocol: ProtocolHLS, Pull: &PullSource{URL: srv.URL + "/live.m3u8"}}
+	src := &urlSource{task: task}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pub, err := src.accept(ctx)
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	pub.Close()
+	if src.attempt != 1 || requests.Load() != 2 {
+		t.Errorf("accept took %d attempts and %d requests, want 1 retry", src.attempt, requests.Load())
+	}
+
+	// A run that lasted starts the next outage from the first backoff again
+	src.attempt = 5
+	src.started = time.Now().Add(-pullStableAfter)
+	start := time.Now()
+	pub, err = src.accept(ctx)
+	if err != nil {
+		t.Fatalf("accept after a stable run: %v", err)
+	}
+	pub.Close()
+	if src.attempt != 0 || time.Since(start) >= pullInitialBackoff {
+		t.Errorf("accept after a stable run: attempt %d after %v, want an immediate reconnect", src.attempt, time.Since(start))
+	}
+
+	// A run that ended right away counts as a failed attempt and backs off
+	src.started = time.Now()
+	short, cancelShort := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelShort()
+	if _, err := src.accept(short); err == nil {
+		t.Errorf("accept after a short run reconnected without backing off")
+	}
+	if src.attempt != 1 {
+		t.Errorf("attempt after a short run = %d, want 1", src.attempt)
+	}
+}
+
+func TestURLSourceReconnectRTSP(t *testing.T) {
+	// Reserve an address, then leave nothing listening on it
+	reserved := rtspStandIn(t, "127.0.0.1:0")
+	addr := reserved.Addr().String()
+	reserved.Close()
+
+	task := &Task{Id: "pulled", Protocol: ProtocolRTSP, Pull: &PullSource{URL: "rtsp://" + addr + "/live"}}
+	src := &urlSource{task: task}
+
+	// The server comes up during the first backoff
+	up := make(chan net.Listener, 1)
+	time.AfterFunc(200*time.Millisecond, func() {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Errorf("restarting the stand-in: %v", err)
+			close(up)
+			return
+		}
+		serveRTSPStandIn(ln)
+		up <- ln
+	})
+	defer func() {
+		if ln, ok := <-up; ok {
+			ln.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pub, err := src.accept(ctx)
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer pub.Close()
+	if src.attempt != 1 {
+		t.Errorf("accept took %d attempts, want 1 retry", src.attempt)
+	}
+}
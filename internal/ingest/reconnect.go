@@ -0,0 +1,210 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/vijayvenkatj/LiveTran/internal/config"
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
+)
+
+const maxReconnectGrace = time.Hour
+
+// Size and rate of the slate, the ABR ladder scales it like any publisher
+const (
+	slateWidth  = 1280
+	slateHeight = 720
+	slateRate   = 30
+)
+
+var ErrInvalidReconnectOptions = errors.New("invalid reconnect options")
+
+// Slate images end up in an FFmpeg filter graph, paths needing escapes there are refused
+var slateImagePath = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
+// ReconnectOptions decide what viewers get while the publisher of a live stream is away
+type ReconnectOptions struct {
+	GraceSeconds int   `json:"grace_seconds,omitempty"` // How long the stream stays LIVE waiting for the publisher, -1 turns the grace window off
+	Slate        *bool `json:"slate,omitempty"`         // Fill the grace window with a slate instead of letting the playlist stall
+}
+
+// ReconnectConfig are the server defaults of the reconnect options
+type ReconnectConfig struct {
+	Defaults ReconnectOptions
+	// Image shown by the slate, black frames without one
+	SlateImage string
+}
+
+func ReconnectConfigFromEnv() ReconnectConfig {
+	slate := config.EnvBool("RECONNECT_SLATE", false)
+
+	return ReconnectConfig{
+		Defaults: ReconnectOptions{
+			GraceSeconds: int(config.EnvDuration("RECONNECT_GRACE", 0).Seconds()),
+			Slate:        &slate,
+		},
+		SlateImage: os.Getenv("RECONNECT_SLATE_IMAGE"),
+	}
+}
+
+// SetReconnect configures the reconnect defaults, before streams are started or restored
+func (tm *TaskManager) SetReconnect(cfg ReconnectConfig) error {
+	if _, err := cfg.Defaults.merge().resolve(); err != nil {
+		return fmt.Errorf("reconnect defaults: %s", err)
+	}
+	if cfg.SlateImage != "" && !slateImagePath.MatchString(cfg.SlateImage) {
+		return fmt.Errorf("slate image %q: only letters, digits and ._/- are allowed in the path", cfg.SlateImage)
+	}
+
+	tm.reconnect = cfg
+	return nil
+}
+
+// merge overrides the options with the fields set in each of layers, later layers win
+func (opts ReconnectOptions) merge(layers ...*ReconnectOptions) *ReconnectOptions {
+	for _, layer := range layers {
+		if layer == nil {
+			continue
+		}
+		if layer.GraceSeconds != 0 {
+			opts.GraceSeconds = layer.GraceSeconds
+		}
+		if layer.Slate != nil {
+			slate := *layer.Slate
+			opts.Slate = &slate
+		}
+	}
+	if opts.GraceSeconds < 0 {
+		opts.GraceSeconds = 0
+	}
+	return &opts
+}
+
+// resolve validates merged options and fills in what no layer set
+func (opts *ReconnectOptions) resolve() (*ReconnectOptions, error) {
+	if opts.grace() > maxReconnectGrace {
+		return nil, fmt.Errorf("%w: grace_seconds must be at most %d, or -1 for none", ErrInvalidReconnectOptions, int(maxReconnectGrace.Seconds()))
+	}
+	if opts.Slate == nil {
+		slate := false
+		opts.Slate = &slate
+	}
+	return opts, nil
+}
+
+// matches reports whether a start request asks for the options the task runs with, fields left out accept any value
+func (opts *ReconnectOptions) matches(requested *ReconnectOptions) bool {
+	if requested == nil {
+		return true
+	}
+	if opts == nil {
+		return false
+	}
+	merged := opts.merge(requested)
+	return merged.GraceSeconds == opts.GraceSeconds && merged.slate() == opts.slate()
+}
+
+func (opts *ReconnectOptions) clone() *ReconnectOptions {
+	if opts == nil {
+		return nil
+	}
+	return ReconnectOptions{}.merge(opts)
+}
+
+// grace is how long a live stream waits for its publisher before it goes to RECONNECTING
+func (opts *ReconnectOptions) grace() time.Duration {
+	if opts == nil {
+		return 0
+	}
+	return time.Duration(opts.GraceSeconds) * time.Second
+}
+
+func (opts *ReconnectOptions) slate() bool {
+	return opts != nil && opts.Slate != nil && *opts.Slate
+}
+
+// resolveReconnect sets the task's reconnect options over the server defaults
+func (tm *TaskManager) resolveReconnect(task *Task) error {
+	opts, err := tm.reconnect.Defaults.merge(task.Reconnect).resolve()
+	if err != nil {
+		return err
+	}
+	task.Reconnect = opts
+	task.slateImage = tm.reconnect.SlateImage
+	return nil
+}
+
+// startSlate keeps the stream's playlist going with the slate until the returned function is called
+func startSlate(ctx context.Context, task *Task, wg *sync.WaitGroup) func() {
+	slateCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		err := ProcessStream(slateCtx, newSlatePublisher(task.slateImage), task, wg)
+		if err != nil && slateCtx.Err() == nil {
+			slog.Warn("Slate stopped", "stream_id", task.Id, "error", err)
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// slatePublisher stands in for the publisher during the grace window, FFmpeg renders the slate itself
+type slatePublisher struct {
+	graph string
+
+	done chan struct{}
+	once sync.Once
+}
+
+func newSlatePublisher(image string) *slatePublisher {
+	video := fmt.Sprintf("color=c=black:s=%dx%d:r=%d", slateWidth, slateHeight, slateRate)
+	if image != "" {
+		// The image is a single frame, looped and stamped at the slate's rate
+		video = fmt.Sprintf("movie=%s:loop=0,setpts=N/(%d*TB),scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2",
+			image, slateRate, slateWidth, slateHeight, slateWidth, slateHeight)
+	}
+
+	return &slatePublisher{
+		graph: video + ",format=yuv420p[out0];anullsrc=r=48000:cl=stereo[out1]",
+		done:  make(chan struct{}),
+	}
+}
+
+// Read carries no media. It blocks until the slate is taken down.
+func (pub *slatePublisher) Read(p []byte) (int, error) {
+	<-pub.done
+	return 0, fmt.Errorf("slate stopped")
+}
+
+func (pub *slatePublisher) Close() error {
+	pub.once.Do(func() {
+		close(pub.done)
+	})
+	return nil
+}
+
+func (pub *slatePublisher) RemoteAddr() string {
+	return "slate"
+}
+
+// describeSource tells FFmpeg what the slate sends. The variants stay those fitted to the first publisher,
+// its raw frames are scaled to them and encoded, a passthrough rendition included.
+func (pub *slatePublisher) describeSource() *transcode.Source {
+	return &transcode.Source{FrameRate: slateRate, Video: true, Audio: true}
+}
+
+func (pub *slatePublisher) InputArgs() []string {
+	return []string{"-re", "-f", "lavfi", "-i", pub.graph}
+}
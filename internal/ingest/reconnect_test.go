@@ -0,0 +1,211 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
+)
+
+// The slate comes after a publisher the ladder was trimmed for, it keeps that ladder
+func TestSlateKeepsLayout(t *testing.T) {
+	profile, err := transcode.BuiltinRegistry().Get(transcode.AbrProfileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Id: "slate", Profile: profile, Mode: transcode.ModeTranscode}
+
+	first := task.fitLayout(&transcode.Source{Width: 1280, Height: 720, FrameRate: 30, Video: true, VideoCodec: "h264"})
+	if len(first.Ladder) != 2 || first.Audio {
+		t.Fatalf("layout of a 720p publisher without audio = %+v, want 2 renditions and no audio", first)
+	}
+
+	slate := newSlatePublisher("")
+	defer slate.Close()
+	got := task.fitLayout(slate.describeSource())
+	if !slices.Equal(got.Ladder, first.Ladder) || got.Video != first.Video || got.Audio != first.Audio {
+		t.Errorf("slate layout = %+v, want the publisher's %+v", got, first)
+	}
+}
+
+func TestReconnectOptions(t *testing.T) {
+	on, off := true, false
+	defaults := ReconnectOptions{GraceSeconds: 30, Slate: &on}
+
+	tests := []struct {
+		name      string
+		requested *ReconnectOptions
+		grace     time.Duration
+		slate     bool
+		valid     bool
+	}{
+		{"server defaults", nil, 30 * time.Second, true, true},
+		{"longer window", &ReconnectOptions{GraceSeconds: 120}, 2 * time.Minute, true, true},
+		{"no slate", &ReconnectOptions{Slate: &off}, 30 * time.Second, false, true},
+		{"no grace window", &ReconnectOptions{GraceSeconds: -1}, 0, true, true},
+		{"window of an hour", &ReconnectOptions{GraceSeconds: 3600}, time.Hour, true, true},
+		{"window over an hour", &ReconnectOptions{GraceSeconds: 3601}, 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := defaults.merge(tt.requested).resolve()
+			if !tt.valid {
+				if !errors.Is(err, ErrInvalidReconnectOptions) {
+					t.Errorf("resolve = %v, want %v", err, ErrInvalidReconnectOptions)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.grace() != tt.grace || opts.slate() != tt.slate {
+				t.Errorf("grace %s and slate %v, want %s and %v", opts.grace(), opts.slate(), tt.grace, tt.slate)
+			}
+		})
+	}
+}
+
+// queuedSource hands out the publishers sent on its channel
+type queuedSource chan publisher
+
+func (src queuedSource) accept(ctx context.Context) (publisher, error) {
+	select {
+	case <-ctx.Done():
+		return nil, acceptCanceled(ctx)
+	case pub := <-src:
+		return pub, nil
+	}
+}
+
+func (src queuedSource) Close() {}
+
+// droppedPublisher is gone before it sent anything
+type droppedPublisher struct{}
+
+func (droppedPublisher) Read(p []byte) (int, error) { return 0, io.EOF }
+func (droppedPublisher) Close() error               { return nil }
+func (droppedPublisher) RemoteAddr() string         { return "10.0.0.1:5000" }
+func (droppedPublisher) InputArgs() []string        { return []string{"-f", "mpegts", "-i", "pipe:0"} }
+
+// reconnectingTask is a stream whose playlist is published already, so it goes LIVE as soon as a publisher connects
+func reconnectingTask(t *testing.T, opts ReconnectOptions) (*Task, *Subscription) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+
+	profile, err := transcode.BuiltinRegistry().Get(transcode.AbrProfileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := opts.merge().resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := NewEventBus()
+	sub := events.Subscribe("test", 64, DropNewest, nil)
+	t.Cleanup(sub.Close)
+
+	task := &Task{Id: "reconnect", Protocol: ProtocolRTMP, Status: StreamReady, Profile: profile, Mode: transcode.ModeTranscode, Reconnect: resolved, events: events}
+	task.hls = newHlsOutput(filepath.Join("output", task.Id), profile)
+	task.setStreamURL("https://cdn.example/reconnect/index.m3u8")
+	return task, sub
+}
+
+// expectEvent waits for the next event of the stream, which has to be eventType emitted in status
+func expectEvent(t *testing.T, sub *Subscription, eventType EventType, status StreamState) Event {
+	t.Helper()
+	select {
+	case event := <-sub.Events():
+		if event.Type != eventType || event.Status != status {
+			t.Fatalf("event = %s in %s, want %s in %s", event.Type, event.Status, eventType, status)
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no %s event", eventType)
+		return Event{}
+	}
+}
+
+// The stream stays LIVE with the slate on while the publisher is away, and only goes RECONNECTING once the window is over
+func TestReconnectGraceWindow(t *testing.T) {
+	on := true
+	task, sub := reconnectingTask(t, ReconnectOptions{GraceSeconds: 1, Slate: &on})
+
+	source := make(queuedSource, 1)
+	source <- droppedPublisher{}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	var wg sync.WaitGroup
+	done := make(chan error, 1)
+	go func() {
+		_, err := handleStream(ctx, source, task, &wg)
+		done <- err
+	}()
+
+	expectEvent(t, sub, EventPublisherConnected, StreamConnecting)
+	expectEvent(t, sub, EventStreamLive, StreamLive)
+	disconnected := expectEvent(t, sub, EventPublisherDisconnected, StreamLive)
+	if data := disconnected.Data.(PublisherData); data.GraceSeconds != 1 {
+		t.Errorf("disconnect within the window carries grace_seconds %d, want 1", data.GraceSeconds)
+	}
+
+	// Back within the window, viewers never saw it go
+	source <- droppedPublisher{}
+	expectEvent(t, sub, EventPublisherConnected, StreamLive)
+	expectEvent(t, sub, EventPublisherDisconnected, StreamLive)
+
+	// Nobody within the window
+	expired := expectEvent(t, sub, EventGraceExpired, StreamReconnecting)
+	if data := expired.Data.(PublisherData); data.RemoteAddr != "10.0.0.1:5000" {
+		t.Errorf("grace_expired names %q, want the publisher that left", data.RemoteAddr)
+	}
+	if src := task.Stats().Source; src == nil || *src != *newSlatePublisher("").describeSource() {
+		t.Errorf("source during the window = %+v, want the slate", src)
+	}
+
+	// A reconnect after the window brings the stream back
+	source <- droppedPublisher{}
+	expectEvent(t, sub, EventPublisherConnected, StreamLive)
+	expectEvent(t, sub, EventPublisherDisconnected, StreamLive)
+
+	cancel(errors.New("stopped"))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if task.GetStatus() != StreamEnding {
+		t.Errorf("stopped stream is %s, want %s", task.GetStatus(), StreamEnding)
+	}
+}
+
+// Without a window the stream tells viewers right away
+func TestReconnectWithoutGrace(t *testing.T) {
+	task, sub := reconnectingTask(t, ReconnectOptions{GraceSeconds: -1})
+
+	source := make(queuedSource, 1)
+	source <- droppedPublisher{}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	var wg sync.WaitGroup
+	done := make(chan error, 1)
+	go func() {
+		_, err := handleStream(ctx, source, task, &wg)
+		done <- err
+	}()
+
+	expectEvent(t, sub, EventPublisherConnected, StreamConnecting)
+	expectEvent(t, sub, EventStreamLive, StreamLive)
+	expectEvent(t, sub, EventPublisherDisconnected, StreamReconnecting)
+
+	cancel(errors.New("stopped"))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
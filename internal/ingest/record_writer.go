@@ -0,0 +1,154 @@
+package ingest
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// recordWriter saves task records on a goroutine of its own, so a transition never waits on the disk.
+// Saves of the same task are coalesced, only its latest record is written. Reads, deletes and archives
+// go straight to the store, after the pending record of that task was written or dropped.
+type recordWriter struct {
+	TaskStore
+
+	mu      sync.Mutex
+	pending map[string]TaskRecord
+	order   []string              // Ids of pending, in the order they were first saved
+	writing map[string]TaskRecord // Taken from pending by the flush in progress
+	wake    chan struct{}
+	stopped bool
+
+	writeMu sync.Mutex // Held while writing, so a delete cannot overtake a save of the same task
+	done    chan struct{}
+}
+
+func newRecordWriter(store TaskStore) *recordWriter {
+	w := &recordWriter{
+		TaskStore: store,
+		pending:   make(map[string]TaskRecord),
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Save queues the record and returns right away, write errors are logged
+func (w *recordWriter) Save(record TaskRecord) error {
+	w.mu.Lock()
+	if w.stopped {
+		w.mu.Unlock()
+		return w.TaskStore.Save(record)
+	}
+	if _, queued := w.pending[record.Id]; !queued {
+		w.order = append(w.order, record.Id)
+	}
+	w.pending[record.Id] = record
+
+	// Under the lock so stop cannot close wake underneath us
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	w.mu.Unlock()
+	return nil
+}
+
+// Get returns the pending record of the task if it was not written yet
+func (w *recordWriter) Get(id string) (TaskRecord, error) {
+	w.mu.Lock()
+	record, queued := w.pending[id]
+	if !queued {
+		record, queued = w.writing[id]
+	}
+	w.mu.Unlock()
+	if queued {
+		return record, nil
+	}
+	return w.TaskStore.Get(id)
+}
+
+func (w *recordWriter) List() ([]TaskRecord, error) {
+	w.flush()
+	return w.TaskStore.List()
+}
+
+// Delete drops the pending record of the task, it would otherwise bring the task back
+func (w *recordWriter) Delete(id string) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	w.drop(id)
+	return w.TaskStore.Delete(id)
+}
+
+// Archive drops the pending record of the task, it would otherwise bring the task back to the active set
+func (w *recordWriter) Archive(record TaskRecord) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	w.drop(record.Id)
+	return w.TaskStore.Archive(record)
+}
+
+// stop writes what is pending and stops the writer, later saves are written right away.
+// The underlying store is left open.
+func (w *recordWriter) stop() {
+	w.mu.Lock()
+	if w.stopped {
+		w.mu.Unlock()
+		return
+	}
+	w.stopped = true
+	close(w.wake)
+	w.mu.Unlock()
+
+	<-w.done
+}
+
+func (w *recordWriter) run() {
+	defer close(w.done)
+
+	for range w.wake {
+		w.flush()
+	}
+	w.flush()
+}
+
+// flush writes every pending record, oldest first
+func (w *recordWriter) flush() {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	w.mu.Lock()
+	pending, order := w.pending, w.order
+	w.pending, w.order = make(map[string]TaskRecord), nil
+	w.writing = pending
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		w.writing = nil
+		w.mu.Unlock()
+	}()
+
+	for _, id := range order {
+		record, queued := pending[id]
+		if !queued {
+			continue // Dropped, or saved again after a drop and already written
+		}
+		if err := w.TaskStore.Save(record); err != nil {
+			slog.Error("Failed to persist task", "stream_id", id, "error", err)
+		}
+		w.mu.Lock()
+		delete(pending, id)
+		w.mu.Unlock()
+	}
+}
+
+// Must be called with w.writeMu held
+func (w *recordWriter) drop(id string) {
+	w.mu.Lock()
+	delete(w.pending, id)
+	w.mu.Unlock()
+}
@@ -0,0 +1,176 @@
+package ingest
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore is a TaskStore kept in maps. Saves wait on gate when it is set.
+type memoryStore struct {
+	mu       sync.Mutex
+	records  map[string]TaskRecord
+	archived map[string]TaskRecord
+	saves    int
+	gate     chan struct{}
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{records: make(map[string]TaskRecord), archived: make(map[string]TaskRecord)}
+}
+
+func (s *memoryStore) Save(record TaskRecord) error {
+	if s.gate != nil {
+		<-s.gate
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.Id] = record
+	s.saves++
+	return nil
+}
+
+func (s *memoryStore) Get(id string) (TaskRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[id]
+	if !ok {
+		return TaskRecord{}, ErrTaskNotFound
+	}
+	return record, nil
+}
+
+func (s *memoryStore) List() ([]TaskRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]TaskRecord, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *memoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, id)
+	delete(s.archived, id)
+	return nil
+}
+
+func (s *memoryStore) Archive(record TaskRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, record.Id)
+	s.archived[record.Id] = record
+	return nil
+}
+
+func (s *memoryStore) GetArchived(id string) (TaskRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.archived[id]
+	if !ok {
+		return TaskRecord{}, ErrTaskNotFound
+	}
+	return record, nil
+}
+
+func (s *memoryStore) DeleteArchived(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.archived[id]; !ok {
+		return ErrTaskNotFound
+	}
+	delete(s.archived, id)
+	return nil
+}
+
+func (s *memoryStore) Close() error { return nil }
+
+func (s *memoryStore) stored(id string) (TaskRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[id]
+	return record, ok
+}
+
+func TestRecordWriterDoesNotBlockTransitions(t *testing.T) {
+	store := newMemoryStore()
+	store.gate = make(chan struct{}) // The disk hangs until the test lets it go
+	writer := newRecordWriter(store)
+
+	task := &Task{Id: "slow-disk", Status: StreamInit, store: writer}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		task.Transition(StreamReady, EventStreamReady, "The stream is ready", nil)
+		task.Transition(StreamConnecting, EventPublisherConnected, "Publisher connected", nil)
+		task.Transition(StreamLive, EventStreamLive, "Live link generated", nil)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("transitions waited on the store")
+	}
+
+	// Reads see the latest record before it is written
+	if record, err := writer.Get("slow-disk"); err != nil || record.Status != StreamLive {
+		t.Errorf("Get = %s, %v, want the pending LIVE record", record.Status, err)
+	}
+
+	close(store.gate)
+	writer.stop()
+
+	record, ok := store.stored("slow-disk")
+	if !ok || record.Status != StreamLive {
+		t.Fatalf("stored record = %+v, want LIVE", record)
+	}
+	// The first save may have started before the others were queued, the rest is coalesced
+	if store.saves > 2 {
+		t.Errorf("%d saves for one task, want the pending ones coalesced", store.saves)
+	}
+
+	// Once stopped, saves are written right away
+	task.Transition(StreamEnding, EventStreamEnding, "Stopped", nil)
+	if record, _ := store.stored("slow-disk"); record.Status != StreamEnding {
+		t.Errorf("save after stop was not written, record is %s", record.Status)
+	}
+}
+
+func TestRecordWriterDeleteDropsPending(t *testing.T) {
+	store := newMemoryStore()
+	store.gate = make(chan struct{})
+	writer := newRecordWriter(store)
+
+	// Held up writing the first task, so the second one stays pending
+	writer.Save(TaskRecord{Id: "first"})
+	time.Sleep(20 * time.Millisecond)
+	writer.Save(TaskRecord{Id: "deleted", Status: StreamLive})
+	writer.Save(TaskRecord{Id: "archived", Status: StreamEnded})
+
+	deleted := make(chan error, 1)
+	go func() { deleted <- writer.Delete("deleted") }()
+	archived := make(chan error, 1)
+	go func() { archived <- writer.Archive(TaskRecord{Id: "archived", Status: StreamEnded}) }()
+
+	close(store.gate)
+	if err := <-deleted; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-archived; err != nil {
+		t.Fatal(err)
+	}
+	writer.stop()
+
+	if _, ok := store.stored("deleted"); ok {
+		t.Error("a pending save brought a deleted task back")
+	}
+	if _, ok := store.stored("archived"); ok {
+		t.Error("a pending save brought an archived task back to the active set")
+	}
+	if _, ok := store.stored("first"); !ok {
+		t.Error("the first task was never written")
+	}
+}
@@ -0,0 +1,235 @@
+package ingest
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/vijayvenkatj/LiveTran/internal/config"
+)
+
+// Root of the local HLS output, FFmpeg writes to <root>/<stream_id>
+const defaultOutputDir = "output"
+
+// Prefix of output directories that were detached from their stream and are being removed
+const reclaimPrefix = ".reclaim-"
+
+// Prefix of the output of evicted streams whose uploads failed, kept for RetainedMaxAge
+const retainedPrefix = ".retained-"
+
+// RetentionConfig controls how long finished tasks are kept around
+type RetentionConfig struct {
+	// How long an ended or failed task stays in memory once it finished
+	GracePeriod time.Duration
+	// How often finished tasks are swept
+	Interval time.Duration
+	// Root of the local HLS output, one directory per stream
+	OutputDir string
+	// How long the output of an evicted stream is kept when some of its files were never uploaded
+	RetainedMaxAge time.Duration
+}
+
+func RetentionConfigFromEnv() RetentionConfig {
+	return RetentionConfig{
+		GracePeriod:    config.EnvDuration("RETENTION_GRACE_PERIOD", time.Hour),
+		Interval:       config.EnvDuration("RETENTION_SWEEP_INTERVAL", time.Minute),
+		OutputDir:      defaultOutputDir,
+		RetainedMaxAge: config.EnvDuration("RETENTION_FAILED_UPLOAD_MAX_AGE", 72*time.Hour),
+	}
+}
+
+// RetentionStats are the running totals of the retention sweeps since startup
+type RetentionStats struct {
+	TasksEvicted    int64
+	OutputsRemoved  int64
+	OutputsRetained int64
+	BytesReclaimed  int64
+}
+
+type retentionCounters struct {
+	tasksEvicted    atomic.Int64
+	outputsRemoved  atomic.Int64
+	outputsRetained atomic.Int64
+	bytesReclaimed  atomic.Int64
+}
+
+// RetentionStats returns what retention has reclaimed so far
+func (tm *TaskManager) RetentionStats() RetentionStats {
+	return RetentionStats{
+		TasksEvicted:    tm.retention.tasksEvicted.Load(),
+		OutputsRemoved:  tm.retention.outputsRemoved.Load(),
+		OutputsRetained: tm.retention.outputsRetained.Load(),
+		BytesReclaimed:  tm.retention.bytesReclaimed.Load(),
+	}
+}
+
+// StartRetention periodically evicts tasks that finished more than the grace period ago.
+// The returned function stops it and waits for a running sweep to complete.
+func (tm *TaskManager) StartRetention(cfg RetentionConfig) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	slog.Info("Retention started", "grace_period", cfg.GracePeriod, "interval", cfg.Interval)
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				tm.sweep(cfg, now)
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (tm *TaskManager) sweep(cfg RetentionConfig, now time.Time) {
+	for _, task := range tm.ListTasks() {
+		record := task.Record()
+		if !record.Status.IsTerminal() || now.Sub(record.EndTime) < cfg.GracePeriod {
+			continue
+		}
+		tm.evict(cfg, task, record, now)
+	}
+
+	// Left behind if the process died while removing them
+	leftovers, _ := filepath.Glob(filepath.Join(cfg.OutputDir, reclaimPrefix+"*"))
+	for _, dir := range leftovers {
+		tm.reclaim(dir)
+	}
+
+	retained, _ := filepath.Glob(filepath.Join(cfg.OutputDir, retainedPrefix+"*"))
+	for _, dir := range retained {
+		if detachedAt(dir).Add(cfg.RetainedMaxAge).After(now) {
+			continue
+		}
+		slog.Warn("Removing retained stream output, its failed uploads were not recovered in time", "dir", dir, "max_age", cfg.RetainedMaxAge)
+		tm.reclaim(dir)
+	}
+}
+
+// evict archives the record, detaches the local output and drops the task from memory.
+// Output is only removed when every file made it to the bucket, otherwise it is the only copy
+// and is kept aside for cfg.RetainedMaxAge, so it can be recovered by hand.
+func (tm *TaskManager) evict(cfg RetentionConfig, task *Task, record TaskRecord, now time.Time) {
+	tm.mu.Lock()
+
+	// Restarted or deleted since the sweep looked at it
+	task.mu.Lock()
+	deleting := task.deleting
+	task.mu.Unlock()
+	if tm.TaskMap[task.Id] != task || deleting {
+		tm.mu.Unlock()
+		return
+	}
+
+	if tm.store != nil {
+		if err := tm.store.Archive(record); err != nil {
+			tm.mu.Unlock()
+			slog.Error("Failed to archive task", "stream_id", task.Id, "error", err)
+			return
+		}
+	}
+	delete(tm.TaskMap, task.Id)
+
+	// Renamed while holding the lock so a restart of the same id starts with a fresh directory
+	dir := filepath.Join(cfg.OutputDir, task.Id)
+	prefix := reclaimPrefix
+	if record.UploadFailures > 0 {
+		prefix = retainedPrefix
+	}
+	detached, err := detachOutput(cfg.OutputDir, task.Id, prefix, now)
+	if err != nil {
+		slog.Error("Failed to detach stream output", "stream_id", task.Id, "dir", dir, "error", err)
+	}
+	tm.mu.Unlock()
+
+	task.mu.Lock()
+	task.store = nil
+	task.mu.Unlock()
+
+	tm.retention.tasksEvicted.Add(1)
+	slog.Info("Task evicted", "stream_id", task.Id, "status", record.Status, "ended_at", record.EndTime)
+
+	if record.UploadFailures > 0 {
+		tm.retention.outputsRetained.Add(1)
+		slog.Warn("Keeping local output, some files were never uploaded",
+			"stream_id", task.Id,
+			"dir", detached,
+			"upload_failures", record.UploadFailures,
+			"until", now.Add(cfg.RetainedMaxAge),
+		)
+		return
+	}
+
+	if detached != "" {
+		tm.reclaim(detached)
+	}
+}
+
+// detachOutput moves the output of a stream out of the way under prefix, so it can be removed without holding up
+// a new run of the same id. Returns where it went, empty when there was none. Must be called with tm.mu held.
+func detachOutput(root string, id string, prefix string, now time.Time) (string, error) {
+	target := filepath.Join(root, prefix+id+"-"+strconv.FormatInt(now.UnixNano(), 10))
+	err := os.Rename(filepath.Join(root, id), target)
+	switch {
+	case err == nil:
+		return target, nil
+	case os.IsNotExist(err):
+		return "", nil
+	default:
+		return "", err
+	}
+}
+
+// detachedAt is when detachOutput moved dir aside, the zero time when its name does not tell
+func detachedAt(dir string) time.Time {
+	name := filepath.Base(dir)
+	nanos, err := strconv.ParseInt(name[strings.LastIndex(name, "-")+1:], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+func (tm *TaskManager) reclaim(dir string) {
+	size := dirSize(dir)
+	if err := os.RemoveAll(dir); err != nil {
+		slog.Error("Failed to remove stream output", "dir", dir, "error", err)
+		return
+	}
+
+	tm.retention.outputsRemoved.Add(1)
+	tm.retention.bytesReclaimed.Add(size)
+	slog.Info("Stream output removed", "dir", dir, "bytes", size)
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,119 @@
+package ingest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// finishedTask is a task of tm that ended at end, with files in the output directory
+func finishedTask(t *testing.T, tm *TaskManager, root string, id string, status StreamState, end time.Time) *Task {
+	t.Helper()
+	task := &Task{Id: id, Status: status, EndTime: end, store: tm.store, CancelFn: func(error) {}}
+	tm.TaskMap[id] = task
+
+	dir := filepath.Join(root, id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, id+"_000.ts"), make([]byte, 1000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestRetentionSweep(t *testing.T) {
+	root := t.TempDir()
+	store := newMemoryStore()
+	tm := NewTaskManager(store, nil)
+	defer tm.Close()
+
+	cfg := RetentionConfig{GracePeriod: time.Hour, Interval: time.Minute, OutputDir: root, RetainedMaxAge: 24 * time.Hour}
+	now := time.Now()
+
+	uploaded := finishedTask(t, tm, root, "uploaded", StreamEnded, now.Add(-2*time.Hour))
+	failed := finishedTask(t, tm, root, "failed", StreamFailed, now.Add(-2*time.Hour))
+	failed.priorUploadFailures = 3
+	recent := finishedTask(t, tm, root, "recent", StreamEnded, now.Add(-time.Minute))
+	live := finishedTask(t, tm, root, "live", StreamLive, time.Time{})
+
+	tm.sweep(cfg, now)
+
+	for _, task := range []*Task{uploaded, failed} {
+		if _, ok := tm.GetTask(task.Id); ok {
+			t.Errorf("%s is still in memory", task.Id)
+		}
+		if _, err := store.GetArchived(task.Id); err != nil {
+			t.Errorf("%s was not archived: %v", task.Id, err)
+		}
+		if exists(filepath.Join(root, task.Id)) {
+			t.Errorf("output of %s is still under its id, a restart would share it", task.Id)
+		}
+	}
+	for _, task := range []*Task{recent, live} {
+		if _, ok := tm.GetTask(task.Id); !ok {
+			t.Errorf("%s was evicted", task.Id)
+		}
+		if !exists(filepath.Join(root, task.Id)) {
+			t.Errorf("output of %s was removed", task.Id)
+		}
+	}
+
+	// The only copy of what never made it to the bucket is kept aside
+	retained, _ := filepath.Glob(filepath.Join(root, retainedPrefix+"failed-*"))
+	if len(retained) != 1 || !exists(filepath.Join(retained[0], "failed_000.ts")) {
+		t.Fatalf("retained output = %v, want the files of failed", retained)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(root, reclaimPrefix+"*")); len(leftovers) != 0 {
+		t.Errorf("reclaimed output left behind: %v", leftovers)
+	}
+
+	stats := tm.RetentionStats()
+	if stats.TasksEvicted != 2 || stats.OutputsRemoved != 1 || stats.OutputsRetained != 1 || stats.BytesReclaimed != 1000 {
+		t.Errorf("retention stats = %+v", stats)
+	}
+
+	// Kept for RetainedMaxAge, not forever
+	tm.sweep(cfg, now.Add(cfg.RetainedMaxAge-time.Minute))
+	if !exists(retained[0]) {
+		t.Fatal("retained output removed before RetainedMaxAge")
+	}
+	tm.sweep(cfg, now.Add(cfg.RetainedMaxAge))
+	if exists(retained[0]) {
+		t.Error("retained output outlived RetainedMaxAge")
+	}
+
+	// Deleting an evicted stream purges its archived record
+	if err := tm.DeleteTask(uploaded.Id); err != nil {
+		t.Fatalf("deleting an evicted stream: %v", err)
+	}
+	if _, err := store.GetArchived(uploaded.Id); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("archived record after the delete = %v, want %v", err, ErrTaskNotFound)
+	}
+	if err := tm.DeleteTask(uploaded.Id); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("deleting it again = %v, want %v", err, ErrTaskNotFound)
+	}
+}
+
+// Output detached by a process that died while removing it is removed by the next sweep
+func TestRetentionSweepLeftovers(t *testing.T) {
+	root := t.TempDir()
+	tm := NewTaskManager(nil, nil)
+	defer tm.Close()
+
+	leftover := filepath.Join(root, reclaimPrefix+"gone-1")
+	if err := os.MkdirAll(leftover, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tm.sweep(RetentionConfig{GracePeriod: time.Hour, OutputDir: root, RetainedMaxAge: time.Hour}, time.Now())
+	if exists(leftover) {
+		t.Error("leftover output was not removed")
+	}
+}
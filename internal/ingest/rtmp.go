@@ -0,0 +1,257 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+
+	"github.com/vijayvenkatj/LiveTran/internal/auth"
+	"github.com/vijayvenkatj/LiveTran/internal/config"
+	rtmp "github.com/yutopp/go-rtmp"
+	rtmpmsg "github.com/yutopp/go-rtmp/message"
+)
+
+// FLV tag types, RTMP audio/video/data message bodies are FLV tag bodies as is
+const (
+	flvTagAudio  = 8
+	flvTagVideo  = 9
+	flvTagScript = 18
+)
+
+// RTMPConfig controls the shared RTMP listener
+type RTMPConfig struct {
+	Port int
+	// Application name publishers connect to, e.g. rtmp://host:1935/live
+	App string
+}
+
+func RTMPConfigFromEnv() RTMPConfig {
+	app := os.Getenv("RTMP_APP")
+	if app == "" {
+		app = "live"
+	}
+
+	return RTMPConfig{
+		Port: config.EnvInt("RTMP_PORT", 1935),
+		App:  app,
+	}
+}
+
+// RTMPServer accepts every RTMP publisher on one port and routes them to their
+// stream by the stream id carried in the stream key
+type RTMPServer struct {
+	cfg      RTMPConfig
+	listener net.Listener
+	server   *rtmp.Server
+	routes   *routeTable
+}
+
+// StartRTMP opens the shared RTMP listener, streams started with the rtmp protocol are served by it.
+// The returned function closes the listener.
+func (tm *TaskManager) StartRTMP(cfg RTMPConfig) (func(), error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Port))
+	if err != nil {
+		return nil, fmt.Errorf("RTMP Listener error: %s", err)
+	}
+
+	s := &RTMPServer{
+		cfg:      cfg,
+		listener: listener,
+		routes:   newRouteTable(),
+	}
+	s.server = rtmp.NewServer(&rtmp.ServerConfig{
+		OnConnect: func(conn net.Conn) (io.ReadWriteCloser, *rtmp.ConnConfig) {
+			return conn, &rtmp.ConnConfig{
+				Handler: &rtmpHandler{server: s, remoteAddr: conn.RemoteAddr().String()},
+				ControlState: rtmp.StreamControlStateConfig{
+					DefaultBandwidthWindowSize: 6 * 1024 * 1024 / 8,
+				},
+			}
+		},
+	})
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, rtmp.ErrClosed) {
+			slog.Error("RTMP server stopped", "error", err)
+		}
+	}()
+
+	tm.rtmp = s
+	slog.Info("RTMP ingest listening", "port", cfg.Port, "app", cfg.App)
+
+	return func() {
+		s.server.Close()
+	}, nil
+}
+
+// prepareIngest registers the task's route and generates the stream key
+func (s *RTMPServer) prepareIngest(task *Task) (ingestSource, IngestInfo, error) {
+	streamkey, expiresAt, err := auth.GenerateStreamKey(task.Id)
+	if err != nil {
+		return nil, IngestInfo{}, fmt.Errorf("StreamKey error: %s", err)
+	}
+
+	source := s.routes.register(task)
+
+	ip := GetLocalIP()
+	serverURL := fmt.Sprintf("rtmp://%s:%d/%s", ip, s.cfg.Port, s.cfg.App)
+
+	info := IngestInfo{
+		StreamId:    task.Id,
+		Protocol:    ProtocolRTMP,
+		URL:         fmt.Sprintf("%s/%s", serverURL, streamkey),
+		ServerURL:   serverURL,
+		Host:        ip,
+		Port:        s.cfg.Port,
+		StreamKey:   streamkey,
+		KeyExpiry:   expiresAt,
+		PlaybackURL: PlaybackURL(task.Id, task.Abr),
+		DashURL:     DashPlaybackURL(task.Id, task.Profile),
+	}
+
+	return source, info, nil
+}
+
+// rtmpPublisher re-muxes the RTMP messages of one connection into an FLV byte stream
+type rtmpPublisher struct {
+	remoteAddr string
+	reader     *io.PipeReader
+	writer     *io.PipeWriter
+	conn       *rtmp.Conn
+}
+
+func (pub *rtmpPublisher) Read(p []byte) (int, error) {
+	return pub.reader.Read(p)
+}
+
+func (pub *rtmpPublisher) Close() error {
+	pub.reader.Close()
+	return pub.conn.Close()
+}
+
+func (pub *rtmpPublisher) RemoteAddr() string {
+	return pub.remoteAddr
+}
+
+func (pub *rtmpPublisher) InputArgs() []string {
+	return []string{"-f", "flv", "-i", "pipe:0"}
+}
+
+func (pub *rtmpPublisher) writeHeader() error {
+	// FLV signature, version 1, audio+video flags, header size, then PreviousTagSize0
+	header := []byte{'F', 'L', 'V', 1, 0x05, 0, 0, 0, 9, 0, 0, 0, 0}
+	_, err := pub.writer.Write(header)
+	return err
+}
+
+func (pub *rtmpPublisher) writeTag(tagType byte, timestamp uint32, body []byte) error {
+	tag := make([]byte, 11, 11+len(body)+4)
+	tag[0] = tagType
+	tag[1], tag[2], tag[3] = byte(len(body)>>16), byte(len(body)>>8), byte(len(body))
+	tag[4], tag[5], tag[6] = byte(timestamp>>16), byte(timestamp>>8), byte(timestamp)
+	tag[7] = byte(timestamp >> 24)
+	// StreamID is always 0
+
+	tag = append(tag, body...)
+	tag = binary.BigEndian.AppendUint32(tag, uint32(11+len(body)))
+
+	_, err := pub.writer.Write(tag)
+	return err
+}
+
+// rtmpHandler authenticates one RTMP connection and forwards its media to the stream it publishes to
+type rtmpHandler struct {
+	rtmp.DefaultHandler
+	server     *RTMPServer
+	remoteAddr string
+	conn       *rtmp.Conn
+	app        string
+	pub        *rtmpPublisher
+}
+
+func (h *rtmpHandler) OnServe(conn *rtmp.Conn) {
+	h.conn = conn
+}
+
+func (h *rtmpHandler) OnConnect(timestamp uint32, cmd *rtmpmsg.NetConnectionConnect) error {
+	h.app = cmd.Command.App
+	if h.app != h.server.cfg.App {
+		return fmt.Errorf("unknown RTMP app %q", h.app)
+	}
+	return nil
+}
+
+// OnPublish routes by the stream id in the stream key, validates the key and hands the publisher to the task
+func (h *rtmpHandler) OnPublish(_ *rtmp.StreamContext, timestamp uint32, cmd *rtmpmsg.NetStreamPublish) error {
+	streamkey := cmd.PublishingName
+
+	id, err := auth.StreamKeyId(streamkey)
+	if err != nil {
+		slog.Warn("Rejected RTMP publisher", "remote_addr", h.remoteAddr, "reason", err)
+		return err
+	}
+
+	source, ok := h.server.routes.lookup(id)
+	if !ok {
+		slog.Warn("Rejected RTMP publisher", "stream_id", id, "remote_addr", h.remoteAddr, "reason", "unknown stream")
+		return fmt.Errorf("unknown stream %q", id)
+	}
+
+	if ok, reason := auth.DecodeStreamKey(id, streamkey); !ok {
+		slog.Warn("Rejected RTMP publisher", "stream_id", id, "remote_addr", h.remoteAddr, "reason", reason)
+		return fmt.Errorf("invalid stream key: %s", reason)
+	}
+
+	reader, writer := io.Pipe()
+	pub := &rtmpPublisher{
+		remoteAddr: h.remoteAddr,
+		reader:     reader,
+		writer:     writer,
+		conn:       h.conn,
+	}
+
+	if err := source.handoff(pub); err != nil {
+		slog.Warn("Rejected RTMP publisher", "stream_id", id, "remote_addr", h.remoteAddr, "reason", err)
+		return fmt.Errorf("stream %q: %w", id, err)
+	}
+
+	h.pub = pub
+	return pub.writeHeader()
+}
+
+func (h *rtmpHandler) OnSetDataFrame(timestamp uint32, data *rtmpmsg.NetStreamSetDataFrame) error {
+	if h.pub == nil {
+		return nil
+	}
+	return h.pub.writeTag(flvTagScript, timestamp, data.Payload)
+}
+
+func (h *rtmpHandler) OnAudio(timestamp uint32, payload io.Reader) error {
+	return h.forward(flvTagAudio, timestamp, payload)
+}
+
+func (h *rtmpHandler) OnVideo(timestamp uint32, payload io.Reader) error {
+	return h.forward(flvTagVideo, timestamp, payload)
+}
+
+func (h *rtmpHandler) forward(tagType byte, timestamp uint32, payload io.Reader) error {
+	if h.pub == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(payload)
+	if err != nil {
+		return err
+	}
+	return h.pub.writeTag(tagType, timestamp, body)
+}
+
+func (h *rtmpHandler) OnClose() {
+	if h.pub != nil {
+		h.pub.writer.Close()
+	}
+}
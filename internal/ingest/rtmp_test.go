@@ -0,0 +1,144 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/vijayvenkatj/LiveTran/internal/auth"
+	rtmpmsg "github.com/yutopp/go-rtmp/message"
+)
+
+// The RTMP messages come out as an FLV stream FFmpeg reads from the pipe
+func TestRTMPFlvRemux(t *testing.T) {
+	reader, writer := io.Pipe()
+	pub := &rtmpPublisher{reader: reader, writer: writer}
+
+	video := bytes.Repeat([]byte{0x17}, 300)
+	go func() {
+		pub.writeHeader()
+		pub.writeTag(flvTagScript, 0, []byte{0x02})
+		// Past 2^24 ms the top byte of the timestamp goes in the extended byte
+		pub.writeTag(flvTagVideo, 0x12345678, video)
+		writer.Close()
+	}()
+	flv, err := io.ReadAll(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := []byte{'F', 'L', 'V', 1, 0x05, 0, 0, 0, 9, 0, 0, 0, 0}
+	if !bytes.HasPrefix(flv, header) {
+		t.Fatalf("FLV header = % x, want % x", flv[:min(len(flv), len(header))], header)
+	}
+	flv = flv[len(header):]
+
+	tags := []struct {
+		tagType   byte
+		timestamp uint32
+		body      []byte
+	}{
+		{flvTagScript, 0, []byte{0x02}},
+		{flvTagVideo, 0x12345678, video},
+	}
+	for _, want := range tags {
+		if len(flv) < 11+len(want.body)+4 {
+			t.Fatalf("tag of type %d is cut short, %d bytes left", want.tagType, len(flv))
+		}
+		tag := flv[:11+len(want.body)+4]
+		flv = flv[len(tag):]
+
+		if tag[0] != want.tagType {
+			t.Errorf("tag type = %d, want %d", tag[0], want.tagType)
+		}
+		if size := int(tag[1])<<16 | int(tag[2])<<8 | int(tag[3]); size != len(want.body) {
+			t.Errorf("tag of type %d: DataSize = %d, want %d", want.tagType, size, len(want.body))
+		}
+		timestamp := uint32(tag[7])<<24 | uint32(tag[4])<<16 | uint32(tag[5])<<8 | uint32(tag[6])
+		if timestamp != want.timestamp {
+			t.Errorf("tag of type %d: timestamp = %#x, want %#x", want.tagType, timestamp, want.timestamp)
+		}
+		if !bytes.Equal(tag[8:11], []byte{0, 0, 0}) {
+			t.Errorf("tag of type %d: StreamID = % x, want 0", want.tagType, tag[8:11])
+		}
+		if !bytes.Equal(tag[11:11+len(want.body)], want.body) {
+			t.Errorf("tag of type %d: body differs", want.tagType)
+		}
+		if previous := binary.BigEndian.Uint32(tag[11+len(want.body):]); previous != uint32(11+len(want.body)) {
+			t.Errorf("tag of type %d: PreviousTagSize = %d, want %d", want.tagType, previous, 11+len(want.body))
+		}
+	}
+	if len(flv) != 0 {
+		t.Errorf("%d bytes after the last tag", len(flv))
+	}
+}
+
+func TestRTMPOnPublishRejects(t *testing.T) {
+	t.Setenv("JWT_SECRET", "rtmp-test")
+
+	server := &RTMPServer{cfg: RTMPConfig{App: "live"}, routes: newRouteTable()}
+	source := server.routes.register(&Task{Id: "cam"})
+	defer source.Close()
+	h := &rtmpHandler{server: server, remoteAddr: "127.0.0.1:50000"}
+
+	if err := h.OnConnect(0, &rtmpmsg.NetConnectionConnect{Command: rtmpmsg.NetConnectionConnectCommand{App: "vod"}}); err == nil {
+		t.Error("OnConnect accepted the unknown app vod")
+	}
+	if err := h.OnConnect(0, &rtmpmsg.NetConnectionConnect{Command: rtmpmsg.NetConnectionConnectCommand{App: "live"}}); err != nil {
+		t.Errorf("OnConnect to the live app: %v", err)
+	}
+
+	key, _, err := auth.GenerateStreamKey("cam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, _, err := auth.GenerateStreamKey("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JWT_SECRET", "another-secret")
+	forged, _, err := auth.GenerateStreamKey("cam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JWT_SECRET", "rtmp-test")
+
+	rejected := []struct {
+		name string
+		key  string
+	}{
+		{"malformed key", "not-a-stream-key"},
+		{"unknown stream", unknown},
+		{"key signed with another secret", forged},
+		{"key of another stream", strings.Replace(unknown, "rid=other", "rid=cam", 1)},
+	}
+	for _, r := range rejected {
+		t.Run(r.name, func(t *testing.T) {
+			if err := h.OnPublish(nil, 0, &rtmpmsg.NetStreamPublish{PublishingName: r.key}); err == nil {
+				t.Errorf("OnPublish accepted %s", r.key)
+			}
+			if h.pub != nil {
+				t.Error("a rejected publisher was handed to the stream")
+			}
+		})
+	}
+
+	// The stream's own key is handed to the stream, which reads the FLV header first
+	accepted := make(chan publisher, 1)
+	go func() {
+		pub, err := acceptWithin(source)
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- pub
+		io.ReadFull(pub, make([]byte, 13))
+	}()
+	if err := h.OnPublish(nil, 0, &rtmpmsg.NetStreamPublish{PublishingName: key}); err != nil {
+		t.Fatalf("OnPublish with the stream's key: %v", err)
+	}
+	if pub := <-accepted; pub != h.pub || pub.RemoteAddr() != "127.0.0.1:50000" {
+		t.Errorf("stream got publisher %+v, want the handler's", pub)
+	}
+}
@@ -0,0 +1,212 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Protocol is how publishers push media into a stream
+type Protocol string
+
+const (
+	ProtocolSRT  Protocol = "srt"
+	ProtocolRTMP Protocol = "rtmp"
+	ProtocolWHIP Protocol = "whip"
+	// Pull only, FFmpeg reads the remote URL itself
+	ProtocolRTSP Protocol = "rtsp"
+	ProtocolHLS  Protocol = "hls"
+)
+
+// How long a publisher on a shared endpoint waits for its stream to pick it up before it is turned away
+const handoffTimeout = 2 * time.Second
+
+var (
+	ErrUnsupportedProtocol = errors.New("unsupported ingest protocol")
+	ErrProtocolDisabled    = errors.New("ingest protocol is not enabled on this server")
+	ErrUnknownStream       = errors.New("no stream is waiting for publishers under this id")
+	ErrPublisherBusy       = errors.New("stream is not waiting for a publisher")
+)
+
+// ParseProtocol validates a protocol from a request, SRT is the default
+func ParseProtocol(value string) (Protocol, error) {
+	switch Protocol(strings.ToLower(value)) {
+	case "", ProtocolSRT:
+		return ProtocolSRT, nil
+	case ProtocolRTMP:
+		return ProtocolRTMP, nil
+	case ProtocolWHIP:
+		return ProtocolWHIP, nil
+	case ProtocolRTSP:
+		return ProtocolRTSP, nil
+	case ProtocolHLS:
+		return ProtocolHLS, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnsupportedProtocol, value)
+}
+
+// publisher is an authenticated encoder pushing media into a task
+type publisher interface {
+	io.ReadCloser
+	RemoteAddr() string
+	// FFmpeg input options ending with the input itself, pipe:0 when FFmpeg reads the publisher's bytes
+	InputArgs() []string
+}
+
+// ingestSource hands out the publishers of a single task
+type ingestSource interface {
+	// accept waits for the next authenticated publisher
+	accept(ctx context.Context) (publisher, error)
+	Close()
+}
+
+// prepareIngest opens the task's ingest endpoint for its protocol so the
+// ingest URL is known before the task starts running
+func (tm *TaskManager) prepareIngest(task *Task) (ingestSource, IngestInfo, error) {
+	if task.Encryption != nil {
+		if task.Protocol != ProtocolSRT || task.Pull != nil {
+			return nil, IngestInfo{}, fmt.Errorf("%w: only SRT listeners are encrypted, pulled SRT sources take pull.passphrase", ErrInvalidEncryption)
+		}
+		if err := task.Encryption.prepare(); err != nil {
+			return nil, IngestInfo{}, err
+		}
+	}
+
+	if task.Protocol == ProtocolSRT {
+		// Options in the query of a pull URL count as given, explicit ones win
+		var fromURL *SrtOptions
+		if task.Pull != nil {
+			var err error
+			if fromURL, err = srtOptionsFromURL(task.Pull.URL); err != nil {
+				return nil, IngestInfo{}, fmt.Errorf("%w: %s", ErrInvalidPullSource, err)
+			}
+		}
+
+		opts, err := tm.srtServer().options(fromURL, task.Srt)
+		if err != nil {
+			return nil, IngestInfo{}, err
+		}
+		task.Srt = opts
+	} else if task.Srt != nil {
+		return nil, IngestInfo{}, fmt.Errorf("%w: only SRT streams take srt options", ErrInvalidSrtOptions)
+	}
+
+	if err := tm.resolveReconnect(task); err != nil {
+		return nil, IngestInfo{}, err
+	}
+	if err := tm.resolveProfile(task); err != nil {
+		return nil, IngestInfo{}, err
+	}
+
+	if task.Pull != nil {
+		return preparePull(task)
+	}
+
+	switch task.Protocol {
+	case ProtocolRTMP:
+		if tm.rtmp == nil {
+			return nil, IngestInfo{}, fmt.Errorf("%w: %s", ErrProtocolDisabled, task.Protocol)
+		}
+		return tm.rtmp.prepareIngest(task)
+	case ProtocolWHIP:
+		if tm.whip == nil {
+			return nil, IngestInfo{}, fmt.Errorf("%w: %s", ErrProtocolDisabled, task.Protocol)
+		}
+		return tm.whip.prepareIngest(task)
+	case ProtocolSRT:
+		return tm.srtServer().prepareIngest(task)
+	case ProtocolRTSP, ProtocolHLS:
+		return nil, IngestInfo{}, fmt.Errorf("%w: %s streams can only be pulled", ErrInvalidPullSource, task.Protocol)
+	}
+	return nil, IngestInfo{}, fmt.Errorf("%w: %q", ErrUnsupportedProtocol, task.Protocol)
+}
+
+// srtServer is where SRT streams are served, per-stream listeners on any port with the built-in defaults when SRT was not started
+func (tm *TaskManager) srtServer() *SRTServer {
+	if tm.srt == nil {
+		return &SRTServer{cfg: SRTConfig{Defaults: DefaultSrtOptions()}}
+	}
+	return tm.srt
+}
+
+// acceptCanceled is what accept returns once ctx is done, a deadline means no publisher showed up in time
+func acceptCanceled(ctx context.Context) error {
+	if context.Cause(ctx) == context.DeadlineExceeded {
+		return ctx.Err()
+	}
+	return fmt.Errorf("connection canceled or user stopped the stream")
+}
+
+// routeTable maps stream ids to the tasks waiting for publishers on a shared endpoint
+type routeTable struct {
+	mu     sync.Mutex
+	routes map[string]*routedSource
+}
+
+func newRouteTable() *routeTable {
+	return &routeTable{routes: make(map[string]*routedSource)}
+}
+
+// register routes the publishers of task to a new source, a restart replaces the route of the previous run
+func (t *routeTable) register(task *Task) *routedSource {
+	source := &routedSource{
+		id:         task.Id,
+		task:       task,
+		table:      t,
+		publishers: make(chan publisher),
+	}
+
+	t.mu.Lock()
+	t.routes[task.Id] = source
+	t.mu.Unlock()
+
+	return source
+}
+
+func (t *routeTable) lookup(id string) (*routedSource, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	source, ok := t.routes[id]
+	return source, ok
+}
+
+// routedSource receives the publishers a shared endpoint routes to one task
+type routedSource struct {
+	id         string
+	task       *Task
+	table      *routeTable
+	publishers chan publisher
+}
+
+func (src *routedSource) accept(ctx context.Context) (publisher, error) {
+	select {
+	case <-ctx.Done():
+		return nil, acceptCanceled(ctx)
+	case pub := <-src.publishers:
+		return pub, nil
+	}
+}
+
+// handoff gives pub to the task, which only takes it while it is waiting for a publisher
+func (src *routedSource) handoff(pub publisher) error {
+	select {
+	case src.publishers <- pub:
+		return nil
+	case <-time.After(handoffTimeout):
+		return ErrPublisherBusy
+	}
+}
+
+func (src *routedSource) Close() {
+	src.table.mu.Lock()
+	defer src.table.mu.Unlock()
+
+	if src.table.routes[src.id] == src {
+		delete(src.table.routes, src.id)
+	}
+}
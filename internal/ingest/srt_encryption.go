@@ -0,0 +1,159 @@
+package ingest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+// Length of generated passphrases, SRT accepts 10 to 80 characters
+const generatedPassphraseBytes = 24
+
+var ErrInvalidEncryption = errors.New("invalid SRT encryption")
+
+// Codes of stream.publisher_rejected, why an SRT handshake was turned down
+const (
+	RejectInvalidStreamKey  = "invalid_stream_key"
+	RejectBadPassphrase     = "bad_passphrase"
+	RejectWrongKeyLength    = "wrong_key_length"
+	RejectEncryptionMissing = "encryption_required"
+	RejectUnexpectedCrypto  = "encryption_not_expected"
+	RejectRoleTaken         = "role_in_use"
+)
+
+// SrtEncryption protects a stream's media on the wire with AES, keyed from the passphrase
+type SrtEncryption struct {
+	Passphrase string `json:"passphrase,omitempty"` // Generated when empty
+	KeyLength  int    `json:"key_length,omitempty"` // AES key size in bits: 128 (default), 192 or 256
+}
+
+// prepare fills in the defaults and validates the settings, a generated passphrase is kept for the task's lifetime
+func (enc *SrtEncryption) prepare() error {
+	if enc.KeyLength == 0 {
+		enc.KeyLength = 128
+	}
+	if enc.KeyLength != 128 && enc.KeyLength != 192 && enc.KeyLength != 256 {
+		return fmt.Errorf("%w: key_length must be 128, 192 or 256", ErrInvalidEncryption)
+	}
+
+	if enc.Passphrase == "" {
+		b := make([]byte, generatedPassphraseBytes)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("passphrase generation error: %s", err)
+		}
+		enc.Passphrase = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	config := srt.DefaultConfig()
+	config.Passphrase = enc.Passphrase
+	config.PBKeylen = enc.pbKeylen()
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidEncryption, err)
+	}
+	return nil
+}
+
+// pbKeylen is the key length in bytes, as SRT options take it
+func (enc *SrtEncryption) pbKeylen() int {
+	return enc.KeyLength / 8
+}
+
+// clone keeps the caller's settings untouched when the passphrase is generated
+func (enc *SrtEncryption) clone() *SrtEncryption {
+	if enc == nil {
+		return nil
+	}
+	c := *enc
+	return &c
+}
+
+// matches reports whether a start request asks for the encryption the task runs with.
+// Leaving out the passphrase or key length accepts what was generated or defaulted.
+func (enc *SrtEncryption) matches(requested *SrtEncryption) bool {
+	if enc == nil || requested == nil {
+		return enc == requested
+	}
+	if requested.Passphrase != "" && requested.Passphrase != enc.Passphrase {
+		return false
+	}
+	return requested.KeyLength == 0 || requested.KeyLength == enc.KeyLength
+}
+
+// verifyEncryption applies the stream's passphrase to a handshake.
+// It returns the code and reason to reject it with, or an empty code when it may proceed.
+func verifyEncryption(req srt.ConnRequest, enc *SrtEncryption) (string, string) {
+	switch {
+	case enc == nil && req.IsEncrypted():
+		return RejectUnexpectedCrypto, "Publisher is encrypted but the stream has no passphrase"
+	case enc == nil:
+		return "", ""
+	case !req.IsEncrypted():
+		return RejectEncryptionMissing, "Stream requires an encrypted publisher"
+	}
+
+	// The caller picks the key size in SRT, a listener takes whatever it is offered unless told otherwise.
+	// Where gosrt no longer keeps it, the key size is not enforced rather than every publisher rejected.
+	keyLength, ok := handshakeKeyLength(req)
+	if !ok {
+		slog.Warn("Cannot read the key length of the SRT handshake, not enforcing it", "remote_addr", req.RemoteAddr())
+	} else if keyLength != enc.pbKeylen() {
+		return RejectWrongKeyLength, fmt.Sprintf("Stream requires AES-%d, set pbkeylen=%d", enc.KeyLength, enc.pbKeylen())
+	}
+
+	if err := req.SetPassphrase(enc.Passphrase); err != nil {
+		return RejectBadPassphrase, "Wrong passphrase"
+	}
+	return "", ""
+}
+
+// handshakeKeyLength returns the AES key length in bytes an encrypted handshake offers.
+// gosrt does not expose it on ConnRequest, it is read from the unexported keying material of the request
+// (handshake.SRTKM.KLen), ok is false when it cannot be found there.
+func handshakeKeyLength(req srt.ConnRequest) (keyLength int, ok bool) {
+	defer func() {
+		if recover() != nil {
+			keyLength, ok = 0, false
+		}
+	}()
+
+	v := reflect.ValueOf(req)
+	for _, field := range []string{"handshake", "SRTKM", "KLen"} {
+		if v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return 0, false
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return 0, false
+		}
+		v = v.FieldByName(field)
+		if !v.IsValid() {
+			return 0, false
+		}
+	}
+	if !v.CanUint() {
+		return 0, false
+	}
+	return int(v.Uint()), true
+}
+
+// rejectHandshake turns down an SRT handshake and reports it to the stream's webhooks
+func rejectHandshake(task *Task, req srt.ConnRequest, code string, reason string) {
+	rejection := srt.REJ_BADSECRET
+	if code == RejectEncryptionMissing || code == RejectUnexpectedCrypto || code == RejectWrongKeyLength {
+		rejection = srt.REJ_UNSECURE
+	}
+	req.Reject(rejection)
+
+	task.Emit(EventPublisherRejected, RejectedData{
+		RemoteAddr: req.RemoteAddr().String(),
+		Code:       code,
+		Reason:     reason,
+	})
+}
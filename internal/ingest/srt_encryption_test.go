@@ -0,0 +1,167 @@
+package ingest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+func TestSrtPassphraseOnlyInStartResponse(t *testing.T) {
+	enc := &SrtEncryption{KeyLength: 256}
+	if err := enc.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Id: "enc1", Protocol: ProtocolSRT, Status: StreamReady, Encryption: enc, Srt: DefaultSrtOptions().merge(), IdempotencyKey: "key-1"}
+	task.Ingest = srtIngestInfo(task, "10.0.0.1", 9000, "mode=publish,rid=enc1,token=t", time.Now())
+
+	kept := []struct {
+		name string
+		url  string
+	}{
+		{"ingest URL", task.Ingest.URL},
+		{"backup ingest URL", task.Ingest.BackupURL},
+		{"API view", task.Info().IngestURL},
+		{"stored record", task.Record().IngestURL},
+	}
+	for _, k := range kept {
+		if strings.Contains(k.url, enc.Passphrase) || strings.Contains(k.url, "passphrase=") {
+			t.Errorf("%s carries the passphrase: %s", k.name, k.url)
+		}
+	}
+	if !strings.Contains(task.Ingest.URL, "pbkeylen=32") {
+		t.Errorf("ingest URL %s does not set the key length", task.Ingest.URL)
+	}
+
+	response := task.Ingest.withPassphrase(enc)
+	if response.Passphrase != enc.Passphrase || !strings.Contains(response.URL, "&passphrase="+enc.Passphrase) || !strings.Contains(response.BackupURL, "&passphrase="+enc.Passphrase) {
+		t.Errorf("start response does not carry the passphrase: %+v", response)
+	}
+
+	// A retry of the same request gets the passphrase again, a duplicate start does not
+	info, restart, err := task.resolveStart(StartOptions{Protocol: ProtocolSRT, Encryption: &SrtEncryption{}, IdempotencyKey: "key-1", Mode: task.Mode})
+	if err != nil || restart || info.Passphrase != enc.Passphrase {
+		t.Errorf("idempotent retry = %+v, %v, %v, want the passphrase", info, restart, err)
+	}
+	info, restart, err = task.resolveStart(StartOptions{Protocol: ProtocolSRT, Encryption: &SrtEncryption{}, Mode: task.Mode})
+	if err != nil || restart || info.Passphrase != "" || strings.Contains(info.URL, "passphrase=") {
+		t.Errorf("duplicate start = %+v, %v, %v, want no passphrase", info, restart, err)
+	}
+
+	// Records stored before the passphrase was kept out of the URL
+	old := task.Record()
+	old.IngestURL = response.URL
+	if restored := taskFromRecord(old).Info().IngestURL; strings.Contains(restored, "passphrase=") {
+		t.Errorf("restored record carries the passphrase: %s", restored)
+	}
+}
+
+func TestSrtEncryptionHandshake(t *testing.T) {
+	enc := &SrtEncryption{Passphrase: "correct horse battery", KeyLength: 256}
+	if err := enc.prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := srt.Listen("srt", "127.0.0.1:0", srt.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	codes := make(chan string, 1)
+	go func() {
+		for {
+			req, err := listener.Accept2()
+			if err != nil {
+				return
+			}
+			code, _ := verifyEncryption(req, enc)
+			codes <- code
+			if code != "" {
+				req.Reject(srt.REJ_UNSECURE)
+				continue
+			}
+			if conn, err := req.Accept(); err == nil {
+				conn.Close()
+			}
+		}
+	}()
+
+	tests := []struct {
+		name       string
+		passphrase string
+		keyLength  int // In bytes, as SRT options take it
+		want       string
+	}{
+		{"requested key length", "correct horse battery", 32, ""},
+		{"AES-128 publisher", "correct horse battery", 16, RejectWrongKeyLength},
+		{"AES-192 publisher", "correct horse battery", 24, RejectWrongKeyLength},
+		{"wrong passphrase", "wrong horse battery", 32, RejectBadPassphrase},
+		{"unencrypted publisher", "", 16, RejectEncryptionMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := srt.DefaultConfig()
+			config.Passphrase = tt.passphrase
+			config.PBKeylen = tt.keyLength
+			config.ConnectionTimeout = 2 * time.Second
+
+			conn, err := srt.Dial("srt", listener.Addr().String(), config)
+			if conn != nil {
+				conn.Close()
+			}
+			if code := <-codes; code != tt.want {
+				t.Errorf("handshake rejected with %q, want %q", code, tt.want)
+			}
+			if (err == nil) != (tt.want == "") {
+				t.Errorf("Dial = %v, want accepted %v", err, tt.want == "")
+			}
+		})
+	}
+}
+
+// The key length is read from unexported gosrt fields. Should a gosrt update move them, key lengths
+// silently stop being enforced, this test is what notices.
+func TestHandshakeKeyLengthReadable(t *testing.T) {
+	listener, err := srt.Listen("srt", "127.0.0.1:0", srt.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	type result struct {
+		keyLength int
+		ok        bool
+	}
+	results := make(chan result, 1)
+	go func() {
+		req, err := listener.Accept2()
+		if err != nil {
+			return
+		}
+		keyLength, ok := handshakeKeyLength(req)
+		results <- result{keyLength, ok}
+		req.Reject(srt.REJ_UNSECURE)
+	}()
+
+	config := srt.DefaultConfig()
+	config.Passphrase = "correct horse battery"
+	config.PBKeylen = 24
+	config.ConnectionTimeout = 2 * time.Second
+	if conn, err := srt.Dial("srt", listener.Addr().String(), config); err == nil {
+		conn.Close()
+	}
+
+	select {
+	case r := <-results:
+		if !r.ok {
+			t.Fatal("handshake.SRTKM.KLen is gone from gosrt's ConnRequest, handshakeKeyLength must find the key length elsewhere")
+		}
+		if r.keyLength != 24 {
+			t.Fatalf("handshakeKeyLength = %d, want the 24 bytes offered", r.keyLength)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no handshake")
+	}
+}
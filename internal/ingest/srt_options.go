@@ -0,0 +1,214 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+	"github.com/vijayvenkatj/LiveTran/internal/config"
+)
+
+// Bounds of the SRT options a stream can ask for
+const (
+	minSrtLatency         = 20 * time.Millisecond
+	maxSrtLatency         = 8 * time.Second
+	minSrtPeerIdleTimeout = time.Second
+	maxSrtPeerIdleTimeout = time.Minute
+	minConnectTimeout     = 5 * time.Second
+	maxConnectTimeout     = time.Hour
+)
+
+// SRT carries MPEG-TS, a payload holds whole 188 byte packets
+const tsPacketSize = 188
+
+// How long a stream waits for a publisher when nothing else is configured
+const defaultConnectTimeout = 120 * time.Second
+
+var ErrInvalidSrtOptions = errors.New("invalid SRT options")
+
+// SrtOptions tune the SRT connection of a stream, fields left out take the server defaults
+type SrtOptions struct {
+	LatencyMs             int   `json:"latency_ms,omitempty"`              // Receiver buffer to recover lost packets in, more for long or lossy links
+	PeerIdleTimeoutMs     int   `json:"peer_idle_timeout_ms,omitempty"`    // The publisher is dropped after this long without data
+	MaxBandwidth          int64 `json:"max_bandwidth,omitempty"`           // Bytes per second the publisher may send, retransmissions included. -1 is unlimited.
+	PayloadSize           int   `json:"payload_size,omitempty"`            // Bytes per packet, a multiple of 188
+	ConnectTimeoutSeconds int   `json:"connect_timeout_seconds,omitempty"` // How long the stream waits for a publisher before it ends
+}
+
+// DefaultSrtOptions are the settings LiveTran used before they were configurable
+func DefaultSrtOptions() SrtOptions {
+	return SrtOptions{
+		LatencyMs:             120,
+		PeerIdleTimeoutMs:     2000,
+		MaxBandwidth:          -1,
+		PayloadSize:           7 * tsPacketSize,
+		ConnectTimeoutSeconds: int(defaultConnectTimeout.Seconds()),
+	}
+}
+
+// SrtOptionsFromEnv reads the server defaults, every stream can override them
+func SrtOptionsFromEnv() SrtOptions {
+	def := DefaultSrtOptions()
+
+	maxBandwidth := int64(config.EnvInt("SRT_MAX_BANDWIDTH", 0))
+	if maxBandwidth == 0 {
+		maxBandwidth = def.MaxBandwidth
+	}
+
+	return SrtOptions{
+		LatencyMs:             int(config.EnvDuration("SRT_LATENCY", def.latency()).Milliseconds()),
+		PeerIdleTimeoutMs:     int(config.EnvDuration("SRT_PEER_IDLE_TIMEOUT", def.peerIdleTimeout()).Milliseconds()),
+		MaxBandwidth:          maxBandwidth,
+		PayloadSize:           config.EnvInt("SRT_PAYLOAD_SIZE", def.PayloadSize),
+		ConnectTimeoutSeconds: int(config.EnvDuration("SRT_CONNECT_TIMEOUT", def.connectTimeout()).Seconds()),
+	}
+}
+
+// merge overrides the options with the fields set in each of layers, later layers win
+func (opts SrtOptions) merge(layers ...*SrtOptions) *SrtOptions {
+	for _, layer := range layers {
+		if layer == nil {
+			continue
+		}
+		if layer.LatencyMs != 0 {
+			opts.LatencyMs = layer.LatencyMs
+		}
+		if layer.PeerIdleTimeoutMs != 0 {
+			opts.PeerIdleTimeoutMs = layer.PeerIdleTimeoutMs
+		}
+		if layer.MaxBandwidth != 0 {
+			opts.MaxBandwidth = layer.MaxBandwidth
+		}
+		if layer.PayloadSize != 0 {
+			opts.PayloadSize = layer.PayloadSize
+		}
+		if layer.ConnectTimeoutSeconds != 0 {
+			opts.ConnectTimeoutSeconds = layer.ConnectTimeoutSeconds
+		}
+	}
+	return &opts
+}
+
+func (opts *SrtOptions) validate() error {
+	if opts.latency() < minSrtLatency || opts.latency() > maxSrtLatency {
+		return fmt.Errorf("%w: latency_ms must be between %d and %d", ErrInvalidSrtOptions, minSrtLatency.Milliseconds(), maxSrtLatency.Milliseconds())
+	}
+	if opts.peerIdleTimeout() < minSrtPeerIdleTimeout || opts.peerIdleTimeout() > maxSrtPeerIdleTimeout {
+		return fmt.Errorf("%w: peer_idle_timeout_ms must be between %d and %d", ErrInvalidSrtOptions, minSrtPeerIdleTimeout.Milliseconds(), maxSrtPeerIdleTimeout.Milliseconds())
+	}
+	if opts.MaxBandwidth < -1 {
+		return fmt.Errorf("%w: max_bandwidth must be positive, or -1 for unlimited", ErrInvalidSrtOptions)
+	}
+	if opts.PayloadSize%tsPacketSize != 0 {
+		return fmt.Errorf("%w: payload_size must be a multiple of %d", ErrInvalidSrtOptions, tsPacketSize)
+	}
+	if opts.connectTimeout() < minConnectTimeout || opts.connectTimeout() > maxConnectTimeout {
+		return fmt.Errorf("%w: connect_timeout_seconds must be between %d and %d", ErrInvalidSrtOptions, int(minConnectTimeout.Seconds()), int(maxConnectTimeout.Seconds()))
+	}
+
+	config := srt.DefaultConfig()
+	opts.apply(&config)
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSrtOptions, err)
+	}
+	return nil
+}
+
+// apply sets the options on the SRT config of a listener or caller
+func (opts *SrtOptions) apply(config *srt.Config) {
+	config.Latency = opts.latency()
+	config.ReceiverLatency = opts.latency()
+	config.PeerLatency = opts.latency()
+	config.PeerIdleTimeout = opts.peerIdleTimeout()
+	config.MaxBW = opts.MaxBandwidth
+	config.PayloadSize = uint32(opts.PayloadSize)
+}
+
+// urlParams are the options the publisher has to set itself, as SRT URL options (latency in microseconds).
+// Those matching what encoders default to are left out.
+func (opts *SrtOptions) urlParams() string {
+	def := DefaultSrtOptions()
+	params := ""
+
+	if opts.LatencyMs != def.LatencyMs {
+		params += "&latency=" + strconv.FormatInt(opts.latency().Microseconds(), 10)
+	}
+	if opts.MaxBandwidth > 0 {
+		params += "&maxbw=" + strconv.FormatInt(opts.MaxBandwidth, 10)
+	}
+	if opts.PayloadSize != def.PayloadSize {
+		params += "&payload_size=" + strconv.Itoa(opts.PayloadSize)
+	}
+	return params
+}
+
+// matches reports whether a start request asks for the options the task runs with, fields left out accept any value
+func (opts *SrtOptions) matches(requested *SrtOptions) bool {
+	if requested == nil {
+		return true
+	}
+	if opts == nil {
+		return false
+	}
+	return *opts == *opts.merge(requested)
+}
+
+func (opts *SrtOptions) clone() *SrtOptions {
+	if opts == nil {
+		return nil
+	}
+	c := *opts
+	return &c
+}
+
+func (opts *SrtOptions) latency() time.Duration {
+	return time.Duration(opts.LatencyMs) * time.Millisecond
+}
+
+func (opts *SrtOptions) peerIdleTimeout() time.Duration {
+	return time.Duration(opts.PeerIdleTimeoutMs) * time.Millisecond
+}
+
+func (opts *SrtOptions) connectTimeout() time.Duration {
+	return time.Duration(opts.ConnectTimeoutSeconds) * time.Second
+}
+
+// srtOptionsFromURL reads the options given as query of a pull URL, in the units SRT URLs use (milliseconds)
+func srtOptionsFromURL(rawURL string) (*SrtOptions, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+
+	var opts SrtOptions
+	ints := map[string]*int{
+		"latency":       &opts.LatencyMs,
+		"peeridletimeo": &opts.PeerIdleTimeoutMs,
+		"payloadsize":   &opts.PayloadSize,
+	}
+	for key, field := range ints {
+		if value := query.Get(key); value != "" {
+			if *field, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("%s in pull URL: %s", key, err)
+			}
+		}
+	}
+	if value := query.Get("maxbw"); value != "" {
+		if opts.MaxBandwidth, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("maxbw in pull URL: %s", err)
+		}
+	}
+	return &opts, nil
+}
+
+// connectTimeout is how long the task waits for a publisher
+func (task *Task) connectTimeout() time.Duration {
+	if task.Srt != nil {
+		return task.Srt.connectTimeout()
+	}
+	return defaultConnectTimeout
+}
@@ -0,0 +1,139 @@
+package ingest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+// Server defaults, then the options of a pull URL, then the ones the request sets
+func TestSrtOptionsPrecedence(t *testing.T) {
+	defaults := DefaultSrtOptions()
+	defaults.LatencyMs = 500
+	defaults.ConnectTimeoutSeconds = 60
+	s := &SRTServer{cfg: SRTConfig{Defaults: defaults}}
+
+	fromURL, err := srtOptionsFromURL("srt://origin.example:9000?latency=3000&peeridletimeo=5000&maxbw=1000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := s.options(fromURL, &SrtOptions{PeerIdleTimeoutMs: 8000, PayloadSize: 2 * tsPacketSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := SrtOptions{LatencyMs: 3000, PeerIdleTimeoutMs: 8000, MaxBandwidth: 1000000, PayloadSize: 2 * tsPacketSize, ConnectTimeoutSeconds: 60}
+	if *opts != want {
+		t.Errorf("options = %+v, want %+v", *opts, want)
+	}
+
+	if _, err := srtOptionsFromURL("srt://origin.example:9000?latency=soon"); err == nil {
+		t.Error("latency=soon in a pull URL was accepted")
+	}
+}
+
+func TestSrtOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  SrtOptions
+		valid bool
+	}{
+		{"defaults", SrtOptions{}, true},
+		{"studio latency", SrtOptions{LatencyMs: 120}, true},
+		{"long-haul latency", SrtOptions{LatencyMs: 4000}, true},
+		{"latency too low", SrtOptions{LatencyMs: 10}, false},
+		{"latency too high", SrtOptions{LatencyMs: 9000}, false},
+		{"idle timeout too short", SrtOptions{PeerIdleTimeoutMs: 500}, false},
+		{"idle timeout too long", SrtOptions{PeerIdleTimeoutMs: 120000}, false},
+		{"bandwidth cap", SrtOptions{MaxBandwidth: 2000000}, true},
+		{"negative bandwidth", SrtOptions{MaxBandwidth: -2}, false},
+		{"payload of whole TS packets", SrtOptions{PayloadSize: 6 * tsPacketSize}, true},
+		{"payload of partial TS packets", SrtOptions{PayloadSize: 1000}, false},
+		{"payload over the MTU", SrtOptions{PayloadSize: 10 * tsPacketSize}, false},
+		{"connect timeout too short", SrtOptions{ConnectTimeoutSeconds: 1}, false},
+		{"connect timeout of an hour", SrtOptions{ConnectTimeoutSeconds: 3600}, true},
+		{"connect timeout too long", SrtOptions{ConnectTimeoutSeconds: 3601}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := DefaultSrtOptions().merge(&tt.opts).validate()
+			if tt.valid && err != nil {
+				t.Errorf("validate = %v, want nil", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidSrtOptions) {
+				t.Errorf("validate = %v, want %v", err, ErrInvalidSrtOptions)
+			}
+		})
+	}
+}
+
+func TestPrepareIngestSrtOptions(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	tm := NewTaskManager(nil, nil)
+	defer tm.Close()
+
+	if _, _, err := tm.prepareIngest(&Task{Id: "rtmp", Protocol: ProtocolRTMP, Srt: &SrtOptions{LatencyMs: 500}}); !errors.Is(err, ErrInvalidSrtOptions) {
+		t.Errorf("RTMP stream with SRT options = %v, want %v", err, ErrInvalidSrtOptions)
+	}
+	if _, _, err := tm.prepareIngest(&Task{Id: "bad", Protocol: ProtocolSRT, Srt: &SrtOptions{PayloadSize: 1000}}); !errors.Is(err, ErrInvalidSrtOptions) {
+		t.Errorf("payload_size 1000 = %v, want %v", err, ErrInvalidSrtOptions)
+	}
+
+	// The publisher is told what to set, the defaults of encoders are left out
+	task := &Task{Id: "long-haul", Protocol: ProtocolSRT, Srt: &SrtOptions{LatencyMs: 3000, MaxBandwidth: 1000000}}
+	source, info, err := tm.prepareIngest(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	if want := "&latency=3000000&maxbw=1000000"; !strings.HasSuffix(info.URL, want) {
+		t.Errorf("ingest URL = %s, want it to end with %s", info.URL, want)
+	}
+	if task.connectTimeout() != defaultConnectTimeout {
+		t.Errorf("connect timeout = %s, want %s", task.connectTimeout(), defaultConnectTimeout)
+	}
+
+	// A publisher asking for less latency gets the stream's
+	conn, err := dialSrt(info.Port, info.StreamKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	pub, err := acceptWithin(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+
+	var stats srt.Statistics
+	pub.(*srtPublisher).conn.Stats(&stats)
+	if delay := stats.Instantaneous.MsRecvTsbPdDelay; delay != 3000 {
+		t.Errorf("receiver latency = %dms, want 3000ms", delay)
+	}
+}
+
+// Publishers on the shared port cannot go below the listener's latency, so streams cannot ask for less
+func TestSharedPortLatencyFloor(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	tm := NewTaskManager(nil, nil)
+	defer tm.Close()
+
+	defaults := DefaultSrtOptions()
+	defaults.LatencyMs = 500
+	stop, err := tm.StartSRT(SRTConfig{Port: freeUDPPorts(t, 1), Defaults: defaults})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	if _, _, err := tm.prepareIngest(&Task{Id: "studio", Protocol: ProtocolSRT, Srt: &SrtOptions{LatencyMs: 120}}); !errors.Is(err, ErrInvalidSrtOptions) {
+		t.Errorf("latency below the shared port's = %v, want %v", err, ErrInvalidSrtOptions)
+	}
+	source, _, err := tm.prepareIngest(&Task{Id: "long-haul", Protocol: ProtocolSRT, Srt: &SrtOptions{LatencyMs: 3000}})
+	if err != nil {
+		t.Fatalf("latency above the shared port's = %v", err)
+	}
+	source.Close()
+}
@@ -0,0 +1,275 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"sync"
+
+	srt "github.com/datarhei/gosrt"
+	"github.com/vijayvenkatj/LiveTran/internal/auth"
+	"github.com/vijayvenkatj/LiveTran/internal/config"
+)
+
+// SRTConfig controls where SRT publishers connect
+type SRTConfig struct {
+	// Shared port every SRT publisher connects to, 0 gives each stream its own listener
+	Port int
+	// Ports per-stream listeners are opened on, when there is no shared listener. 0 lets the OS pick.
+	PortMin int
+	PortMax int
+	// Options of streams that do not set their own
+	Defaults SrtOptions
+}
+
+func SRTConfigFromEnv() SRTConfig {
+	return SRTConfig{
+		Port:     config.EnvNonNegativeInt("SRT_PORT", 9000),
+		PortMin:  config.EnvNonNegativeInt("SRT_PORT_MIN", 0),
+		PortMax:  config.EnvNonNegativeInt("SRT_PORT_MAX", 0),
+		Defaults: SrtOptionsFromEnv(),
+	}
+}
+
+// SRTServer accepts every SRT publisher on one port and routes them to their
+// stream by the stream id carried in the streamid
+type SRTServer struct {
+	cfg      SRTConfig
+	listener srt.Listener
+	routes   *routeTable
+
+	// Ports of the range held by per-stream listeners
+	mu    sync.Mutex
+	inUse map[int]bool
+}
+
+// StartSRT opens the shared SRT listener, SRT streams are served by it from then on.
+// Without it (disabled or failed to bind) streams get their own listener from the port range.
+// The returned function closes the listener.
+func (tm *TaskManager) StartSRT(cfg SRTConfig) (func(), error) {
+	if cfg.PortMin > cfg.PortMax || (cfg.PortMin == 0) != (cfg.PortMax == 0) {
+		return nil, fmt.Errorf("SRT port range %d-%d is invalid", cfg.PortMin, cfg.PortMax)
+	}
+	if err := cfg.Defaults.validate(); err != nil {
+		return nil, fmt.Errorf("SRT defaults: %s", err)
+	}
+
+	s := &SRTServer{
+		cfg:    cfg,
+		routes: newRouteTable(),
+	}
+	tm.srt = s
+
+	if cfg.Port == 0 {
+		slog.Info("SRT ingest uses one listener per stream", "port_min", cfg.PortMin, "port_max", cfg.PortMax)
+		return func() {}, nil
+	}
+
+	// SRT listeners share their port silently, make sure nobody else is on it
+	if !udpPortFree(cfg.Port) {
+		return nil, fmt.Errorf("SRT Listener error: port %d is in use", cfg.Port)
+	}
+
+	// Every stream shares the listener's settings. Its latency is the least a publisher gets,
+	// idle publishers are dropped by their stream's own timeout.
+	config := srt.DefaultConfig()
+	cfg.Defaults.apply(&config)
+	config.PeerIdleTimeout = maxSrtPeerIdleTimeout
+
+	listener, err := srt.Listen("srt", fmt.Sprintf(":%d", cfg.Port), config)
+	if err != nil {
+		return nil, fmt.Errorf("SRT Listener error: %s", err)
+	}
+	s.listener = listener
+
+	go serveSrt(listener, s.routes.lookup)
+
+	slog.Info("SRT ingest listening", "port", cfg.Port)
+
+	return func() {
+		listener.Close()
+	}, nil
+}
+
+// prepareIngest registers the task's route on the shared listener, or opens a listener for the task
+func (s *SRTServer) prepareIngest(task *Task) (ingestSource, IngestInfo, error) {
+	if s.listener == nil {
+		return s.prepareListener(task)
+	}
+
+	// Publishers can ask for more latency than the listener in the handshake, not for less
+	if task.Srt.latency() < s.cfg.Defaults.latency() {
+		return nil, IngestInfo{}, fmt.Errorf("%w: latency_ms cannot be below the shared port's %d", ErrInvalidSrtOptions, s.cfg.Defaults.LatencyMs)
+	}
+
+	streamkey, expiresAt, err := auth.GenerateStreamKey(task.Id)
+	if err != nil {
+		return nil, IngestInfo{}, fmt.Errorf("StreamKey error: %s", err)
+	}
+
+	source := s.routes.register(task)
+
+	return source, srtIngestInfo(task, GetLocalIP(), s.cfg.Port, streamkey, expiresAt), nil
+}
+
+// serveSrt accepts handshakes until the listener is closed, lookup finds the stream a streamid is routed to
+func serveSrt(listener srt.Listener, lookup func(id string) (*routedSource, bool)) {
+	for {
+		req, err := listener.Accept2()
+		if errors.Is(err, srt.ErrListenerClosed) {
+			return
+		}
+		if err != nil {
+			slog.Error("SRT accept failed", "error", err)
+			continue
+		}
+
+		routeSrt(req, lookup)
+	}
+}
+
+// routeSrt validates a handshake against the stream its streamid names and hands the connection to it
+func routeSrt(req srt.ConnRequest, lookup func(id string) (*routedSource, bool)) {
+	remoteAddr := req.RemoteAddr().String()
+	streamkey := req.StreamId()
+
+	id, err := auth.StreamKeyId(streamkey)
+	if err != nil {
+		slog.Warn("Rejected SRT publisher", "remote_addr", remoteAddr, "reason", err)
+		req.Reject(srt.REJX_BAD_REQUEST)
+		return
+	}
+
+	source, ok := lookup(id)
+	if !ok {
+		slog.Warn("Rejected SRT publisher", "stream_id", id, "remote_addr", remoteAddr, "reason", "unknown stream")
+		req.Reject(srt.REJX_NOTFOUND)
+		return
+	}
+	task := source.task
+
+	if ok, reason := auth.DecodeStreamKey(id, streamkey); !ok {
+		rejectHandshake(task, req, RejectInvalidStreamKey, reason)
+		return
+	}
+	if code, reason := verifyEncryption(req, task.Encryption); code != "" {
+		rejectHandshake(task, req, code, reason)
+		return
+	}
+	role, err := publisherRole(streamkey)
+	if err != nil {
+		rejectHandshake(task, req, RejectInvalidStreamKey, err.Error())
+		return
+	}
+
+	conn, err := req.Accept()
+	if err != nil {
+		// The publisher never got in, the state does not change
+		task.Emit(EventPublisherDisconnected, PublisherData{RemoteAddr: remoteAddr, Reason: fmt.Sprintf("Accept failed : %s", err)})
+		return
+	}
+
+	// Waiting for the task must not hold up the handshakes of other streams
+	go func() {
+		pub := newSrtPublisher(conn, remoteAddr, task.Srt.peerIdleTimeout())
+		pub.inputRole = role
+
+		if err := source.handoff(pub); err != nil {
+			slog.Warn("Rejected SRT publisher", "stream_id", id, "remote_addr", remoteAddr, "role", role, "reason", err)
+			conn.Close()
+		}
+	}()
+}
+
+// options resolves the SRT options of a stream over the server defaults, later layers win
+func (s *SRTServer) options(layers ...*SrtOptions) (*SrtOptions, error) {
+	opts := s.cfg.Defaults.merge(layers...)
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// prepareListener opens an SRT listener for the task alone and generates its stream key
+func (s *SRTServer) prepareListener(task *Task) (ingestSource, IngestInfo, error) {
+	config := srt.DefaultConfig()
+	task.Srt.apply(&config)
+	if task.Encryption != nil {
+		config.PBKeylen = task.Encryption.pbKeylen()
+	}
+
+	listener, port, release, err := s.listen(config)
+	if err != nil {
+		return nil, IngestInfo{}, fmt.Errorf("SRT Listener error: %s", err)
+	}
+
+	streamkey, expiresAt, err := auth.GenerateStreamKey(task.Id)
+	if err != nil {
+		listener.Close()
+		release()
+		return nil, IngestInfo{}, fmt.Errorf("StreamKey error: %s", err)
+	}
+
+	info := srtIngestInfo(task, GetLocalIP(), port, streamkey, expiresAt)
+
+	// The listener only serves the task, keys of other streams are not routed
+	source := newRouteTable().register(task)
+	go serveSrt(listener, func(id string) (*routedSource, bool) { return source, id == task.Id })
+
+	return &srtSource{routedSource: source, listener: listener, release: release}, info, nil
+}
+
+// listen opens a per-stream listener on a port from the configured range, any free port without one.
+// release gives the port back once the listener is closed.
+func (s *SRTServer) listen(config srt.Config) (listener srt.Listener, port int, release func(), err error) {
+	if s.cfg.PortMin == 0 {
+		listener, err := srt.Listen("srt", ":0", config)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		return listener, listener.Addr().(*net.UDPAddr).Port, func() {}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inUse == nil {
+		s.inUse = make(map[int]bool)
+	}
+
+	// Start at a random port so a restart does not hand out the ports of the previous run first
+	size := s.cfg.PortMax - s.cfg.PortMin + 1
+	offset := rand.Intn(size)
+	for i := 0; i < size; i++ {
+		port := s.cfg.PortMin + (offset+i)%size
+		if s.inUse[port] || !udpPortFree(port) {
+			continue
+		}
+
+		listener, err := srt.Listen("srt", fmt.Sprintf(":%d", port), config)
+		if err != nil {
+			continue
+		}
+
+		s.inUse[port] = true
+		return listener, port, func() {
+			s.mu.Lock()
+			delete(s.inUse, port)
+			s.mu.Unlock()
+		}, nil
+	}
+	return nil, 0, nil, fmt.Errorf("no free port in %d-%d", s.cfg.PortMin, s.cfg.PortMax)
+}
+
+// udpPortFree reports whether nothing is bound to port. SRT sockets set SO_REUSEADDR, so
+// opening an SRT listener succeeds even when the port is taken.
+func udpPortFree(port int) bool {
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
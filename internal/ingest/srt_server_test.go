@@ -0,0 +1,177 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+	"github.com/vijayvenkatj/LiveTran/internal/auth"
+)
+
+// freeUDPPorts finds n consecutive ports nothing is bound to
+func freeUDPPorts(t *testing.T, n int) int {
+	t.Helper()
+	for range 100 {
+		first := 20000 + rand.Intn(20000)
+		free := true
+		for port := first; port < first+n && free; port++ {
+			free = udpPortFree(port)
+		}
+		if free {
+			return first
+		}
+	}
+	t.Fatal("no free UDP ports")
+	return 0
+}
+
+// dialSrt connects to the SRT port on the loopback with streamid
+func dialSrt(port int, streamid string) (srt.Conn, error) {
+	config := srt.DefaultConfig()
+	config.StreamId = streamid
+	config.ConnectionTimeout = 2 * time.Second
+	return srt.Dial("srt", fmt.Sprintf("127.0.0.1:%d", port), config)
+}
+
+// acceptWithin waits a little for the next publisher of source
+func acceptWithin(source ingestSource) (publisher, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return source.accept(ctx)
+}
+
+func TestSrtSharedPortRouting(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	tm := NewTaskManager(nil, nil)
+	defer tm.Close()
+
+	port := freeUDPPorts(t, 1)
+	stop, err := tm.StartSRT(SRTConfig{Port: port, Defaults: DefaultSrtOptions()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	sources := map[string]ingestSource{}
+	keys := map[string]string{}
+	for _, id := range []string{"shared-a", "shared-b"} {
+		source, info, err := tm.prepareIngest(&Task{Id: id, Protocol: ProtocolSRT})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer source.Close()
+		if info.Port != port {
+			t.Errorf("%s ingests on port %d, want the shared %d", id, info.Port, port)
+		}
+		sources[id], keys[id] = source, info.StreamKey
+	}
+
+	// Each publisher lands on the stream its key names
+	for _, id := range []string{"shared-b", "shared-a"} {
+		conn, err := dialSrt(port, keys[id])
+		if err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		defer conn.Close()
+
+		pub, err := acceptWithin(sources[id])
+		if err != nil {
+			t.Fatalf("%s got no publisher: %v", id, err)
+		}
+		defer pub.Close()
+		if role := pub.(*srtPublisher).inputRole; role != RolePrimary {
+			t.Errorf("%s publisher role = %s, want %s", id, role, RolePrimary)
+		}
+	}
+
+	unknown, _, err := auth.GenerateStreamKey("unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected := []struct {
+		name     string
+		streamid string
+	}{
+		{"unknown stream", unknown},
+		{"key of another stream", strings.Replace(keys["shared-a"], "rid=shared-a", "rid=shared-b", 1)},
+		{"no rid", "mode=publish"},
+	}
+	for _, r := range rejected {
+		t.Run(r.name, func(t *testing.T) {
+			if conn, err := dialSrt(port, r.streamid); err == nil {
+				conn.Close()
+				t.Fatal("publisher was accepted")
+			}
+		})
+	}
+
+	// A closed route no longer takes publishers
+	sources["shared-a"].Close()
+	if conn, err := dialSrt(port, keys["shared-a"]); err == nil {
+		conn.Close()
+		t.Error("publisher of a closed route was accepted")
+	}
+}
+
+// With SRT_PORT=0 every stream gets a listener on a port of the range, closing the stream frees it
+func TestSrtPerStreamListeners(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	tm := NewTaskManager(nil, nil)
+	defer tm.Close()
+
+	first := freeUDPPorts(t, 2)
+	if _, err := tm.StartSRT(SRTConfig{PortMin: first, PortMax: first + 1, Defaults: DefaultSrtOptions()}); err != nil {
+		t.Fatal(err)
+	}
+
+	var sources []ingestSource
+	var infos []IngestInfo
+	for _, id := range []string{"own-a", "own-b"} {
+		source, info, err := tm.prepareIngest(&Task{Id: id, Protocol: ProtocolSRT})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer source.Close()
+		if info.Port < first || info.Port > first+1 {
+			t.Errorf("%s listens on port %d, outside %d-%d", id, info.Port, first, first+1)
+		}
+		sources, infos = append(sources, source), append(infos, info)
+	}
+	if infos[0].Port == infos[1].Port {
+		t.Fatalf("both streams listen on port %d", infos[0].Port)
+	}
+
+	if _, _, err := tm.prepareIngest(&Task{Id: "own-c", Protocol: ProtocolSRT}); err == nil || !strings.Contains(err.Error(), "no free port") {
+		t.Fatalf("third stream = %v, want the range exhausted", err)
+	}
+
+	// The listener checks the key against its own stream only
+	if conn, err := dialSrt(infos[0].Port, infos[1].StreamKey); err == nil {
+		conn.Close()
+		t.Error("key of own-b was accepted on the port of own-a")
+	}
+	conn, err := dialSrt(infos[0].Port, infos[0].StreamKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	pub, err := acceptWithin(sources[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub.Close()
+
+	sources[0].Close()
+	source, info, err := tm.prepareIngest(&Task{Id: "own-c", Protocol: ProtocolSRT})
+	if err != nil {
+		t.Fatalf("port of a closed stream was not freed: %v", err)
+	}
+	defer source.Close()
+	if info.Port != infos[0].Port {
+		t.Errorf("own-c listens on port %d, want the freed %d", info.Port, infos[0].Port)
+	}
+}
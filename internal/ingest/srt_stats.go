@@ -0,0 +1,103 @@
+package ingest
+
+import (
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+// SrtStats are the figures of a stream's SRT connection. Packet counts add up over
+// every connection of the stream, the rest describes the current one.
+type SrtStats struct {
+	Connected         bool      `json:"connected"`
+	RemoteAddr        string    `json:"remote_addr,omitempty"`
+	RTTMs             float64   `json:"rtt_ms"`
+	ReceiveRateMbps   float64   `json:"receive_rate_mbps"`
+	LinkCapacityMbps  float64   `json:"link_capacity_mbps"`
+	PacketLossPercent float64   `json:"packet_loss_percent"` // Over the last sample interval
+	LatencyMs         uint64    `json:"latency_ms"`          // Negotiated with the publisher
+	ReceiveBufferMs   uint64    `json:"receive_buffer_ms"`
+	PacketsReceived   uint64    `json:"packets_received"`
+	PacketsLost       uint64    `json:"packets_lost"`
+	PacketsRetrans    uint64    `json:"packets_retransmitted"`
+	PacketsDropped    uint64    `json:"packets_dropped"` // Arrived too late to be delivered
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// srtStatsSource is a publisher that can report the statistics of its SRT connection
+type srtStatsSource interface {
+	srtStatistics() srt.Statistics
+}
+
+// srtCounters are the packet counts of connections that have ended
+type srtCounters struct {
+	received, lost, retransmitted, dropped uint64
+}
+
+// sampleSrt records the latest statistics of the connection from remoteAddr
+func (c *statsCollector) sampleSrt(s srt.Statistics, remoteAddr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var loss float64
+	if total := s.Interval.PktRecv + s.Interval.PktRecvLoss; total > 0 {
+		loss = float64(s.Interval.PktRecvLoss) * 100 / float64(total)
+	}
+
+	c.srt = &SrtStats{
+		Connected:         true,
+		RemoteAddr:        remoteAddr,
+		RTTMs:             s.Instantaneous.MsRTT,
+		ReceiveRateMbps:   s.Instantaneous.MbpsRecvRate,
+		LinkCapacityMbps:  s.Instantaneous.MbpsLinkCapacity,
+		PacketLossPercent: loss,
+		LatencyMs:         s.Instantaneous.MsRecvTsbPdDelay,
+		ReceiveBufferMs:   s.Instantaneous.MsRecvBuf,
+		PacketsReceived:   c.srtEnded.received + s.Accumulated.PktRecv,
+		PacketsLost:       c.srtEnded.lost + s.Accumulated.PktRecvLoss,
+		PacketsRetrans:    c.srtEnded.retransmitted + s.Accumulated.PktRecvRetrans,
+		PacketsDropped:    c.srtEnded.dropped + s.Accumulated.PktRecvDrop,
+		UpdatedAt:         time.Now(),
+	}
+}
+
+// endSrt keeps the packet counts of the connection that is gone and clears the rest.
+// Must be called with c.mu held.
+func (c *statsCollector) endSrt() {
+	if c.srt == nil || !c.srt.Connected {
+		return
+	}
+
+	c.srtEnded = srtCounters{
+		received:      c.srt.PacketsReceived,
+		lost:          c.srt.PacketsLost,
+		retransmitted: c.srt.PacketsRetrans,
+		dropped:       c.srt.PacketsDropped,
+	}
+	c.srt = &SrtStats{
+		PacketsReceived: c.srt.PacketsReceived,
+		PacketsLost:     c.srt.PacketsLost,
+		PacketsRetrans:  c.srt.PacketsRetrans,
+		PacketsDropped:  c.srt.PacketsDropped,
+		UpdatedAt:       time.Now(),
+	}
+}
+
+func (s *SrtStats) clone() *SrtStats {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	return &c
+}
+
+// SrtStats returns the SRT statistics of every stream that has had an SRT connection, by stream id
+func (tm *TaskManager) SrtStats() map[string]SrtStats {
+	stats := make(map[string]SrtStats)
+	for _, task := range tm.ListTasks() {
+		if s := task.Stats().Srt; s != nil {
+			stats[task.Id] = *s
+		}
+	}
+	return stats
+}
@@ -0,0 +1,174 @@
+package ingest
+
+import (
+	"testing"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+func connectionStats(received, lost, intervalReceived, intervalLost uint64) srt.Statistics {
+	var s srt.Statistics
+	s.Accumulated.PktRecv = received
+	s.Accumulated.PktRecvLoss = lost
+	s.Interval.PktRecv = intervalReceived
+	s.Interval.PktRecvLoss = intervalLost
+	s.Instantaneous.MsRTT = 42
+	s.Instantaneous.MsRecvTsbPdDelay = 120
+	return s
+}
+
+// Packet counts keep adding up over reconnects, the rest is the current connection's
+func TestSampleSrtAcrossConnections(t *testing.T) {
+	var c statsCollector
+
+	c.sampleSrt(connectionStats(1000, 50, 90, 10), "10.0.0.1:5000")
+	s := c.snapshot().Srt
+	if !s.Connected || s.RemoteAddr != "10.0.0.1:5000" || s.RTTMs != 42 || s.LatencyMs != 120 {
+		t.Errorf("first connection = %+v", s)
+	}
+	if s.PacketLossPercent != 10 || s.PacketsReceived != 1000 || s.PacketsLost != 50 {
+		t.Errorf("first connection lost %.1f%%, %d of %d packets, want 10%%, 50 of 1000", s.PacketLossPercent, s.PacketsLost, s.PacketsReceived)
+	}
+
+	// The publisher is gone, only the counts are left
+	c.resetRates()
+	s = c.snapshot().Srt
+	if s.Connected || s.RemoteAddr != "" || s.RTTMs != 0 || s.PacketsReceived != 1000 || s.PacketsLost != 50 {
+		t.Errorf("after the connection = %+v", s)
+	}
+
+	c.sampleSrt(connectionStats(200, 0, 0, 0), "10.0.0.2:5000")
+	s = c.snapshot().Srt
+	if s.RemoteAddr != "10.0.0.2:5000" || s.PacketLossPercent != 0 || s.PacketsReceived != 1200 || s.PacketsLost != 50 {
+		t.Errorf("second connection = %+v, want 1200 packets received and 50 lost in total", s)
+	}
+
+	// Snapshots are copies
+	s.PacketsReceived = 0
+	if c.snapshot().Srt.PacketsReceived != 1200 {
+		t.Error("changing a snapshot changed the stream's stats")
+	}
+}
+
+// What the sampler records is what the SRT connection reports
+func TestSrtStatsOfConnection(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	tm := NewTaskManager(nil, nil)
+	defer tm.Close()
+
+	task := &Task{Id: "stats", Protocol: ProtocolSRT}
+	source, info, err := tm.prepareIngest(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	tm.TaskMap[task.Id] = task
+
+	conn, err := dialSrt(info.Port, info.StreamKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	pub, err := acceptWithin(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+
+	packet := make([]byte, 7*tsPacketSize)
+	for range 10 {
+		if _, err := conn.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := make([]byte, len(packet))
+	for range 10 {
+		if _, err := pub.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(tm.SrtStats()) != 0 {
+		t.Error("stats of a stream that was never sampled")
+	}
+	task.stats.sampleSrt(pub.(srtStatsSource).srtStatistics(), pub.RemoteAddr())
+
+	s, ok := tm.SrtStats()[task.Id]
+	if !ok {
+		t.Fatal("no SRT stats for the stream")
+	}
+	if !s.Connected || s.RemoteAddr != pub.RemoteAddr() || s.LatencyMs != 120 {
+		t.Errorf("stats = %+v", s)
+	}
+	if s.PacketsReceived < 10 {
+		t.Errorf("%d packets received, want at least 10", s.PacketsReceived)
+	}
+}
+
+// statsPublisher is a publisher of an SRT connection that reported accumulated
+type statsPublisher struct {
+	*fakeTsPublisher
+	accumulated srt.StatisticsAccumulated
+}
+
+func (pub *statsPublisher) srtStatistics() srt.Statistics {
+	return srt.Statistics{Accumulated: pub.accumulated}
+}
+
+// After a failover the stream counts the packets of the input taking over from when it did
+func TestFailoverSrtStats(t *testing.T) {
+	primary := &statsPublisher{fakeTsPublisher: newFakeTsPublisher(RolePrimary)}
+	backup := &statsPublisher{fakeTsPublisher: newFakeTsPublisher(RoleBackup)}
+	task := &Task{Id: "failover-stats"}
+	fp := newFailoverPublisher(task, primary)
+	defer fp.Close()
+	fp.attach(backup)
+
+	fp.mu.Lock()
+	fp.activate(fp.inputs[RolePrimary])
+	fp.mu.Unlock()
+	primary.accumulated.PktRecv = 1000
+	task.stats.sampleSrt(fp.srtStatistics(), fp.RemoteAddr())
+
+	// The backup has been receiving all along, what came before the switch was thrown away
+	backup.accumulated.PktRecv = 700
+	fp.mu.Lock()
+	fp.activate(fp.inputs[RoleBackup])
+	fp.mu.Unlock()
+	backup.accumulated.PktRecv = 750
+	task.stats.sampleSrt(fp.srtStatistics(), fp.RemoteAddr())
+
+	if s := task.Stats().Srt; s.PacketsReceived != 1050 || s.RemoteAddr != RoleBackup {
+		t.Errorf("stats = %+v, want 1050 packets received, the latest from the backup", s)
+	}
+}
+
+// Stats are read while a failover switches inputs, run with -race
+func TestFailoverSrtStatsDuringSwitch(t *testing.T) {
+	primary := &statsPublisher{fakeTsPublisher: newFakeTsPublisher(RolePrimary), accumulated: srt.StatisticsAccumulated{PktRecv: 1000}}
+	backup := &statsPublisher{fakeTsPublisher: newFakeTsPublisher(RoleBackup), accumulated: srt.StatisticsAccumulated{PktRecv: 700}}
+	fp := newFailoverPublisher(&Task{Id: "failover-switching"}, primary)
+	defer fp.Close()
+	fp.attach(backup)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 20000 {
+			fp.mu.Lock()
+			fp.activate(fp.inputs[[]string{RolePrimary, RoleBackup}[i%2]])
+			fp.mu.Unlock()
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		// Counted from the switch, a baseline of the other input would wrap around
+		if s := fp.srtStatistics(); s.Accumulated.PktRecv != 0 {
+			t.Fatalf("%d packets received since the switch, want 0", s.Accumulated.PktRecv)
+		}
+	}
+}
@@ -2,120 +2,454 @@ package ingest
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
+	"net/url"
 	"os"
 	"os/exec"
+	"regexp"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	srt "github.com/datarhei/gosrt"
-	"github.com/vijayvenkatj/LiveTran/internal/auth"
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
 	"github.com/vijayvenkatj/LiveTran/internal/upload"
 )
 
-func SrtConnectionTask(ctx context.Context, task *Task) {
+// How long FFmpeg gets to drain its input once the publisher is gone
+const ffmpegDrainTimeout = 5 * time.Second
+
+// IngestInfo tells a publisher where to push a stream
+type IngestInfo struct {
+	StreamId    string    `json:"stream_id"`
+	Protocol    Protocol  `json:"protocol"`
+	URL         string    `json:"ingest_url"`
+	ServerURL   string    `json:"server_url,omitempty"` // RTMP only, for encoders that take server and key separately
+	Pull        bool      `json:"pull,omitempty"`       // LiveTran connects to URL, nothing is pushed
+	Host        string    `json:"host"`
+	Port        int       `json:"port"`
+	StreamKey   string    `json:"streamid"`
+	BackupURL   string    `json:"backup_ingest_url,omitempty"` // SRT only, for a redundant encoder that takes over when the primary fails
+	BackupKey   string    `json:"backup_streamid,omitempty"`
+	Passphrase  string    `json:"passphrase,omitempty"` // SRT encryption only
+	KeyLength   int       `json:"key_length,omitempty"` // AES key size in bits
+	KeyExpiry   time.Time `json:"key_expiry,omitzero"`
+	PlaybackURL string    `json:"playback_url,omitempty"`
+	DashURL     string    `json:"dash_url,omitempty"` // CMAF only, the DASH manifest next to the HLS playlists
+}
 
-	port, err := getFreePort()
-	if err != nil {
-		task.UpdateStatus(StreamStopped, fmt.Sprintf("PORT error: %s", err))
-		return
+// srtIngestInfo is where a publisher pushes the task to, with the SRT options it has to set in the URL
+func srtIngestInfo(task *Task, ip string, port int, streamkey string, expiresAt time.Time) IngestInfo {
+	backupKey := streamkey + ",role=" + RoleBackup
+
+	info := IngestInfo{
+		StreamId:    task.Id,
+		Protocol:    ProtocolSRT,
+		URL:         fmt.Sprintf("srt://%s:%d?streamid=%s", ip, port, streamkey),
+		BackupURL:   fmt.Sprintf("srt://%s:%d?streamid=%s", ip, port, backupKey),
+		Host:        ip,
+		Port:        port,
+		StreamKey:   streamkey,
+		BackupKey:   backupKey,
+		KeyExpiry:   expiresAt,
+		PlaybackURL: PlaybackURL(task.Id, task.Abr),
+		DashURL:     DashPlaybackURL(task.Id, task.Profile),
+	}
+
+	// The passphrase is left out, it is only handed to the caller of start-stream (see withPassphrase)
+	params := task.Srt.urlParams()
+	if enc := task.Encryption; enc != nil {
+		params = fmt.Sprintf("&pbkeylen=%d", enc.pbKeylen()) + params
+		info.KeyLength = enc.KeyLength
+	}
+	info.URL += params
+	info.BackupURL += params
+
+	return info
+}
+
+// withPassphrase adds the stream's passphrase to the ingest details, for the start-stream response only.
+// What the task keeps, persists and sends in events never carries it.
+func (info IngestInfo) withPassphrase(enc *SrtEncryption) IngestInfo {
+	if enc == nil || info.Pull || info.Protocol != ProtocolSRT {
+		return info
+	}
+
+	param := "&passphrase=" + url.QueryEscape(enc.Passphrase)
+	info.Passphrase = enc.Passphrase
+	info.URL += param
+	if info.BackupURL != "" {
+		info.BackupURL += param
 	}
+	return info
+}
+
+// Records written before the passphrase was kept out of the ingest URL
+var passphraseParam = regexp.MustCompile(`&passphrase=[^&]*`)
+
+func redactPassphrase(ingestURL string) string {
+	return passphraseParam.ReplaceAllString(ingestURL, "")
+}
+
+// srtSource accepts the publishers of a task on its own SRT listener
+type srtSource struct {
+	*routedSource
+	listener srt.Listener
+	release  func() // Gives the port back to the range
+}
 
-	addr := fmt.Sprintf(":%d", port)
-	listener, err := srt.Listen("srt", addr, srt.DefaultConfig())
+func (src *srtSource) Close() {
+	src.listener.Close()
+	src.release()
+	src.routedSource.Close()
+}
+
+// prepareSrtPull resolves the remote SRT listener the task calls into
+func prepareSrtPull(task *Task) (ingestSource, IngestInfo, error) {
+	addr, config, err := srtCallerConfig(task.Pull)
 	if err != nil {
-		task.UpdateStatus(StreamStopped, fmt.Sprintf("SRT Listener error: %s", err))
-		return
+		return nil, IngestInfo{}, fmt.Errorf("%w: %s", ErrInvalidPullSource, err)
 	}
 
-	defer listener.Close()
+	task.Srt.apply(&config)
+
+	host, portValue, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portValue)
+
+	info := IngestInfo{
+		StreamId:    task.Id,
+		Protocol:    ProtocolSRT,
+		URL:         task.Pull.redactedURL(),
+		Pull:        true,
+		Host:        host,
+		Port:        port,
+		StreamKey:   config.StreamId,
+		PlaybackURL: PlaybackURL(task.Id, task.Abr),
+		DashURL:     DashPlaybackURL(task.Id, task.Profile),
+	}
 
-	ip := GetLocalIP()
+	return &srtCallerSource{addr: addr, config: config, task: task}, info, nil
+}
 
-	streamkey, err := auth.GenerateStreamKey(task.Id)
+// srtCallerConfig reads the caller settings from the pull URL, explicit passphrase and streamid take precedence
+func srtCallerConfig(pull *PullSource) (string, srt.Config, error) {
+	config := srt.DefaultConfig()
+
+	addr, err := config.UnmarshalURL(pull.URL)
 	if err != nil {
-		task.UpdateStatus(StreamStopped, fmt.Sprintf("StreamKey error: %s", err))
-		return
+		return "", config, err
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", config, fmt.Errorf("pull URL needs a host and port: %s", err)
 	}
 
-	url := fmt.Sprintf("srt://%s:%d?streamid=%s", ip, port, streamkey)
-	task.UpdateStatus(StreamReady, fmt.Sprintf("The stream is ready! URL -> %s", url))
+	if pull.Passphrase != "" {
+		config.Passphrase = pull.Passphrase
+	}
+	if pull.StreamId != "" {
+		config.StreamId = pull.StreamId
+	}
+
+	if err := config.Validate(); err != nil {
+		return "", config, err
+	}
+	return addr, config, nil
+}
+
+// srtCallerSource dials a remote SRT listener, backing off while it is unreachable
+type srtCallerSource struct {
+	addr    string
+	config  srt.Config
+	task    *Task
+	attempt int
+}
+
+func (src *srtCallerSource) accept(ctx context.Context) (publisher, error) {
+	for {
+		if src.attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, acceptCanceled(ctx)
+			case <-time.After(pullBackoff(src.attempt)):
+			}
+		}
+
+		conn, err := srt.Dial("srt", src.addr, src.config)
+		if ctx.Err() != nil {
+			if conn != nil {
+				conn.Close()
+			}
+			return nil, acceptCanceled(ctx)
+		}
+		if err != nil {
+			src.attempt++
+			slog.Warn("SRT pull failed",
+				"stream_id", src.task.Id,
+				"url", src.task.Pull.redactedURL(),
+				"attempt", src.attempt,
+				"error", err,
+			)
+			continue
+		}
+
+		// A dropped source is dialed again right away, the backoff only starts once that fails
+		src.attempt = 0
+		return newSrtPublisher(conn, src.addr, 0), nil
+	}
+}
+
+func (src *srtCallerSource) Close() {}
+
+// srtPublisher carries MPEG-TS over an accepted SRT connection
+type srtPublisher struct {
+	conn       srt.Conn
+	remoteAddr string
+
+	inputRole string // Primary or backup, empty for pulled sources
+
+	// Set when the connection's own idle timeout is not the stream's, as on the shared listener
+	idleTimeout time.Duration
+	idle        *time.Timer
+	idled       atomic.Bool
+
+	// Kept between samples, the interval figures are computed against the previous one
+	statsMu    sync.Mutex
+	statistics srt.Statistics
+}
+
+func newSrtPublisher(conn srt.Conn, remoteAddr string, idleTimeout time.Duration) *srtPublisher {
+	pub := &srtPublisher{conn: conn, remoteAddr: remoteAddr, idleTimeout: idleTimeout}
+	if idleTimeout > 0 {
+		pub.idle = time.AfterFunc(idleTimeout, func() {
+			pub.idled.Store(true)
+			conn.Close()
+		})
+	}
+	return pub
+}
+
+func (pub *srtPublisher) Read(p []byte) (int, error) {
+	n, err := pub.conn.Read(p)
+	if pub.idle == nil {
+		return n, err
+	}
+	if err != nil && pub.idled.Load() {
+		return n, fmt.Errorf("no data from the publisher for %s", pub.idleTimeout)
+	}
+	pub.idle.Reset(pub.idleTimeout)
+	return n, err
+}
+
+func (pub *srtPublisher) Close() error {
+	if pub.idle != nil {
+		pub.idle.Stop()
+	}
+	return pub.conn.Close()
+}
+
+func (pub *srtPublisher) srtStatistics() srt.Statistics {
+	pub.statsMu.Lock()
+	defer pub.statsMu.Unlock()
+
+	pub.conn.Stats(&pub.statistics)
+	return pub.statistics
+}
+
+func (pub *srtPublisher) role() string {
+	return pub.inputRole
+}
+
+func (pub *srtPublisher) RemoteAddr() string {
+	return pub.remoteAddr
+}
+
+func (pub *srtPublisher) InputArgs() []string {
+	return []string{"-f", "mpegts", "-i", "pipe:0"}
+}
+
+// PlaybackURL is the public URL the playlist will be available at once uploaded
+func PlaybackURL(id string, abr bool) string {
+	publicURL := os.Getenv("CLOUDFLARE_PUBLIC_URL")
+	if publicURL == "" {
+		return ""
+	}
+
+	if abr {
+		return fmt.Sprintf("%s/%s/%s_master.m3u8", publicURL, id, id)
+	}
+	return fmt.Sprintf("%s/%s/%s.m3u8", publicURL, id, id)
+}
+
+// DashPlaybackURL is the public URL of the DASH manifest, empty for profiles without one
+func DashPlaybackURL(id string, profile *transcode.Profile) string {
+	publicURL := os.Getenv("CLOUDFLARE_PUBLIC_URL")
+	if publicURL == "" || profile == nil || !profile.DASH() {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/%s.mpd", publicURL, id, id)
+}
+
+// IngestTask runs a stream: it accepts publishers from source, transcodes them and uploads the output
+func IngestTask(ctx context.Context, task *Task, source ingestSource) {
+
+	defer source.Close()
 
 	accountId := os.Getenv("R2_ACCOUNT_ID")
 	accessKey := os.Getenv("R2_ACCESS_KEY")
 	secretKey := os.Getenv("R2_SECRET_KEY")
 
 	if accountId == "" || accessKey == "" || secretKey == "" {
-		task.UpdateStatus(StreamStopped, fmt.Sprintf("Failed to initialise secrets : %s", err))
+		failTask(task, "Failed to initialise secrets : R2 credentials are not set")
 		return
 	}
 
 	uploader, err := upload.CreateCloudFlareUploader(ctx, accessKey, secretKey, accountId)
 	if err != nil {
-		task.UpdateStatus(StreamStopped, fmt.Sprintf("Failed to initialise Uploader : %s", err))
+		failTask(task, fmt.Sprintf("Failed to initialise Uploader : %s", err))
 		return
 	}
 
 	bucket_name := os.Getenv("BUCKET_NAME")
 	if bucket_name == "" {
-		task.UpdateStatus(StreamStopped,"Failed to initialise storage")
+		failTask(task, "Failed to initialise storage")
 		return
 	}
 
 	uploadDir := fmt.Sprintf("output/%s", task.Id)
 	err = os.MkdirAll(uploadDir, os.ModePerm)
 	if err != nil {
-		task.UpdateStatus(StreamStopped, fmt.Sprintf("Failed to create upload directory : %s", err))
+		failTask(task, fmt.Sprintf("Failed to create upload directory : %s", err))
 		return
 	}
-	
-	go uploader.WatchAndUpload(ctx, uploadDir, task.Id, bucket_name, task.Abr, func(url string) {
-		if task.StreamURL == "" {
-			task.StreamURL = url;
-			task.UpdateStatus(StreamActive, fmt.Sprintf("Live link generated : %s",url))
-		}
+
+	task.Transition(StreamReady, EventStreamReady, "The stream is ready", ReadyData{
+		IngestURL:   task.Ingest.URL,
+		KeyExpiry:   task.Ingest.KeyExpiry,
+		PlaybackURL: task.Ingest.PlaybackURL,
 	})
+	
+	task.mu.Lock()
+	task.hls = newHlsOutput(uploadDir, task.Profile)
+	task.mu.Unlock()
+	hlsCtx, stopHls := context.WithCancel(context.Background())
+	go task.hls.watch(hlsCtx)
+
+	// Uploads outlive ctx so the segments FFmpeg flushes on stop still make it to R2
+	uploadCtx, stopUploads := context.WithCancel(context.Background())
+	uploadsDone := make(chan struct{})
+	go func() {
+		defer close(uploadsDone)
+		uploader.WatchAndUpload(uploadCtx, uploadDir, task.Id, bucket_name, task.Abr, task.playlistUploaded, func(key string, err error) {
+			task.stats.uploadResult(key, err)
+		})
+	}()
 
 	var wg sync.WaitGroup
-	handleStream(ctx, listener, task, &wg)
-	wg.Wait()
+	reason, err := handleStream(ctx, source, task, &wg)
+	wg.Wait() // Let FFmpeg flush before reporting the stream as ended
 
-	close(task.UpdatesChan)
+	stopHls()
+	task.hls.finish()
+	stopUploads()
+	<-uploadsDone
+
+	if err != nil {
+		failTask(task, err.Error())
+		return
+	}
+	task.Transition(StreamEnded, EventStreamStopped, reason, StoppedData{Reason: reason})
 }
 
-func handleStream(ctx context.Context, listener srt.Listener, task *Task, wg *sync.WaitGroup) {
+// playlistUploaded takes the live link of the first playlist uploaded, the stream goes LIVE once a publisher is connected
+func (task *Task) playlistUploaded(url string) {
+	if task.setStreamURL(url) && task.GetStatus() == StreamConnecting {
+		task.Transition(StreamLive, EventStreamLive, "Live link generated", LiveData{PlaybackURL: url})
+	}
+}
+
+// handleStream accepts publishers until the task is stopped or no publisher shows up in time.
+// It returns why the stream ended, or an error if it failed.
+func handleStream(ctx context.Context, source ingestSource, task *Task, wg *sync.WaitGroup) (string, error) {
+
+	// Set while a live stream waits out the grace window for the publisher that dropped
+	var gone *PublisherData
 
 	for {
 
 		select {
 
 		case <-ctx.Done():
-			task.UpdateStatus(StreamStopped, fmt.Sprintf("Stream Stopped: %s", context.Cause(ctx)))
-			return
+			reason := fmt.Sprintf("%s", context.Cause(ctx))
+			task.Transition(StreamEnding, EventStreamEnding, reason, StoppedData{Reason: reason})
+			return reason, nil
 
 		default:
 
-			cancelCtx, cancel := context.WithTimeout(ctx, 120*time.Second) // Adding deadline to the ctx
+			timeout := task.connectTimeout()
+			stopSlate := func() {}
+			if gone != nil {
+				timeout = task.Reconnect.grace()
+				if task.Reconnect.slate() {
+					stopSlate = startSlate(ctx, task, wg)
+				}
+			}
 
-			req, err := WaitForConnection(cancelCtx, listener, task)
-			cancel() // Resourse Cleanup
+			cancelCtx, cancel := context.WithTimeout(ctx, timeout) // Adding deadline to the ctx
 
+			pub, err := source.accept(cancelCtx)
+			cancel() // Resourse Cleanup
+			stopSlate()
+
+			if ctx.Err() != nil {
+				// A publisher accepted just as the stream stopped is not fed to anything
+				if pub != nil {
+					pub.Close()
+				}
+				continue // Stopped while waiting, reported by the ctx.Done case
+			}
+			if errors.Is(err, context.DeadlineExceeded) && gone != nil {
+				// Viewers are told the stream is interrupted, it keeps waiting for the publisher
+				data := *gone
+				data.Reason = fmt.Sprintf("No publisher within the %s grace window", task.Reconnect.grace())
+				task.Transition(StreamReconnecting, EventGraceExpired, data.Reason, data)
+				gone = nil
+				continue
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				reason := "Timed out waiting for a publisher"
+				task.Transition(StreamEnding, EventStreamEnding, reason, StoppedData{Reason: reason})
+				return reason, nil
+			}
 			if err != nil {
-				task.UpdateStatus(StreamStopped, fmt.Sprintf("%s", err))
-				return
+				return "", err
 			}
+			gone = nil
 
-			conn, err := req.Accept()
-			if err != nil {
-				task.UpdateStatus(StreamActive, fmt.Sprintf("Accept failed : %s", err))
-				continue
+			data := PublisherData{RemoteAddr: pub.RemoteAddr(), Role: roleOf(pub)}
+
+			// A backup publisher can join while the stream runs, it takes over when the primary fails
+			stopInputs := func() {}
+			if supportsFailover(task) {
+				fp := newFailoverPublisher(task, pub)
+				inputsCtx, cancelInputs := context.WithCancel(ctx)
+				go fp.acceptInputs(inputsCtx, source)
+				pub, stopInputs = fp, cancelInputs
 			}
-			// task.UpdateStatus(StreamActive, "OBS connected!")
 
-			err = ProcessStream(ctx, conn, task, wg)
-			if err != nil {
-				task.UpdateStatus(StreamReady, fmt.Sprintf("Processing error: %s", err))
+			publisherConnected(task, data)
+
+			err = ProcessStream(ctx, pub, task, wg)
+			stopInputs()
+			task.setActiveInput("")
+
+			if err != nil && ctx.Err() == nil {
+				data = PublisherData{RemoteAddr: pub.RemoteAddr(), Role: roleOf(pub), Reason: fmt.Sprintf("Processing error: %s", err)}
+				gone = publisherDisconnected(task, data)
 				continue
 			}
 
@@ -124,60 +458,43 @@ func handleStream(ctx context.Context, listener srt.Listener, task *Task, wg *sy
 
 }
 
-func WaitForConnection(ctx context.Context, listener srt.Listener, task *Task) (srt.ConnRequest, error) {
-	type result struct {
-		req srt.ConnRequest
-		err error
+// publisherConnected resumes a stream that was live before, otherwise waits for the first playlist
+func publisherConnected(task *Task, data PublisherData) {
+	switch task.GetStatus() {
+	case StreamLive:
+		// Back within the grace window, viewers never saw the stream go away
+		task.Emit(EventPublisherConnected, data)
+		return
+	case StreamReconnecting:
+		task.Transition(StreamLive, EventPublisherConnected, "Publisher reconnected", data)
+		return
 	}
 
-	resultCh := make(chan result, 1)
-
-	go func() {
-		req, err := listener.Accept2()
-		resultCh <- result{req, err}
-	}()
-
-	/*
-		PROBLEM:
-			The Accept2() function is working always to catch the incoming stream.
-			Suppose if i use this in this routine, then I can't control this properly because Default would block the events.
-			ie ) Flow wont reach <- ctx.Done()
-		SOLN:
-			Make a seperate goroutine to check for Accept2()
-				IF error then send the error to the error buffered channel
-				IF Connection is found add it to reqChan
-	*/
-
-	select {
-
-	case <-ctx.Done():
-		cause := context.Cause(ctx)
-		if cause == context.DeadlineExceeded {
-			task.UpdateStatus(StreamStopped, "TIMEOUT")
-			return nil, ctx.Err()
-		}
-
-		return nil, fmt.Errorf("connection canceled or user stopped the stream")
+	task.Transition(StreamConnecting, EventPublisherConnected, "Publisher connected", data)
 
-	case res := <-resultCh:
-
-		if res.err != nil {
-			return nil, res.err
-		}
+	// The playlist was already published by an earlier publisher, no new live link will come
+	if url := task.streamURL(); url != "" {
+		task.Transition(StreamLive, EventStreamLive, "Publisher resumed", LiveData{PlaybackURL: url})
+	}
+}
 
-		streamkey := res.req.StreamId()
-		ok, _ := auth.DecodeStreamKey(task.Id, streamkey)
-		if !ok {
-			res.req.Reject(srt.REJ_BADSECRET)
-			time.Sleep(300 * time.Millisecond)
-			return WaitForConnection(ctx, listener, task)
+// publisherDisconnected moves the stream on after its publisher is gone.
+// A live stream with a grace window stays LIVE, it returns what the publisher left with.
+func publisherDisconnected(task *Task, data PublisherData) *PublisherData {
+	if task.GetStatus() == StreamLive {
+		if grace := task.Reconnect.grace(); grace > 0 {
+			data.GraceSeconds = int(grace.Seconds())
+			task.Emit(EventPublisherDisconnected, data)
+			return &data
 		}
-
-		return res.req, nil
+		task.Transition(StreamReconnecting, EventPublisherDisconnected, data.Reason, data)
+		return nil
 	}
+	task.Transition(StreamReady, EventPublisherDisconnected, data.Reason, data)
+	return nil
 }
 
-func ProcessStream(ctx context.Context, conn srt.Conn, task *Task, wg *sync.WaitGroup) error {
+func ProcessStream(ctx context.Context, pub publisher, task *Task, wg *sync.WaitGroup) error {
 
 	var cmd *exec.Cmd
 
@@ -186,107 +503,108 @@ func ProcessStream(ctx context.Context, conn srt.Conn, task *Task, wg *sync.Wait
 		return fmt.Errorf("failed to create output directory: %s", file)
 	}
 
-	if task.Abr {
-		cmd = exec.Command("ffmpeg",
-			"-f", "mpegts",
-			"-i", "pipe:0",
-	
-			// Encoder settings tuned for stability
-			"-c:v", "libx264",
-			"-preset", "veryfast",
-			"-tune", "zerolatency",
-			"-crf", "23",
-			"-g", "60",           // GOP size = 2s (for 30fps)
-			"-keyint_min", "60",
-			"-sc_threshold", "0", // consistent keyframes across variants
-			"-c:a", "aac",
-			"-ar", "48000",
-			"-b:a", "128k",
-	
-			// Define variants (ABR ladder)
-			"-map", "0:v:0", "-map", "0:a:0", "-b:v:0", "5000k", "-s:v:0", "1920x1080",
-			"-map", "0:v:0", "-map", "0:a:0", "-b:v:1", "3000k", "-s:v:1", "1280x720",
-			"-map", "0:v:0", "-map", "0:a:0", "-b:v:2", "1500k", "-s:v:2", "854x480",
-	
-			// HLS configuration (stable)
-			"-f", "hls",
-			"-hls_time", "4",                     // 4-second segments for stability
-			"-hls_list_size", "10",               // keep last 10 segments
-			"-hls_flags", "delete_segments+independent_segments+append_list",
-			"-hls_segment_type", "mpegts",
-			"-hls_allow_cache", "1",
-	
-			// Variant mapping and output
-			"-var_stream_map", "v:0,a:0 v:1,a:1 v:2,a:2",
-			"-master_pl_name", fmt.Sprintf("%s_master.m3u8", task.Id),
-			"-hls_segment_filename", fmt.Sprintf("output/%s/%s_%%v_%%03d.ts", task.Id,task.Id),
-			fmt.Sprintf("output/%s/%s_%%v.m3u8", task.Id,task.Id),
-		)
-	} else {
-		cmd = exec.Command("ffmpeg",
-			"-f", "mpegts",
-			"-i", "pipe:0",
-	
-			// Simpler single-stream settings
-			"-c:v", "libx264",
-			"-preset", "veryfast",
-			"-tune", "zerolatency",
-			"-crf", "23",
-			"-g", "60",
-			"-keyint_min", "60",
-			"-c:a", "aac",
-			"-b:a", "128k",
-	
-			"-f", "hls",
-			"-hls_time", "4",                   
-			"-hls_list_size", "10",
-			"-hls_flags", "delete_segments+independent_segments+append_list",
-			"-hls_segment_type", "mpegts",
-			"-hls_allow_cache", "1",
-	
-			"-hls_segment_filename", fmt.Sprintf("output/%s_%%03d.ts", task.Id),
-			fmt.Sprintf("output/%s.m3u8", task.Id),
-		)
+	// Every run writes playlists of its own, stitched into the public ones by task.hls
+	playlistDir, startNumber, err := task.hls.startRun()
+	if err != nil {
+		return fmt.Errorf("failed to create playlist directory: %s", err)
 	}
-	
+	defer task.hls.endRun()
+
+	// The renditions are fitted to what the publisher sends, media read to find out is handed to FFmpeg first
+	src, probed, err := probeSource(ctx, pub)
+	if err != nil {
+		return fmt.Errorf("%s read error: %v", task.Protocol, err)
+	}
+	task.stats.addBytes(len(probed))
+
+	layout := task.fitLayout(src)
+	task.stats.setLadder(src, layout.Ladder)
+	slog.Info("Transcoding source", "stream_id", task.Id, "profile", task.Profile.Name, "mode", task.Mode, "source", src, "renditions", len(layout.Ladder))
+
+	input := []string{"-progress", "pipe:1"} // key=value progress on stdout, parsed into task stats
+	input = append(input, pub.InputArgs()...)
+
+	// Encoding and packaging come from the stream's transcoding profile
+	cmd = exec.Command("ffmpeg", append(input, task.Profile.FFmpegArgs(transcode.HLSOutput{
+		Name:        task.Id,
+		SegmentDir:  fmt.Sprintf("output/%s", task.Id),
+		PlaylistDir: playlistDir,
+		StartNumber: startNumber,
+		ListSize:    hlsListSize,
+	}, layout, src, task.Mode)...)...)
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		return fmt.Errorf("FFmpeg stdin error: %s", err)
 	}
 	cmd.Stderr = os.Stderr
+	cmd.Stdout = &progressWriter{stats: &task.stats}
 
 	if err := cmd.Start(); err != nil {
 		stdin.Close()
 		return fmt.Errorf("FFmpeg start error: %s", err)
 	}
 
+	proc := watchFFmpeg(cmd, stdin)
+
+	// FFmpeg quitting on its own ends the publisher too, it is all there is for inputs FFmpeg reads itself
+	go func() {
+		<-proc.exited
+		pub.Close()
+	}()
+
 	wg.Add(1)
 	go func() {
 		<-ctx.Done()
 		defer wg.Done()
-		task.UpdateStatus(StreamStopped, "User stopped the stream!")
 		stdin.Close()
-		conn.Close()
+		pub.Close()
 		_ = cmd.Process.Signal(os.Interrupt)
-		cmd.Wait()
+		<-proc.exited
+	}()
+
+	samplerDone := make(chan struct{})
+	defer close(samplerDone)
+	go func() {
+		ticker := time.NewTicker(statsSampleInterval)
+		defer ticker.Stop()
+		defer task.stats.resetRates()
+
+		for {
+			select {
+			case <-samplerDone:
+				return
+			case <-ticker.C:
+				task.stats.sample()
+				if conn, ok := pub.(srtStatsSource); ok {
+					task.stats.sampleSrt(conn.srtStatistics(), pub.RemoteAddr())
+				}
+			}
+		}
 	}()
 
+	if _, err := stdin.Write(probed); err != nil {
+		if err := proc.finish(); err != nil {
+			return fmt.Errorf("FFmpeg exited with error: %v", err)
+		}
+		return fmt.Errorf("FFmpeg write error: %v", err)
+	}
+
 	buf := make([]byte, 8*1316)
 
 	for {
-		n, err := conn.Read(buf)
+		n, err := pub.Read(buf)
 		if err != nil {
-			stdin.Close()
-			if err := cmd.Wait(); err != nil {
+			if err := proc.finish(); err != nil {
 				return fmt.Errorf("FFmpeg exited with error: %v", err)
 			}
-			return fmt.Errorf("SRT read error: %v", err)
+			return fmt.Errorf("%s read error: %v", task.Protocol, err)
 		}
 
+		task.stats.addBytes(n)
+
 		if _, err := stdin.Write(buf[:n]); err != nil {
-			stdin.Close()
-			if err := cmd.Wait(); err != nil {
+			if err := proc.finish(); err != nil {
 				return fmt.Errorf("FFmpeg exited with error: %v", err)
 			}
 			return fmt.Errorf("FFmpeg write error: %v", err)
@@ -294,6 +612,41 @@ func ProcessStream(ctx context.Context, conn srt.Conn, task *Task, wg *sync.Wait
 	}
 }
 
+// ffmpegProcess is a started FFmpeg whose exit is waited for once
+type ffmpegProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.Closer
+	exited chan struct{}
+	err    error
+}
+
+func watchFFmpeg(cmd *exec.Cmd, stdin io.Closer) *ffmpegProcess {
+	proc := &ffmpegProcess{cmd: cmd, stdin: stdin, exited: make(chan struct{})}
+	go func() {
+		proc.err = cmd.Wait()
+		close(proc.exited)
+	}()
+	return proc
+}
+
+// finish closes FFmpeg's input and waits for it to flush, interrupting it if it does not exit on its own.
+// Inputs FFmpeg reads from the network itself (WHIP forwards RTP over UDP) never see EOF.
+func (proc *ffmpegProcess) finish() error {
+	proc.stdin.Close()
+
+	select {
+	case <-proc.exited:
+	case <-time.After(ffmpegDrainTimeout):
+		_ = proc.cmd.Process.Signal(os.Interrupt)
+		<-proc.exited
+	}
+	return proc.err
+}
+
+func failTask(task *Task, reason string) {
+	task.Transition(StreamFailed, EventStreamFailed, reason, FailedData{Error: reason})
+}
+
 func GetLocalIP() string {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
@@ -310,15 +663,6 @@ func GetLocalIP() string {
 	return "127.0.0.1"
 }
 
-func getFreePort() (int, error) {
-	l, err := net.Listen("tcp", ":0")
-	if err != nil {
-		return 0, err
-	}
-	defer l.Close()
-	return l.Addr().(*net.TCPAddr).Port, nil
-}
-
 /*
 	TEST SCENARIOS:
 		- Connection stopped with OBS
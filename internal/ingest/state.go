@@ -0,0 +1,59 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// StreamState is a state of the task lifecycle:
+//
+//	INITIALISED → READY → CONNECTING → LIVE ⇄ RECONNECTING
+//	any non terminal state → ENDING → ENDED
+//	any non terminal state → FAILED
+type StreamState string
+
+const (
+	StreamInit         StreamState = "INITIALISED"
+	StreamReady        StreamState = "READY"
+	StreamConnecting   StreamState = "CONNECTING"
+	StreamLive         StreamState = "LIVE"
+	StreamReconnecting StreamState = "RECONNECTING"
+	StreamEnding       StreamState = "ENDING"
+	StreamEnded        StreamState = "ENDED"
+	StreamFailed       StreamState = "FAILED"
+)
+
+var ErrIllegalTransition = errors.New("illegal state transition")
+
+var legalTransitions = map[StreamState][]StreamState{
+	StreamInit:         {StreamReady, StreamEnding, StreamFailed},
+	StreamReady:        {StreamConnecting, StreamEnding, StreamFailed},
+	StreamConnecting:   {StreamLive, StreamReady, StreamEnding, StreamFailed}, // back to READY if the publisher leaves before going live
+	StreamLive:         {StreamReconnecting, StreamEnding, StreamFailed},
+	StreamReconnecting: {StreamLive, StreamEnding, StreamFailed},
+	StreamEnding:       {StreamEnded, StreamFailed},
+}
+
+// IsTerminal reports whether the task can no longer change state
+func (s StreamState) IsTerminal() bool {
+	return s == StreamEnded || s == StreamFailed
+}
+
+// IsValid reports whether s is one of the states above
+func (s StreamState) IsValid() bool {
+	_, ok := legalTransitions[s]
+	return ok || s.IsTerminal()
+}
+
+// CanTransitionTo reports whether moving from s to next is allowed
+func (s StreamState) CanTransitionTo(next StreamState) bool {
+	return slices.Contains(legalTransitions[s], next)
+}
+
+func validateTransition(from StreamState, to StreamState) error {
+	if !from.CanTransitionTo(to) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, from, to)
+	}
+	return nil
+}
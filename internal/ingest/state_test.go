@@ -0,0 +1,87 @@
+package ingest
+
+import (
+	"errors"
+	"testing"
+)
+
+var allStates = []StreamState{
+	StreamInit, StreamReady, StreamConnecting, StreamLive, StreamReconnecting, StreamEnding, StreamEnded, StreamFailed,
+}
+
+// Every pair of states, the allowed transitions listed and every other one refused
+func TestTransitions(t *testing.T) {
+	allowed := map[StreamState][]StreamState{
+		StreamInit:         {StreamReady, StreamEnding, StreamFailed},
+		StreamReady:        {StreamConnecting, StreamEnding, StreamFailed},
+		StreamConnecting:   {StreamLive, StreamReady, StreamEnding, StreamFailed},
+		StreamLive:         {StreamReconnecting, StreamEnding, StreamFailed},
+		StreamReconnecting: {StreamLive, StreamEnding, StreamFailed},
+		StreamEnding:       {StreamEnded, StreamFailed},
+		StreamEnded:        nil,
+		StreamFailed:       nil,
+	}
+
+	for _, from := range allStates {
+		for _, to := range allStates {
+			want := false
+			for _, next := range allowed[from] {
+				want = want || next == to
+			}
+
+			t.Run(string(from)+"->"+string(to), func(t *testing.T) {
+				if got := from.CanTransitionTo(to); got != want {
+					t.Errorf("CanTransitionTo = %v, want %v", got, want)
+				}
+
+				err := validateTransition(from, to)
+				if want && err != nil {
+					t.Errorf("validateTransition = %v, want nil", err)
+				}
+				if !want && !errors.Is(err, ErrIllegalTransition) {
+					t.Errorf("validateTransition = %v, want %v", err, ErrIllegalTransition)
+				}
+			})
+		}
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	for _, state := range allStates {
+		want := state == StreamEnded || state == StreamFailed
+		if got := state.IsTerminal(); got != want {
+			t.Errorf("%s.IsTerminal() = %v, want %v", state, got, want)
+		}
+	}
+}
+
+// A refused transition leaves the task as it was and emits nothing
+func TestTaskTransitionRefused(t *testing.T) {
+	events := NewEventBus()
+	sub := events.Subscribe("test", 8, DropNewest, nil)
+	defer sub.Close()
+
+	task := &Task{Id: "refused", Status: StreamEnded, events: events}
+	if err := task.Transition(StreamLive, EventStreamLive, "late playlist", nil); !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("Transition = %v, want %v", err, ErrIllegalTransition)
+	}
+	if task.Status != StreamEnded || len(task.Transitions) != 0 {
+		t.Errorf("task = %s with %d transitions, want it untouched", task.Status, len(task.Transitions))
+	}
+	select {
+	case event := <-sub.Events():
+		t.Errorf("emitted %s for a refused transition", event.Type)
+	default:
+	}
+
+	task.Status = StreamEnding
+	if err := task.Transition(StreamEnded, EventStreamStopped, "stopped", nil); err != nil {
+		t.Fatal(err)
+	}
+	if event := <-sub.Events(); event.Type != EventStreamStopped || event.Status != StreamEnded {
+		t.Errorf("event = %s in %s, want %s in %s", event.Type, event.Status, EventStreamStopped, StreamEnded)
+	}
+	if n := len(task.Transitions); n != 1 || task.Transitions[0].From != StreamEnding || task.Transitions[0].To != StreamEnded {
+		t.Errorf("transitions = %+v", task.Transitions)
+	}
+}
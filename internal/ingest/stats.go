@@ -0,0 +1,193 @@
+package ingest
+
+import (
+	"bytes"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
+)
+
+// How often the input bitrate is sampled while a publisher is connected
+const statsSampleInterval = 2 * time.Second
+
+// StreamStats are the live figures of a running stream
+type StreamStats struct {
+	InputBitrateKbps float64               `json:"input_bitrate_kbps"`
+	BytesReceived    uint64                `json:"bytes_received"`
+	FFmpegFrames     uint64                `json:"ffmpeg_frames"`
+	FFmpegFPS        float64               `json:"ffmpeg_fps"`
+	FFmpegSpeed      float64               `json:"ffmpeg_speed"`
+	SegmentsUploaded uint64                `json:"segments_uploaded"`
+	UploadFailures   int                   `json:"upload_failures"`
+	Srt              *SrtStats             `json:"srt,omitempty"`        // Once an SRT publisher has connected
+	Source           *transcode.Source     `json:"source,omitempty"`     // Media of the latest publisher, as far as probing found out
+	Renditions       []transcode.Rendition `json:"renditions,omitempty"` // Ladder FFmpeg encodes for it
+	UpdatedAt        time.Time             `json:"updated_at"`
+}
+
+type statsCollector struct {
+	bytesReceived    atomic.Uint64
+	segmentsUploaded atomic.Uint64
+
+	mu          sync.Mutex
+	bitrateKbps float64
+	frames      uint64
+	fps         float64
+	speed       float64
+	lastBytes   uint64
+	lastSample  time.Time
+	updatedAt   time.Time
+
+	srt      *SrtStats
+	srtEnded srtCounters
+
+	source     *transcode.Source
+	renditions []transcode.Rendition
+
+	// Object keys whose latest upload failed, a later successful upload of the same key clears it
+	failedUploads map[string]struct{}
+}
+
+func (c *statsCollector) addBytes(n int) {
+	c.bytesReceived.Add(uint64(n))
+}
+
+// uploadResult records the outcome of uploading key to the bucket
+func (c *statsCollector) uploadResult(key string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		if c.failedUploads == nil {
+			c.failedUploads = make(map[string]struct{})
+		}
+		c.failedUploads[key] = struct{}{}
+		return
+	}
+
+	delete(c.failedUploads, key)
+	if strings.HasSuffix(key, ".ts") || strings.HasSuffix(key, ".m4s") && !hlsPartName.MatchString(key) {
+		c.segmentsUploaded.Add(1)
+	}
+}
+
+// uploadFailures is the number of files that are only available locally
+func (c *statsCollector) uploadFailures() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.failedUploads)
+}
+
+// setLadder records what the latest publisher sends and the renditions encoded from it
+func (c *statsCollector) setLadder(src *transcode.Source, renditions []transcode.Rendition) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.source = src
+	c.renditions = renditions
+}
+
+// sample recomputes the input bitrate from the bytes received since the last sample
+func (c *statsCollector) sample() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	bytes := c.bytesReceived.Load()
+
+	if !c.lastSample.IsZero() {
+		elapsed := now.Sub(c.lastSample).Seconds()
+		if elapsed > 0 {
+			c.bitrateKbps = float64(bytes-c.lastBytes) * 8 / 1000 / elapsed
+		}
+	}
+
+	c.lastBytes = bytes
+	c.lastSample = now
+	c.updatedAt = now
+}
+
+// resetRates clears the instantaneous figures once the publisher is gone
+func (c *statsCollector) resetRates() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.bitrateKbps = 0
+	c.fps = 0
+	c.speed = 0
+	c.lastSample = time.Time{}
+	c.updatedAt = time.Now()
+	c.endSrt()
+}
+
+// progressWriter parses FFmpeg's `-progress` key=value output
+type progressWriter struct {
+	stats *statsCollector
+	buf   []byte
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.stats.parseProgressLine(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (c *statsCollector) parseProgressLine(line string) {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return
+	}
+	value = strings.TrimSpace(value)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch key {
+	case "frame":
+		if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+			c.frames = n
+		}
+	case "fps":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			c.fps = f
+		}
+	case "speed":
+		if f, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64); err == nil {
+			c.speed = f
+		}
+	case "progress":
+		c.updatedAt = time.Now()
+	}
+}
+
+func (c *statsCollector) snapshot() StreamStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return StreamStats{
+		InputBitrateKbps: c.bitrateKbps,
+		BytesReceived:    c.bytesReceived.Load(),
+		FFmpegFrames:     c.frames,
+		FFmpegFPS:        c.fps,
+		FFmpegSpeed:      c.speed,
+		SegmentsUploaded: c.segmentsUploaded.Load(),
+		UploadFailures:   len(c.failedUploads),
+		Srt:              c.srt.clone(),
+		Source:           c.source,
+		Renditions:       slices.Clone(c.renditions),
+		UpdatedAt:        c.updatedAt,
+	}
+}
@@ -1,62 +1,376 @@
 package ingest
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"log/slog"
-	"net/http"
+	"regexp"
+	"slices"
+	"sort"
 	"sync"
 	"time"
-)
 
-type UpdateResponse struct {
-	Status 		string
-	Update	 	string
-	StreamLink	string
-}
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
+	"github.com/vijayvenkatj/LiveTran/internal/webhook"
+)
 
 type Task struct {
 	mu 		    sync.Mutex
 	Id 			string
-	Status		string
+	Status		StreamState
 	Webhooks 	[]string
-	Abr			bool
+	Abr			bool        // The output has a master playlist, decided by the profile
+	Profile		*transcode.Profile // Copy of the transcoding profile the stream started with
+	Mode		transcode.Mode // Whether the publisher's video is passed through
+	Protocol	Protocol
+	Pull		*PullSource // Set when the stream is pulled from a remote source
+	Encryption	*SrtEncryption
+	Srt			*SrtOptions // Resolved over the server defaults, SRT only
+	Reconnect	*ReconnectOptions // Resolved over the server defaults
+	activeInput	string      // Role of the publisher feeding the stream
+	slateImage	string      // Shown by the slate, black frames when empty
+	hls			*hlsOutput  // Public playlists, stitched from every FFmpeg run
+	layout		*transcode.Layout // Variants fitted to the first publisher, every later run writes them
+	IdempotencyKey string
 	CancelFn	context.CancelCauseFunc
-	UpdatesChan	chan UpdateResponse
+	done		chan struct{} // Closed when the run stops, nil for tasks that never ran
+	deleting	bool        // Set by DeleteTask while it waits for the run to stop
 	StreamURL   string
+	Ingest		IngestInfo
 	StartTime	time.Time
+	EndTime		time.Time
+	Transitions []StatusTransition
+	store		TaskStore
+	events		*EventBus
+	stats		statsCollector
+
+	// Files of earlier runs (before a restart or restore) that never made it to the bucket
+	priorUploadFailures int
 }
 
-const (
-	StreamInit = "INITIALISED"
-	StreamReady = "READY"
-	StreamStopped = "STOPPED"
-	StreamActive = "STREAMING"
-)
+// TaskInfo is a point in time view of a task as returned by the API
+type TaskInfo struct {
+	Id            string             `json:"id"`
+	Status        StreamState        `json:"status"`
+	Protocol      Protocol           `json:"protocol"`
+	Pull          bool               `json:"pull,omitempty"`
+	Encrypted     bool               `json:"encrypted,omitempty"`
+	Srt           *SrtOptions        `json:"srt,omitempty"`
+	Reconnect     *ReconnectOptions  `json:"reconnect,omitempty"`
+	ActiveInput   string             `json:"active_input,omitempty"`
+	Abr           bool               `json:"abr"`
+	Profile       string             `json:"profile,omitempty"`
+	Mode          transcode.Mode     `json:"mode"`
+	IngestURL     string             `json:"ingest_url,omitempty"`
+	SrtURL        string             `json:"srt_url,omitempty"` // Deprecated: the ingest URL under its old name, whatever the protocol
+	PlaybackURL   string             `json:"playback_url,omitempty"`
+	DashURL       string             `json:"dash_url,omitempty"`
+	StartTime     time.Time          `json:"start_time"`
+	EndTime       *time.Time         `json:"end_time,omitempty"`
+	UptimeSeconds int64              `json:"uptime_seconds"`
+	Webhooks      []string           `json:"webhooks,omitempty"`
+	Transitions   []StatusTransition `json:"transitions,omitempty"`
+	Archived      bool               `json:"archived,omitempty"`
+}
+
+var ErrTaskExists = errors.New("task already exists")
+
+// Events buffered for the webhook dispatcher, a burst beyond this is dead-lettered rather than stalling ingest
+const webhookBufferSize = 1024
+
+var ErrInvalidStreamId = errors.New("invalid stream_id")
+
+var errServerShutdown = errors.New("server shutting down")
+
+// Stream ids name the output directory and the bucket prefix of the stream, and are carried in stream keys
+var streamIdPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
 
 type TaskManager struct {
 	mu		sync.Mutex
 	TaskMap	map[string]*Task
+	store	TaskStore
+	writer	*recordWriter // Writes what tasks save, off their lock
+	webhooks *webhook.Dispatcher
+	webhookSub *Subscription
+	webhooksDone chan struct{}
+	spillMu	sync.Mutex
+	spilled	[]Event // Overflowed the webhook buffer, waiting to be dead-lettered
+	spillWake chan struct{}
+	events	*EventBus
+	retention retentionCounters
+	reconnect ReconnectConfig
+	profiles *transcode.Registry
+	srt		*SRTServer
+	rtmp	*RTMPServer
+	whip	*WHIPServer
+}
+
+func NewTaskManager(store TaskStore, webhooks *webhook.Dispatcher) *TaskManager {
+	tm := &TaskManager{
+		TaskMap:      make(map[string]*Task),
+		store:        store,
+		webhooks:     webhooks,
+		webhooksDone: make(chan struct{}),
+		spillWake:    make(chan struct{}, 1),
+		events:       NewEventBus(),
+		profiles:     transcode.BuiltinRegistry(),
+	}
+	if store != nil {
+		tm.writer = newRecordWriter(store)
+		tm.store = tm.writer
+	}
+
+	go tm.logEvents(tm.events.Subscribe("logs", 256, DropOldest, nil))
+	// Queued off the publisher's goroutine, the dispatcher dead-letters what its own queues cannot take
+	// and what overflows the buffer
+	tm.webhookSub = tm.events.SubscribeSpill("webhooks", webhookBufferSize, func(event Event) bool {
+		return len(event.webhooks) > 0
+	}, tm.spillWebhooks)
+	go tm.dispatchWebhooks(tm.webhookSub)
+
+	return tm
+}
+
+// Shutdown stops every running task and waits until FFmpeg has flushed and the uploads are done, or ctx is done.
+// The records of the stopped tasks are left as they were, so Restore picks the streams up on the next start.
+func (tm *TaskManager) Shutdown(ctx context.Context) {
+	tm.mu.Lock()
+	tasks := make([]*Task, 0, len(tm.TaskMap))
+	for _, task := range tm.TaskMap {
+		tasks = append(tasks, task)
+	}
+	tm.mu.Unlock()
+
+	for _, task := range tasks {
+		task.mu.Lock()
+		running := task.done != nil && !task.Status.IsTerminal()
+		if running {
+			task.store = nil
+		}
+		task.mu.Unlock()
+
+		if running {
+			task.CancelFn(errServerShutdown)
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for _, task := range tasks {
+			task.wait()
+		}
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		slog.Error("Streams still running at shutdown", "error", ctx.Err())
+	}
+}
+
+// Close hands the events still buffered to the webhook dispatcher and writes the pending task records.
+// Call it after Shutdown and before the dispatcher and the store are closed.
+func (tm *TaskManager) Close() {
+	tm.webhookSub.Close()
+	<-tm.webhooksDone
+
+	if tm.writer != nil {
+		tm.writer.stop()
+	}
+}
+
+// Events exposes the bus so other subsystems (metrics, SSE) can subscribe
+func (tm *TaskManager) Events() *EventBus {
+	return tm.events
 }
 
-func NewTaskManager() *TaskManager {
-	return &TaskManager{
-		TaskMap: make(map[string]*Task),
+// Transition moves the task to the next state, records reason in its history and emits
+// a typed event for it. Illegal transitions are rejected and nothing is emitted.
+// Publishing never blocks, so this is safe to call from the media path at any time.
+func (task *Task) Transition(to StreamState, eventType EventType, reason string, data any) error {
+	task.mu.Lock()
+	defer task.mu.Unlock()
+
+	from := task.Status
+	if err := validateTransition(from, to); err != nil {
+		slog.Warn("Rejected state transition", "stream_id", task.Id, "from", from, "to", to, "reason", reason)
+		return err
+	}
+
+	task.Status = to
+	if to.IsTerminal() {
+		task.EndTime = time.Now()
 	}
+	task.recordTransition(from, to, eventType, reason)
+	task.persist()
+	task.publish(eventType, data)
+
+	return nil
 }
 
-func (task *Task) UpdateStatus(status string, update string) {
+// Emit publishes an event that does not change the state of the task
+func (task *Task) Emit(eventType EventType, data any) {
 	task.mu.Lock()
 	defer task.mu.Unlock()
 
-	task.Status = status
+	task.publish(eventType, data)
+}
 
-	task.UpdatesChan <- UpdateResponse{
-		Status: status,
-		Update: update,
-		StreamLink: task.StreamURL,
+// Must be called with task.mu held
+func (task *Task) publish(eventType EventType, data any) {
+	if task.events != nil {
+		task.events.Publish(newEvent(task, eventType, task.Status, data))
+	}
+}
+
+// Record returns a snapshot of the task suitable for persisting
+func (task *Task) Record() TaskRecord {
+	task.mu.Lock()
+	defer task.mu.Unlock()
+
+	return task.record()
+}
+
+func (task *Task) record() TaskRecord {
+	return TaskRecord{
+		Id:             task.Id,
+		Status:         task.Status,
+		Webhooks:       task.Webhooks,
+		Abr:            task.Abr,
+		Profile:        task.Profile,
+		Mode:           task.Mode,
+		Protocol:       task.Protocol,
+		Pull:           task.Pull,
+		Encryption:     task.Encryption,
+		Srt:            task.Srt,
+		Reconnect:      task.Reconnect,
+		IdempotencyKey: task.IdempotencyKey,
+		StreamURL:      task.StreamURL,
+		IngestURL:      task.Ingest.URL,
+		StartTime:      task.StartTime,
+		EndTime:        task.EndTime,
+		UploadFailures: task.uploadFailures(),
+		UpdatedAt:      time.Now(),
+		Transitions:    append([]StatusTransition(nil), task.Transitions...),
+	}
+}
+
+// Info returns the API view of the task
+func (task *Task) Info() TaskInfo {
+	task.mu.Lock()
+	defer task.mu.Unlock()
+
+	info := TaskInfo{
+		Id:          task.Id,
+		Status:      task.Status,
+		Protocol:    task.Protocol,
+		Pull:        task.Pull != nil,
+		Encrypted:   task.Encryption != nil,
+		Srt:         task.Srt,
+		Reconnect:   task.Reconnect,
+		ActiveInput: task.activeInput,
+		Abr:         task.Abr,
+		Profile:     task.profileName(),
+		Mode:        task.Mode,
+		IngestURL:   task.Ingest.URL,
+		SrtURL:      task.Ingest.URL,
+		PlaybackURL: task.StreamURL,
+		DashURL:     task.Ingest.DashURL,
+		StartTime:   task.StartTime,
+		Webhooks:    task.Webhooks,
+		Transitions: append([]StatusTransition(nil), task.Transitions...),
+	}
+
+	end := time.Now()
+	if !task.EndTime.IsZero() {
+		endTime := task.EndTime
+		info.EndTime = &endTime
+		end = endTime
+	}
+	info.UptimeSeconds = int64(end.Sub(task.StartTime).Seconds())
+
+	return info
+}
+
+// setStreamURL records the playback URL, returns false if it was already known
+func (task *Task) setStreamURL(url string) bool {
+	task.mu.Lock()
+	defer task.mu.Unlock()
+
+	if task.StreamURL != "" {
+		return false
+	}
+	task.StreamURL = url
+	return true
+}
+
+// fitLayout fits the profile to the first publisher of the task. Reconnects and the slate get the same
+// variants, the master playlist and the playback URLs stay valid whatever they send.
+func (task *Task) fitLayout(src *transcode.Source) transcode.Layout {
+	task.mu.Lock()
+	defer task.mu.Unlock()
+
+	if task.layout == nil {
+		layout := task.Profile.Layout(src, task.Mode)
+		task.layout = &layout
+	}
+	return *task.layout
+}
+
+// Must be called with task.mu held
+func (task *Task) uploadFailures() int {
+	return task.priorUploadFailures + task.stats.uploadFailures()
+}
+
+// Stats returns the live figures of the stream
+func (task *Task) Stats() StreamStats {
+	return task.stats.snapshot()
+}
+
+// StatsEvent wraps the current stats in the event envelope for live status streams
+func (task *Task) StatsEvent() Event {
+	return newEvent(task, EventStreamStats, task.GetStatus(), task.Stats())
+}
+
+func (task *Task) streamURL() string {
+	task.mu.Lock()
+	defer task.mu.Unlock()
+
+	return task.StreamURL
+}
+
+// GetStatus returns the current state of the task
+func (task *Task) GetStatus() StreamState {
+	task.mu.Lock()
+	defer task.mu.Unlock()
+
+	return task.Status
+}
+
+// Must be called with task.mu held
+func (task *Task) recordTransition(from StreamState, to StreamState, eventType EventType, reason string) {
+	task.Transitions = append(task.Transitions, StatusTransition{
+		From:   from,
+		To:     to,
+		Event:  eventType,
+		Reason: reason,
+		At:     time.Now(),
+	})
+	if len(task.Transitions) > maxTransitions {
+		task.Transitions = task.Transitions[len(task.Transitions)-maxTransitions:]
+	}
+}
+
+// Must be called with task.mu held
+func (task *Task) persist() {
+	if task.store == nil {
+		return
+	}
+	if err := task.store.Save(task.record()); err != nil {
+		slog.Error("Failed to persist task", "stream_id", task.Id, "error", err)
 	}
 }
 
@@ -65,10 +379,10 @@ func (tm *TaskManager) GetAllStreams() (active,idle,stopped int64) {
 	defer tm.mu.Unlock()
 
 	for _, stream := range tm.TaskMap {
-		switch stream.Status {
-			case StreamActive:
+		switch stream.GetStatus() {
+			case StreamConnecting, StreamLive, StreamReconnecting:
 				active++
-			case StreamStopped:
+			case StreamEnded, StreamFailed:
 				stopped++;
 			default:
 				idle++;
@@ -79,61 +393,440 @@ func (tm *TaskManager) GetAllStreams() (active,idle,stopped int64) {
 }
 
 
-// Starting a Task 
-func (tm *TaskManager) StartTask(id string,webhooks []string, abr bool) {
+// GetTask looks up a task by its stream id
+func (tm *TaskManager) GetTask(id string) (*Task, bool) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	task, exists := tm.TaskMap[id]
+	return task, exists
+}
+
+// GetArchived looks up a task that retention has already evicted from memory
+func (tm *TaskManager) GetArchived(id string) (TaskInfo, error) {
+	if tm.store == nil {
+		return TaskInfo{}, ErrTaskNotFound
+	}
+	record, err := tm.store.GetArchived(id)
+	if err != nil {
+		return TaskInfo{}, err
+	}
+
+	info := taskFromRecord(record).Info()
+	info.Archived = true
+	return info, nil
+}
+
+// ListTasks returns all known tasks, oldest first
+func (tm *TaskManager) ListTasks() []*Task {
+	tm.mu.Lock()
+	tasks := make([]*Task, 0, len(tm.TaskMap))
+	for _, task := range tm.TaskMap {
+		tasks = append(tasks, task)
+	}
+	tm.mu.Unlock()
+
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].StartTime.Equal(tasks[j].StartTime) {
+			return tasks[i].Id < tasks[j].Id
+		}
+		return tasks[i].StartTime.Before(tasks[j].StartTime)
+	})
+
+	return tasks
+}
+
+
+// StartOptions are the parameters a stream is started with
+type StartOptions struct {
+	Protocol       Protocol
+	Pull           *PullSource
+	Encryption     *SrtEncryption
+	Srt            *SrtOptions
+	Reconnect      *ReconnectOptions
+	Webhooks       []string
+	Abr            bool
+	Profile        string // Name of the transcoding profile, the server default when empty
+	Mode           transcode.Mode // Transcode when empty
+	IdempotencyKey string
+}
+
+// ConflictError is returned when a start request does not match the task already registered under the id
+type ConflictError struct {
+	Id     string
+	Status StreamState
+	Fields []string
+	Reason string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("stream %s (%s): %s", e.Id, e.Status, e.Reason)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrTaskExists
+}
+
+// Starting a Task, returns where the publisher should push the stream.
+// Starting an id that is already running with the same parameters returns its current ingest details,
+// starting an id that has ended or failed restarts it, anything else is a *ConflictError.
+func (tm *TaskManager) StartTask(id string, opts StartOptions) (IngestInfo, error) {
+	if !streamIdPattern.MatchString(id) {
+		return IngestInfo{}, fmt.Errorf("%w: use up to 64 letters, digits, _ and -, starting with a letter or digit", ErrInvalidStreamId)
+	}
+	if opts.Mode == "" {
+		opts.Mode = transcode.ModeTranscode
+	}
+
 	tm.mu.Lock()
-	if _, exists := tm.TaskMap[id]; exists {
+	var previous *Task
+	if existing, exists := tm.TaskMap[id]; exists {
+		info, restart, err := existing.resolveStart(opts)
+		if !restart {
+			tm.mu.Unlock()
+			return info, err
+		}
+		previous = existing
+	}
+
+	profile, err := tm.selectProfile(opts.Profile, opts.Abr)
+	if err != nil {
 		tm.mu.Unlock()
-		slog.Error("Job Exists!");
-		return 
+		return IngestInfo{}, err
 	}
 
 	cancelCtx, cancelFunc := context.WithCancelCause(context.Background())
 	task := &Task{
-		Id:          id,
-		CancelFn:    cancelFunc,
-		Status:      StreamInit,
-		Webhooks: 	 webhooks,
-		Abr:		 abr || false,
-		UpdatesChan: make(chan UpdateResponse, 4),
-		StreamURL:   "",
-		StartTime:   time.Now(),
+		Id:             id,
+		CancelFn:       cancelFunc,
+		done:           make(chan struct{}),
+		Status:         StreamInit,
+		Webhooks:       opts.Webhooks,
+		Abr:            opts.Abr,
+		Profile:        profile,
+		Mode:           opts.Mode,
+		Protocol:       opts.Protocol,
+		Pull:           opts.Pull,
+		Encryption:     opts.Encryption.clone(),
+		Srt:            opts.Srt.clone(),
+		Reconnect:      opts.Reconnect.clone(),
+		IdempotencyKey: opts.IdempotencyKey,
+		StreamURL:      "",
+		StartTime:      time.Now(),
+		store:          tm.store,
+		events:         tm.events,
 	}
+
+	source, info, err := tm.prepareIngest(task)
+	if err != nil {
+		tm.mu.Unlock()
+		cancelFunc(err)
+		return IngestInfo{}, err
+	}
+	task.Ingest = info
+
 	tm.TaskMap[id] = task
 	tm.mu.Unlock()
 
-	// Listen for updates
-	go func(updates <-chan UpdateResponse) {
-		for update := range updates {
+	task.mu.Lock()
+	if previous != nil {
+		previous.mu.Lock()
+		from := previous.Status
+		task.Transitions = append([]StatusTransition(nil), previous.Transitions...)
+		task.priorUploadFailures = previous.uploadFailures()
+		previous.mu.Unlock()
 
-			slog.Info(update.Update)
+		task.recordTransition(from, StreamInit, "", "Stream restarted")
+		slog.Info("Restarting stream", "stream_id", id, "previous_status", from)
+	} else {
+		task.recordTransition("", StreamInit, "", "Task created")
+	}
+	task.persist()
+	task.mu.Unlock()
 
-			jsonData, err := json.Marshal(update)
-			if err != nil {
-				slog.Error("Failed to send webhook", "error", err);
-				continue
-			}
+	tm.launch(cancelCtx, cancelFunc, task, source)
 
-			for _,webhook := range task.Webhooks {
-				resp,err := http.Post(webhook,"application/json",bytes.NewBuffer(jsonData))
-				if err != nil {
-					slog.Error("Failed to send webhook", "error", err);
-					continue
-				}
-				_, _ = io.Copy(io.Discard, resp.Body)
-				resp.Body.Close()
-			}
-			
+	return info.withPassphrase(task.Encryption), nil
+}
+
+// resolveStart decides what a start request for an id that is already registered does.
+// It either answers with the ingest details of the running task, asks for a restart or refuses.
+func (task *Task) resolveStart(opts StartOptions) (IngestInfo, bool, error) {
+	task.mu.Lock()
+	defer task.mu.Unlock()
+
+	conflict := func(reason string, fields []string) error {
+		return &ConflictError{Id: task.Id, Status: task.Status, Fields: fields, Reason: reason}
+	}
+	if task.deleting {
+		return IngestInfo{}, false, conflict("stream is being deleted, retry once it is gone", nil)
+	}
+	fields := task.conflictingFields(opts)
+
+	// A retry of the request that started the current run, whatever happened to the stream since
+	if opts.IdempotencyKey != "" && opts.IdempotencyKey == task.IdempotencyKey {
+		if len(fields) > 0 {
+			return IngestInfo{}, false, conflict("Idempotency-Key was already used with different parameters", fields)
+		}
+		// The same request gets the same answer, a generated passphrase included
+		return task.Ingest.withPassphrase(task.Encryption), false, nil
+	}
+
+	switch {
+	case task.Status.IsTerminal():
+		return IngestInfo{}, true, nil
+	case task.Status == StreamEnding:
+		return IngestInfo{}, false, conflict("stream is stopping, retry once it has ended", nil)
+	case len(fields) > 0:
+		return IngestInfo{}, false, conflict("stream is running with different parameters", fields)
+	}
+
+	return task.Ingest, false, nil
+}
+
+// Must be called with task.mu held
+func (task *Task) conflictingFields(opts StartOptions) []string {
+	var fields []string
+	if task.Protocol != opts.Protocol {
+		fields = append(fields, "protocol")
+	}
+	if !samePullSource(task.Pull, opts.Pull) {
+		fields = append(fields, "pull")
+	}
+	if !task.Encryption.matches(opts.Encryption) {
+		fields = append(fields, "encryption")
+	}
+	if !task.Srt.matches(opts.Srt) {
+		fields = append(fields, "srt")
+	}
+	if !task.Reconnect.matches(opts.Reconnect) {
+		fields = append(fields, "reconnect")
+	}
+	if opts.Abr && !task.Abr {
+		fields = append(fields, "abr")
+	}
+	if opts.Profile != "" && opts.Profile != task.profileName() {
+		fields = append(fields, "profile")
+	}
+	if task.Mode != opts.Mode {
+		fields = append(fields, "mode")
+	}
+	if !sameURLs(task.Webhooks, opts.Webhooks) {
+		fields = append(fields, "webhook_urls")
+	}
+	return fields
+}
+
+func sameURLs(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+// Restore rehydrates the tasks found in the store after a restart.
+// Ended and failed tasks stay queryable, every other task gets its ingest endpoint back.
+func (tm *TaskManager) Restore() error {
+	records, err := tm.store.List()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		// Written before stream ids were validated, the id cannot be trusted as a path
+		if !streamIdPattern.MatchString(record.Id) {
+			slog.Error("Not restoring task with an invalid stream_id", "stream_id", record.Id)
+			continue
 		}
-	}(task.UpdatesChan)
 
-	
+		task := taskFromRecord(record)
+		task.store = tm.store
+		task.events = tm.events
+
+		if record.Status.IsTerminal() {
+			task.CancelFn = func(error) {}
+
+			tm.mu.Lock()
+			tm.TaskMap[task.Id] = task
+			tm.mu.Unlock()
+			continue
+		}
+
+		source, info, err := tm.prepareIngest(task)
+		if err != nil {
+			task.CancelFn = func(error) {}
+			task.Status = StreamFailed
+			task.EndTime = time.Now()
+
+			task.mu.Lock()
+			task.recordTransition(record.Status, StreamFailed, EventStreamFailed, fmt.Sprintf("Failed to restore stream: %s", err))
+			task.persist()
+			task.mu.Unlock()
+
+			tm.mu.Lock()
+			tm.TaskMap[task.Id] = task
+			tm.mu.Unlock()
+
+			slog.Error("Failed to restore stream", "stream_id", task.Id, "error", err)
+			continue
+		}
+
+		cancelCtx, cancelFunc := context.WithCancelCause(context.Background())
+		task.CancelFn = cancelFunc
+		task.done = make(chan struct{})
+		task.Status = StreamInit
+		task.StreamURL = "" // Regenerated once the first playlist is uploaded again
+		task.Ingest = info
+
+		task.mu.Lock()
+		task.recordTransition(record.Status, StreamInit, "", "Task restored after restart")
+		task.persist()
+		task.mu.Unlock()
+
+		tm.mu.Lock()
+		tm.TaskMap[task.Id] = task
+		tm.mu.Unlock()
+
+		slog.Info("Restoring stream", "stream_id", task.Id, "previous_status", record.Status)
+		tm.launch(cancelCtx, cancelFunc, task, source)
+	}
+
+	return nil
+}
+
+// taskFromRecord rebuilds a task from its persisted form, without a running listener
+func taskFromRecord(record TaskRecord) *Task {
+	// Records written before RTMP ingest existed were all SRT
+	if record.Protocol == "" {
+		record.Protocol = ProtocolSRT
+	}
+	// and before passthrough existed were all transcoded
+	if record.Mode == "" {
+		record.Mode = transcode.ModeTranscode
+	}
+
+	return &Task{
+		Id:                  record.Id,
+		Status:              record.Status,
+		Webhooks:            record.Webhooks,
+		Abr:                 record.Abr,
+		Profile:             record.Profile,
+		Mode:                record.Mode,
+		Protocol:            record.Protocol,
+		Pull:                record.Pull,
+		Encryption:          record.Encryption,
+		Srt:                 record.Srt,
+		Reconnect:           record.Reconnect,
+		StreamURL:           record.StreamURL,
+		IdempotencyKey:      record.IdempotencyKey,
+		Ingest:              IngestInfo{StreamId: record.Id, Protocol: record.Protocol, URL: redactPassphrase(record.IngestURL), Pull: record.Pull != nil},
+		StartTime:           record.StartTime,
+		EndTime:             record.EndTime,
+		Transitions:         record.Transitions,
+		priorUploadFailures: record.UploadFailures,
+	}
+}
+
+// launch runs the task until it ends. The run only ever cancels its own context,
+// so a restart that reuses the id is not torn down by the previous run finishing.
+func (tm *TaskManager) launch(ctx context.Context, cancel context.CancelCauseFunc, task *Task, source ingestSource) {
+
 	go func() {
-		SrtConnectionTask(cancelCtx, task)
-		tm.StopTask(id, context.Canceled)
+		defer close(task.done)
+		IngestTask(ctx, task, source)
+		cancel(context.Canceled)
 	}()
 }
 
+// wait blocks until the run of the task has stopped, FFmpeg and uploads included
+func (task *Task) wait() {
+	if task.done != nil {
+		<-task.done
+	}
+}
+
+
+func (tm *TaskManager) logEvents(sub *Subscription) {
+	for event := range sub.Events() {
+		slog.Info("stream event",
+			"stream_id", event.StreamId,
+			"type", event.Type,
+			"status", event.Status,
+			"event_id", event.EventId,
+		)
+	}
+}
+
+// dispatchWebhooks queues every event for the webhooks of its stream until the subscription is closed
+func (tm *TaskManager) dispatchWebhooks(sub *Subscription) {
+	defer close(tm.webhooksDone)
+
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				tm.deadLetterSpilled()
+				return
+			}
+			tm.enqueueWebhooks(event)
+		case <-tm.spillWake:
+			tm.deadLetterSpilled()
+		}
+	}
+}
+
+// spillWebhooks keeps an event the webhook buffer could not take, for dispatchWebhooks to dead-letter
+func (tm *TaskManager) spillWebhooks(event Event) {
+	tm.spillMu.Lock()
+	tm.spilled = append(tm.spilled, event)
+	tm.spillMu.Unlock()
+
+	select {
+	case tm.spillWake <- struct{}{}:
+	default:
+	}
+}
+
+// deadLetterSpilled moves the spilled events to the dead-letter store, to be replayed once the burst is over
+func (tm *TaskManager) deadLetterSpilled() {
+	tm.spillMu.Lock()
+	spilled := tm.spilled
+	tm.spilled = nil
+	tm.spillMu.Unlock()
+
+	for _, event := range spilled {
+		tm.eachWebhook(event, func(url string, msg webhook.Message) {
+			tm.webhooks.DeadLetter(url, msg, "event buffer full")
+		})
+	}
+}
+
+// enqueueWebhooks queues the event for every webhook of its stream
+func (tm *TaskManager) enqueueWebhooks(event Event) {
+	tm.eachWebhook(event, tm.webhooks.Enqueue)
+}
+
+// eachWebhook hands the message of event to fn once for every webhook of its stream
+func (tm *TaskManager) eachWebhook(event Event, fn func(url string, msg webhook.Message)) {
+	if len(event.webhooks) == 0 || tm.webhooks == nil {
+		return
+	}
+
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to send webhook", "error", err);
+		return
+	}
+
+	for _, url := range event.webhooks {
+		fn(url, webhook.Message{
+			EventId:  event.EventId,
+			StreamId: event.StreamId,
+			Payload:  jsonData,
+		})
+	}
+}
+
 
 // Stopping a task
 func (tm *TaskManager) StopTask(id string,reason error) {
@@ -149,3 +842,58 @@ func (tm *TaskManager) StopTask(id string,reason error) {
 
 }
 
+// DeleteTask stops the task, waits for its run to end and purges it from memory, the store and the local output.
+// Starting the same id is refused until it is gone, so a new run never shares the directory with the old one.
+// A task retention already evicted only has its archived record left to purge.
+func (tm *TaskManager) DeleteTask(id string) error {
+	tm.mu.Lock()
+	task, exists := tm.TaskMap[id]
+	tm.mu.Unlock()
+	if !exists {
+		if tm.store == nil {
+			return ErrTaskNotFound
+		}
+		if err := tm.store.DeleteArchived(id); err != nil {
+			return err
+		}
+		slog.Info("Archived stream deleted", "stream_id", id)
+		return nil
+	}
+
+	// Detach first so the final ENDED update does not re-create the record
+	task.mu.Lock()
+	task.deleting = true
+	task.store = nil
+	task.mu.Unlock()
+
+	task.CancelFn(errors.New("stream deleted"))
+	task.wait()
+
+	tm.mu.Lock()
+	// Deleted by a concurrent request while this one waited
+	if tm.TaskMap[id] != task {
+		tm.mu.Unlock()
+		return nil
+	}
+	delete(tm.TaskMap, id)
+
+	// Nothing refers to the output anymore, even files that were never uploaded
+	detached, err := detachOutput(defaultOutputDir, id, reclaimPrefix, time.Now())
+	if err != nil {
+		slog.Error("Failed to detach stream output", "stream_id", id, "error", err)
+	}
+	tm.mu.Unlock()
+
+	if detached != "" {
+		tm.reclaim(detached)
+	}
+
+	if tm.store != nil {
+		if err := tm.store.Delete(id); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Stream deleted", "stream_id", id)
+	return nil
+}
@@ -0,0 +1,319 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
+	"github.com/vijayvenkatj/LiveTran/internal/webhook"
+)
+
+// runningTask is a task of tm whose run ends as a real one would, ENDED once it is cancelled
+func runningTask(tm *TaskManager, id string) (*Task, <-chan error) {
+	task := &Task{Id: id, Status: StreamLive, store: tm.store, events: tm.events, done: make(chan struct{})}
+	causes := make(chan error, 1)
+	task.CancelFn = func(cause error) {
+		causes <- cause
+		go func() {
+			defer close(task.done)
+			time.Sleep(50 * time.Millisecond) // FFmpeg flushing, uploads finishing
+			task.Transition(StreamEnding, EventStreamEnding, cause.Error(), StoppedData{Reason: cause.Error()})
+			if err := task.Transition(StreamEnded, EventStreamStopped, cause.Error(), StoppedData{Reason: cause.Error()}); err != nil {
+				panic(err)
+			}
+		}()
+	}
+	tm.mu.Lock()
+	tm.TaskMap[id] = task
+	tm.mu.Unlock()
+	task.persist()
+	return task, causes
+}
+
+// Without a store streams live only in memory, deleting them or looking up archived ones must not need one
+func TestDeleteWithoutStore(t *testing.T) {
+	t.Chdir(t.TempDir())
+	tm := NewTaskManager(nil, nil)
+	defer tm.Close()
+
+	task, _ := runningTask(tm, "memory")
+	if err := tm.DeleteTask(task.Id); err != nil {
+		t.Fatalf("DeleteTask = %v", err)
+	}
+	if _, ok := tm.GetTask(task.Id); ok {
+		t.Error("deleted task is still in memory")
+	}
+	if err := tm.DeleteTask(task.Id); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("deleting it again = %v, want %v", err, ErrTaskNotFound)
+	}
+	if _, err := tm.GetArchived("unknown"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("GetArchived = %v, want %v", err, ErrTaskNotFound)
+	}
+}
+
+// deadLetters is a webhook.DeadLetterStore in memory
+type deadLetters struct {
+	mu      sync.Mutex
+	letters []webhook.DeadLetter
+}
+
+func (s *deadLetters) SaveDeadLetter(letter webhook.DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.letters = append(s.letters, letter)
+	return nil
+}
+
+func (s *deadLetters) GetDeadLetter(id string) (webhook.DeadLetter, error) {
+	return webhook.DeadLetter{}, errors.New("not found")
+}
+
+func (s *deadLetters) ListDeadLetters() ([]webhook.DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.letters), nil
+}
+
+func (s *deadLetters) DeleteDeadLetter(id string) error { return nil }
+
+// A burst of events the webhooks cannot keep up with is dead-lettered, none is lost
+func TestWebhookBurstIsNotLost(t *testing.T) {
+	// Every delivery fails, so each event must end up as exactly one dead letter
+	endpoint := httptest.NewServer(http.NotFoundHandler())
+	endpoint.Close()
+
+	dlq := &deadLetters{}
+	d := webhook.NewDispatcher(webhook.Config{Timeout: time.Second, MaxAttempts: 1, QueueSize: 8, IdleTimeout: time.Minute}, dlq)
+	tm := NewTaskManager(nil, d)
+
+	const publishers, events = 4, 1000
+	var wg sync.WaitGroup
+	for p := range publishers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range events {
+				tm.events.Publish(Event{EventId: fmt.Sprintf("evt_%d_%d", p, i), StreamId: "burst", Type: EventStreamStats, webhooks: []string{endpoint.URL}})
+			}
+		}()
+	}
+	wg.Wait()
+	tm.Close()
+	d.Close()
+
+	letters, _ := dlq.ListDeadLetters()
+	dead := map[string]int{}
+	for _, letter := range letters {
+		dead[letter.EventId]++
+	}
+	for p := range publishers {
+		for i := range events {
+			if id := fmt.Sprintf("evt_%d_%d", p, i); dead[id] != 1 {
+				t.Fatalf("%s dead-lettered %d times, want once", id, dead[id])
+			}
+		}
+	}
+}
+
+// Streams stopped by a shutdown are drained but keep their record, they are restored on the next start
+func TestShutdownDrainsTasks(t *testing.T) {
+	store := newMemoryStore()
+	tm := NewTaskManager(store, nil)
+
+	task, causes := runningTask(tm, "live")
+	ended := &Task{Id: "ended", Status: StreamEnded, CancelFn: func(error) { t.Error("cancelled an ended task") }}
+	tm.TaskMap[ended.Id] = ended
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tm.Shutdown(ctx)
+
+	select {
+	case <-task.done:
+	default:
+		t.Fatal("Shutdown returned before the task was drained")
+	}
+	if cause := <-causes; cause != errServerShutdown {
+		t.Errorf("cancelled with %v, want %v", cause, errServerShutdown)
+	}
+
+	tm.Close()
+	record, err := store.Get(task.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != StreamLive {
+		t.Errorf("stored status = %s, want %s so the stream is restored", record.Status, StreamLive)
+	}
+}
+
+// A run that does not stop in time does not hold up the shutdown
+func TestShutdownTimeout(t *testing.T) {
+	tm := NewTaskManager(newMemoryStore(), nil)
+	defer tm.Close()
+
+	task := &Task{Id: "stuck", Status: StreamLive, done: make(chan struct{}), CancelFn: func(error) {}}
+	tm.TaskMap[task.Id] = task
+	defer close(task.done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	tm.Shutdown(ctx)
+}
+
+// A start request for an id that is registered: the same run again, a restart of a finished one, or a conflict
+func TestResolveStart(t *testing.T) {
+	profile, err := transcode.BuiltinRegistry().Get(transcode.DefaultProfileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grace := 30
+	registered := func(status StreamState) *Task {
+		return &Task{
+			Id:             "s1",
+			Status:         status,
+			Protocol:       ProtocolSRT,
+			Profile:        profile,
+			Mode:           transcode.ModeTranscode,
+			Srt:            DefaultSrtOptions().merge(),
+			Reconnect:      &ReconnectOptions{GraceSeconds: grace},
+			Webhooks:       []string{"https://a.example/hook", "https://b.example/hook"},
+			IdempotencyKey: "key-1",
+			Ingest:         IngestInfo{URL: "srt://10.0.0.1:9000?streamid=mode=publish,rid=s1"},
+		}
+	}
+	same := StartOptions{
+		Protocol: ProtocolSRT,
+		Mode:     transcode.ModeTranscode,
+		Webhooks: []string{"https://b.example/hook", "https://a.example/hook"},
+	}
+	with := func(change func(*StartOptions)) StartOptions {
+		opts := same
+		change(&opts)
+		return opts
+	}
+
+	tests := []struct {
+		name     string
+		status   StreamState
+		deleting bool
+		opts     StartOptions
+		restart  bool
+		conflict []string // Conflicting fields, nil for none
+		refused  bool     // A conflict without fields
+	}{
+		{name: "duplicate start of a live stream", status: StreamLive, opts: same},
+		{name: "duplicate start naming its options", status: StreamReady, opts: with(func(o *StartOptions) {
+			o.Profile = transcode.DefaultProfileName
+			o.Reconnect = &ReconnectOptions{GraceSeconds: grace}
+		})},
+		{name: "idempotent retry", status: StreamLive, opts: with(func(o *StartOptions) { o.IdempotencyKey = "key-1" })},
+		{name: "idempotent retry after the stream ended", status: StreamEnded, opts: with(func(o *StartOptions) { o.IdempotencyKey = "key-1" })},
+		{name: "idempotent retry while stopping", status: StreamEnding, opts: with(func(o *StartOptions) { o.IdempotencyKey = "key-1" })},
+		{name: "new request after the stream ended", status: StreamEnded, opts: same, restart: true},
+		{name: "new request after the stream failed", status: StreamFailed, opts: with(func(o *StartOptions) { o.Protocol = ProtocolRTMP }), restart: true},
+		{name: "new request while stopping", status: StreamEnding, opts: same, refused: true},
+		{name: "any request while deleting", status: StreamLive, deleting: true, opts: with(func(o *StartOptions) { o.IdempotencyKey = "key-1" }), refused: true},
+		{name: "idempotency key reused with other parameters", status: StreamEnded, opts: with(func(o *StartOptions) {
+			o.IdempotencyKey = "key-1"
+			o.Mode = transcode.ModePassthrough
+		}), conflict: []string{"mode"}},
+		{name: "other protocol", status: StreamLive, opts: with(func(o *StartOptions) { o.Protocol = ProtocolRTMP }), conflict: []string{"protocol"}},
+		{name: "pulled instead of pushed", status: StreamLive, opts: with(func(o *StartOptions) { o.Pull = &PullSource{URL: "srt://203.0.113.1:9000"} }), conflict: []string{"pull"}},
+		{name: "encryption asked for", status: StreamLive, opts: with(func(o *StartOptions) { o.Encryption = &SrtEncryption{} }), conflict: []string{"encryption"}},
+		{name: "other srt options", status: StreamLive, opts: with(func(o *StartOptions) { o.Srt = &SrtOptions{LatencyMs: 2000} }), conflict: []string{"srt"}},
+		{name: "other grace window", status: StreamLive, opts: with(func(o *StartOptions) { o.Reconnect = &ReconnectOptions{GraceSeconds: 5} }), conflict: []string{"reconnect"}},
+		{name: "abr asked for", status: StreamLive, opts: with(func(o *StartOptions) { o.Abr = true }), conflict: []string{"abr"}},
+		{name: "other profile", status: StreamLive, opts: with(func(o *StartOptions) { o.Profile = transcode.AbrProfileName }), conflict: []string{"profile"}},
+		{name: "other webhooks", status: StreamLive, opts: with(func(o *StartOptions) { o.Webhooks = []string{"https://a.example/hook"} }), conflict: []string{"webhook_urls"}},
+		{name: "several differences", status: StreamConnecting, opts: with(func(o *StartOptions) {
+			o.Protocol = ProtocolRTMP
+			o.Mode = transcode.ModePassthrough
+		}), conflict: []string{"protocol", "mode"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := registered(tt.status)
+			task.deleting = tt.deleting
+
+			info, restart, err := task.resolveStart(tt.opts)
+			if tt.conflict != nil || tt.refused {
+				var conflict *ConflictError
+				if !errors.As(err, &conflict) || !errors.Is(err, ErrTaskExists) {
+					t.Fatalf("resolveStart = %v, want a conflict", err)
+				}
+				if !slices.Equal(conflict.Fields, tt.conflict) {
+					t.Errorf("conflicting fields = %v, want %v", conflict.Fields, tt.conflict)
+				}
+				if conflict.Status != tt.status {
+					t.Errorf("conflict status = %s, want %s", conflict.Status, tt.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveStart = %v", err)
+			}
+			if restart != tt.restart {
+				t.Errorf("restart = %v, want %v", restart, tt.restart)
+			}
+			if !tt.restart && info.URL != task.Ingest.URL {
+				t.Errorf("ingest URL = %q, want the running one %q", info.URL, task.Ingest.URL)
+			}
+		})
+	}
+}
+
+// A passthrough stream copies a publisher HLS can carry on top of its ladder, and stays passthrough across restarts
+func TestPassthroughTask(t *testing.T) {
+	profile, err := transcode.BuiltinRegistry().Get(transcode.AbrProfileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Id: "copy", Profile: profile, Mode: transcode.ModePassthrough}
+
+	src := &transcode.Source{Width: 1920, Height: 1080, FrameRate: 30, Video: true, Audio: true, VideoCodec: "h264", AudioCodec: "aac"}
+	layout := task.fitLayout(src)
+	names := make([]string, len(layout.Ladder))
+	for i, r := range layout.Ladder {
+		names[i] = r.Name
+	}
+	if !slices.Equal(names, []string{"passthrough", "720p", "480p"}) || !layout.Ladder[0].Passthrough {
+		t.Errorf("ladder = %v, want the publisher's video on top of 720p and 480p", names)
+	}
+
+	restored := taskFromRecord(task.Record())
+	if restored.Mode != transcode.ModePassthrough {
+		t.Errorf("restored mode = %q, want %q", restored.Mode, transcode.ModePassthrough)
+	}
+	legacy := task.Record()
+	legacy.Mode = ""
+	if mode := taskFromRecord(legacy).Mode; mode != transcode.ModeTranscode {
+		t.Errorf("record without a mode restored as %q, want %q", mode, transcode.ModeTranscode)
+	}
+}
+
+// The API names the ingest URL after no protocol, srt_url stays for older clients
+func TestTaskInfoIngestURL(t *testing.T) {
+	task := &Task{Id: "cam", Protocol: ProtocolRTMP, Ingest: IngestInfo{URL: "rtmp://10.0.0.1:1935/live/key"}}
+	data, err := json.Marshal(task.Info())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var info map[string]any
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info["ingest_url"] != task.Ingest.URL || info["srt_url"] != task.Ingest.URL {
+		t.Errorf("ingest_url = %v, srt_url = %v, want %s for both", info["ingest_url"], info["srt_url"], task.Ingest.URL)
+	}
+}
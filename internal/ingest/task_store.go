@@ -0,0 +1,59 @@
+package ingest
+
+import (
+	"errors"
+	"time"
+
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
+)
+
+// Keep the persisted history bounded for streams that reconnect a lot
+const maxTransitions = 100
+
+var ErrTaskNotFound = errors.New("task not found")
+
+// TaskStore persists task metadata so streams survive a restart
+type TaskStore interface {
+	Save(record TaskRecord) error
+	Get(id string) (TaskRecord, error)
+	List() ([]TaskRecord, error)
+	Delete(id string) error
+
+	// Archive moves the record out of the active set, GetArchived reads it back and DeleteArchived purges it
+	Archive(record TaskRecord) error
+	GetArchived(id string) (TaskRecord, error)
+	DeleteArchived(id string) error
+
+	Close() error
+}
+
+type StatusTransition struct {
+	From   StreamState `json:"from,omitempty"`
+	To     StreamState `json:"to"`
+	Event  EventType   `json:"event,omitempty"`
+	Reason string      `json:"reason,omitempty"`
+	At     time.Time   `json:"at"`
+}
+
+// TaskRecord is the persisted form of a Task
+type TaskRecord struct {
+	Id             string             `json:"id"`
+	Status         StreamState        `json:"status"`
+	Webhooks       []string           `json:"webhooks,omitempty"`
+	Abr            bool               `json:"abr"`
+	Profile        *transcode.Profile `json:"profile,omitempty"`
+	Mode           transcode.Mode     `json:"mode,omitempty"`
+	Protocol       Protocol           `json:"protocol,omitempty"`
+	Pull           *PullSource        `json:"pull,omitempty"`
+	Encryption     *SrtEncryption     `json:"encryption,omitempty"`
+	Srt            *SrtOptions        `json:"srt,omitempty"`
+	Reconnect      *ReconnectOptions  `json:"reconnect,omitempty"`
+	IdempotencyKey string             `json:"idempotency_key,omitempty"`
+	StreamURL      string             `json:"stream_url,omitempty"`
+	IngestURL      string             `json:"ingest_url,omitempty"`
+	StartTime      time.Time          `json:"start_time"`
+	EndTime        time.Time          `json:"end_time,omitempty"`
+	UploadFailures int                `json:"upload_failures,omitempty"`
+	UpdatedAt      time.Time          `json:"updated_at"`
+	Transitions    []StatusTransition `json:"transitions,omitempty"`
+}
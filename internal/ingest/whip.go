@@ -0,0 +1,539 @@
+package ingest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/intervalpli"
+	"github.com/pion/webrtc/v4"
+	"github.com/vijayvenkatj/LiveTran/internal/auth"
+	"github.com/vijayvenkatj/LiveTran/internal/config"
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
+)
+
+// How long a negotiated WHIP session may take to deliver its tracks before it is dropped
+const whipTrackTimeout = 10 * time.Second
+
+var (
+	ErrInvalidStreamKey = errors.New("invalid stream key")
+	ErrInvalidOffer     = errors.New("invalid SDP offer")
+	ErrUnknownSession   = errors.New("unknown WHIP session")
+)
+
+// WHIPConfig controls WebRTC ingest
+type WHIPConfig struct {
+	// Public URL of the API server, the WHIP endpoint is <BaseURL>/api/whip/<stream_id>
+	BaseURL string
+	// STUN servers used to gather server reflexive candidates
+	STUNURLs []string
+	// Public IP announced as the host candidate when the server runs behind 1:1 NAT
+	PublicIP string
+	// UDP port range used for media, 0 lets the OS pick
+	PortMin uint16
+	PortMax uint16
+}
+
+func WHIPConfigFromEnv() WHIPConfig {
+	baseURL := os.Getenv("WHIP_BASE_URL")
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://%s:8080", GetLocalIP())
+	}
+
+	var stunURLs []string
+	for _, u := range strings.Split(os.Getenv("WHIP_STUN_URLS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			stunURLs = append(stunURLs, u)
+		}
+	}
+
+	return WHIPConfig{
+		BaseURL:  strings.TrimRight(baseURL, "/"),
+		STUNURLs: stunURLs,
+		PublicIP: os.Getenv("WHIP_PUBLIC_IP"),
+		PortMin:  uint16(config.EnvNonNegativeInt("WHIP_UDP_PORT_MIN", 0)),
+		PortMax:  uint16(config.EnvNonNegativeInt("WHIP_UDP_PORT_MAX", 0)),
+	}
+}
+
+// WHIPServer negotiates WebRTC sessions for browser publishers and routes them to their stream
+type WHIPServer struct {
+	cfg    WHIPConfig
+	api    *webrtc.API
+	routes *routeTable
+
+	mu       sync.Mutex
+	sessions map[string]*whipPublisher
+}
+
+// StartWHIP enables the WHIP endpoint, streams started with the whip protocol are served by it.
+// The returned function ends every open session.
+func (tm *TaskManager) StartWHIP(cfg WHIPConfig) (func(), error) {
+	media := &webrtc.MediaEngine{}
+	if err := registerWHIPCodecs(media); err != nil {
+		return nil, fmt.Errorf("WHIP codec error: %s", err)
+	}
+
+	interceptors := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(media, interceptors); err != nil {
+		return nil, fmt.Errorf("WHIP interceptor error: %s", err)
+	}
+	// Regular keyframes so FFmpeg can start decoding soon after it joins
+	pli, err := intervalpli.NewReceiverInterceptor()
+	if err != nil {
+		return nil, fmt.Errorf("WHIP interceptor error: %s", err)
+	}
+	interceptors.Add(pli)
+
+	settings := webrtc.SettingEngine{}
+	if cfg.PublicIP != "" {
+		settings.SetNAT1To1IPs([]string{cfg.PublicIP}, webrtc.ICECandidateTypeHost)
+	}
+	if cfg.PortMin != 0 || cfg.PortMax != 0 {
+		if err := settings.SetEphemeralUDPPortRange(cfg.PortMin, cfg.PortMax); err != nil {
+			return nil, fmt.Errorf("WHIP port range error: %s", err)
+		}
+	}
+
+	s := &WHIPServer{
+		cfg: cfg,
+		api: webrtc.NewAPI(
+			webrtc.WithMediaEngine(media),
+			webrtc.WithInterceptorRegistry(interceptors),
+			webrtc.WithSettingEngine(settings),
+		),
+		routes:   newRouteTable(),
+		sessions: make(map[string]*whipPublisher),
+	}
+
+	tm.whip = s
+	slog.Info("WHIP ingest enabled", "base_url", cfg.BaseURL)
+
+	return func() {
+		s.mu.Lock()
+		sessions := make([]*whipPublisher, 0, len(s.sessions))
+		for _, pub := range s.sessions {
+			sessions = append(sessions, pub)
+		}
+		s.mu.Unlock()
+
+		for _, pub := range sessions {
+			pub.Close()
+		}
+	}, nil
+}
+
+// registerWHIPCodecs limits negotiation to the codecs FFmpeg can depacketize
+func registerWHIPCodecs(media *webrtc.MediaEngine) error {
+	videoFeedback := []webrtc.RTCPFeedback{
+		{Type: "goog-remb"},
+		{Type: "ccm", Parameter: "fir"},
+		{Type: "nack"},
+		{Type: "nack", Parameter: "pli"},
+	}
+
+	video := []webrtc.RTPCodecParameters{
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f", RTCPFeedback: videoFeedback},
+			PayloadType:        102,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f", RTCPFeedback: videoFeedback},
+			PayloadType:        127,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000, RTCPFeedback: videoFeedback},
+			PayloadType:        96,
+		},
+	}
+	for _, codec := range video {
+		if err := media.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
+			return err
+		}
+	}
+
+	return media.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"},
+		PayloadType:        111,
+	}, webrtc.RTPCodecTypeAudio)
+}
+
+// prepareIngest registers the task's route and generates the stream key used as bearer token
+func (s *WHIPServer) prepareIngest(task *Task) (ingestSource, IngestInfo, error) {
+	streamkey, expiresAt, err := auth.GenerateStreamKey(task.Id)
+	if err != nil {
+		return nil, IngestInfo{}, fmt.Errorf("StreamKey error: %s", err)
+	}
+
+	base, err := url.Parse(s.cfg.BaseURL)
+	if err != nil {
+		return nil, IngestInfo{}, fmt.Errorf("WHIP base URL error: %s", err)
+	}
+	port, _ := strconv.Atoi(base.Port())
+	if port == 0 {
+		port = 443
+	}
+
+	source := s.routes.register(task)
+
+	info := IngestInfo{
+		StreamId:    task.Id,
+		Protocol:    ProtocolWHIP,
+		URL:         fmt.Sprintf("%s/api/whip/%s", s.cfg.BaseURL, task.Id),
+		Host:        base.Hostname(),
+		Port:        port,
+		StreamKey:   streamkey,
+		KeyExpiry:   expiresAt,
+		PlaybackURL: PlaybackURL(task.Id, task.Abr),
+		DashURL:     DashPlaybackURL(task.Id, task.Profile),
+	}
+
+	return source, info, nil
+}
+
+// WHIPPublish negotiates a WebRTC session for a browser publishing to stream id and returns the SDP answer.
+// The bearer is the stream key from start-stream, or only its token.
+func (tm *TaskManager) WHIPPublish(ctx context.Context, id string, bearer string, offer string, remoteAddr string) (answer string, session string, err error) {
+	s := tm.whip
+	if s == nil {
+		return "", "", fmt.Errorf("%w: %s", ErrProtocolDisabled, ProtocolWHIP)
+	}
+
+	source, ok := s.routes.lookup(id)
+	if !ok {
+		return "", "", ErrUnknownStream
+	}
+
+	if ok, reason := auth.DecodeStreamKey(id, whipStreamKey(id, bearer)); !ok {
+		slog.Warn("Rejected WHIP publisher", "stream_id", id, "remote_addr", remoteAddr, "reason", reason)
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidStreamKey, reason)
+	}
+
+	iceServers := []webrtc.ICEServer{}
+	if len(s.cfg.STUNURLs) > 0 {
+		iceServers = append(iceServers, webrtc.ICEServer{URLs: s.cfg.STUNURLs})
+	}
+
+	pc, err := s.api.NewPeerConnection(webrtc.Configuration{ICEServers: iceServers})
+	if err != nil {
+		return "", "", fmt.Errorf("WHIP peer connection error: %s", err)
+	}
+
+	session, err = newSessionId()
+	if err != nil {
+		pc.Close()
+		return "", "", err
+	}
+
+	pub := newWHIPPublisher(id, session, remoteAddr, pc, &source.task.stats)
+	if err := s.addSession(pub); err != nil {
+		pc.Close()
+		return "", "", err
+	}
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		pub.Close()
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidOffer, err)
+	}
+
+	sdpAnswer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		pub.Close()
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidOffer, err)
+	}
+
+	// No trickle ICE, the answer carries every candidate
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(sdpAnswer); err != nil {
+		pub.Close()
+		return "", "", fmt.Errorf("WHIP answer error: %s", err)
+	}
+	select {
+	case <-gathered:
+	case <-ctx.Done():
+		pub.Close()
+		return "", "", ctx.Err()
+	}
+
+	go s.serve(source, pub, len(pc.GetTransceivers()))
+
+	return pc.LocalDescription().SDP, session, nil
+}
+
+// WHIPEnd ends a session, the stream goes on waiting for the next publisher
+func (tm *TaskManager) WHIPEnd(id string, bearer string, session string) error {
+	s := tm.whip
+	if s == nil {
+		return fmt.Errorf("%w: %s", ErrProtocolDisabled, ProtocolWHIP)
+	}
+
+	if ok, reason := auth.DecodeStreamKey(id, whipStreamKey(id, bearer)); !ok {
+		return fmt.Errorf("%w: %s", ErrInvalidStreamKey, reason)
+	}
+
+	s.mu.Lock()
+	pub, ok := s.sessions[session]
+	s.mu.Unlock()
+
+	if !ok || pub.streamId != id {
+		return ErrUnknownSession
+	}
+
+	return pub.Close()
+}
+
+// serve hands the publisher to its stream once its tracks arrived
+func (s *WHIPServer) serve(source *routedSource, pub *whipPublisher, expected int) {
+	if err := pub.waitForTracks(expected, whipTrackTimeout); err != nil {
+		slog.Warn("Dropped WHIP publisher", "stream_id", pub.streamId, "remote_addr", pub.remoteAddr, "reason", err)
+		pub.Close()
+		return
+	}
+
+	if err := source.handoff(pub); err != nil {
+		slog.Warn("Rejected WHIP publisher", "stream_id", pub.streamId, "remote_addr", pub.remoteAddr, "reason", err)
+		pub.Close()
+	}
+}
+
+// addSession tracks pub until it closes, a stream takes one session at a time
+func (s *WHIPServer) addSession(pub *whipPublisher) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, other := range s.sessions {
+		if other.streamId == pub.streamId {
+			return ErrPublisherBusy
+		}
+	}
+	s.sessions[pub.session] = pub
+
+	go func() {
+		<-pub.done
+		s.mu.Lock()
+		delete(s.sessions, pub.session)
+		s.mu.Unlock()
+	}()
+
+	return nil
+}
+
+// whipStreamKey accepts either the full stream key or only its token as bearer
+func whipStreamKey(id string, bearer string) string {
+	if strings.Contains(bearer, "rid=") {
+		return bearer
+	}
+	return fmt.Sprintf("mode=publish,rid=%s,token=%s", id, bearer)
+}
+
+func newSessionId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("WHIP session id error: %s", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// whipPublisher relays the RTP of each received track to FFmpeg, which reads them as an RTSP source
+type whipPublisher struct {
+	streamId   string
+	session    string
+	remoteAddr string
+	pc         *webrtc.PeerConnection
+	stats      *statsCollector
+
+	mu        sync.Mutex
+	tracks    []whipTrack
+	arrived   chan struct{}
+	relay     atomic.Pointer[rtspRelay]
+	sdpTracks int // Tracks described to FFmpeg, those arriving later are not
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type whipTrack struct {
+	codec webrtc.RTPCodecParameters
+	kind  webrtc.RTPCodecType
+}
+
+func newWHIPPublisher(id, session, remoteAddr string, pc *webrtc.PeerConnection, stats *statsCollector) *whipPublisher {
+	pub := &whipPublisher{
+		streamId:   id,
+		session:    session,
+		remoteAddr: remoteAddr,
+		pc:         pc,
+		stats:      stats,
+		arrived:    make(chan struct{}, 4),
+		done:       make(chan struct{}),
+	}
+
+	pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		pub.forward(track)
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		slog.Info("WHIP connection state", "stream_id", id, "session", session, "state", state)
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			pub.Close()
+		}
+	})
+
+	return pub
+}
+
+// forward relays the RTP packets of track to FFmpeg
+func (pub *whipPublisher) forward(track *webrtc.TrackRemote) {
+	pub.mu.Lock()
+	index := len(pub.tracks)
+	pub.tracks = append(pub.tracks, whipTrack{codec: track.Codec(), kind: track.Kind()})
+	pub.mu.Unlock()
+
+	select {
+	case pub.arrived <- struct{}{}:
+	default: // Arrived after FFmpeg was given the tracks
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := track.Read(buf)
+		if err != nil {
+			return
+		}
+		pub.stats.addBytes(n)
+
+		// Lost until FFmpeg plays the session, tracks it was not given are never set up
+		if relay := pub.relay.Load(); relay != nil {
+			relay.write(index, buf[:n])
+		}
+	}
+}
+
+// waitForTracks waits until every negotiated track arrived, or a part of them once timeout passed,
+// then opens the relay FFmpeg reads them from
+func (pub *whipPublisher) waitForTracks(expected int, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for received := 0; received < expected; {
+		select {
+		case <-pub.arrived:
+			received++
+		case <-pub.done:
+			return errors.New("session ended before its tracks arrived")
+		case <-timer.C:
+			if received == 0 {
+				return errors.New("no tracks received")
+			}
+			expected = received
+		}
+	}
+
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+
+	pub.sdpTracks = len(pub.tracks)
+	relay, err := newRTSPRelay(pub.session, pub.sdp(), pub.sdpTracks)
+	if err != nil {
+		return err
+	}
+
+	// Close does not see a relay it raced with
+	select {
+	case <-pub.done:
+		relay.Close()
+		return errors.New("session ended before its tracks arrived")
+	default:
+	}
+	pub.relay.Store(relay)
+	return nil
+}
+
+func (pub *whipPublisher) sdp() string {
+	var b strings.Builder
+	b.WriteString("v=0\r\n")
+	b.WriteString("o=- 0 0 IN IP4 127.0.0.1\r\n")
+	b.WriteString("s=LiveTran WHIP\r\n")
+	b.WriteString("c=IN IP4 127.0.0.1\r\n")
+	b.WriteString("t=0 0\r\n")
+
+	for i, track := range pub.tracks[:pub.sdpTracks] {
+		codec := track.codec
+		name := codec.MimeType[strings.Index(codec.MimeType, "/")+1:]
+
+		fmt.Fprintf(&b, "m=%s 0 RTP/AVP %d\r\n", track.kind, codec.PayloadType)
+		fmt.Fprintf(&b, "a=control:trackID=%d\r\n", i)
+		if codec.Channels > 0 {
+			fmt.Fprintf(&b, "a=rtpmap:%d %s/%d/%d\r\n", codec.PayloadType, name, codec.ClockRate, codec.Channels)
+		} else {
+			fmt.Fprintf(&b, "a=rtpmap:%d %s/%d\r\n", codec.PayloadType, name, codec.ClockRate)
+		}
+		if codec.SDPFmtpLine != "" {
+			fmt.Fprintf(&b, "a=fmtp:%d %s\r\n", codec.PayloadType, codec.SDPFmtpLine)
+		}
+	}
+
+	return b.String()
+}
+
+// Read carries no media, FFmpeg receives it from the relay. It blocks until the session ends.
+func (pub *whipPublisher) Read(p []byte) (int, error) {
+	<-pub.done
+	return 0, fmt.Errorf("WebRTC session %s ended", pub.session)
+}
+
+func (pub *whipPublisher) Close() error {
+	var err error
+	pub.closeOnce.Do(func() {
+		close(pub.done)
+		err = pub.pc.Close()
+
+		pub.mu.Lock()
+		if relay := pub.relay.Load(); relay != nil {
+			relay.Close()
+		}
+		pub.mu.Unlock()
+	})
+	return err
+}
+
+func (pub *whipPublisher) RemoteAddr() string {
+	return pub.remoteAddr
+}
+
+// describeSource tells which tracks FFmpeg was given and their codecs. It receives the RTP itself, so there is
+// nothing to probe before it starts and the size and frame rate stay unknown.
+func (pub *whipPublisher) describeSource() *transcode.Source {
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+
+	src := &transcode.Source{}
+	for _, track := range pub.tracks[:pub.sdpTracks] {
+		codec := strings.ToLower(track.codec.MimeType[strings.Index(track.codec.MimeType, "/")+1:])
+		switch track.kind {
+		case webrtc.RTPCodecTypeVideo:
+			src.Video, src.VideoCodec = true, codec
+		case webrtc.RTPCodecTypeAudio:
+			src.Audio, src.AudioCodec = true, codec
+		}
+	}
+	return src
+}
+
+// InputArgs has FFmpeg take the RTP interleaved on its RTSP connection, it binds no ports of its own
+func (pub *whipPublisher) InputArgs() []string {
+	return []string{
+		"-rtsp_transport", "tcp",
+		"-i", pub.relay.Load().URL(),
+	}
+}
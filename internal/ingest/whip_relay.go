@@ -0,0 +1,268 @@
+package ingest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Channels the client asks RTP of a track to be interleaved on, and the track a SETUP names
+var (
+	rtspInterleaved = regexp.MustCompile(`interleaved=(\d+)-(\d+)`)
+	rtspTrackId     = regexp.MustCompile(`/trackID=(\d+)$`)
+)
+
+// rtspRelay serves the tracks of a WHIP session to FFmpeg as an RTSP source, the RTP interleaved on
+// FFmpeg's TCP connection. The listener is held from the start, no one else can take the port in between.
+type rtspRelay struct {
+	listener net.Listener
+	session  string
+	sdp      string // Describes the tracks, a=control:trackID=<index> each
+	tracks   int
+
+	mu      sync.Mutex
+	client  *rtspClient // FFmpeg, the first to connect. Later connections cannot take the media away from it.
+	playing bool        // Until the client goes away
+	closed  bool
+}
+
+// How long FFmpeg may take to read a packet or response before it is dropped, the track's reading does not wait for it
+const rtspWriteTimeout = 100 * time.Millisecond
+
+// rtspClient is a connection to the relay, FFmpeg's
+type rtspClient struct {
+	conn net.Conn
+
+	mu       sync.Mutex // Responses and RTP share the connection
+	channels map[int]byte
+}
+
+func newRTSPRelay(session, sdp string, tracks int) (*rtspRelay, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &rtspRelay{
+		listener: listener,
+		session:  session,
+		sdp:      sdp,
+		tracks:   tracks,
+	}
+	go r.serve()
+	return r, nil
+}
+
+// URL is what FFmpeg reads the session from
+func (r *rtspRelay) URL() string {
+	return fmt.Sprintf("rtsp://%s/%s", r.listener.Addr(), r.session)
+}
+
+func (r *rtspRelay) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &rtspClient{conn: conn, channels: make(map[int]byte)}
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			conn.Close()
+			return
+		}
+		if r.client != nil {
+			r.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		r.client = c
+		r.mu.Unlock()
+
+		go r.handle(c)
+	}
+}
+
+// handle answers the requests of c until it tears down or goes away
+func (r *rtspRelay) handle(c *rtspClient) {
+	defer func() {
+		r.mu.Lock()
+		r.playing = false
+		r.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	reader := bufio.NewReader(c.conn)
+	requests := textproto.NewReader(reader)
+	for {
+		// The client's RTCP comes interleaved too, it is not needed
+		next, err := reader.Peek(1)
+		if err != nil {
+			return
+		}
+		if next[0] == '$' {
+			var header [4]byte
+			if _, err := io.ReadFull(reader, header[:]); err != nil {
+				return
+			}
+			if _, err := reader.Discard(int(binary.BigEndian.Uint16(header[2:]))); err != nil {
+				return
+			}
+			continue
+		}
+
+		line, err := requests.ReadLine()
+		if err != nil {
+			return
+		}
+		header, err := requests.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		if length, _ := strconv.Atoi(header.Get("Content-Length")); length > 0 {
+			if _, err := reader.Discard(length); err != nil {
+				return
+			}
+		}
+
+		method, url, _ := strings.Cut(line, " ")
+		url, _, _ = strings.Cut(url, " ")
+		cseq := header.Get("CSeq")
+
+		// Only the session's URL and its tracks are served
+		if url != r.URL() && !strings.HasPrefix(url, r.URL()+"/") {
+			c.respond(cseq, "404 Not Found", nil, "")
+			continue
+		}
+		session := header.Get("Session")
+		if (session != "" || method == "PLAY" || method == "TEARDOWN") && session != r.session {
+			c.respond(cseq, "454 Session Not Found", nil, "")
+			continue
+		}
+
+		switch method {
+		case "OPTIONS", "GET_PARAMETER", "SET_PARAMETER":
+			c.respond(cseq, "200 OK", []string{"Public: OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER"}, "")
+		case "DESCRIBE":
+			c.respond(cseq, "200 OK", []string{"Content-Base: " + r.URL() + "/", "Content-Type: application/sdp"}, r.sdp)
+		case "SETUP":
+			track, channels, ok := r.setup(url, header.Get("Transport"))
+			if !ok {
+				c.respond(cseq, "461 Unsupported Transport", nil, "")
+				continue
+			}
+			c.mu.Lock()
+			c.channels[track] = byte(channels[0])
+			c.mu.Unlock()
+			c.respond(cseq, "200 OK", []string{
+				fmt.Sprintf("Transport: RTP/AVP/TCP;unicast;interleaved=%d-%d", channels[0], channels[1]),
+				"Session: " + r.session,
+			}, "")
+		case "PLAY":
+			c.respond(cseq, "200 OK", []string{"Session: " + r.session, "Range: npt=0.000-"}, "")
+			r.mu.Lock()
+			r.playing = true
+			r.mu.Unlock()
+		case "TEARDOWN":
+			c.respond(cseq, "200 OK", []string{"Session: " + r.session}, "")
+			return
+		default:
+			c.respond(cseq, "501 Not Implemented", nil, "")
+		}
+	}
+}
+
+// setup reads the track a SETUP request is for and the channels its RTP and RTCP go on.
+// Only interleaved TCP is offered, there are no UDP ports to agree on.
+func (r *rtspRelay) setup(url, transport string) (int, [2]int, bool) {
+	var channels [2]int
+	match := rtspTrackId.FindStringSubmatch(url)
+	if match == nil {
+		return 0, channels, false
+	}
+	track, _ := strconv.Atoi(match[1])
+
+	interleaved := rtspInterleaved.FindStringSubmatch(transport)
+	if track >= r.tracks || !strings.HasPrefix(transport, "RTP/AVP/TCP") || interleaved == nil {
+		return 0, channels, false
+	}
+	channels[0], _ = strconv.Atoi(interleaved[1])
+	channels[1], _ = strconv.Atoi(interleaved[2])
+	if channels[0] > 255 || channels[1] > 255 {
+		return 0, channels, false
+	}
+	return track, channels, true
+}
+
+func (c *rtspClient) respond(cseq string, status string, headers []string, body string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "RTSP/1.0 %s\r\nCSeq: %s\r\n", status, cseq)
+	for _, header := range headers {
+		b.WriteString(header + "\r\n")
+	}
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n%s", len(body), body)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(rtspWriteTimeout))
+	c.conn.Write([]byte(b.String()))
+}
+
+// write relays an RTP packet of track to the playing client. Until FFmpeg plays the packets are lost either way.
+// A client that does not keep up loses the packet, a stalled one the connection.
+func (r *rtspRelay) write(track int, packet []byte) {
+	r.mu.Lock()
+	c := r.client
+	if !r.playing {
+		c = nil
+	}
+	r.mu.Unlock()
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	channel, ok := c.channels[track]
+	if !ok {
+		return
+	}
+	frame := make([]byte, 4+len(packet))
+	frame[0], frame[1] = '$', channel
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(packet)))
+	copy(frame[4:], packet)
+
+	c.conn.SetWriteDeadline(time.Now().Add(rtspWriteTimeout))
+	n, err := c.conn.Write(frame)
+	if err == nil {
+		return
+	}
+	// Part of the frame went out, the stream cannot be resynchronized
+	if n > 0 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		c.conn.Close()
+	}
+}
+
+func (r *rtspRelay) Close() {
+	r.listener.Close()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	if r.client != nil {
+		r.client.conn.Close()
+	}
+}
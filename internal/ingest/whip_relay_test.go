@@ -0,0 +1,205 @@
+package ingest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rtspHost is the address of the RTSP server of url
+func rtspHost(url string) string {
+	host, _, _ := strings.Cut(strings.TrimPrefix(url, "rtsp://"), "/")
+	return host
+}
+
+// rtspConn is an RTSP client connection
+type rtspConn struct {
+	net.Conn
+	reader *bufio.Reader
+	cseq   int
+}
+
+func dialRTSP(t *testing.T, url string) *rtspConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", rtspHost(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &rtspConn{Conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// request sends a request and returns the status line, headers and body of the response
+func (c *rtspConn) request(t *testing.T, method, target string, headers ...string) (string, textproto.MIMEHeader, string) {
+	t.Helper()
+	c.cseq++
+	fmt.Fprintf(c, "%s %s RTSP/1.0\r\nCSeq: %d\r\n%s\r\n", method, target, c.cseq, strings.Join(append(headers, ""), "\r\n"))
+
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	defer c.SetReadDeadline(time.Time{})
+	responses := textproto.NewReader(c.reader)
+	status, err := responses.ReadLine()
+	if err != nil {
+		t.Fatalf("%s: %v", method, err)
+	}
+	header, err := responses.ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("%s: %v", method, err)
+	}
+	length, _ := strconv.Atoi(header.Get("Content-Length"))
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		t.Fatalf("%s: %v", method, err)
+	}
+	return status, header, string(body)
+}
+
+// ok is request for requests that must succeed
+func (c *rtspConn) ok(t *testing.T, method, target string, headers ...string) (textproto.MIMEHeader, string) {
+	t.Helper()
+	status, header, body := c.request(t, method, target, headers...)
+	if status != "RTSP/1.0 200 OK" {
+		t.Fatalf("%s answered %s", method, status)
+	}
+	return header, body
+}
+
+// readRTP returns the channel and packet of the next interleaved frame
+func (c *rtspConn) readRTP(t *testing.T) (byte, []byte) {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	defer c.SetReadDeadline(time.Time{})
+
+	var header [4]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		t.Fatalf("reading RTP from the relay: %v", err)
+	}
+	if header[0] != '$' {
+		t.Fatalf("relay sent %q", header)
+	}
+	packet := make([]byte, binary.BigEndian.Uint16(header[2:]))
+	if _, err := io.ReadFull(c.reader, packet); err != nil {
+		t.Fatalf("reading RTP from the relay: %v", err)
+	}
+	return header[1], packet
+}
+
+// rtspPlay sets up every track of the RTSP session at url the way FFmpeg does and plays it.
+// It returns the SDP of the session and the connection the RTP then arrives on.
+func rtspPlay(t *testing.T, url string) (string, *rtspConn) {
+	t.Helper()
+	c := dialRTSP(t, url)
+
+	c.ok(t, "OPTIONS", url)
+	header, sdp := c.ok(t, "DESCRIBE", url, "Accept: application/sdp")
+	var session string
+	for i := range strings.Count(sdp, "a=control:") {
+		headers := []string{fmt.Sprintf("Transport: RTP/AVP/TCP;unicast;interleaved=%d-%d", 2*i, 2*i+1)}
+		if session != "" {
+			headers = append(headers, "Session: "+session)
+		}
+		setup, _ := c.ok(t, "SETUP", fmt.Sprintf("%strackID=%d", header.Get("Content-Base"), i), headers...)
+		if !strings.Contains(setup.Get("Transport"), fmt.Sprintf("interleaved=%d-%d", 2*i, 2*i+1)) {
+			t.Fatalf("SETUP of track %d answered transport %s", i, setup.Get("Transport"))
+		}
+		session = setup.Get("Session")
+	}
+	c.ok(t, "PLAY", url, "Session: "+session, "Range: npt=0.000-")
+
+	return sdp, c
+}
+
+const relayTestSdp = "v=0\r\nm=video 0 RTP/AVP 96\r\na=control:trackID=0\r\na=rtpmap:96 VP8/90000\r\n"
+
+// Only the session's URL and session id are served, and only to the first client
+func TestRTSPRelayClients(t *testing.T) {
+	relay, err := newRTSPRelay("session-a", relayTestSdp, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+	url := relay.URL()
+
+	c := dialRTSP(t, url)
+	// The listener serves connections in order, the second one is turned away once the first is taken
+	c.ok(t, "OPTIONS", url)
+
+	other := dialRTSP(t, url)
+	fmt.Fprintf(other, "OPTIONS %s RTSP/1.0\r\nCSeq: 1\r\n\r\n", url)
+	other.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := other.reader.ReadByte(); err == nil {
+		t.Error("a second client was answered")
+	}
+
+	rejected := []struct {
+		name    string
+		method  string
+		target  string
+		headers []string
+		status  string
+	}{
+		{"another session's URL", "DESCRIBE", strings.Replace(url, "session-a", "session-b", 1), nil, "RTSP/1.0 404 Not Found"},
+		{"another track's URL", "SETUP", url + "/trackID=1", []string{"Transport: RTP/AVP/TCP;unicast;interleaved=0-1"}, "RTSP/1.0 461 Unsupported Transport"},
+		{"UDP transport", "SETUP", url + "/trackID=0", []string{"Transport: RTP/AVP;unicast;client_port=5000-5001"}, "RTSP/1.0 461 Unsupported Transport"},
+		{"another session", "PLAY", url, []string{"Session: session-b"}, "RTSP/1.0 454 Session Not Found"},
+		{"no session", "PLAY", url, nil, "RTSP/1.0 454 Session Not Found"},
+	}
+	for _, r := range rejected {
+		t.Run(r.name, func(t *testing.T) {
+			if status, _, _ := c.request(t, r.method, r.target, r.headers...); status != r.status {
+				t.Errorf("%s answered %s, want %s", r.method, status, r.status)
+			}
+		})
+	}
+
+	// Nothing is relayed before the client plays
+	relay.write(0, []byte{0x80, 96})
+	c.ok(t, "SETUP", url+"/trackID=0", "Transport: RTP/AVP/TCP;unicast;interleaved=4-5")
+	c.ok(t, "PLAY", url, "Session: session-a")
+	relay.write(0, []byte{0x80, 96, 1})
+	if channel, packet := c.readRTP(t); channel != 4 || len(packet) != 3 {
+		t.Errorf("relayed packet of %d bytes on channel %d, want the 3 bytes written after PLAY on channel 4", len(packet), channel)
+	}
+
+	// The client that went away is not replaced by another
+	c.ok(t, "TEARDOWN", url, "Session: session-a")
+	late := dialRTSP(t, url)
+	fmt.Fprintf(late, "OPTIONS %s RTSP/1.0\r\nCSeq: 1\r\n\r\n", url)
+	late.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := late.reader.ReadByte(); err == nil {
+		t.Error("a client after the first was answered")
+	}
+}
+
+// A client that stops reading costs packets, not the track's reading
+func TestRTSPRelayStalledClient(t *testing.T) {
+	relay, err := newRTSPRelay("session", relayTestSdp, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+
+	_, c := rtspPlay(t, relay.URL())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		packet := make([]byte, 1200)
+		for range 10000 {
+			relay.write(0, packet)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("writing to a client that does not read blocked")
+	}
+	c.Close()
+}
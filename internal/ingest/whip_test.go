@@ -0,0 +1,195 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/vijayvenkatj/LiveTran/internal/auth"
+)
+
+// whipClient is a browser-like publisher sending a VP8 and an Opus track until the test ends
+func whipClient(t *testing.T) *webrtc.PeerConnection {
+	t.Helper()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	video, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "webcam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "webcam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, track := range []webrtc.TrackLocal{video, audio} {
+		if _, err := pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The server only sees a track once its first packet arrives
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				video.WriteSample(media.Sample{Data: []byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a}, Duration: 20 * time.Millisecond})
+				audio.WriteSample(media.Sample{Data: []byte{0xfc, 0xff, 0xfe}, Duration: 20 * time.Millisecond})
+			}
+		}
+	}()
+
+	return pc
+}
+
+// nextEvent returns the next event of the subscription of the given type, skipping the others
+func nextEvent(t *testing.T, sub *Subscription, eventType EventType) Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-sub.Events():
+			if event.Type == eventType {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no %s event", eventType)
+		}
+	}
+}
+
+func TestWHIPLoopback(t *testing.T) {
+	t.Setenv("JWT_SECRET", "whip-test")
+
+	tm := NewTaskManager(nil, nil)
+	closeWHIP, err := tm.StartWHIP(WHIPConfig{BaseURL: "http://127.0.0.1:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeWHIP()
+
+	sub := tm.Events().Subscribe("test", 64, DropNewest, nil)
+	defer sub.Close()
+
+	// What StartTask and IngestTask do before FFmpeg and the uploads, which this test stands in for
+	task := &Task{Id: "webcam", Protocol: ProtocolWHIP, Status: StreamInit, events: tm.events}
+	source, info, err := tm.whip.prepareIngest(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	task.Transition(StreamReady, EventStreamReady, "The stream is ready", nil)
+
+	if info.URL != "http://127.0.0.1:8080/api/whip/webcam" {
+		t.Errorf("WHIP endpoint = %s", info.URL)
+	}
+	_, token, _ := strings.Cut(info.StreamKey, "token=")
+
+	pc := whipClient(t)
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	if _, _, err := tm.WHIPPublish(ctx, "webcam", "not-a-stream-key", pc.LocalDescription().SDP, "127.0.0.1:50000"); !errors.Is(err, ErrInvalidStreamKey) {
+		t.Errorf("WHIPPublish with a wrong stream key = %v, want ErrInvalidStreamKey", err)
+	}
+	liveToken, _, err := auth.GenerateLiveToken("webcam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tm.WHIPPublish(ctx, "webcam", liveToken, pc.LocalDescription().SDP, "127.0.0.1:50000"); !errors.Is(err, ErrInvalidStreamKey) {
+		t.Errorf("WHIPPublish with a live token = %v, want ErrInvalidStreamKey", err)
+	}
+
+	// Browsers send only the token of the stream key as bearer
+	answer, session, err := tm.WHIPPublish(ctx, "webcam", token, pc.LocalDescription().SDP, "127.0.0.1:50000")
+	if err != nil {
+		t.Fatalf("WHIPPublish: %v", err)
+	}
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatalf("applying the answer: %v", err)
+	}
+
+	if _, _, err := tm.WHIPPublish(ctx, "webcam", token, pc.LocalDescription().SDP, "127.0.0.1:50001"); !errors.Is(err, ErrPublisherBusy) {
+		t.Errorf("second WHIP session = %v, want ErrPublisherBusy", err)
+	}
+
+	// Handed to the stream once both tracks arrived
+	pub, err := source.accept(ctx)
+	if err != nil {
+		t.Fatalf("no publisher handed to the stream: %v", err)
+	}
+	whip := pub.(*whipPublisher)
+	if src := whip.describeSource(); !src.Video || src.VideoCodec != "vp8" || !src.Audio || src.AudioCodec != "opus" {
+		t.Errorf("publisher sends %+v, want vp8 and opus", src)
+	}
+
+	// FFmpeg plays the session from the relay and gets the RTP of both tracks on its connection
+	url := pub.InputArgs()[len(pub.InputArgs())-1]
+	sdp, rtsp := rtspPlay(t, url)
+	for _, line := range []string{"a=rtpmap:96 VP8/90000", "a=rtpmap:111 opus/48000/2", "a=control:trackID=1"} {
+		if !strings.Contains(sdp, line) {
+			t.Errorf("SDP handed to FFmpeg has no %s:\n%s", line, sdp)
+		}
+	}
+	received := map[byte]bool{}
+	for len(received) < 2 {
+		if _, packet := rtsp.readRTP(t); len(packet) >= 2 {
+			received[packet[1]&0x7f] = true
+		}
+	}
+	if !received[96] || !received[111] {
+		t.Errorf("relay sent payload types %v, want 96 and 111", received)
+	}
+
+	publisherConnected(task, PublisherData{RemoteAddr: pub.RemoteAddr()})
+	if event := nextEvent(t, sub, EventPublisherConnected); event.Data.(PublisherData).RemoteAddr != "127.0.0.1:50000" {
+		t.Errorf("publisher connected from %+v", event.Data)
+	}
+
+	// The first playlist FFmpeg writes is uploaded
+	task.playlistUploaded("https://cdn.example.com/webcam/webcam.m3u8")
+	nextEvent(t, sub, EventStreamLive)
+	if status := task.GetStatus(); status != StreamLive {
+		t.Fatalf("stream is %s, want %s", status, StreamLive)
+	}
+
+	// Ending the session leaves the stream waiting for the next publisher
+	if err := tm.WHIPEnd("webcam", token, "unknown"); !errors.Is(err, ErrUnknownSession) {
+		t.Errorf("WHIPEnd of an unknown session = %v, want ErrUnknownSession", err)
+	}
+	if err := tm.WHIPEnd("webcam", token, session); err != nil {
+		t.Fatalf("WHIPEnd: %v", err)
+	}
+	if _, err := pub.Read(make([]byte, 1)); err == nil {
+		t.Errorf("publisher still reads after its session ended")
+	}
+	if conn, err := net.Dial("tcp", rtspHost(url)); err == nil {
+		conn.Close()
+		t.Errorf("relay still listens after the session ended")
+	}
+}
@@ -0,0 +1,227 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vijayvenkatj/LiveTran/internal/ingest"
+	"github.com/vijayvenkatj/LiveTran/internal/transcode"
+	"github.com/vijayvenkatj/LiveTran/internal/webhook"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	tasksBucket       = []byte("tasks")
+	archiveBucket     = []byte("archive")
+	deadLettersBucket = []byte("dead_letters")
+	profilesBucket    = []byte("profiles")
+)
+
+// BoltStore is an embedded, file backed ingest.TaskStore, webhook.DeadLetterStore and transcode.ProfileStore
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (or creates) the database file at path
+func NewBoltStore(path string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("create store directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{tasksBucket, archiveBucket, deadLettersBucket, profilesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init store: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Save(record ingest.TaskRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tasksBucket).Put([]byte(record.Id), data)
+	})
+}
+
+func (s *BoltStore) Get(id string) (ingest.TaskRecord, error) {
+	var record ingest.TaskRecord
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(tasksBucket).Get([]byte(id))
+		if data == nil {
+			return ingest.ErrTaskNotFound
+		}
+		return json.Unmarshal(data, &record)
+	})
+
+	return record, err
+}
+
+func (s *BoltStore) List() ([]ingest.TaskRecord, error) {
+	var records []ingest.TaskRecord
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tasksBucket).ForEach(func(k, v []byte) error {
+			var record ingest.TaskRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return fmt.Errorf("decode task %s: %w", k, err)
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+
+	return records, err
+}
+
+func (s *BoltStore) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tasksBucket).Delete([]byte(id))
+	})
+}
+
+// Archive moves the record from the active tasks to the archive in one transaction
+func (s *BoltStore) Archive(record ingest.TaskRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(archiveBucket).Put([]byte(record.Id), data); err != nil {
+			return err
+		}
+		return tx.Bucket(tasksBucket).Delete([]byte(record.Id))
+	})
+}
+
+func (s *BoltStore) GetArchived(id string) (ingest.TaskRecord, error) {
+	var record ingest.TaskRecord
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(archiveBucket).Get([]byte(id))
+		if data == nil {
+			return ingest.ErrTaskNotFound
+		}
+		return json.Unmarshal(data, &record)
+	})
+
+	return record, err
+}
+
+// DeleteArchived purges an archived record, ErrTaskNotFound if there is none
+func (s *BoltStore) DeleteArchived(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		archive := tx.Bucket(archiveBucket)
+		if archive.Get([]byte(id)) == nil {
+			return ingest.ErrTaskNotFound
+		}
+		return archive.Delete([]byte(id))
+	})
+}
+
+func (s *BoltStore) SaveDeadLetter(letter webhook.DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(deadLettersBucket).Put([]byte(letter.Id), data)
+	})
+}
+
+func (s *BoltStore) GetDeadLetter(id string) (webhook.DeadLetter, error) {
+	var letter webhook.DeadLetter
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(deadLettersBucket).Get([]byte(id))
+		if data == nil {
+			return webhook.ErrDeadLetterNotFound
+		}
+		return json.Unmarshal(data, &letter)
+	})
+
+	return letter, err
+}
+
+func (s *BoltStore) ListDeadLetters() ([]webhook.DeadLetter, error) {
+	letters := []webhook.DeadLetter{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deadLettersBucket).ForEach(func(k, v []byte) error {
+			var letter webhook.DeadLetter
+			if err := json.Unmarshal(v, &letter); err != nil {
+				return fmt.Errorf("decode dead letter %s: %w", k, err)
+			}
+			letters = append(letters, letter)
+			return nil
+		})
+	})
+
+	return letters, err
+}
+
+func (s *BoltStore) DeleteDeadLetter(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(deadLettersBucket).Delete([]byte(id))
+	})
+}
+
+func (s *BoltStore) SaveProfile(profile transcode.Profile) error {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(profilesBucket).Put([]byte(profile.Name), data)
+	})
+}
+
+func (s *BoltStore) ListProfiles() ([]transcode.Profile, error) {
+	var profiles []transcode.Profile
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(profilesBucket).ForEach(func(k, v []byte) error {
+			var profile transcode.Profile
+			if err := json.Unmarshal(v, &profile); err != nil {
+				return fmt.Errorf("decode profile %s: %w", k, err)
+			}
+			profiles = append(profiles, profile)
+			return nil
+		})
+	})
+
+	return profiles, err
+}
+
+func (s *BoltStore) DeleteProfile(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(profilesBucket).Delete([]byte(name))
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
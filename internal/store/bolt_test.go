@@ -0,0 +1,157 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vijayvenkatj/LiveTran/internal/ingest"
+	"github.com/vijayvenkatj/LiveTran/internal/webhook"
+)
+
+func openStore(t *testing.T, path string) *BoltStore {
+	t.Helper()
+	s, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestBoltTasks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "livetran.db")
+	s := openStore(t, path)
+
+	start := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	for _, record := range []ingest.TaskRecord{
+		{Id: "a", Status: ingest.StreamInit, StartTime: start},
+		{Id: "b", Status: ingest.StreamLive, Protocol: ingest.ProtocolRTMP, Webhooks: []string{"https://example.com/hook"}, StartTime: start},
+	} {
+		if err := s.Save(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Saving again replaces the record
+	if err := s.Save(ingest.TaskRecord{Id: "a", Status: ingest.StreamReady, StartTime: start}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The records are in the file, not just the open database
+	s.Close()
+	s = openStore(t, path)
+	defer s.Close()
+
+	record, err := s.Get("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != ingest.StreamLive || record.Protocol != ingest.ProtocolRTMP || len(record.Webhooks) != 1 || !record.StartTime.Equal(start) {
+		t.Errorf("Get(b) = %+v", record)
+	}
+	if record, _ := s.Get("a"); record.Status != ingest.StreamReady {
+		t.Errorf("Get(a) is %s, want the status saved last", record.Status)
+	}
+	if _, err := s.Get("c"); !errors.Is(err, ingest.ErrTaskNotFound) {
+		t.Errorf("Get of an unknown task = %v, want ErrTaskNotFound", err)
+	}
+
+	records, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Id != "a" || records[1].Id != "b" {
+		t.Errorf("List = %+v, want a and b", records)
+	}
+
+	if err := s.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("a"); !errors.Is(err, ingest.ErrTaskNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrTaskNotFound", err)
+	}
+	if records, _ := s.List(); len(records) != 1 {
+		t.Errorf("List after Delete = %+v, want b only", records)
+	}
+}
+
+func TestBoltArchive(t *testing.T) {
+	s := openStore(t, filepath.Join(t.TempDir(), "livetran.db"))
+	defer s.Close()
+
+	if err := s.Save(ingest.TaskRecord{Id: "a", Status: ingest.StreamLive}); err != nil {
+		t.Fatal(err)
+	}
+	end := time.Date(2026, 1, 2, 16, 0, 0, 0, time.UTC)
+	if err := s.Archive(ingest.TaskRecord{Id: "a", Status: ingest.StreamEnded, EndTime: end}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moved, not copied
+	if _, err := s.Get("a"); !errors.Is(err, ingest.ErrTaskNotFound) {
+		t.Errorf("Get of an archived task = %v, want ErrTaskNotFound", err)
+	}
+	record, err := s.GetArchived("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != ingest.StreamEnded || !record.EndTime.Equal(end) {
+		t.Errorf("GetArchived(a) = %+v", record)
+	}
+	if _, err := s.GetArchived("b"); !errors.Is(err, ingest.ErrTaskNotFound) {
+		t.Errorf("GetArchived of an unknown task = %v, want ErrTaskNotFound", err)
+	}
+
+	if err := s.DeleteArchived("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetArchived("a"); !errors.Is(err, ingest.ErrTaskNotFound) {
+		t.Errorf("GetArchived after DeleteArchived = %v, want ErrTaskNotFound", err)
+	}
+	if err := s.DeleteArchived("a"); !errors.Is(err, ingest.ErrTaskNotFound) {
+		t.Errorf("second DeleteArchived = %v, want ErrTaskNotFound", err)
+	}
+}
+
+func TestBoltDeadLetters(t *testing.T) {
+	s := openStore(t, filepath.Join(t.TempDir(), "livetran.db"))
+	defer s.Close()
+
+	if letters, err := s.ListDeadLetters(); err != nil || letters == nil || len(letters) != 0 {
+		t.Errorf("ListDeadLetters of an empty store = %v, %v, want an empty list", letters, err)
+	}
+
+	failed := time.Date(2026, 1, 2, 17, 0, 0, 0, time.UTC)
+	letter := webhook.DeadLetter{
+		Id:        "dl_1",
+		EventId:   "evt_1",
+		StreamId:  "a",
+		URL:       "https://example.com/hook",
+		Payload:   []byte(`{"type":"stream.live"}`),
+		Attempts:  5,
+		LastError: "unexpected status code 500",
+		FailedAt:  failed,
+	}
+	if err := s.SaveDeadLetter(letter); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetDeadLetter("dl_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.EventId != letter.EventId || got.StreamId != letter.StreamId || got.URL != letter.URL || string(got.Payload) != string(letter.Payload) ||
+		got.Attempts != letter.Attempts || got.LastError != letter.LastError || !got.FailedAt.Equal(failed) {
+		t.Errorf("GetDeadLetter = %+v, want %+v", got, letter)
+	}
+	if letters, _ := s.ListDeadLetters(); len(letters) != 1 || letters[0].Id != "dl_1" {
+		t.Errorf("ListDeadLetters = %+v, want dl_1", letters)
+	}
+
+	if err := s.DeleteDeadLetter("dl_1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetDeadLetter("dl_1"); !errors.Is(err, webhook.ErrDeadLetterNotFound) {
+		t.Errorf("GetDeadLetter after DeleteDeadLetter = %v, want ErrDeadLetterNotFound", err)
+	}
+}
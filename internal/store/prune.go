@@ -0,0 +1,133 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/vijayvenkatj/LiveTran/internal/config"
+	bolt "go.etcd.io/bbolt"
+)
+
+// PruneConfig bounds the archive and the dead letters, which otherwise grow with every stream and every failed delivery.
+// A limit of 0 entries keeps any number.
+type PruneConfig struct {
+	ArchiveMaxAge    time.Duration // Since the stream ended
+	MaxArchived      int
+	DeadLetterMaxAge time.Duration // Since the last attempt failed
+	MaxDeadLetters   int
+	Interval         time.Duration
+}
+
+func PruneConfigFromEnv() PruneConfig {
+	return PruneConfig{
+		ArchiveMaxAge:    config.EnvDuration("ARCHIVE_MAX_AGE", 30*24*time.Hour),
+		MaxArchived:      config.EnvNonNegativeInt("ARCHIVE_MAX_ENTRIES", 10000),
+		DeadLetterMaxAge: config.EnvDuration("DEAD_LETTER_MAX_AGE", 7*24*time.Hour),
+		MaxDeadLetters:   config.EnvNonNegativeInt("DEAD_LETTER_MAX_ENTRIES", 10000),
+		Interval:         config.EnvDuration("STORE_PRUNE_INTERVAL", 10*time.Minute),
+	}
+}
+
+// StartPruning prunes the store right away and then every cfg.Interval. The returned function stops it.
+func (s *BoltStore) StartPruning(cfg PruneConfig) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	slog.Info("Store pruning started", "archive_max_age", cfg.ArchiveMaxAge, "max_archived", cfg.MaxArchived,
+		"dead_letter_max_age", cfg.DeadLetterMaxAge, "max_dead_letters", cfg.MaxDeadLetters, "interval", cfg.Interval)
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+
+		now := time.Now()
+		for {
+			archived, letters, err := s.Prune(cfg, now)
+			if err != nil {
+				slog.Error("Failed to prune store", "error", err)
+			} else if archived > 0 || letters > 0 {
+				slog.Info("Pruned store", "archived_tasks", archived, "dead_letters", letters)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case now = <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// Prune deletes the archived tasks and dead letters older than the limits of cfg, then the oldest of those
+// beyond the maximum count. It returns how many of each it deleted.
+func (s *BoltStore) Prune(cfg PruneConfig, now time.Time) (archived int, letters int, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		archived, err = prune(tx.Bucket(archiveBucket), now.Add(-cfg.ArchiveMaxAge), cfg.MaxArchived, func(v []byte) time.Time {
+			var record struct {
+				EndTime   time.Time `json:"end_time"`
+				UpdatedAt time.Time `json:"updated_at"`
+			}
+			_ = json.Unmarshal(v, &record)
+			if record.EndTime.IsZero() {
+				return record.UpdatedAt
+			}
+			return record.EndTime
+		})
+		if err != nil {
+			return err
+		}
+
+		letters, err = prune(tx.Bucket(deadLettersBucket), now.Add(-cfg.DeadLetterMaxAge), cfg.MaxDeadLetters, func(v []byte) time.Time {
+			var letter struct {
+				FailedAt time.Time `json:"failed_at"`
+			}
+			_ = json.Unmarshal(v, &letter)
+			return letter.FailedAt
+		})
+		return err
+	})
+	return archived, letters, err
+}
+
+// prune deletes the entries of bucket from before cutoff, then the oldest beyond max.
+// Entries that cannot be decoded have no time and go first.
+func prune(bucket *bolt.Bucket, cutoff time.Time, max int, at func(v []byte) time.Time) (int, error) {
+	type entry struct {
+		key []byte
+		at  time.Time
+	}
+
+	var entries []entry
+	err := bucket.ForEach(func(k, v []byte) error {
+		entries = append(entries, entry{key: slices.Clone(k), at: at(v)})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	slices.SortFunc(entries, func(a, b entry) int {
+		return a.at.Compare(b.at)
+	})
+
+	deleted := 0
+	for i, e := range entries {
+		if !e.at.Before(cutoff) && (max == 0 || len(entries)-i <= max) {
+			break
+		}
+		if err := bucket.Delete(e.key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
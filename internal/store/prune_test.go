@@ -0,0 +1,62 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vijayvenkatj/LiveTran/internal/ingest"
+	"github.com/vijayvenkatj/LiveTran/internal/webhook"
+)
+
+func TestPrune(t *testing.T) {
+	s, err := NewBoltStore(filepath.Join(t.TempDir(), "livetran.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	now := time.Now()
+	// Ended 1 to 5 days ago
+	for i := 1; i <= 5; i++ {
+		record := ingest.TaskRecord{Id: fmt.Sprintf("s%d", i), Status: ingest.StreamEnded, EndTime: now.Add(-time.Duration(i) * 24 * time.Hour)}
+		if err := s.Archive(record); err != nil {
+			t.Fatal(err)
+		}
+		letter := webhook.DeadLetter{Id: fmt.Sprintf("dl_%d", i), FailedAt: now.Add(-time.Duration(i) * time.Hour)}
+		if err := s.SaveDeadLetter(letter); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := PruneConfig{ArchiveMaxAge: 60 * time.Hour, MaxArchived: 10, DeadLetterMaxAge: time.Hour * 24, MaxDeadLetters: 2}
+	archived, letters, err := s.Prune(cfg, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archived != 3 || letters != 3 {
+		t.Errorf("Prune deleted %d archived tasks and %d dead letters, want 3 and 3", archived, letters)
+	}
+
+	// Ended over 60h ago, s3 to s5 are gone
+	for i := 1; i <= 5; i++ {
+		_, err := s.GetArchived(fmt.Sprintf("s%d", i))
+		if kept := err == nil; kept != (i < 3) {
+			t.Errorf("archived s%d kept = %v", i, kept)
+		}
+	}
+	// Only the 2 newest dead letters are kept
+	remaining, err := s.ListDeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 2 {
+		t.Fatalf("dead letters = %+v, want 2", remaining)
+	}
+	for _, letter := range remaining {
+		if letter.Id != "dl_1" && letter.Id != "dl_2" {
+			t.Errorf("kept dead letter %s, want the newest", letter.Id)
+		}
+	}
+}
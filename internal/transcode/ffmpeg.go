@@ -0,0 +1,213 @@
+package transcode
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// HLSOutput is where an FFmpeg run writes the HLS output of a profile
+type HLSOutput struct {
+	Name        string // Prefix of every file, the stream id
+	SegmentDir  string // Not used by Low-Latency HLS, its parts are written to PlaylistDir
+	PlaylistDir string // Media playlists and the master playlist
+	StartNumber int    // Number of the run's first segment, runs carry on from each other
+	ListSize    int    // Segments kept in the media playlists
+}
+
+// Layout is the variants of a stream's output, fitted to its first source. Every FFmpeg run of the stream
+// writes them, whatever a later publisher or the slate sends, so the master playlist never changes.
+type Layout struct {
+	Ladder []Rendition
+	Video  bool
+	Audio  bool
+}
+
+// Layout fits the profile to the first source of a stream, see Ladder
+func (p *Profile) Layout(src *Source, mode Mode) Layout {
+	return Layout{Ladder: p.Ladder(src, mode), Video: src.hasVideo(), Audio: src.hasAudio()}
+}
+
+// FFmpegArgs are the FFmpeg options that encode the input as the profile says and package it to out.
+// They follow the input options. The variants are those of layout, src is what the run's input sends:
+// a passthrough rendition is encoded at the first source's size when src cannot be copied.
+// Whether there is a master playlist is up to the profile alone, so the playback URL does not depend on the source.
+func (p *Profile) FFmpegArgs(out HLSOutput, layout Layout, src *Source, mode Mode) []string {
+	return append(p.encodeArgs(layout, src, mode), p.hlsArgs(out, layout)...)
+}
+
+func (p *Profile) encodeArgs(layout Layout, src *Source, mode Mode) []string {
+	var args []string
+
+	ladder := layout.Ladder
+	passthrough := len(ladder) > 0 && ladder[0].Passthrough && src.copiesVideo(mode)
+	switch {
+	case !layout.Video:
+		args = append(args, "-vn")
+	case passthrough && len(ladder) == 1:
+		// Nothing to encode, the publisher's video is only segmented
+	default:
+		args = append(args, "-c:v", videoEncoders[p.Video.Codec], "-preset", p.Video.Preset)
+		if p.Video.Tune != "none" {
+			args = append(args, "-tune", p.Video.Tune)
+		}
+
+		// Keyframes at fixed times line up across renditions, scene cuts would add some of their own.
+		// At a known frame rate that is a fixed GOP, otherwise they are forced by timestamp.
+		// Below a passthrough they follow the publisher's keyframes, where its segments are cut.
+		switch frames := p.gopFrames(src); {
+		case passthrough:
+			args = append(args, "-force_key_frames", "source")
+		case frames > 0:
+			args = append(args, "-g", strconv.Itoa(frames), "-keyint_min", strconv.Itoa(frames))
+		default:
+			args = append(args, "-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%s)", formatSeconds(p.GopSeconds)))
+		}
+		args = append(args, "-sc_threshold", "0")
+	}
+
+	for i, r := range ladder {
+		// A single playlist takes FFmpeg's pick of streams
+		if p.MasterPlaylist() {
+			args = append(args, "-map", "0:v:0")
+			if layout.Audio && !p.DASH() {
+				args = append(args, "-map", "0:a:0")
+			}
+		}
+
+		v := fmt.Sprintf(":v:%d", i)
+		if r.Passthrough && passthrough {
+			args = append(args, "-c"+v, "copy")
+			continue
+		}
+		if filter := r.scaleFilter(); filter != "" {
+			args = append(args, "-filter"+v, filter)
+		}
+		if r.VideoBitrateKbps > 0 {
+			args = append(args, "-b"+v, kbps(r.VideoBitrateKbps))
+		} else {
+			args = append(args, "-crf"+v, strconv.Itoa(p.Video.Crf))
+		}
+		if r.MaxBitrateKbps > 0 {
+			args = append(args, "-maxrate"+v, kbps(r.MaxBitrateKbps), "-bufsize"+v, kbps(2*r.MaxBitrateKbps))
+		}
+	}
+
+	if !layout.Audio {
+		return append(args, "-an")
+	}
+	// DASH players want the audio in a representation of its own, the video variants share it
+	if p.MasterPlaylist() && (len(ladder) == 0 || p.DASH()) {
+		args = append(args, "-map", "0:a:0")
+	}
+	if src.copiesAudio(mode) {
+		return append(args, "-c:a", "copy")
+	}
+	return append(args,
+		"-c:a", audioEncoders[p.Audio.Codec],
+		"-b:a", kbps(p.Audio.BitrateKbps),
+		"-ar", strconv.Itoa(p.Audio.SampleRate),
+		"-ac", strconv.Itoa(p.Audio.Channels),
+	)
+}
+
+func (p *Profile) hlsArgs(out HLSOutput, layout Layout) []string {
+	args := []string{
+		"-f", "hls",
+		"-hls_list_size", strconv.Itoa(out.ListSize),
+		"-hls_allow_cache", "1",
+		"-start_number", strconv.Itoa(out.StartNumber),
+	}
+
+	variant := ""
+	if p.MasterPlaylist() {
+		variant = "_%v"
+	}
+
+	segments := filepath.Join(out.SegmentDir, out.Name+variant+"_%03d.ts")
+	switch {
+	case p.LowLatency():
+		// FFmpeg writes fMP4 parts next to its playlists, cut by time rather than at keyframes.
+		// Whoever runs it publishes them and puts them together into segments.
+		segments = filepath.Join(out.PlaylistDir, out.Name+variant+"_%05d.m4s")
+		args = append(args,
+			"-hls_time", formatSeconds(p.Packaging.PartSeconds),
+			"-hls_flags", "split_by_time+omit_endlist",
+			"-hls_segment_type", "fmp4",
+			"-hls_fmp4_init_filename", out.Name+variant+"_init.mp4",
+		)
+	case p.DASH():
+		// The initialization section is written next to the playlist, whoever runs FFmpeg publishes it
+		segments = filepath.Join(out.SegmentDir, out.Name+variant+"_%03d.m4s")
+		args = append(args,
+			"-hls_time", strconv.Itoa(p.Packaging.SegmentSeconds),
+			"-hls_flags", "delete_segments+independent_segments+omit_endlist",
+			"-hls_segment_type", "fmp4",
+			"-hls_fmp4_init_filename", out.Name+variant+"_init.mp4",
+		)
+	default:
+		args = append(args,
+			"-hls_time", strconv.Itoa(p.Packaging.SegmentSeconds),
+			"-hls_flags", "delete_segments+independent_segments+omit_endlist", // the stream only ends with the task
+			"-hls_segment_type", "mpegts",
+		)
+	}
+
+	if p.MasterPlaylist() {
+		// Every variant carries the audio, an audio-only source is a single variant.
+		// With DASH the audio is the last variant, in a group the others play along with.
+		var streams []string
+		for i := range layout.Ladder {
+			stream := fmt.Sprintf("v:%d", i)
+			switch {
+			case !layout.Audio:
+			case p.DASH():
+				stream += ",agroup:audio"
+			default:
+				stream += fmt.Sprintf(",a:%d", i)
+			}
+			streams = append(streams, stream)
+		}
+		switch {
+		case len(layout.Ladder) == 0:
+			streams = append(streams, "a:0")
+		case p.DASH() && layout.Audio:
+			streams = append(streams, "a:0,agroup:audio")
+		}
+		args = append(args, "-var_stream_map", strings.Join(streams, " "), "-master_pl_name", out.Name+"_master.m3u8")
+	}
+
+	return append(args,
+		"-hls_segment_filename", segments,
+		filepath.Join(out.PlaylistDir, out.Name+variant+".m3u8"),
+	)
+}
+
+// scaleFilter scales the source to the rendition. With both sizes the source is fitted into the box
+// at its own aspect ratio, with one the other follows the aspect ratio. Sizes stay even for the encoder.
+func (r Rendition) scaleFilter() string {
+	switch {
+	case r.Width > 0 && r.Height > 0:
+		return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease:force_divisible_by=2", r.Width, r.Height)
+	case r.Width > 0 || r.Height > 0:
+		return fmt.Sprintf("scale=%d:%d", scaleSize(r.Width), scaleSize(r.Height))
+	}
+	return ""
+}
+
+// scaleSize is a dimension of the scale filter, -2 keeps the aspect ratio at an even size
+func scaleSize(size int) int {
+	if size == 0 {
+		return -2
+	}
+	return size
+}
+
+func kbps(n int) string {
+	return strconv.Itoa(n) + "k"
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', -1, 64)
+}
@@ -0,0 +1,238 @@
+package transcode
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// prepared is a profile with its defaults filled in, as the registry hands them out
+func prepared(t *testing.T, p Profile) *Profile {
+	t.Helper()
+	if err := p.prepare(); err != nil {
+		t.Fatalf("prepare %s: %v", p.Name, err)
+	}
+	return &p
+}
+
+func builtin(t *testing.T, name string) *Profile {
+	t.Helper()
+	p, err := BuiltinRegistry().Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestFFmpegArgs(t *testing.T) {
+	out := HLSOutput{Name: "s", SegmentDir: "seg", PlaylistDir: "pl", StartNumber: 7, ListSize: 6}
+	hd := &Source{Width: 1920, Height: 1080, FrameRate: 30, Video: true, Audio: true, VideoCodec: "h264", AudioCodec: "aac"}
+
+	x264 := []string{"-c:v", "libx264", "-preset", "veryfast", "-tune", "zerolatency"}
+	aac := []string{"-c:a", "aac", "-b:a", "128k", "-ar", "48000", "-ac", "2"}
+	hls := []string{"-f", "hls", "-hls_list_size", "6", "-hls_allow_cache", "1", "-start_number", "7"}
+	mpegts := []string{"-hls_time", "4", "-hls_flags", "delete_segments+independent_segments+omit_endlist", "-hls_segment_type", "mpegts"}
+	abrLadder := []string{
+		"-map", "0:v:0", "-map", "0:a:0", "-filter:v:0", "scale=1920:1080:force_original_aspect_ratio=decrease:force_divisible_by=2", "-b:v:0", "5000k",
+		"-map", "0:v:0", "-map", "0:a:0", "-filter:v:1", "scale=1280:720:force_original_aspect_ratio=decrease:force_divisible_by=2", "-b:v:1", "3000k",
+		"-map", "0:v:0", "-map", "0:a:0", "-filter:v:2", "scale=854:480:force_original_aspect_ratio=decrease:force_divisible_by=2", "-b:v:2", "1500k",
+	}
+	master := []string{"-master_pl_name", "s_master.m3u8", "-hls_segment_filename", "seg/s_%v_%03d.ts", "pl/s_%v.m3u8"}
+
+	tests := []struct {
+		name    string
+		profile *Profile
+		src     *Source
+		mode    Mode
+		want    [][]string
+	}{
+		{
+			name:    "default profile, unknown source",
+			profile: builtin(t, DefaultProfileName),
+			mode:    ModeTranscode,
+			want: [][]string{
+				x264, {"-force_key_frames", "expr:gte(t,n_forced*2)", "-sc_threshold", "0", "-crf:v:0", "23"}, aac,
+				hls, mpegts, {"-hls_segment_filename", "seg/s_%03d.ts", "pl/s.m3u8"},
+			},
+		},
+		{
+			name:    "abr profile, known frame rate",
+			profile: builtin(t, AbrProfileName),
+			src:     hd,
+			mode:    ModeTranscode,
+			want: [][]string{
+				x264, {"-g", "60", "-keyint_min", "60", "-sc_threshold", "0"}, abrLadder, aac,
+				hls, mpegts, {"-var_stream_map", "v:0,a:0 v:1,a:1 v:2,a:2"}, master,
+			},
+		},
+		{
+			name:    "abr profile, audio-only source",
+			profile: builtin(t, AbrProfileName),
+			src:     &Source{Audio: true, AudioCodec: "aac"},
+			mode:    ModeTranscode,
+			want: [][]string{
+				{"-vn", "-map", "0:a:0"}, aac,
+				hls, mpegts, {"-var_stream_map", "a:0"}, master,
+			},
+		},
+		{
+			name:    "default profile, video-only source",
+			profile: builtin(t, DefaultProfileName),
+			src:     &Source{Width: 1280, Height: 720, FrameRate: 25, Video: true, VideoCodec: "h264"},
+			mode:    ModeTranscode,
+			want: [][]string{
+				x264, {"-g", "50", "-keyint_min", "50", "-sc_threshold", "0", "-crf:v:0", "23", "-an"},
+				hls, mpegts, {"-hls_segment_filename", "seg/s_%03d.ts", "pl/s.m3u8"},
+			},
+		},
+		{
+			name:    "default profile, passthrough",
+			profile: builtin(t, DefaultProfileName),
+			src:     hd,
+			mode:    ModePassthrough,
+			want: [][]string{
+				{"-c:v:0", "copy", "-c:a", "copy"},
+				hls, mpegts, {"-hls_segment_filename", "seg/s_%03d.ts", "pl/s.m3u8"},
+			},
+		},
+		{
+			name:    "default profile, passthrough of a codec HLS cannot carry",
+			profile: builtin(t, DefaultProfileName),
+			src:     &Source{Width: 1280, Height: 720, Video: true, Audio: true, VideoCodec: "vp8", AudioCodec: "opus"},
+			mode:    ModePassthrough,
+			want: [][]string{
+				x264, {"-force_key_frames", "expr:gte(t,n_forced*2)", "-sc_threshold", "0", "-crf:v:0", "23"}, aac,
+				hls, mpegts, {"-hls_segment_filename", "seg/s_%03d.ts", "pl/s.m3u8"},
+			},
+		},
+		{
+			name:    "abr profile, passthrough on top of the smaller renditions",
+			profile: builtin(t, AbrProfileName),
+			src:     hd,
+			mode:    ModePassthrough,
+			want: [][]string{
+				x264, {"-force_key_frames", "source", "-sc_threshold", "0"},
+				{"-map", "0:v:0", "-map", "0:a:0", "-c:v:0", "copy"},
+				{"-map", "0:v:0", "-map", "0:a:0", "-filter:v:1", "scale=1280:720:force_original_aspect_ratio=decrease:force_divisible_by=2", "-b:v:1", "3000k"},
+				{"-map", "0:v:0", "-map", "0:a:0", "-filter:v:2", "scale=854:480:force_original_aspect_ratio=decrease:force_divisible_by=2", "-b:v:2", "1500k"},
+				{"-c:a", "copy"},
+				hls, mpegts, {"-var_stream_map", "v:0,a:0 v:1,a:1 v:2,a:2"}, master,
+			},
+		},
+		{
+			name: "width-only rendition with a capped bitrate",
+			profile: prepared(t, Profile{
+				Name:       "capped",
+				Renditions: []Rendition{{Width: 640, VideoBitrateKbps: 800, MaxBitrateKbps: 1000}},
+				Video:      Video{Codec: "hevc", Preset: "fast", Tune: "none"},
+				GopSeconds: 1,
+			}),
+			mode: ModeTranscode,
+			want: [][]string{
+				{"-c:v", "libx265", "-preset", "fast", "-force_key_frames", "expr:gte(t,n_forced*1)", "-sc_threshold", "0"},
+				{"-filter:v:0", "scale=640:-2", "-b:v:0", "800k", "-maxrate:v:0", "1000k", "-bufsize:v:0", "2000k"},
+				aac, hls, mpegts, {"-hls_segment_filename", "seg/s_%03d.ts", "pl/s.m3u8"},
+			},
+		},
+		{
+			name: "ll-hls",
+			profile: prepared(t, Profile{
+				Name:       "low",
+				Renditions: []Rendition{{Name: "source"}},
+				Packaging:  Packaging{Format: FormatLLHLS, PartSeconds: 0.5},
+			}),
+			mode: ModeTranscode,
+			want: [][]string{
+				x264, {"-force_key_frames", "expr:gte(t,n_forced*2)", "-sc_threshold", "0", "-crf:v:0", "23"}, aac,
+				hls, {"-hls_time", "0.5", "-hls_flags", "split_by_time+omit_endlist", "-hls_segment_type", "fmp4", "-hls_fmp4_init_filename", "s_init.mp4"},
+				{"-hls_segment_filename", "pl/s_%05d.m4s", "pl/s.m3u8"},
+			},
+		},
+		{
+			name: "cmaf, audio in a group of its own",
+			profile: prepared(t, Profile{
+				Name:       "dash",
+				Renditions: []Rendition{{Height: 720, VideoBitrateKbps: 3000}, {Height: 360, VideoBitrateKbps: 800}},
+				Packaging:  Packaging{Format: FormatCMAF},
+			}),
+			src:  hd,
+			mode: ModeTranscode,
+			want: [][]string{
+				x264, {"-g", "60", "-keyint_min", "60", "-sc_threshold", "0"},
+				{"-map", "0:v:0", "-filter:v:0", "scale=-2:720", "-b:v:0", "3000k"},
+				{"-map", "0:v:0", "-filter:v:1", "scale=-2:360", "-b:v:1", "800k"},
+				{"-map", "0:a:0"}, aac,
+				hls, {"-hls_time", "4", "-hls_flags", "delete_segments+independent_segments+omit_endlist", "-hls_segment_type", "fmp4", "-hls_fmp4_init_filename", "s_%v_init.mp4"},
+				{"-var_stream_map", "v:0,agroup:audio v:1,agroup:audio a:0,agroup:audio"},
+				{"-master_pl_name", "s_master.m3u8", "-hls_segment_filename", "seg/s_%v_%03d.m4s", "pl/s_%v.m3u8"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.profile.FFmpegArgs(out, tt.profile.Layout(tt.src, tt.mode), tt.src, tt.mode)
+			if want := slices.Concat(tt.want...); !slices.Equal(got, want) {
+				t.Errorf("FFmpegArgs\n got: %s\nwant: %s", strings.Join(got, " "), strings.Join(want, " "))
+			}
+		})
+	}
+}
+
+// The slate follows a publisher the ladder was trimmed for, it must write the same variants
+func TestFFmpegArgsKeepLayout(t *testing.T) {
+	out := HLSOutput{Name: "s", SegmentDir: "seg", PlaylistDir: "pl", StartNumber: 7, ListSize: 6}
+	hd := &Source{Width: 1280, Height: 720, FrameRate: 30, Video: true, VideoCodec: "h264"}
+	slate := &Source{FrameRate: 25, Video: true, Audio: true}
+	abr := builtin(t, AbrProfileName)
+
+	tests := []struct {
+		name string
+		mode Mode
+		want []string
+	}{
+		{
+			name: "trimmed ladder",
+			mode: ModeTranscode,
+			want: []string{
+				"-map", "0:v:0", "-filter:v:0", "scale=1280:720:force_original_aspect_ratio=decrease:force_divisible_by=2", "-b:v:0", "3000k",
+				"-map", "0:v:0", "-filter:v:1", "scale=854:480:force_original_aspect_ratio=decrease:force_divisible_by=2", "-b:v:1", "1500k",
+				"-an",
+			},
+		},
+		{
+			name: "passthrough encoded at the publisher's size",
+			mode: ModePassthrough,
+			want: []string{
+				"-map", "0:v:0", "-filter:v:0", "scale=1280:720:force_original_aspect_ratio=decrease:force_divisible_by=2", "-crf:v:0", "23",
+				"-map", "0:v:0", "-filter:v:1", "scale=854:480:force_original_aspect_ratio=decrease:force_divisible_by=2", "-b:v:1", "1500k",
+				"-an",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout := abr.Layout(hd, tt.mode)
+			if len(layout.Ladder) != 2 {
+				t.Fatalf("Ladder = %+v, want 2 renditions", layout.Ladder)
+			}
+
+			publisher := strings.Join(abr.FFmpegArgs(out, layout, hd, tt.mode), " ")
+			got := strings.Join(abr.FFmpegArgs(out, layout, slate, tt.mode), " ")
+			if want := strings.Join(tt.want, " "); !strings.Contains(got, want) {
+				t.Errorf("slate FFmpegArgs\n got: %s\nwant: %s", got, want)
+			}
+			if strings.Contains(got, "-force_key_frames source") {
+				t.Errorf("slate FFmpegArgs follow the keyframes of a copy: %s", got)
+			}
+
+			// Both runs write the same playlists
+			for _, args := range []string{publisher, got} {
+				if !strings.Contains(args, "-var_stream_map v:0 v:1 -master_pl_name s_master.m3u8") {
+					t.Errorf("FFmpegArgs variants: %s", args)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package transcode
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Mode is how the publisher's media gets to the renditions
+type Mode string
+
+const (
+	ModeTranscode   Mode = "transcode"   // Every rendition is encoded
+	ModePassthrough Mode = "passthrough" // The publisher's video is the top rendition as it is, the rest of the ladder is encoded below it
+)
+
+var ErrInvalidMode = errors.New("invalid stream mode")
+
+// Codecs HLS carries as they are, anything else is encoded even in passthrough
+var (
+	copyableVideo = []string{"h264", "hevc"}
+	copyableAudio = []string{"aac"}
+)
+
+// ParseMode validates a mode from a request, transcode is the default
+func ParseMode(value string) (Mode, error) {
+	switch mode := Mode(strings.ToLower(value)); mode {
+	case "", ModeTranscode:
+		return ModeTranscode, nil
+	case ModePassthrough:
+		return mode, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidMode, value)
+}
+
+// copiesVideo and copiesAudio report whether the source is passed through. Only codecs the probe
+// found are copied, an unknown one might not fit in the segments.
+func (src *Source) copiesVideo(mode Mode) bool {
+	return mode == ModePassthrough && src != nil && src.Video && slices.Contains(copyableVideo, src.VideoCodec)
+}
+
+func (src *Source) copiesAudio(mode Mode) bool {
+	return mode == ModePassthrough && src != nil && src.Audio && slices.Contains(copyableAudio, src.AudioCodec)
+}
+
+// passthroughLadder puts the publisher's video on top of the encoded renditions smaller than it.
+// Without a known size the largest rendition is taken to be the source's and left out.
+// A profile with a single rendition is the publisher's video alone. The passthrough has the source's size,
+// a later input that cannot be copied is encoded to it.
+func (p *Profile) passthroughLadder(ladder []Rendition, src *Source) []Rendition {
+	rungs := []Rendition{{Name: "passthrough", Width: src.Width, Height: src.Height, Passthrough: true}}
+	if !p.MasterPlaylist() {
+		return rungs
+	}
+
+	// Renditions are ranked as if scaled from a 16:9 source when the real size is unknown
+	ref, limit := src, src.Width*src.Height
+	if limit == 0 {
+		ref = &Source{Width: 1920, Height: 1080}
+		for _, r := range ladder {
+			limit = max(limit, r.area(ref))
+		}
+	}
+
+	for _, r := range ladder {
+		// A rendition without a size is the source's, that is the passthrough
+		if (r.Width > 0 || r.Height > 0) && r.area(ref) < limit {
+			rungs = append(rungs, r)
+		}
+	}
+	return rungs
+}
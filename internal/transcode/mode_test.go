@@ -0,0 +1,51 @@
+package transcode
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Mode
+		valid bool
+	}{
+		{"", ModeTranscode, true},
+		{"transcode", ModeTranscode, true},
+		{"passthrough", ModePassthrough, true},
+		{"Passthrough", ModePassthrough, true},
+		{"copy", "", false},
+	}
+	for _, tt := range tests {
+		got, err := ParseMode(tt.value)
+		if tt.valid && (err != nil || got != tt.want) {
+			t.Errorf("ParseMode(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidMode) {
+			t.Errorf("ParseMode(%q) = %v, want %v", tt.value, err, ErrInvalidMode)
+		}
+	}
+}
+
+// Only codecs HLS segments carry are copied, and only in passthrough
+func TestPassthroughCopies(t *testing.T) {
+	tests := []struct {
+		name         string
+		src          *Source
+		mode         Mode
+		video, audio bool
+	}{
+		{"h264 and aac", &Source{Video: true, Audio: true, VideoCodec: "h264", AudioCodec: "aac"}, ModePassthrough, true, true},
+		{"hevc and opus", &Source{Video: true, Audio: true, VideoCodec: "hevc", AudioCodec: "opus"}, ModePassthrough, true, false},
+		{"vp8 and aac", &Source{Video: true, Audio: true, VideoCodec: "vp8", AudioCodec: "aac"}, ModePassthrough, false, true},
+		{"codecs the probe did not find", &Source{Video: true, Audio: true}, ModePassthrough, false, false},
+		{"unknown source", nil, ModePassthrough, false, false},
+		{"transcode", &Source{Video: true, Audio: true, VideoCodec: "h264", AudioCodec: "aac"}, ModeTranscode, false, false},
+	}
+	for _, tt := range tests {
+		if video, audio := tt.src.copiesVideo(tt.mode), tt.src.copiesAudio(tt.mode); video != tt.video || audio != tt.audio {
+			t.Errorf("%s: copies video %v and audio %v, want %v and %v", tt.name, video, audio, tt.video, tt.audio)
+		}
+	}
+}
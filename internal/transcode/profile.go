@@ -0,0 +1,281 @@
+package transcode
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// Limits of a profile, they keep a typo from asking FFmpeg for something no server can encode live
+const (
+	maxRenditions      = 8
+	maxDimension       = 7680
+	maxVideoBitrate    = 100000
+	minVideoBitrate    = 100
+	maxGopSeconds      = 10
+	minGopSeconds      = 0.5
+	maxSegmentSeconds  = 10
+	minPartSeconds     = 0.2
+	maxPartSeconds     = 2
+	minAudioBitrate    = 32
+	maxAudioBitrate    = 512
+	defaultCrf         = 23
+	defaultGopSeconds  = 2
+	defaultSegmentTime = 4
+	defaultPartSeconds = 1
+)
+
+var ErrInvalidProfile = errors.New("invalid transcoding profile")
+
+// Names end up in URLs and file names
+var profileName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+var renditionName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// FFmpeg encoders of the video codecs a profile can ask for
+var videoEncoders = map[string]string{
+	"h264": "libx264",
+	"hevc": "libx265",
+}
+
+var audioEncoders = map[string]string{
+	"aac": "aac",
+}
+
+var presets = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow"}
+
+// Tunes of each video codec's encoder, "none" leaves it untuned. x265 has no film or stillimage.
+var tunes = map[string][]string{
+	"h264": {"none", "zerolatency", "film", "animation", "grain", "stillimage", "fastdecode", "psnr", "ssim"},
+	"hevc": {"none", "zerolatency", "animation", "grain", "fastdecode", "psnr", "ssim"},
+}
+
+// Profile describes how a stream is transcoded and packaged: its renditions, codecs and segmenting
+type Profile struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Renditions  []Rendition `json:"renditions"`
+	Video       Video       `json:"video"`
+	Audio       Audio       `json:"audio"`
+	GopSeconds  float64     `json:"gop_seconds,omitempty"` // Keyframe interval of every rendition, aligned so players can switch at any segment
+	Packaging   Packaging   `json:"packaging"`
+}
+
+// Rendition is one variant of the output. Without a size it keeps the source's, without a bitrate it is encoded at constant quality.
+type Rendition struct {
+	Name             string `json:"name,omitempty"`
+	Width            int    `json:"width,omitempty"`  // 0 scales with the height
+	Height           int    `json:"height,omitempty"` // 0 scales with the width
+	VideoBitrateKbps int    `json:"video_bitrate_kbps,omitempty"`
+	MaxBitrateKbps   int    `json:"max_bitrate_kbps,omitempty"` // Caps the peaks, the decoder buffer holds twice as much
+	Passthrough      bool   `json:"passthrough,omitempty"`      // The publisher's video as it is, only ever set by the passthrough mode
+}
+
+type Video struct {
+	Codec  string `json:"codec,omitempty"`  // h264 (default) or hevc
+	Preset string `json:"preset,omitempty"` // veryfast by default
+	Tune   string `json:"tune,omitempty"`   // zerolatency by default
+	Crf    int    `json:"crf,omitempty"`    // Quality of the renditions without a bitrate, 23 by default
+}
+
+type Audio struct {
+	Codec       string `json:"codec,omitempty"` // aac
+	BitrateKbps int    `json:"bitrate_kbps,omitempty"`
+	SampleRate  int    `json:"sample_rate,omitempty"`
+	Channels    int    `json:"channels,omitempty"`
+}
+
+// Packaging formats
+const (
+	FormatHLS   = "hls"
+	FormatLLHLS = "ll-hls" // Low-Latency HLS, CMAF segments published part by part
+	FormatCMAF  = "cmaf"   // CMAF segments listed by an HLS master playlist and a DASH manifest alike
+)
+
+type Packaging struct {
+	Format         string  `json:"format,omitempty"` // hls (default), ll-hls or cmaf
+	SegmentSeconds int     `json:"segment_seconds,omitempty"`
+	PartSeconds    float64 `json:"part_seconds,omitempty"` // ll-hls only, 1 by default
+}
+
+// Built-in profiles, what LiveTran encoded before profiles existed
+const (
+	DefaultProfileName = "default"
+	AbrProfileName     = "abr"
+)
+
+func builtinProfiles() []*Profile {
+	return []*Profile{
+		{
+			Name:        DefaultProfileName,
+			Description: "Single rendition at the source resolution",
+			Renditions:  []Rendition{{Name: "source"}},
+		},
+		{
+			Name:        AbrProfileName,
+			Description: "1080p, 720p and 480p ladder",
+			Renditions: []Rendition{
+				{Name: "1080p", Width: 1920, Height: 1080, VideoBitrateKbps: 5000},
+				{Name: "720p", Width: 1280, Height: 720, VideoBitrateKbps: 3000},
+				{Name: "480p", Width: 854, Height: 480, VideoBitrateKbps: 1500},
+			},
+		},
+	}
+}
+
+// MasterPlaylist reports whether the output has a master playlist naming the renditions.
+// CMAF always has one, its audio is a rendition of its own.
+func (p *Profile) MasterPlaylist() bool {
+	return len(p.Renditions) > 1 || p.DASH()
+}
+
+// prepare fills in the defaults and validates the profile
+func (p *Profile) prepare() error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidProfile, fmt.Sprintf(format, args...))
+	}
+
+	if !profileName.MatchString(p.Name) {
+		return invalid("name must be 1-64 lowercase letters, digits, _ or -")
+	}
+
+	if len(p.Renditions) == 0 || len(p.Renditions) > maxRenditions {
+		return invalid("a profile has 1 to %d renditions", maxRenditions)
+	}
+	for i := range p.Renditions {
+		r := &p.Renditions[i]
+		if r.Name == "" {
+			r.Name = r.defaultName()
+		}
+		if !renditionName.MatchString(r.Name) {
+			return invalid("rendition %d: name must be letters, digits, _ or -", i)
+		}
+		if r.Passthrough {
+			return invalid("rendition %s: passthrough comes from the stream's mode, not the profile", r.Name)
+		}
+		for _, size := range []int{r.Width, r.Height} {
+			if size < 0 || size > maxDimension || size%2 != 0 {
+				return invalid("rendition %s: width and height must be even and at most %d", r.Name, maxDimension)
+			}
+		}
+		if r.VideoBitrateKbps != 0 && (r.VideoBitrateKbps < minVideoBitrate || r.VideoBitrateKbps > maxVideoBitrate) {
+			return invalid("rendition %s: video_bitrate_kbps must be between %d and %d", r.Name, minVideoBitrate, maxVideoBitrate)
+		}
+		if r.MaxBitrateKbps != 0 && (r.MaxBitrateKbps < r.VideoBitrateKbps || r.MaxBitrateKbps > maxVideoBitrate) {
+			return invalid("rendition %s: max_bitrate_kbps must be between video_bitrate_kbps and %d", r.Name, maxVideoBitrate)
+		}
+	}
+
+	if p.Video.Codec == "" {
+		p.Video.Codec = "h264"
+	}
+	if _, ok := videoEncoders[p.Video.Codec]; !ok {
+		return invalid("video codec must be h264 or hevc")
+	}
+	if p.Video.Preset == "" {
+		p.Video.Preset = "veryfast"
+	}
+	if !slices.Contains(presets, p.Video.Preset) {
+		return invalid("video preset must be one of %v", presets)
+	}
+	if p.Video.Tune == "" {
+		p.Video.Tune = "zerolatency"
+	}
+	if !slices.Contains(tunes[p.Video.Codec], p.Video.Tune) {
+		return invalid("video tune of %s must be one of %v", p.Video.Codec, tunes[p.Video.Codec])
+	}
+	if p.Video.Crf == 0 {
+		p.Video.Crf = defaultCrf
+	}
+	if p.Video.Crf < 0 || p.Video.Crf > 51 {
+		return invalid("video crf must be between 0 and 51")
+	}
+
+	if p.GopSeconds == 0 {
+		p.GopSeconds = defaultGopSeconds
+	}
+	if p.GopSeconds < minGopSeconds || p.GopSeconds > maxGopSeconds {
+		return invalid("gop_seconds must be between %g and %d", minGopSeconds, maxGopSeconds)
+	}
+
+	if p.Audio.Codec == "" {
+		p.Audio.Codec = "aac"
+	}
+	if _, ok := audioEncoders[p.Audio.Codec]; !ok {
+		return invalid("audio codec must be aac")
+	}
+	if p.Audio.BitrateKbps == 0 {
+		p.Audio.BitrateKbps = 128
+	}
+	if p.Audio.BitrateKbps < minAudioBitrate || p.Audio.BitrateKbps > maxAudioBitrate {
+		return invalid("audio bitrate_kbps must be between %d and %d", minAudioBitrate, maxAudioBitrate)
+	}
+	if p.Audio.SampleRate == 0 {
+		p.Audio.SampleRate = 48000
+	}
+	if p.Audio.SampleRate != 44100 && p.Audio.SampleRate != 48000 {
+		return invalid("audio sample_rate must be 44100 or 48000")
+	}
+	if p.Audio.Channels == 0 {
+		p.Audio.Channels = 2
+	}
+	if p.Audio.Channels != 1 && p.Audio.Channels != 2 {
+		return invalid("audio channels must be 1 or 2")
+	}
+
+	if p.Packaging.Format == "" {
+		p.Packaging.Format = FormatHLS
+	}
+	if !slices.Contains([]string{FormatHLS, FormatLLHLS, FormatCMAF}, p.Packaging.Format) {
+		return invalid("packaging format must be hls, ll-hls or cmaf")
+	}
+	if p.Packaging.SegmentSeconds == 0 {
+		p.Packaging.SegmentSeconds = defaultSegmentTime
+	}
+	if p.Packaging.SegmentSeconds > maxSegmentSeconds || float64(p.Packaging.SegmentSeconds) < p.GopSeconds {
+		return invalid("packaging segment_seconds must be between gop_seconds and %d", maxSegmentSeconds)
+	}
+
+	if p.LowLatency() {
+		if p.Packaging.PartSeconds == 0 {
+			p.Packaging.PartSeconds = defaultPartSeconds
+		}
+		if p.Packaging.PartSeconds < minPartSeconds || p.Packaging.PartSeconds > maxPartSeconds || 2*p.Packaging.PartSeconds > float64(p.Packaging.SegmentSeconds) {
+			return invalid("packaging part_seconds must be between %g and %d, and at most half of segment_seconds", minPartSeconds, maxPartSeconds)
+		}
+	} else if p.Packaging.PartSeconds != 0 {
+		return invalid("packaging part_seconds is for ll-hls only")
+	}
+	return nil
+}
+
+// LowLatency reports whether the output is Low-Latency HLS
+func (p *Profile) LowLatency() bool {
+	return p.Packaging.Format == FormatLLHLS
+}
+
+// DASH reports whether the output has a DASH manifest next to the HLS playlists, both listing the same CMAF segments
+func (p *Profile) DASH() bool {
+	return p.Packaging.Format == FormatCMAF
+}
+
+func (r Rendition) defaultName() string {
+	switch {
+	case r.Height > 0:
+		return fmt.Sprintf("%dp", r.Height)
+	case r.Width > 0:
+		return fmt.Sprintf("w%d", r.Width)
+	}
+	return "source"
+}
+
+// Clone copies the profile, tasks keep the one they started with whatever happens to the registry
+func (p *Profile) Clone() *Profile {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	c.Renditions = slices.Clone(p.Renditions)
+	return &c
+}
@@ -0,0 +1,95 @@
+package transcode
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPrepareDefaults(t *testing.T) {
+	p := prepared(t, Profile{Name: "plain", Renditions: []Rendition{{Height: 720}, {Width: 640}, {}}})
+
+	want := Profile{
+		Name:       "plain",
+		Renditions: []Rendition{{Name: "720p", Height: 720}, {Name: "w640", Width: 640}, {Name: "source"}},
+		Video:      Video{Codec: "h264", Preset: "veryfast", Tune: "zerolatency", Crf: 23},
+		Audio:      Audio{Codec: "aac", BitrateKbps: 128, SampleRate: 48000, Channels: 2},
+		GopSeconds: 2,
+		Packaging:  Packaging{Format: FormatHLS, SegmentSeconds: 4},
+	}
+	if p.Name != want.Name || p.Video != want.Video || p.Audio != want.Audio || p.GopSeconds != want.GopSeconds || p.Packaging != want.Packaging {
+		t.Errorf("prepare filled in %+v, want %+v", *p, want)
+	}
+	for i, r := range p.Renditions {
+		if r != want.Renditions[i] {
+			t.Errorf("rendition %d = %+v, want %+v", i, r, want.Renditions[i])
+		}
+	}
+
+	ll := prepared(t, Profile{Name: "ll", Renditions: []Rendition{{}}, Packaging: Packaging{Format: FormatLLHLS}})
+	if ll.Packaging.PartSeconds != 1 {
+		t.Errorf("ll-hls part_seconds = %g, want 1", ll.Packaging.PartSeconds)
+	}
+}
+
+func TestPrepareValidation(t *testing.T) {
+	source := []Rendition{{Name: "source"}}
+
+	tests := []struct {
+		name    string
+		profile Profile
+		valid   bool
+	}{
+		{"minimal", Profile{Name: "a", Renditions: source}, true},
+		{"empty name", Profile{Renditions: source}, false},
+		{"uppercase name", Profile{Name: "Sports", Renditions: source}, false},
+		{"name with a slash", Profile{Name: "a/b", Renditions: source}, false},
+		{"name of 65 characters", Profile{Name: strings.Repeat("a", 65), Renditions: source}, false},
+		{"no renditions", Profile{Name: "a"}, false},
+		{"too many renditions", Profile{Name: "a", Renditions: make([]Rendition, maxRenditions+1)}, false},
+		{"invalid rendition name", Profile{Name: "a", Renditions: []Rendition{{Name: "hd 720"}}}, false},
+		{"passthrough rendition", Profile{Name: "a", Renditions: []Rendition{{Passthrough: true}}}, false},
+		{"odd height", Profile{Name: "a", Renditions: []Rendition{{Height: 721}}}, false},
+		{"negative width", Profile{Name: "a", Renditions: []Rendition{{Width: -2}}}, false},
+		{"width over the limit", Profile{Name: "a", Renditions: []Rendition{{Width: maxDimension + 2}}}, false},
+		{"bitrate too low", Profile{Name: "a", Renditions: []Rendition{{VideoBitrateKbps: minVideoBitrate - 1}}}, false},
+		{"max bitrate below the bitrate", Profile{Name: "a", Renditions: []Rendition{{VideoBitrateKbps: 3000, MaxBitrateKbps: 2000}}}, false},
+		{"max bitrate without a bitrate", Profile{Name: "a", Renditions: []Rendition{{MaxBitrateKbps: 2000}}}, true},
+		{"hevc", Profile{Name: "a", Renditions: source, Video: Video{Codec: "hevc"}}, true},
+		{"vp9", Profile{Name: "a", Renditions: source, Video: Video{Codec: "vp9"}}, false},
+		{"unknown preset", Profile{Name: "a", Renditions: source, Video: Video{Preset: "fastest"}}, false},
+		{"untuned", Profile{Name: "a", Renditions: source, Video: Video{Tune: "none"}}, true},
+		{"unknown tune", Profile{Name: "a", Renditions: source, Video: Video{Tune: "sports"}}, false},
+		{"film tune", Profile{Name: "a", Renditions: source, Video: Video{Tune: "film"}}, true},
+		{"hevc film tune", Profile{Name: "a", Renditions: source, Video: Video{Codec: "hevc", Tune: "film"}}, false},
+		{"hevc stillimage tune", Profile{Name: "a", Renditions: source, Video: Video{Codec: "hevc", Tune: "stillimage"}}, false},
+		{"hevc grain tune", Profile{Name: "a", Renditions: source, Video: Video{Codec: "hevc", Tune: "grain"}}, true},
+		{"crf over 51", Profile{Name: "a", Renditions: source, Video: Video{Crf: 52}}, false},
+		{"gop too short", Profile{Name: "a", Renditions: source, GopSeconds: 0.25}, false},
+		{"gop too long", Profile{Name: "a", Renditions: source, GopSeconds: 11, Packaging: Packaging{SegmentSeconds: 10}}, false},
+		{"opus audio", Profile{Name: "a", Renditions: source, Audio: Audio{Codec: "opus"}}, false},
+		{"audio bitrate too high", Profile{Name: "a", Renditions: source, Audio: Audio{BitrateKbps: maxAudioBitrate + 1}}, false},
+		{"44.1 kHz audio", Profile{Name: "a", Renditions: source, Audio: Audio{SampleRate: 44100}}, true},
+		{"32 kHz audio", Profile{Name: "a", Renditions: source, Audio: Audio{SampleRate: 32000}}, false},
+		{"surround audio", Profile{Name: "a", Renditions: source, Audio: Audio{Channels: 6}}, false},
+		{"unknown format", Profile{Name: "a", Renditions: source, Packaging: Packaging{Format: "dash"}}, false},
+		{"segments shorter than the gop", Profile{Name: "a", Renditions: source, GopSeconds: 4, Packaging: Packaging{SegmentSeconds: 2}}, false},
+		{"segments too long", Profile{Name: "a", Renditions: source, Packaging: Packaging{SegmentSeconds: maxSegmentSeconds + 1}}, false},
+		{"parts outside ll-hls", Profile{Name: "a", Renditions: source, Packaging: Packaging{PartSeconds: 1}}, false},
+		{"parts too short", Profile{Name: "a", Renditions: source, Packaging: Packaging{Format: FormatLLHLS, PartSeconds: 0.1}}, false},
+		{"parts over half a segment", Profile{Name: "a", Renditions: source, Packaging: Packaging{Format: FormatLLHLS, SegmentSeconds: 2, PartSeconds: 1.5}}, false},
+		{"cmaf", Profile{Name: "a", Renditions: source, Packaging: Packaging{Format: FormatCMAF}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.profile.prepare()
+			if tt.valid && err != nil {
+				t.Errorf("prepare: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidProfile) {
+				t.Errorf("prepare = %v, want ErrInvalidProfile", err)
+			}
+		})
+	}
+}
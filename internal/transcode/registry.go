@@ -0,0 +1,255 @@
+package transcode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrUnknownProfile  = errors.New("unknown transcoding profile")
+	ErrProfileReadOnly = errors.New("transcoding profile is defined by the server configuration")
+)
+
+// Where a profile comes from. Only profiles created through the API can be replaced or deleted there.
+const (
+	SourceBuiltin = "builtin"
+	SourceConfig  = "config"
+	SourceAPI     = "api"
+)
+
+// ProfileStore persists the profiles created through the API
+type ProfileStore interface {
+	SaveProfile(profile Profile) error
+	ListProfiles() ([]Profile, error)
+	DeleteProfile(name string) error
+}
+
+type Config struct {
+	// JSON file with an array of profiles, they override built-in profiles of the same name
+	Path string
+	// Profile of streams that do not ask for one
+	Default string
+}
+
+func ConfigFromEnv() Config {
+	def := os.Getenv("TRANSCODE_DEFAULT_PROFILE")
+	if def == "" {
+		def = DefaultProfileName
+	}
+
+	return Config{
+		Path:    os.Getenv("TRANSCODE_PROFILES_PATH"),
+		Default: def,
+	}
+}
+
+// ProfileInfo is a profile as the API lists it
+type ProfileInfo struct {
+	Profile
+	Source  string `json:"source"`
+	Default bool   `json:"default,omitempty"`
+}
+
+type entry struct {
+	profile *Profile
+	source  string
+}
+
+// Registry holds the profiles streams can be started with
+type Registry struct {
+	store ProfileStore
+
+	mu       sync.Mutex
+	profiles map[string]entry
+	def      string
+}
+
+// NewRegistry loads the built-in profiles, those of the configuration file and the ones created through the API.
+// A store may be nil, API profiles then last until the process exits.
+func NewRegistry(cfg Config, store ProfileStore) (*Registry, error) {
+	reg := &Registry{
+		store:    store,
+		profiles: make(map[string]entry),
+		def:      cfg.Default,
+	}
+	if reg.def == "" {
+		reg.def = DefaultProfileName
+	}
+
+	for _, p := range builtinProfiles() {
+		if err := p.prepare(); err != nil {
+			return nil, err
+		}
+		reg.profiles[p.Name] = entry{profile: p, source: SourceBuiltin}
+	}
+
+	if cfg.Path != "" {
+		profiles, err := loadProfiles(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range profiles {
+			reg.profiles[p.Name] = entry{profile: p, source: SourceConfig}
+		}
+	}
+
+	if store != nil {
+		profiles, err := store.ListProfiles()
+		if err != nil {
+			return nil, fmt.Errorf("read stored profiles: %w", err)
+		}
+		for _, p := range profiles {
+			if existing, exists := reg.profiles[p.Name]; exists {
+				slog.Warn("Stored profile is shadowed by the configuration", "profile", p.Name, "source", existing.source)
+				continue
+			}
+			if err := p.prepare(); err != nil {
+				slog.Warn("Skipping invalid stored profile", "profile", p.Name, "error", err)
+				continue
+			}
+			reg.profiles[p.Name] = entry{profile: &p, source: SourceAPI}
+		}
+	}
+
+	if _, exists := reg.profiles[reg.def]; !exists {
+		return nil, fmt.Errorf("%w: default profile %q", ErrUnknownProfile, reg.def)
+	}
+	return reg, nil
+}
+
+// BuiltinRegistry only knows the built-in profiles
+func BuiltinRegistry() *Registry {
+	reg, err := NewRegistry(Config{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	return reg
+}
+
+func loadProfiles(path string) ([]*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read profiles: %w", err)
+	}
+
+	var profiles []*Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("decode profiles %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range profiles {
+		if err := p.prepare(); err != nil {
+			return nil, fmt.Errorf("profile %q in %s: %w", p.Name, path, err)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("profile %q is defined twice in %s", p.Name, path)
+		}
+		seen[p.Name] = true
+	}
+	return profiles, nil
+}
+
+// Get returns a copy of the named profile, the default one for an empty name
+func (reg *Registry) Get(name string) (*Profile, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if name == "" {
+		name = reg.def
+	}
+	e, exists := reg.profiles[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProfile, name)
+	}
+	return e.profile.Clone(), nil
+}
+
+// Info describes the named profile
+func (reg *Registry) Info(name string) (ProfileInfo, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	e, exists := reg.profiles[name]
+	if !exists {
+		return ProfileInfo{}, fmt.Errorf("%w: %q", ErrUnknownProfile, name)
+	}
+	return reg.info(e), nil
+}
+
+// List describes every profile, sorted by name
+func (reg *Registry) List() []ProfileInfo {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	infos := make([]ProfileInfo, 0, len(reg.profiles))
+	for _, e := range reg.profiles {
+		infos = append(infos, reg.info(e))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Must be called with reg.mu held
+func (reg *Registry) info(e entry) ProfileInfo {
+	return ProfileInfo{
+		Profile: *e.profile.Clone(),
+		Source:  e.source,
+		Default: e.profile.Name == reg.def,
+	}
+}
+
+// Save validates the profile and creates it, or replaces the one created through the API under its name.
+// Running streams keep the profile they started with.
+func (reg *Registry) Save(profile Profile) (ProfileInfo, error) {
+	p := profile.Clone()
+	if err := p.prepare(); err != nil {
+		return ProfileInfo{}, err
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if existing, exists := reg.profiles[p.Name]; exists && existing.source != SourceAPI {
+		return ProfileInfo{}, fmt.Errorf("%w: %q", ErrProfileReadOnly, p.Name)
+	}
+	if reg.store != nil {
+		if err := reg.store.SaveProfile(*p); err != nil {
+			return ProfileInfo{}, fmt.Errorf("save profile: %w", err)
+		}
+	}
+
+	e := entry{profile: p, source: SourceAPI}
+	reg.profiles[p.Name] = e
+	return reg.info(e), nil
+}
+
+// Delete removes a profile created through the API
+func (reg *Registry) Delete(name string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	e, exists := reg.profiles[name]
+	if !exists {
+		return fmt.Errorf("%w: %q", ErrUnknownProfile, name)
+	}
+	if e.source != SourceAPI {
+		return fmt.Errorf("%w: %q", ErrProfileReadOnly, name)
+	}
+	if name == reg.def {
+		return fmt.Errorf("%w: %q is the default profile", ErrProfileReadOnly, name)
+	}
+	if reg.store != nil {
+		if err := reg.store.DeleteProfile(name); err != nil {
+			return fmt.Errorf("delete profile: %w", err)
+		}
+	}
+
+	delete(reg.profiles, name)
+	return nil
+}
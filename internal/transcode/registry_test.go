@@ -0,0 +1,162 @@
+package transcode
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// memoryStore is a ProfileStore that keeps the profiles in a map
+type memoryStore map[string]Profile
+
+func (m memoryStore) SaveProfile(profile Profile) error {
+	m[profile.Name] = profile
+	return nil
+}
+
+func (m memoryStore) ListProfiles() ([]Profile, error) {
+	var profiles []Profile
+	for _, p := range m {
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+func (m memoryStore) DeleteProfile(name string) error {
+	delete(m, name)
+	return nil
+}
+
+func newTestRegistry(t *testing.T, def string, store ProfileStore) *Registry {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	config := `[{"name":"venue","renditions":[{"height":720}]},{"name":"abr","renditions":[{"height":480}]}]`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reg, err := NewRegistry(Config{Path: path, Default: def}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reg
+}
+
+func TestRegistrySources(t *testing.T) {
+	store := memoryStore{
+		"sports": {Name: "sports", Renditions: []Rendition{{Height: 1080}}},
+		"venue":  {Name: "venue", Renditions: []Rendition{{Height: 360}}}, // Shadowed by the configuration
+		"broken": {Name: "broken"},
+	}
+	reg := newTestRegistry(t, "", store)
+
+	tests := []struct {
+		name   string
+		source string
+		height int
+	}{
+		{DefaultProfileName, SourceBuiltin, 0},
+		{AbrProfileName, SourceConfig, 480},
+		{"venue", SourceConfig, 720},
+		{"sports", SourceAPI, 1080},
+	}
+	for _, tt := range tests {
+		info, err := reg.Info(tt.name)
+		if err != nil {
+			t.Errorf("Info(%s): %v", tt.name, err)
+			continue
+		}
+		if info.Source != tt.source || info.Renditions[0].Height != tt.height {
+			t.Errorf("Info(%s) = source %s, height %d, want %s, %d", tt.name, info.Source, info.Renditions[0].Height, tt.source, tt.height)
+		}
+	}
+
+	if _, err := reg.Info("broken"); !errors.Is(err, ErrUnknownProfile) {
+		t.Errorf("invalid stored profile was loaded: %v", err)
+	}
+	if p, _ := reg.Get(""); p.Name != DefaultProfileName {
+		t.Errorf("Get(\"\") = %s, want the default profile", p.Name)
+	}
+}
+
+func TestRegistryUnknownDefault(t *testing.T) {
+	if _, err := NewRegistry(Config{Default: "missing"}, nil); !errors.Is(err, ErrUnknownProfile) {
+		t.Errorf("NewRegistry = %v, want ErrUnknownProfile", err)
+	}
+}
+
+func TestRegistrySave(t *testing.T) {
+	store := memoryStore{}
+	reg := newTestRegistry(t, "", store)
+	sports := Profile{Name: "sports", Renditions: []Rendition{{Height: 720}}}
+
+	tests := []struct {
+		name    string
+		profile Profile
+		want    error
+	}{
+		{"new profile", sports, nil},
+		{"replaces an API profile", Profile{Name: "sports", Renditions: []Rendition{{Height: 1080}}}, nil},
+		{"built-in profile", Profile{Name: DefaultProfileName, Renditions: []Rendition{{}}}, ErrProfileReadOnly},
+		{"profile of the configuration", Profile{Name: "venue", Renditions: []Rendition{{}}}, ErrProfileReadOnly},
+		{"invalid profile", Profile{Name: "bad name"}, ErrInvalidProfile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := reg.Save(tt.profile)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Save = %v, want %v", err, tt.want)
+			}
+			if err == nil && (info.Source != SourceAPI || store[tt.profile.Name].Name != tt.profile.Name) {
+				t.Errorf("saved profile is %+v, stored %v", info, store[tt.profile.Name].Name != "")
+			}
+		})
+	}
+
+	if p, _ := reg.Get("sports"); p.Renditions[0].Height != 1080 {
+		t.Errorf("sports was not replaced, height %d", p.Renditions[0].Height)
+	}
+
+	// Streams keep the copy they started with
+	p, _ := reg.Get("sports")
+	p.Renditions[0].Height = 240
+	if again, _ := reg.Get("sports"); again.Renditions[0].Height != 1080 {
+		t.Errorf("Get handed out the registry's profile")
+	}
+}
+
+func TestRegistryDelete(t *testing.T) {
+	store := memoryStore{
+		"sports": {Name: "sports", Renditions: []Rendition{{Height: 720}}},
+		"main":   {Name: "main", Renditions: []Rendition{{Height: 720}}},
+	}
+	reg := newTestRegistry(t, "main", store)
+
+	tests := []struct {
+		name    string
+		profile string
+		want    error
+	}{
+		{"built-in profile", DefaultProfileName, ErrProfileReadOnly},
+		{"profile of the configuration", "venue", ErrProfileReadOnly},
+		{"default profile", "main", ErrProfileReadOnly},
+		{"unknown profile", "missing", ErrUnknownProfile},
+		{"API profile", "sports", nil},
+		{"deleted profile", "sports", ErrUnknownProfile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := reg.Delete(tt.profile); !errors.Is(err, tt.want) {
+				t.Errorf("Delete(%s) = %v, want %v", tt.profile, err, tt.want)
+			}
+		})
+	}
+
+	if _, stored := store["sports"]; stored {
+		t.Error("deleted profile is still stored")
+	}
+	if _, stored := store["main"]; !stored {
+		t.Error("default profile was removed from the store")
+	}
+}
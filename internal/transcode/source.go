@@ -0,0 +1,95 @@
+package transcode
+
+// Source is what is known about the media of a publisher before FFmpeg starts.
+// Zero sizes and frame rate are unknown, a nil *Source knows nothing and keeps the profile as it is.
+type Source struct {
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	FrameRate  float64 `json:"frame_rate,omitempty"`
+	Video      bool    `json:"video"`
+	Audio      bool    `json:"audio"`
+	VideoCodec string  `json:"video_codec,omitempty"` // As ffprobe names them, h264, hevc, vp8...
+	AudioCodec string  `json:"audio_codec,omitempty"`
+}
+
+// hasVideo and hasAudio assume both for an unknown source, as FFmpeg was always asked for them
+func (src *Source) hasVideo() bool {
+	return src == nil || src.Video
+}
+
+func (src *Source) hasAudio() bool {
+	return src == nil || src.Audio
+}
+
+// Ladder is the profile's renditions fitted to the source. Renditions that would upscale it are dropped,
+// when that is all of them the smallest is kept at the source size. An audio-only source has no renditions.
+// In passthrough the publisher's video, when it can be copied, takes the top, see passthroughLadder.
+func (p *Profile) Ladder(src *Source, mode Mode) []Rendition {
+	if !src.hasVideo() {
+		return nil
+	}
+	ladder := p.fit(src)
+	if src.copiesVideo(mode) {
+		return p.passthroughLadder(ladder, src)
+	}
+	return ladder
+}
+
+func (p *Profile) fit(src *Source) []Rendition {
+	if src == nil || src.Width == 0 || src.Height == 0 {
+		return p.Renditions
+	}
+
+	var ladder []Rendition
+	smallest := p.Renditions[0]
+	for _, r := range p.Renditions {
+		if r.area(src) < smallest.area(src) {
+			smallest = r
+		}
+		if !r.upscales(src) {
+			ladder = append(ladder, r)
+		}
+	}
+
+	if len(ladder) == 0 {
+		smallest.Width, smallest.Height = 0, 0
+		ladder = []Rendition{smallest}
+	}
+	return ladder
+}
+
+// upscales reports whether the rendition is larger than the source. Renditions with both sizes fit the source into
+// that box, keeping its aspect ratio, so only a box larger in both directions would scale it up.
+func (r Rendition) upscales(src *Source) bool {
+	switch {
+	case r.Width > 0 && r.Height > 0:
+		return r.Width > src.Width && r.Height > src.Height
+	case r.Height > 0:
+		return r.Height > src.Height
+	case r.Width > 0:
+		return r.Width > src.Width
+	}
+	return false
+}
+
+// area is the number of pixels of the rendition scaled from src, used to rank renditions
+func (r Rendition) area(src *Source) int {
+	switch {
+	case r.Width > 0 && r.Height > 0:
+		scale := min(float64(r.Width)/float64(src.Width), float64(r.Height)/float64(src.Height))
+		return int(float64(src.Width*src.Height) * scale * scale)
+	case r.Height > 0:
+		return r.Height * r.Height * src.Width / src.Height
+	case r.Width > 0:
+		return r.Width * r.Width * src.Height / src.Width
+	}
+	return src.Width * src.Height
+}
+
+// gopFrames is the keyframe interval in frames at the source's frame rate, 0 when it is unknown
+func (p *Profile) gopFrames(src *Source) int {
+	if src == nil || src.FrameRate <= 0 {
+		return 0
+	}
+	return max(1, int(p.GopSeconds*src.FrameRate+0.5))
+}
@@ -0,0 +1,182 @@
+package transcode
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLadder(t *testing.T) {
+	abr := []Rendition{
+		{Name: "1080p", Width: 1920, Height: 1080},
+		{Name: "720p", Width: 1280, Height: 720},
+		{Name: "480p", Width: 854, Height: 480},
+	}
+	heights := []Rendition{{Name: "720p", Height: 720}, {Name: "360p", Height: 360}}
+	widths := []Rendition{{Name: "w1280", Width: 1280}, {Name: "w640", Width: 640}}
+
+	tests := []struct {
+		name       string
+		renditions []Rendition
+		src        *Source
+		mode       Mode
+		want       []string          // Names of the renditions kept
+		sizes      map[string][2]int // Width and height of the ones that changed
+	}{
+		{
+			name:       "unknown source keeps the profile",
+			renditions: abr,
+			src:        nil,
+			want:       []string{"1080p", "720p", "480p"},
+		},
+		{
+			name:       "unknown size keeps the profile",
+			renditions: abr,
+			src:        &Source{Video: true, Audio: true},
+			want:       []string{"1080p", "720p", "480p"},
+		},
+		{
+			name:       "only the width is known",
+			renditions: abr,
+			src:        &Source{Width: 640, Video: true},
+			want:       []string{"1080p", "720p", "480p"},
+		},
+		{
+			name:       "source as large as the top rendition",
+			renditions: abr,
+			src:        &Source{Width: 1920, Height: 1080, Video: true},
+			want:       []string{"1080p", "720p", "480p"},
+		},
+		{
+			name:       "720p source drops 1080p",
+			renditions: abr,
+			src:        &Source{Width: 1280, Height: 720, Video: true},
+			want:       []string{"720p", "480p"},
+		},
+		{
+			name:       "source below the smallest rendition keeps it at the source size",
+			renditions: abr,
+			src:        &Source{Width: 640, Height: 360, Video: true},
+			want:       []string{"480p"},
+			sizes:      map[string][2]int{"480p": {0, 0}},
+		},
+		{
+			name:       "portrait source fits into the landscape boxes",
+			renditions: abr,
+			src:        &Source{Width: 1080, Height: 1920, Video: true},
+			want:       []string{"1080p", "720p", "480p"},
+		},
+		{
+			name:       "small portrait source drops boxes larger both ways",
+			renditions: abr,
+			src:        &Source{Width: 540, Height: 960, Video: true},
+			want:       []string{"720p", "480p"},
+		},
+		{
+			name:       "height-only renditions above the source",
+			renditions: heights,
+			src:        &Source{Width: 1280, Height: 540, Video: true},
+			want:       []string{"360p"},
+		},
+		{
+			name:       "height-only renditions of a portrait source",
+			renditions: heights,
+			src:        &Source{Width: 720, Height: 1280, Video: true},
+			want:       []string{"720p", "360p"},
+		},
+		{
+			name:       "width-only renditions above the source",
+			renditions: widths,
+			src:        &Source{Width: 960, Height: 540, Video: true},
+			want:       []string{"w640"},
+		},
+		{
+			name:       "width-only renditions of a portrait source",
+			renditions: widths,
+			src:        &Source{Width: 540, Height: 960, Video: true},
+			want:       []string{"w640"},
+			sizes:      map[string][2]int{"w640": {0, 0}},
+		},
+		{
+			name:       "smallest rendition is picked by area, not by position",
+			renditions: []Rendition{{Name: "small", Height: 240}, {Name: "large", Height: 720}},
+			src:        &Source{Width: 320, Height: 180, Video: true},
+			want:       []string{"small"},
+			sizes:      map[string][2]int{"small": {0, 0}},
+		},
+		{
+			name:       "rendition without a size never upscales",
+			renditions: []Rendition{{Name: "source"}, {Name: "1080p", Height: 1080}},
+			src:        &Source{Width: 640, Height: 360, Video: true},
+			want:       []string{"source"},
+		},
+		{
+			name:       "audio-only source has no renditions",
+			renditions: abr,
+			src:        &Source{Audio: true},
+			want:       nil,
+		},
+		{
+			name:       "passthrough takes the top of a 720p source",
+			renditions: abr,
+			src:        &Source{Width: 1280, Height: 720, Video: true, VideoCodec: "h264"},
+			mode:       ModePassthrough,
+			want:       []string{"passthrough", "480p"},
+		},
+		{
+			name:       "passthrough of an unknown size leaves out the largest rendition",
+			renditions: abr,
+			src:        &Source{Video: true, VideoCodec: "hevc"},
+			mode:       ModePassthrough,
+			want:       []string{"passthrough", "720p", "480p"},
+		},
+		{
+			name:       "passthrough of a codec HLS cannot carry is encoded",
+			renditions: abr,
+			src:        &Source{Width: 1280, Height: 720, Video: true, VideoCodec: "vp8"},
+			mode:       ModePassthrough,
+			want:       []string{"720p", "480p"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Profile{Renditions: tt.renditions}
+			ladder := p.Ladder(tt.src, tt.mode)
+
+			var names []string
+			for _, r := range ladder {
+				names = append(names, r.Name)
+				if size, ok := tt.sizes[r.Name]; ok && (r.Width != size[0] || r.Height != size[1]) {
+					t.Errorf("rendition %s is %dx%d, want %dx%d", r.Name, r.Width, r.Height, size[0], size[1])
+				}
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("Ladder = %v, want %v", names, tt.want)
+			}
+		})
+	}
+
+	// Fitting never touches the profile
+	if abr[2].Width != 854 || abr[2].Height != 480 {
+		t.Errorf("Ladder changed the profile's renditions: %+v", abr[2])
+	}
+}
+
+func TestGopFrames(t *testing.T) {
+	p := &Profile{GopSeconds: 2}
+	tests := []struct {
+		src  *Source
+		want int
+	}{
+		{nil, 0},
+		{&Source{}, 0},
+		{&Source{FrameRate: 30}, 60},
+		{&Source{FrameRate: 29.97}, 60},
+		{&Source{FrameRate: 0.2}, 1},
+	}
+	for _, tt := range tests {
+		if got := p.gopFrames(tt.src); got != tt.want {
+			t.Errorf("gopFrames(%+v) = %d, want %d", tt.src, got, tt.want)
+		}
+	}
+}
@@ -60,17 +60,29 @@ func detectContentType(key string) string {
 	switch {
 	case strings.HasSuffix(key, ".m3u8"):
 		return "application/vnd.apple.mpegurl"
+	case strings.HasSuffix(key, ".mpd"):
+		return "application/dash+xml"
 	case strings.HasSuffix(key, ".ts"):
 		return "video/MP2T"
+	case strings.HasSuffix(key, ".m4s"):
+		return "video/iso.segment"
+	case strings.HasSuffix(key, ".mp4"):
+		return "video/mp4"
 	default:
 		return "application/octet-stream"
 	}
 }
 
-// WatchAndUpload monitors a directory and uploads new .ts and .m3u8 files to Cloudflare R2
-func (uploader *CloudflareUploader) WatchAndUpload(ctx context.Context, outputDir string, taskId string, bucket string, abr bool, linkCallback func(url string)) {
+// WatchAndUpload monitors a directory and uploads new segments (.ts, or .m4s and their .mp4 initialization
+// sections), .m3u8 playlists and .mpd manifests to Cloudflare R2.
+// uploadCallback is called with the object key of every file once it is uploaded, or with the
+// last error once its retries are exhausted. Uploads in flight when ctx is cancelled are finished
+// before WatchAndUpload returns.
+func (uploader *CloudflareUploader) WatchAndUpload(ctx context.Context, outputDir string, taskId string, bucket string, abr bool, linkCallback func(url string), uploadCallback func(key string, err error)) {
 	var wg sync.WaitGroup
 
+	uploadCtx := context.WithoutCancel(ctx)
+
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		slog.Error("Failed to create watcher", "error", err)
@@ -101,8 +113,8 @@ func (uploader *CloudflareUploader) WatchAndUpload(ctx context.Context, outputDi
 			key := taskId + "/" + filepath.Base(event.Name)
 			path := event.Name
 
-			// === Handle TS files (on create only) ===
-			if strings.HasSuffix(path, ".ts") && event.Op&fsnotify.Create == fsnotify.Create {
+			// === Handle segment files (on create only) ===
+			if isSegmentFile(path) && event.Op&fsnotify.Create == fsnotify.Create {
 				if seenTS[path] {
 					continue
 				}
@@ -116,6 +128,7 @@ func (uploader *CloudflareUploader) WatchAndUpload(ctx context.Context, outputDi
 
 					contentType := detectContentType(key)
 
+					var uploadErr error
 					for i := 0; i < 3; i++ {
 						file, err := os.Open(path)
 						if err != nil {
@@ -123,22 +136,26 @@ func (uploader *CloudflareUploader) WatchAndUpload(ctx context.Context, outputDi
 							return
 						}
 
-						err = uploader.UploadStream(ctx, bucket, key, file, contentType)
+						uploadErr = uploader.UploadStream(uploadCtx, bucket, key, file, contentType)
 						file.Close()
 
-						if err == nil {
+						if uploadErr == nil {
 							slog.Info("Upload successful", "key", key)
 							break
 						}
 
-						slog.Error("Upload failed, retrying...", "attempt", i+1, "key", key, "error", err)
+						slog.Error("Upload failed, retrying...", "attempt", i+1, "key", key, "error", uploadErr)
 						time.Sleep(time.Second * time.Duration(i+1)) // exponential backoff
 					}
+
+					if uploadCallback != nil {
+						uploadCallback(key, uploadErr)
+					}
 				}(path, key)
 			}
 
-			// === Handle M3U8 files (on create or write) ===
-			if strings.HasSuffix(path, ".m3u8") && (event.Op&fsnotify.Create == fsnotify.Create || event.Op&fsnotify.Write == fsnotify.Write) {
+			// === Handle M3U8 and MPD files (on create or write) ===
+			if isManifestFile(path) && (event.Op&fsnotify.Create == fsnotify.Create || event.Op&fsnotify.Write == fsnotify.Write) {
 
 				wg.Add(1)
 				go func(path, key string) {
@@ -148,6 +165,7 @@ func (uploader *CloudflareUploader) WatchAndUpload(ctx context.Context, outputDi
 
 					contentType := detectContentType(key)
 
+					var uploadErr error
 					for i := 0; i < 3; i++ {
 						file, err := os.Open(path)
 						if err != nil {
@@ -155,16 +173,17 @@ func (uploader *CloudflareUploader) WatchAndUpload(ctx context.Context, outputDi
 							return
 						}
 
-						err = uploader.UploadStream(ctx, bucket, key, file, contentType)
+						uploadErr = uploader.UploadStream(uploadCtx, bucket, key, file, contentType)
 						file.Close()
 
-						if err == nil {
+						if uploadErr == nil {
 							slog.Info("Upload successful", "key", key)
 
 							publicURL := os.Getenv("CLOUDFLARE_PUBLIC_URL")
 							url := fmt.Sprintf("%s/%s", publicURL, key)
 
-							if linkCallback != nil {
+							// The playback URL is the HLS one, the DASH manifest sits next to it
+							if linkCallback != nil && strings.HasSuffix(key, ".m3u8") {
 								if !abr {
 									linkCallback(url)
 								} else if strings.Contains(strings.ToLower(url), "master") {
@@ -174,9 +193,13 @@ func (uploader *CloudflareUploader) WatchAndUpload(ctx context.Context, outputDi
 							break
 						}
 
-						slog.Error("Upload failed, retrying...", "attempt", i+1, "key", key, "error", err)
+						slog.Error("Upload failed, retrying...", "attempt", i+1, "key", key, "error", uploadErr)
 						time.Sleep(time.Second * time.Duration(i+1))
 					}
+
+					if uploadCallback != nil {
+						uploadCallback(key, uploadErr)
+					}
 				}(path, key)
 			}
 
@@ -188,3 +211,21 @@ func (uploader *CloudflareUploader) WatchAndUpload(ctx context.Context, outputDi
 		}
 	}
 }
+
+// isManifestFile reports whether path is a playlist or manifest, rewritten as the stream goes on
+func isManifestFile(path string) bool {
+	switch filepath.Ext(path) {
+	case ".m3u8", ".mpd":
+		return true
+	}
+	return false
+}
+
+// isSegmentFile reports whether path is media that is written once, as opposed to a playlist
+func isSegmentFile(path string) bool {
+	switch filepath.Ext(path) {
+	case ".ts", ".m4s", ".mp4":
+		return true
+	}
+	return false
+}
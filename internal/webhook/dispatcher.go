@@ -0,0 +1,382 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	mrand "math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vijayvenkatj/LiveTran/internal/config"
+)
+
+// Number of delivery attempts kept in memory for the attempt log
+const maxDeliveryLog = 500
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+type Config struct {
+	Secret         string
+	Timeout        time.Duration
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	QueueSize      int
+	IdleTimeout    time.Duration // An endpoint's worker and queue are dropped after this long without messages
+}
+
+// Message is a payload queued for a single endpoint
+type Message struct {
+	EventId  string
+	StreamId string
+	Payload  []byte
+}
+
+// Delivery is one entry of the attempt log
+type Delivery struct {
+	Id         string    `json:"id"`
+	EventId    string    `json:"event_id"`
+	StreamId   string    `json:"stream_id"`
+	URL        string    `json:"url"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	At         time.Time `json:"at"`
+}
+
+// DeadLetter is a message that exhausted its retries
+type DeadLetter struct {
+	Id        string          `json:"id"`
+	EventId   string          `json:"event_id"`
+	StreamId  string          `json:"stream_id"`
+	URL       string          `json:"url"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error"`
+	FailedAt  time.Time       `json:"failed_at"`
+}
+
+// DeadLetterStore keeps undeliverable messages around so they can be replayed
+type DeadLetterStore interface {
+	SaveDeadLetter(letter DeadLetter) error
+	GetDeadLetter(id string) (DeadLetter, error)
+	ListDeadLetters() ([]DeadLetter, error)
+	DeleteDeadLetter(id string) error
+}
+
+type Dispatcher struct {
+	cfg    Config
+	client *http.Client
+	dlq    DeadLetterStore
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu         sync.Mutex
+	queues     map[string]chan Message
+	deliveries []Delivery
+	closed     bool
+}
+
+// ConfigFromEnv builds the dispatcher config, falling back to sane defaults
+func ConfigFromEnv() Config {
+	secret := os.Getenv("WEBHOOK_SECRET")
+	if secret == "" {
+		secret = os.Getenv("HMAC_SECRET")
+	}
+
+	return Config{
+		Secret:         secret,
+		Timeout:        config.EnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		MaxAttempts:    config.EnvInt("WEBHOOK_MAX_ATTEMPTS", 5),
+		InitialBackoff: config.EnvDuration("WEBHOOK_INITIAL_BACKOFF", time.Second),
+		MaxBackoff:     config.EnvDuration("WEBHOOK_MAX_BACKOFF", time.Minute),
+		QueueSize:      config.EnvInt("WEBHOOK_QUEUE_SIZE", 256),
+		IdleTimeout:    config.EnvDuration("WEBHOOK_IDLE_TIMEOUT", 5*time.Minute),
+	}
+}
+
+func NewDispatcher(cfg Config, dlq DeadLetterStore) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Dispatcher{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		dlq:    dlq,
+		ctx:    ctx,
+		cancel: cancel,
+		queues: make(map[string]chan Message),
+	}
+}
+
+// Enqueue schedules msg for delivery to url, never blocks the caller
+func (d *Dispatcher) Enqueue(url string, msg Message) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		d.deadLetter(url, msg, 0, "dispatcher closed")
+		return
+	}
+
+	queue, exists := d.queues[url]
+	if !exists {
+		queue = make(chan Message, d.cfg.QueueSize)
+		d.queues[url] = queue
+
+		d.wg.Add(1)
+		go d.worker(url, queue)
+	}
+
+	// Sending under the lock so Close cannot close the queue underneath us
+	queued := false
+	select {
+	case queue <- msg:
+		queued = true
+	default:
+	}
+	d.mu.Unlock()
+
+	if !queued {
+		d.deadLetter(url, msg, 0, "endpoint queue full")
+	}
+}
+
+// DeadLetter stores msg for url as undelivered without trying it, for messages that never reached a queue
+func (d *Dispatcher) DeadLetter(url string, msg Message, reason string) {
+	d.deadLetter(url, msg, 0, reason)
+}
+
+// Replay removes a dead letter and queues it again
+func (d *Dispatcher) Replay(id string) error {
+	letter, err := d.dlq.GetDeadLetter(id)
+	if err != nil {
+		return err
+	}
+
+	if err := d.dlq.DeleteDeadLetter(id); err != nil {
+		return err
+	}
+
+	slog.Info("Replaying webhook", "dead_letter_id", id, "url", letter.URL, "event_id", letter.EventId)
+	d.Enqueue(letter.URL, Message{
+		EventId:  letter.EventId,
+		StreamId: letter.StreamId,
+		Payload:  letter.Payload,
+	})
+	return nil
+}
+
+func (d *Dispatcher) DeadLetters() ([]DeadLetter, error) {
+	return d.dlq.ListDeadLetters()
+}
+
+// Deliveries returns the attempt log, newest first. An empty streamId returns every entry.
+func (d *Dispatcher) Deliveries(streamId string) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	deliveries := []Delivery{}
+	for i := len(d.deliveries) - 1; i >= 0; i-- {
+		if streamId == "" || d.deliveries[i].StreamId == streamId {
+			deliveries = append(deliveries, d.deliveries[i])
+		}
+	}
+	return deliveries
+}
+
+// Close stops the workers. Messages still pending are moved to the dead-letter store.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.mu.Unlock()
+
+	d.cancel()
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) worker(url string, queue chan Message) {
+	defer d.wg.Done()
+
+	var idle <-chan time.Time
+	timer := time.NewTimer(d.cfg.IdleTimeout)
+	defer timer.Stop()
+	if d.cfg.IdleTimeout > 0 {
+		idle = timer.C
+	}
+
+	for {
+		select {
+		case msg, ok := <-queue:
+			if !ok {
+				return
+			}
+			if d.ctx.Err() != nil {
+				d.deadLetter(url, msg, 0, "dispatcher closed")
+				continue
+			}
+			d.deliver(url, msg)
+		case <-idle:
+			if d.reap(url, queue) {
+				return
+			}
+		}
+		timer.Reset(d.cfg.IdleTimeout)
+	}
+}
+
+// reap forgets the queue of an idle endpoint, so endpoints that are no longer used do not keep a worker.
+// A message queued later starts a new one.
+func (d *Dispatcher) reap(url string, queue chan Message) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Enqueue sends under the lock, so an empty queue stays empty
+	if d.closed || len(queue) > 0 || d.queues[url] != queue {
+		return false
+	}
+	delete(d.queues, url)
+	return true
+}
+
+func (d *Dispatcher) deliver(url string, msg Message) {
+	var lastErr error
+
+	for attempt := 1; attempt <= d.cfg.MaxAttempts; attempt++ {
+		lastErr = d.attempt(url, msg, attempt)
+		if lastErr == nil {
+			return
+		}
+
+		if attempt == d.cfg.MaxAttempts {
+			break
+		}
+
+		select {
+		case <-d.ctx.Done():
+			d.deadLetter(url, msg, attempt, fmt.Sprintf("dispatcher closed: %s", lastErr))
+			return
+		case <-time.After(d.backoff(attempt)):
+		}
+	}
+
+	d.deadLetter(url, msg, d.cfg.MaxAttempts, lastErr.Error())
+}
+
+func (d *Dispatcher) attempt(url string, msg Message, attempt int) error {
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, url, bytes.NewReader(msg.Payload))
+	if err != nil {
+		d.logDelivery(url, msg, attempt, 0, err, start)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("LT-EVENT-ID", msg.EventId)
+	req.Header.Set("LT-DELIVERY-ATTEMPT", strconv.Itoa(attempt))
+	if d.cfg.Secret != "" {
+		req.Header.Set("LT-SIGNATURE", Sign(msg.Payload, []byte(d.cfg.Secret)))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		d.logDelivery(url, msg, attempt, 0, err, start)
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	d.logDelivery(url, msg, attempt, resp.StatusCode, err, start)
+	return err
+}
+
+// Exponential backoff with up to 20% jitter, capped at MaxBackoff
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := float64(d.cfg.InitialBackoff) * math.Pow(2, float64(attempt-1))
+	delay = math.Min(delay, float64(d.cfg.MaxBackoff))
+	jitter := delay * 0.2 * mrand.Float64()
+	return time.Duration(delay + jitter)
+}
+
+func (d *Dispatcher) logDelivery(url string, msg Message, attempt int, statusCode int, err error, start time.Time) {
+	delivery := Delivery{
+		Id:         newId("dlv_"),
+		EventId:    msg.EventId,
+		StreamId:   msg.StreamId,
+		URL:        url,
+		Attempt:    attempt,
+		StatusCode: statusCode,
+		DurationMs: time.Since(start).Milliseconds(),
+		At:         start,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+		slog.Error("Webhook delivery failed", "url", url, "event_id", msg.EventId, "attempt", attempt, "error", err)
+	} else {
+		slog.Info("Webhook delivered", "url", url, "event_id", msg.EventId, "attempt", attempt, "status_code", statusCode)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.deliveries = append(d.deliveries, delivery)
+	if len(d.deliveries) > maxDeliveryLog {
+		d.deliveries = d.deliveries[len(d.deliveries)-maxDeliveryLog:]
+	}
+}
+
+func (d *Dispatcher) deadLetter(url string, msg Message, attempts int, reason string) {
+	letter := DeadLetter{
+		Id:        newId("dl_"),
+		EventId:   msg.EventId,
+		StreamId:  msg.StreamId,
+		URL:       url,
+		Payload:   msg.Payload,
+		Attempts:  attempts,
+		LastError: reason,
+		FailedAt:  time.Now(),
+	}
+
+	slog.Error("Webhook moved to dead-letter store", "url", url, "event_id", msg.EventId, "reason", reason)
+
+	if err := d.dlq.SaveDeadLetter(letter); err != nil {
+		slog.Error("Failed to save dead letter", "url", url, "event_id", msg.EventId, "error", err)
+	}
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of payload, the same scheme used for LT-SIGNATURE on requests
+func Sign(payload []byte, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newId(prefix string) string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
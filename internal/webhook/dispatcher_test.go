@@ -0,0 +1,261 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryDeadLetters is a DeadLetterStore kept in a map
+type memoryDeadLetters struct {
+	mu      sync.Mutex
+	letters map[string]DeadLetter
+}
+
+func newMemoryDeadLetters() *memoryDeadLetters {
+	return &memoryDeadLetters{letters: make(map[string]DeadLetter)}
+}
+
+func (s *memoryDeadLetters) SaveDeadLetter(letter DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.letters[letter.Id] = letter
+	return nil
+}
+
+func (s *memoryDeadLetters) GetDeadLetter(id string) (DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	letter, ok := s.letters[id]
+	if !ok {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+	return letter, nil
+}
+
+func (s *memoryDeadLetters) ListDeadLetters() ([]DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	letters := []DeadLetter{}
+	for _, letter := range s.letters {
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+func (s *memoryDeadLetters) DeleteDeadLetter(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.letters, id)
+	return nil
+}
+
+// receiver is a webhook endpoint answering with the status codes it is given, 200 once they run out
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	at       []time.Time
+	received chan struct{}
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses, received: make(chan struct{}, 100)}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+
+		r.mu.Lock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		r.at = append(r.at, time.Now())
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.mu.Unlock()
+
+		w.WriteHeader(status)
+		r.received <- struct{}{}
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// wait blocks until the endpoint got n more requests
+func (r *receiver) wait(t *testing.T, n int) {
+	t.Helper()
+	for range n {
+		select {
+		case <-r.received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("webhook was not delivered")
+		}
+	}
+}
+
+func testConfig() Config {
+	return Config{
+		Secret:         "secret",
+		Timeout:        time.Second,
+		MaxAttempts:    3,
+		InitialBackoff: 20 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		QueueSize:      8,
+		IdleTimeout:    time.Minute,
+	}
+}
+
+// eventually polls cond until it holds
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+	}
+}
+
+func TestDispatcherRetriesWithBackoff(t *testing.T) {
+	endpoint := newReceiver(t, http.StatusInternalServerError, http.StatusBadGateway)
+	dlq := newMemoryDeadLetters()
+	d := NewDispatcher(testConfig(), dlq)
+	defer d.Close()
+
+	d.Enqueue(endpoint.URL, Message{EventId: "evt_1", StreamId: "s1", Payload: []byte(`{"type":"stream.live"}`)})
+	endpoint.wait(t, 3)
+
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+	for i, req := range endpoint.requests {
+		if got := req.Header.Get("LT-DELIVERY-ATTEMPT"); got != strconv.Itoa(i+1) {
+			t.Errorf("request %d: LT-DELIVERY-ATTEMPT = %s", i, got)
+		}
+	}
+	// Exponential backoff: 20ms, then 40ms, plus jitter
+	for i, least := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond} {
+		if gap := endpoint.at[i+1].Sub(endpoint.at[i]); gap < least {
+			t.Errorf("attempt %d came %s after the previous one, want at least %s", i+2, gap, least)
+		}
+	}
+
+	eventually(t, func() bool { return len(d.Deliveries("s1")) == 3 })
+	deliveries := d.Deliveries("s1")
+	if deliveries[0].StatusCode != http.StatusOK || deliveries[0].Attempt != 3 || deliveries[2].StatusCode != http.StatusInternalServerError {
+		t.Errorf("attempt log = %+v, want the 500, 502 and 200 newest first", deliveries)
+	}
+	if letters, _ := dlq.ListDeadLetters(); len(letters) != 0 {
+		t.Errorf("dead letters = %+v, want none", letters)
+	}
+}
+
+func TestDispatcherSigns(t *testing.T) {
+	endpoint := newReceiver(t)
+	d := NewDispatcher(testConfig(), newMemoryDeadLetters())
+	defer d.Close()
+
+	payload := []byte(`{"type":"stream.ready"}`)
+	d.Enqueue(endpoint.URL, Message{EventId: "evt_2", StreamId: "s2", Payload: payload})
+	endpoint.wait(t, 1)
+
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+	req, body := endpoint.requests[0], endpoint.bodies[0]
+	if string(body) != string(payload) {
+		t.Errorf("body = %s, want %s", body, payload)
+	}
+	if got := req.Header.Get("LT-EVENT-ID"); got != "evt_2" {
+		t.Errorf("LT-EVENT-ID = %s", got)
+	}
+
+	if got, want := req.Header.Get("LT-SIGNATURE"), Sign(payload, []byte("secret")); got != want {
+		t.Errorf("LT-SIGNATURE = %s, want %s", got, want)
+	}
+}
+
+func TestDispatcherDeadLettersAndReplays(t *testing.T) {
+	endpoint := newReceiver(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	dlq := newMemoryDeadLetters()
+	d := NewDispatcher(testConfig(), dlq)
+	defer d.Close()
+
+	d.Enqueue(endpoint.URL, Message{EventId: "evt_3", StreamId: "s3", Payload: []byte(`{}`)})
+	endpoint.wait(t, 3)
+
+	var letter DeadLetter
+	eventually(t, func() bool {
+		letters, _ := dlq.ListDeadLetters()
+		if len(letters) == 1 {
+			letter = letters[0]
+		}
+		return len(letters) == 1
+	})
+	if letter.EventId != "evt_3" || letter.URL != endpoint.URL || letter.Attempts != 3 || letter.LastError != "unexpected status code 500" {
+		t.Errorf("dead letter = %+v", letter)
+	}
+
+	// The endpoint is back, the replay is delivered and leaves the dead-letter store
+	if err := d.Replay(letter.Id); err != nil {
+		t.Fatal(err)
+	}
+	endpoint.wait(t, 1)
+	if letters, _ := dlq.ListDeadLetters(); len(letters) != 0 {
+		t.Errorf("dead letters after replay = %+v, want none", letters)
+	}
+	eventually(t, func() bool {
+		deliveries := d.Deliveries("s3")
+		return len(deliveries) == 4 && deliveries[0].StatusCode == http.StatusOK
+	})
+
+	if err := d.Replay(letter.Id); err != ErrDeadLetterNotFound {
+		t.Errorf("second replay = %v, want %v", err, ErrDeadLetterNotFound)
+	}
+}
+
+func TestDispatcherDeadLettersOnClose(t *testing.T) {
+	dlq := newMemoryDeadLetters()
+	d := NewDispatcher(testConfig(), dlq)
+	d.Close()
+
+	d.Enqueue("http://127.0.0.1:1/hook", Message{EventId: "evt_4", StreamId: "s4", Payload: []byte(`{}`)})
+	letters, _ := dlq.ListDeadLetters()
+	if len(letters) != 1 || letters[0].LastError != "dispatcher closed" {
+		t.Errorf("dead letters = %+v, want the message queued after Close", letters)
+	}
+}
+
+// Endpoints that are no longer used do not keep a worker, the next message starts one again
+func TestDispatcherReapsIdleEndpoints(t *testing.T) {
+	endpoint := newReceiver(t)
+	cfg := testConfig()
+	cfg.IdleTimeout = 20 * time.Millisecond
+	d := NewDispatcher(cfg, newMemoryDeadLetters())
+	defer d.Close()
+
+	queues := func() int {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return len(d.queues)
+	}
+
+	for i := range 2 {
+		d.Enqueue(endpoint.URL, Message{EventId: "evt_" + strconv.Itoa(i), StreamId: "s5", Payload: []byte(`{}`)})
+		endpoint.wait(t, 1)
+		eventually(t, func() bool { return queues() == 0 })
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{cfg: Config{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}}
+	for attempt, base := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := d.backoff(attempt); got < base || got > base+base/5 {
+			t.Errorf("backoff(%d) = %s, want %s plus up to 20%%", attempt, got, base)
+		}
+	}
+}
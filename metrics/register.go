@@ -70,6 +70,51 @@ func RegisterUpDownCounter(ctx context.Context, meter metric.Meter, name string,
 }
 
 
+// RegisterStreamGauge observes one value per stream, labelled with its stream_id
+func RegisterStreamGauge(meter metric.Meter, name string, description string, callbackFn func() map[string]float64) {
+	gauge, err := meter.Float64ObservableGauge(
+		name,
+		metric.WithDescription(description),
+	)
+	if err != nil {
+		slog.Error("Error creating stream gauge", "error", err)
+		return
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, obs metric.Observer) error {
+		for streamId, value := range callbackFn() {
+			obs.ObserveFloat64(gauge, value, metric.WithAttributes(attribute.String("stream_id", streamId)))
+		}
+		return nil
+	}, gauge)
+	if err != nil {
+		slog.Error("Error registering stream gauge callback", "error", err)
+	}
+}
+
+// RegisterStreamCounter observes one running total per stream, labelled with its stream_id
+func RegisterStreamCounter(meter metric.Meter, name string, description string, callbackFn func() map[string]int64) {
+	counter, err := meter.Int64ObservableCounter(
+		name,
+		metric.WithDescription(description),
+	)
+	if err != nil {
+		slog.Error("Error creating stream counter", "error", err)
+		return
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, obs metric.Observer) error {
+		for streamId, value := range callbackFn() {
+			obs.ObserveInt64(counter, value, metric.WithAttributes(attribute.String("stream_id", streamId)))
+		}
+		return nil
+	}, counter)
+	if err != nil {
+		slog.Error("Error registering stream counter callback", "error", err)
+	}
+}
+
+
 func RegisterHistogram(meter metric.Meter, name string, description string) (metric.Int64Histogram, error) {
     histogram, err := meter.Int64Histogram(
         name,
@@ -82,3 +127,37 @@ func RegisterHistogram(meter metric.Meter, name string, description string) (met
 
     return histogram, nil
 }
+
+
+func RegisterCounter(meter metric.Meter, name string, description string) (metric.Int64Counter, error) {
+    counter, err := meter.Int64Counter(
+        name,
+        metric.WithDescription(description),
+    )
+    if err != nil {
+        slog.Error("Error creating counter", "error", err)
+        return nil, err
+    }
+
+    return counter, nil
+}
+
+
+func RegisterObservableCounter(meter metric.Meter, name string, description string, callbackFn func() int64) {
+	counter, err := meter.Int64ObservableCounter(
+		name,
+		metric.WithDescription(description),
+	)
+	if err != nil {
+		slog.Error("Error creating observable counter", "error", err)
+		return
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, obs metric.Observer) error {
+		obs.ObserveInt64(counter, callbackFn())
+		return nil
+	}, counter)
+	if err != nil {
+		slog.Error("Error registering counter callback", "error", err)
+	}
+}